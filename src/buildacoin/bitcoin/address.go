@@ -0,0 +1,142 @@
+package bitcoin
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+)
+
+const (
+    // Base58 alphabet used by bitcoin addresses and keys
+    Base58Alphabet =
+            "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+    // Length of the checksum appended by Base58Check in bytes
+    ChecksumLen = 4
+)
+
+var (
+    // Error when a string contains a character outside the base58 alphabet
+    ErrBase58Char error = errors.New("illegal base58 character")
+    // Error when a Base58Check checksum doesn't match its payload
+    ErrBadChecksum error = errors.New("base58 checksum mismatch")
+    // Error when a decoded address payload is the wrong length
+    ErrAddressLen error = errors.New("bad length for address")
+
+    bigRadix = big.NewInt(58)
+    base58Index = func() [256]int {
+        var output [256]int
+        for ii := range output {
+            output[ii] = -1
+        }
+        for ii := 0; ii < len(Base58Alphabet); ii++ {
+            output[Base58Alphabet[ii]] = ii
+        }
+        return output
+    }()
+)
+
+// Encode bytes with the bitcoin base58 alphabet.  Leading zero bytes become
+// leading '1's.
+func Base58Encode(input []byte) string {
+    num := new(big.Int).SetBytes(input)
+    mod := new(big.Int)
+    output := make([]byte, 0, len(input) * 138 / 100 + 1)
+    for num.Sign() > 0 {
+        num.DivMod(num, bigRadix, mod)
+        output = append(output, Base58Alphabet[mod.Int64()])
+    }
+    for _, b := range input {
+        if b != 0 {
+            break
+        }
+        output = append(output, Base58Alphabet[0])
+    }
+    // digits were produced least significant first
+    for ii, jj := 0, len(output)-1; ii < jj; ii, jj = ii+1, jj-1 {
+        output[ii], output[jj] = output[jj], output[ii]
+    }
+    return string(output)
+}
+
+// Decode a bitcoin base58 string.
+func Base58Decode(input string) ([]byte, error) {
+    num := new(big.Int)
+    for ii := 0; ii < len(input); ii++ {
+        digit := base58Index[input[ii]]
+        if digit < 0 {
+            return nil, ErrBase58Char
+        }
+        num.Mul(num, bigRadix)
+        num.Add(num, big.NewInt(int64(digit)))
+    }
+    zeros := 0
+    for zeros < len(input) && input[zeros] == Base58Alphabet[0] {
+        zeros++
+    }
+    return append(make([]byte, zeros), num.Bytes()...), nil
+}
+
+// Encode a version byte and payload with a trailing double SHA-256 checksum.
+func Base58CheckEncode(version byte, payload []byte) string {
+    data := append([]byte { version }, payload...)
+    checksum := Sha256d(data)
+    return Base58Encode(append(data, checksum[:ChecksumLen]...))
+}
+
+// Decode and verify a Base58Check string into its version byte and payload.
+func Base58CheckDecode(input string) (byte, []byte, error) {
+    data, err := Base58Decode(input)
+    if err != nil {
+        return 0, nil, err
+    }
+    if len(data) < 1 + ChecksumLen {
+        return 0, nil, ErrBadChecksum
+    }
+    payloadEnd := len(data) - ChecksumLen
+    checksum := Sha256d(data[:payloadEnd])
+    if !bytes.Equal(checksum[:ChecksumLen], data[payloadEnd:]) {
+        return 0, nil, ErrBadChecksum
+    }
+    return data[0], data[1:payloadEnd], nil
+}
+
+// A pubkey hash or script hash address for a particular coin
+type Address struct {
+    // The coin's version byte for the kind of address
+    Version byte
+    // Hash160 of the public key or redeem script
+    Hash [Hash160Size]byte
+}
+
+// Construct an address from a version byte and hash.
+func NewAddress(version byte, hash [Hash160Size]byte) Address {
+    return Address { version, hash }
+}
+
+func addressFromSlice(version byte, hash []byte) Address {
+    output := Address { Version: version }
+    copy(output.Hash[:], hash)
+    return output
+}
+
+// Construct the address of a public key with the coin's version byte.
+func PubkeyAddress(version byte, pubkey []byte) Address {
+    return NewAddress(version, Hash160(pubkey))
+}
+
+// Parse an address string of any coin.
+func ParseAddress(input string) (Address, error) {
+    version, payload, err := Base58CheckDecode(input)
+    if err != nil {
+        return Address{}, err
+    }
+    if len(payload) != Hash160Size {
+        return Address{}, ErrAddressLen
+    }
+    return addressFromSlice(version, payload), nil
+}
+
+// Get the Base58Check encoding of the address.
+func (tt Address) String() string {
+    return Base58CheckEncode(tt.Version, tt.Hash[:])
+}
@@ -0,0 +1,94 @@
+package bitcoin
+
+import (
+    "encoding/hex"
+    "testing"
+)
+
+const (
+    // public key paid by the bitcoin genesis block coinbase
+    ExampleGenesisPubkey = ("04678afdb0fe5548271967f1a67130b7105cd6a828e039" +
+            "09a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba" +
+            "0b8d578a4c702b6bf11d5f")
+    ExampleGenesisAddress = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+)
+
+func TestPubkeyAddress(t *testing.T) {
+    pubkey, err := hex.DecodeString(ExampleGenesisPubkey)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    actual := PubkeyAddress(0x00, pubkey).String()
+    if actual != ExampleGenesisAddress {
+        t.Fatal("address mismatch: expected " + ExampleGenesisAddress +
+                " / actual " + actual)
+    }
+
+    parsed, err := ParseAddress(actual)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if parsed != PubkeyAddress(0x00, pubkey) {
+        t.Fatalf("parsed address mismatch: %v\n", parsed)
+    }
+
+    // flipping a character must break the checksum
+    _, err = ParseAddress("1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb")
+    if err != ErrBadChecksum {
+        t.Fatalf("expected checksum failure, got %v\n", err)
+    }
+}
+
+func TestBase58LeadingZeros(t *testing.T) {
+    input := []byte{ 0x00, 0x00, 0x01, 0x02 }
+    encoded := Base58Encode(input)
+    if encoded != "115T" {
+        t.Fatal("base58 mismatch: expected 115T / actual " + encoded)
+    }
+    decoded, err := Base58Decode(encoded)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if hex.EncodeToString(decoded) != hex.EncodeToString(input) {
+        t.Fatal("base58 round trip mismatch: " + hex.EncodeToString(decoded))
+    }
+}
+
+func TestScriptClasses(t *testing.T) {
+    pubkey, _ := hex.DecodeString(ExampleGenesisPubkey)
+    p2pk := append(append([]byte{ byte(len(pubkey)) }, pubkey...), OpChecksig)
+    hash := Hash160(pubkey)
+    p2pkh := PubkeyHashScript(hash)
+    p2sh := append(append([]byte{ OpHash160, Hash160Size }, hash[:]...),
+            OpEqual)
+
+    for _, tc := range []struct {
+        script []byte
+        class ScriptClass
+        address string
+    } {
+        { p2pk, ScriptPubkey, ExampleGenesisAddress },
+        { p2pkh, ScriptPubkeyHash, ExampleGenesisAddress },
+        { p2sh, ScriptHash, Base58CheckEncode(0x05, hash[:]) },
+        { []byte{ OpReturn, 0x01, 0xff }, ScriptNullData, "" },
+        { []byte{ OpDup, 0x05, 0x01 }, ScriptNonstandard, "" },
+    } {
+        if class := ClassifyScript(tc.script); class != tc.class {
+            t.Fatalf("class mismatch for %s: expected %v / actual %v\n",
+                    DisasmScript(tc.script), tc.class, class)
+        }
+        address, ok := ScriptAddress(tc.script, 0x00, 0x05)
+        if ok != (tc.address != "") || (ok && address.String() != tc.address) {
+            t.Fatalf("address mismatch for %s: expected %s / actual %v\n",
+                    DisasmScript(tc.script), tc.address, address)
+        }
+    }
+
+    expected := "OP_DUP OP_HASH160 " + hex.EncodeToString(hash[:]) +
+            " OP_EQUALVERIFY OP_CHECKSIG"
+    if actual := DisasmScript(p2pkh); actual != expected {
+        t.Fatal("disassembly mismatch: expected " + expected + " / actual " +
+                actual)
+    }
+}
@@ -7,6 +7,13 @@ import (
     "time"
 )
 
+const (
+    // Length of a serialized block header in bytes
+    HeaderLen = 80
+    // Most transactions accepted when decoding a block
+    MaxBlockTxs = 1000000
+)
+
 // A bitcoin block
 type Block struct {
     version uint32
@@ -37,6 +44,8 @@ func (tt *Block) AddTx(tx *Tx) *Block {
     return tt
 }
 
+// Set the merkle root to use in the header instead of computing it from the
+// transactions.
 func (tt *Block) SetMerkleRoot(root Hash) *Block {
     tt.merkleRoot = &root
     return tt
@@ -153,3 +162,95 @@ func (tt *Block) Bytes() []byte {
     }
     return buf.Bytes()
 }
+
+// Compute the double SHA-256 hash of a block's header (the block's identity).
+func (tt *Block) Hash() Hash {
+    return Sha256d(tt.Header())
+}
+
+//
+// Accessors
+//
+
+// Get the block version.
+func (tt *Block) Version() uint32 {
+    return tt.version
+}
+// Get the hash of the previous block in the chain.
+func (tt *Block) PrevBlock() Hash {
+    return tt.prevBlock
+}
+// Get the block timestamp.
+func (tt *Block) Timestamp() time.Time {
+    return tt.timestamp
+}
+// Get the compact proof of work target.
+func (tt *Block) TargetBits() uint32 {
+    return tt.targetBits
+}
+// Get the merkle root committed to in the header.  This differs from
+// MerkleRoot() only for blocks whose transactions don't match their header.
+func (tt *Block) HeaderMerkleRoot() Hash {
+    if tt.merkleRoot != nil {
+        return *tt.merkleRoot
+    }
+    return tt.MerkleRoot()
+}
+// Get the block header nonce.
+func (tt *Block) Nonce() uint32 {
+    return tt.nonce
+}
+// Get the transactions in the block.
+func (tt *Block) Txs() []*Tx {
+    output := make([]*Tx, len(tt.txs))
+    copy(output, tt.txs)
+    return output
+}
+
+// Read in the serialization of a block header.  The resulting block has no
+// transactions, but keeps the header's merkle root.
+func ReadHeader(input io.Reader) (*Block, error) {
+    var header struct {
+        Version uint32
+        PrevBlock Hash
+        MerkleRoot Hash
+        Timestamp uint32
+        TargetBits uint32
+        Nonce uint32
+    }
+    err := binary.Read(input, binary.LittleEndian, &header)
+    if err != nil {
+        return nil, err
+    }
+    return NewBlock(header.Version, header.TargetBits, header.Nonce,
+            header.PrevBlock, time.Unix(int64(header.Timestamp), 0),
+            ).SetMerkleRoot(header.MerkleRoot), nil
+}
+
+// Read in the serialization of an entire block.
+func ReadBlock(input io.Reader) (*Block, error) {
+    output, err := ReadHeader(input)
+    if err != nil {
+        return nil, err
+    }
+    count, err := ReadVarint(input)
+    if err != nil {
+        return nil, err
+    }
+    if count > MaxBlockTxs {
+        return nil, ErrLengthOverflow
+    }
+    for ii := uint64(0); ii < count; ii++ {
+        tx, err := ReadTx(input)
+        if err != nil {
+            return nil, err
+        }
+        output.AddTx(tx)
+    }
+    return output, nil
+}
+
+// Decode an entire block from its serialization.
+func ParseBlock(input []byte) (*Block, error) {
+    return ReadBlock(bytes.NewReader(input))
+}
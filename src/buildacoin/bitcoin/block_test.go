@@ -53,3 +53,27 @@ func TestHeaderSerial(t *testing.T) {
             "\nactual:\n" + hex.EncodeToString(actual))
     }
 }
+
+func TestBlockRoundTrip(t *testing.T) {
+    block := NewBlock(1, Diff1Bits, 42, Hash{}, time.Unix(1231006505, 0),
+            ).AddTx(new(Tx,
+                ).Input(Hash{}, 4294967295, []byte{ 0x04, 0xff, 0xff, 0x00,
+                        0x1d, },
+                ).Output(50 * Coin, []byte{ 0x51, }))
+    expected := block.Bytes()
+
+    decoded, err := ParseBlock(expected)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    actual := decoded.Bytes()
+
+    if !bytes.Equal(expected, actual) {
+        t.Fatal("block mismatch:\nexpected:\n" + hex.EncodeToString(expected) +
+            "\nactual:\n" + hex.EncodeToString(actual))
+    }
+    if !decoded.Hash().Equals(block.Hash()) {
+        t.Fatal("hash mismatch: expected " + block.Hash().String() +
+                " / actual " + decoded.Hash().String())
+    }
+}
@@ -2,6 +2,7 @@ package bitcoin
 
 import (
     "bytes"
+    "code.google.com/p/go.crypto/ripemd160"
     "code.google.com/p/go.crypto/scrypt"
     "crypto/sha256"
     "encoding/hex"
@@ -11,6 +12,8 @@ import (
 const (
     // Length of bitcoin hash values in bytes
     HashSize = 32
+    // Length of the short hashes used in addresses in bytes
+    Hash160Size = 20
     // Descriptive endianness constant for little endian operations
     LittleEndian = false
     // Descriptive endianness constant for big endian operations
@@ -129,6 +132,17 @@ func (tt sha256dHasher) Hash(input []byte) Hash {
 // Hasher for double SHA-256
 var HashSha256d sha256dHasher
 
+// Compute the RIPEMD-160 hash of the SHA-256 hash of the input, as used to
+// shorten keys and scripts into addresses.
+func Hash160(input []byte) [Hash160Size]byte {
+    sha := sha256.Sum256(input)
+    ripe := ripemd160.New()
+    ripe.Write(sha[:])
+    var output [Hash160Size]byte
+    copy(output[:], ripe.Sum(nil))
+    return output
+}
+
 // Produce a merkle tree from a list of hashes.
 func MerkleTree(inputHashes []Hash, hasher Hasher) []Hash {
     // don't mutate the input
@@ -160,3 +174,46 @@ func MerkleTree(inputHashes []Hash, hasher Hasher) []Hash {
 
     return hashes
 }
+
+// Produce the merkle branch proving the inclusion of the hash at index in the
+// tree of the input hashes: the sibling hash at each level from the leaves up
+// to (not including) the root.
+func MerkleBranch(inputHashes []Hash, index int, hasher Hasher) []Hash {
+    row := make([]Hash, len(inputHashes))
+    copy(row, inputHashes)
+    branch := make([]Hash, 0, 8)
+    for len(row) > 1 {
+        // odd rows duplicate their last hash, as in MerkleTree
+        if len(row) % 2 != 0 {
+            row = append(row, row[len(row)-1])
+        }
+        branch = append(branch, row[index ^ 1])
+
+        newRow := make([]Hash, 0, len(row) / 2)
+        for ii := 0; ii < len(row); ii += 2 {
+            newRow = append(newRow, hasher.Hash(bytes.Join(
+                    [][]byte{ row[ii].Bytes(), row[ii+1].Bytes() }, nil)))
+        }
+        row = newRow
+        index /= 2
+    }
+    return branch
+}
+
+// Compute the merkle root implied by a leaf hash, its index and its merkle
+// branch.
+func MerkleRootFromBranch(leaf Hash, index int, branch []Hash,
+        hasher Hasher) Hash {
+    output := leaf
+    for _, sibling := range branch {
+        if index % 2 == 0 {
+            output = hasher.Hash(bytes.Join(
+                    [][]byte{ output.Bytes(), sibling.Bytes() }, nil))
+        } else {
+            output = hasher.Hash(bytes.Join(
+                    [][]byte{ sibling.Bytes(), output.Bytes() }, nil))
+        }
+        index /= 2
+    }
+    return output
+}
@@ -425,3 +425,21 @@ func Test301MerkleRoot(t *testing.T) {
             "18fd279f3e8558c35ef0b9eda7b1f723d384b7803ce61a8364087ae338597c08",
             "ded873555905e4adc79b13ef6574f6210954d6efd952e680df218e5188445fc2")
 }
+
+func TestMerkleBranch(t *testing.T) {
+    hashes := make([]Hash, 0, 7)
+    for ii := 0; ii < 7; ii++ {
+        hashes = append(hashes, Sha256d([]byte{ byte(ii) }))
+    }
+    tree := MerkleTree(hashes, HashSha256d)
+    root := tree[len(tree)-1]
+
+    for idx, leaf := range hashes {
+        branch := MerkleBranch(hashes, idx, HashSha256d)
+        actual := MerkleRootFromBranch(leaf, idx, branch, HashSha256d)
+        if !root.Equals(actual) {
+            t.Fatalf("branch %d root mismatch: expected %s / actual %s\n", idx,
+                    root.String(), actual.String())
+        }
+    }
+}
@@ -0,0 +1,158 @@
+package bitcoin
+
+import (
+    "errors"
+    "io"
+    "math/big"
+)
+
+const (
+    // Length of a private key in bytes
+    PrivateKeyLen = 32
+    // Offset from a coin's address version byte to its private key version
+    // byte, as in bitcoin and its early derivatives
+    WIFVersionOffset = 128
+    // Suffix on WIF payloads for keys whose address uses the compressed pubkey
+    wifCompressedFlag = 0x01
+)
+
+var (
+    // Error when bytes are not a valid secp256k1 private key
+    ErrBadPrivateKey error = errors.New("private key out of range")
+    // Error when a string is not a wallet import format private key
+    ErrBadWIF error = errors.New("malformed wallet import format key")
+)
+
+// A secp256k1 private key, big endian
+type PrivateKey [PrivateKeyLen]byte
+
+// Generate a new private key from a source of randomness.
+func NewPrivateKey(random io.Reader) (PrivateKey, error) {
+    buf := make([]byte, PrivateKeyLen)
+    for {
+        _, err := io.ReadFull(random, buf)
+        if err != nil {
+            return PrivateKey{}, err
+        }
+        // retry the vanishingly rare values outside [1, n)
+        key, err := PrivateKeyFromBytes(buf)
+        if err == nil {
+            return key, nil
+        }
+    }
+}
+
+// Construct a private key from 32 big endian bytes.
+func PrivateKeyFromBytes(input []byte) (PrivateKey, error) {
+    var output PrivateKey
+    if len(input) != PrivateKeyLen {
+        return output, ErrBadPrivateKey
+    }
+    num := new(big.Int).SetBytes(input)
+    if num.Sign() == 0 || num.Cmp(curveN) >= 0 {
+        return output, ErrBadPrivateKey
+    }
+    copy(output[:], input)
+    return output, nil
+}
+
+// Get the bytes of the private key.
+func (tt PrivateKey) Bytes() []byte {
+    return tt[:]
+}
+
+func (tt PrivateKey) point() curvePoint {
+    return curveBaseMult(new(big.Int).SetBytes(tt[:]))
+}
+
+// Get the compressed public key of the private key.
+func (tt PrivateKey) PublicKey() []byte {
+    return tt.point().compressed()
+}
+
+// Get the uncompressed public key of the private key, as used by old wallets
+// and genesis block outputs.
+func (tt PrivateKey) UncompressedPublicKey() []byte {
+    return tt.point().uncompressed()
+}
+
+// Compute (key + tweak) mod n, as in BIP32 private child derivation.
+func (tt PrivateKey) TweakAdd(tweak []byte) (PrivateKey, error) {
+    tweakNum := new(big.Int).SetBytes(tweak)
+    if tweakNum.Cmp(curveN) >= 0 {
+        return PrivateKey{}, ErrBadPrivateKey
+    }
+    sum := tweakNum.Add(tweakNum, new(big.Int).SetBytes(tt[:]))
+    sum.Mod(sum, curveN)
+    if sum.Sign() == 0 {
+        return PrivateKey{}, ErrBadPrivateKey
+    }
+    var output PrivateKey
+    sumBytes := sum.Bytes()
+    copy(output[PrivateKeyLen-len(sumBytes):], sumBytes)
+    return output, nil
+}
+
+// Compute pubkey + tweak * G in compressed form, as in BIP32 public child
+// derivation.
+func PubkeyTweakAdd(pubkey, tweak []byte) ([]byte, error) {
+    point, err := parsePoint(pubkey)
+    if err != nil {
+        return nil, err
+    }
+    tweakNum := new(big.Int).SetBytes(tweak)
+    if tweakNum.Cmp(curveN) >= 0 {
+        return nil, ErrBadPrivateKey
+    }
+    sum := curveAdd(point, curveBaseMult(tweakNum))
+    if sum.infinity() {
+        return nil, ErrBadPubkey
+    }
+    return sum.compressed(), nil
+}
+
+// Convert a public key to compressed form.
+func CompressPubkey(pubkey []byte) ([]byte, error) {
+    point, err := parsePoint(pubkey)
+    if err != nil {
+        return nil, err
+    }
+    return point.compressed(), nil
+}
+
+// Get the private key version byte of a coin from its address version byte.
+func WIFVersion(pubkeyVersion byte) byte {
+    return pubkeyVersion + WIFVersionOffset
+}
+
+// Encode the private key in wallet import format for a compressed pubkey
+// address.
+func (tt PrivateKey) WIF(version byte) string {
+    return Base58CheckEncode(version, append(tt.Bytes(), wifCompressedFlag))
+}
+
+// Encode the private key in wallet import format for an uncompressed pubkey
+// address.
+func (tt PrivateKey) UncompressedWIF(version byte) string {
+    return Base58CheckEncode(version, tt.Bytes())
+}
+
+// Decode a wallet import format key into its version byte, the key, and
+// whether its address uses the compressed pubkey.
+func ParseWIF(input string) (byte, PrivateKey, bool, error) {
+    version, payload, err := Base58CheckDecode(input)
+    if err != nil {
+        return 0, PrivateKey{}, false, err
+    }
+    compressed := false
+    switch {
+    case len(payload) == PrivateKeyLen + 1 &&
+            payload[PrivateKeyLen] == wifCompressedFlag:
+        compressed = true
+        payload = payload[:PrivateKeyLen]
+    case len(payload) != PrivateKeyLen:
+        return 0, PrivateKey{}, false, ErrBadWIF
+    }
+    key, err := PrivateKeyFromBytes(payload)
+    return version, key, compressed, err
+}
@@ -0,0 +1,52 @@
+package bitcoin
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestKeys(t *testing.T) {
+    one := make([]byte, PrivateKeyLen)
+    one[PrivateKeyLen-1] = 1
+    key, err := PrivateKeyFromBytes(one)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    address := PubkeyAddress(0, key.PublicKey()).String()
+    if address != "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH" {
+        t.Fatalf("compressed address mismatch: %s\n", address)
+    }
+    address = PubkeyAddress(0, key.UncompressedPublicKey()).String()
+    if address != "1EHNa6Q4Jz2uvNExL497mE43ikXhwF6kZm" {
+        t.Fatalf("uncompressed address mismatch: %s\n", address)
+    }
+
+    wif := key.WIF(WIFVersion(0))
+    if wif != "KwDiBf89QgGbjEhKnhXJuH7LrciVrZi3qYjgd9M7rFU73sVHnoWn" {
+        t.Fatalf("WIF mismatch: %s\n", wif)
+    }
+    version, parsed, compressed, err := ParseWIF(wif)
+    if err != nil || version != 128 || parsed != key || !compressed {
+        t.Fatalf("WIF round trip failed: %v\n", err)
+    }
+
+    // (1 + 1)G computed both ways
+    doubled, err := key.TweakAdd(one)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tweaked, err := PubkeyTweakAdd(key.PublicKey(), one)
+    if err != nil || !bytes.Equal(tweaked, doubled.PublicKey()) {
+        t.Fatalf("tweak mismatch: %v\n", err)
+    }
+    compressed2, err := CompressPubkey(doubled.UncompressedPublicKey())
+    if err != nil || !bytes.Equal(compressed2, tweaked) {
+        t.Fatalf("compression mismatch: %v\n", err)
+    }
+
+    _, err = PrivateKeyFromBytes(make([]byte, PrivateKeyLen))
+    if err != ErrBadPrivateKey {
+        t.Fatalf("expected bad key error, got %v\n", err)
+    }
+}
@@ -0,0 +1,231 @@
+package bitcoin
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// Script opcodes referred to directly by name
+const (
+    Op0 = 0x00
+    OpPushData1 = 0x4c
+    OpPushData2 = 0x4d
+    OpPushData4 = 0x4e
+    Op1Negate = 0x4f
+    Op1 = 0x51
+    Op16 = 0x60
+    OpReturn = 0x6a
+    OpDup = 0x76
+    OpEqual = 0x87
+    OpEqualVerify = 0x88
+    OpHash160 = 0xa9
+    OpChecksig = 0xac
+    OpCheckMultisig = 0xae
+)
+
+var (
+    // Error when a script push runs past the end of the script
+    ErrScriptTruncated error = errors.New("script push exceeds script length")
+)
+
+// Names of the non-push opcodes, as printed by bitcoind's decodescript
+var opNames = map[byte]string {
+    0x4f: "OP_1NEGATE", 0x50: "OP_RESERVED",
+    0x61: "OP_NOP", 0x62: "OP_VER", 0x63: "OP_IF", 0x64: "OP_NOTIF",
+    0x65: "OP_VERIF", 0x66: "OP_VERNOTIF", 0x67: "OP_ELSE", 0x68: "OP_ENDIF",
+    0x69: "OP_VERIFY", 0x6a: "OP_RETURN",
+    0x6b: "OP_TOALTSTACK", 0x6c: "OP_FROMALTSTACK", 0x6d: "OP_2DROP",
+    0x6e: "OP_2DUP", 0x6f: "OP_3DUP", 0x70: "OP_2OVER", 0x71: "OP_2ROT",
+    0x72: "OP_2SWAP", 0x73: "OP_IFDUP", 0x74: "OP_DEPTH", 0x75: "OP_DROP",
+    0x76: "OP_DUP", 0x77: "OP_NIP", 0x78: "OP_OVER", 0x79: "OP_PICK",
+    0x7a: "OP_ROLL", 0x7b: "OP_ROT", 0x7c: "OP_SWAP", 0x7d: "OP_TUCK",
+    0x7e: "OP_CAT", 0x7f: "OP_SUBSTR", 0x80: "OP_LEFT", 0x81: "OP_RIGHT",
+    0x82: "OP_SIZE", 0x83: "OP_INVERT", 0x84: "OP_AND", 0x85: "OP_OR",
+    0x86: "OP_XOR", 0x87: "OP_EQUAL", 0x88: "OP_EQUALVERIFY",
+    0x89: "OP_RESERVED1", 0x8a: "OP_RESERVED2",
+    0x8b: "OP_1ADD", 0x8c: "OP_1SUB", 0x8d: "OP_2MUL", 0x8e: "OP_2DIV",
+    0x8f: "OP_NEGATE", 0x90: "OP_ABS", 0x91: "OP_NOT", 0x92: "OP_0NOTEQUAL",
+    0x93: "OP_ADD", 0x94: "OP_SUB", 0x95: "OP_MUL", 0x96: "OP_DIV",
+    0x97: "OP_MOD", 0x98: "OP_LSHIFT", 0x99: "OP_RSHIFT",
+    0x9a: "OP_BOOLAND", 0x9b: "OP_BOOLOR", 0x9c: "OP_NUMEQUAL",
+    0x9d: "OP_NUMEQUALVERIFY", 0x9e: "OP_NUMNOTEQUAL", 0x9f: "OP_LESSTHAN",
+    0xa0: "OP_GREATERTHAN", 0xa1: "OP_LESSTHANOREQUAL",
+    0xa2: "OP_GREATERTHANOREQUAL", 0xa3: "OP_MIN", 0xa4: "OP_MAX",
+    0xa5: "OP_WITHIN", 0xa6: "OP_RIPEMD160", 0xa7: "OP_SHA1",
+    0xa8: "OP_SHA256", 0xa9: "OP_HASH160", 0xaa: "OP_HASH256",
+    0xab: "OP_CODESEPARATOR", 0xac: "OP_CHECKSIG", 0xad: "OP_CHECKSIGVERIFY",
+    0xae: "OP_CHECKMULTISIG", 0xaf: "OP_CHECKMULTISIGVERIFY",
+    0xb0: "OP_NOP1", 0xb1: "OP_NOP2", 0xb2: "OP_NOP3", 0xb3: "OP_NOP4",
+    0xb4: "OP_NOP5", 0xb5: "OP_NOP6", 0xb6: "OP_NOP7", 0xb7: "OP_NOP8",
+    0xb8: "OP_NOP9", 0xb9: "OP_NOP10",
+}
+
+// A single parsed script operation
+type ScriptOp struct {
+    Opcode byte
+    // Data pushed by the operation; nil for non-push opcodes
+    Data []byte
+}
+
+// Split a script into its operations.
+func ParseScript(script []byte) ([]ScriptOp, error) {
+    output := make([]ScriptOp, 0, 8)
+    for idx := 0; idx < len(script); {
+        opcode := script[idx]
+        idx++
+
+        // determine the length of pushed data, if any
+        var pushLen int
+        switch {
+        case opcode > Op0 && opcode < OpPushData1:
+            pushLen = int(opcode)
+        case opcode == OpPushData1:
+            if idx + 1 > len(script) {
+                return output, ErrScriptTruncated
+            }
+            pushLen = int(script[idx])
+            idx++
+        case opcode == OpPushData2:
+            if idx + 2 > len(script) {
+                return output, ErrScriptTruncated
+            }
+            pushLen = int(binary.LittleEndian.Uint16(script[idx:]))
+            idx += 2
+        case opcode == OpPushData4:
+            if idx + 4 > len(script) {
+                return output, ErrScriptTruncated
+            }
+            pushLen = int(binary.LittleEndian.Uint32(script[idx:]))
+            idx += 4
+        default:
+            output = append(output, ScriptOp { opcode, nil })
+            continue
+        }
+
+        if pushLen < 0 || idx + pushLen > len(script) {
+            return output, ErrScriptTruncated
+        }
+        output = append(output, ScriptOp { opcode, script[idx:idx+pushLen] })
+        idx += pushLen
+    }
+    return output, nil
+}
+
+// Get the human readable assembly of a script, in the style of bitcoind's
+// decodescript.  Undecodable trailing bytes are shown as "[error]".
+func DisasmScript(script []byte) string {
+    ops, err := ParseScript(script)
+    words := make([]string, 0, len(ops) + 1)
+    for _, op := range ops {
+        words = append(words, op.String())
+    }
+    if err != nil {
+        words = append(words, "[error]")
+    }
+    return strings.Join(words, " ")
+}
+
+// Get the assembly of a single operation.
+func (tt ScriptOp) String() string {
+    switch {
+    case tt.Data != nil:
+        return hex.EncodeToString(tt.Data)
+    case tt.Opcode == Op0:
+        return "0"
+    case tt.Opcode >= Op1 && tt.Opcode <= Op16:
+        return strconv.Itoa(int(tt.Opcode - Op1 + 1))
+    }
+    if name, ok := opNames[tt.Opcode]; ok {
+        return name
+    }
+    return "OP_UNKNOWN"
+}
+
+// Standard output script forms
+type ScriptClass int
+const (
+    ScriptNonstandard ScriptClass = iota
+    // <pubkey> OP_CHECKSIG, as in genesis coinbase outputs
+    ScriptPubkey
+    // OP_DUP OP_HASH160 <hash160> OP_EQUALVERIFY OP_CHECKSIG
+    ScriptPubkeyHash
+    // OP_HASH160 <hash160> OP_EQUAL
+    ScriptHash
+    // <m> <pubkey>... <n> OP_CHECKMULTISIG
+    ScriptMultisig
+    // OP_RETURN <data>
+    ScriptNullData
+)
+
+var scriptClassNames = []string {
+    "nonstandard", "pubkey", "pubkeyhash", "scripthash", "multisig",
+    "nulldata",
+}
+func (tt ScriptClass) String() string {
+    return scriptClassNames[tt]
+}
+
+func isPubkey(data []byte) bool {
+    return len(data) == PubkeyLen || len(data) == CompPubkeyLen
+}
+
+// Determine the standard form of an output script.
+func ClassifyScript(script []byte) ScriptClass {
+    ops, err := ParseScript(script)
+    if err != nil || len(ops) < 1 {
+        return ScriptNonstandard
+    }
+    switch {
+    case len(ops) == 2 && isPubkey(ops[0].Data) && ops[1].Opcode == OpChecksig:
+        return ScriptPubkey
+    case len(ops) == 5 && ops[0].Opcode == OpDup &&
+            ops[1].Opcode == OpHash160 && len(ops[2].Data) == Hash160Size &&
+            ops[3].Opcode == OpEqualVerify && ops[4].Opcode == OpChecksig:
+        return ScriptPubkeyHash
+    case len(ops) == 3 && ops[0].Opcode == OpHash160 &&
+            len(ops[1].Data) == Hash160Size && ops[2].Opcode == OpEqual:
+        return ScriptHash
+    case ops[0].Opcode == OpReturn:
+        return ScriptNullData
+    case len(ops) >= 4 && ops[len(ops)-1].Opcode == OpCheckMultisig:
+        m, n := ops[0].Opcode, ops[len(ops)-2].Opcode
+        if m < Op1 || m > Op16 || n < m || n > Op16 ||
+                int(n - Op1 + 1) != len(ops) - 3 {
+            return ScriptNonstandard
+        }
+        for _, op := range ops[1:len(ops)-2] {
+            if !isPubkey(op.Data) {
+                return ScriptNonstandard
+            }
+        }
+        return ScriptMultisig
+    }
+    return ScriptNonstandard
+}
+
+// Get the address an output script pays to, encoded with the coin's pubkey
+// and script hash version bytes.  Returns false for scripts that don't pay to
+// a single address.
+func ScriptAddress(script []byte, pubkeyVersion,
+        scriptVersion byte) (Address, bool) {
+    ops, _ := ParseScript(script)
+    switch ClassifyScript(script) {
+    case ScriptPubkey:
+        return NewAddress(pubkeyVersion, Hash160(ops[0].Data)), true
+    case ScriptPubkeyHash:
+        return addressFromSlice(pubkeyVersion, ops[2].Data), true
+    case ScriptHash:
+        return addressFromSlice(scriptVersion, ops[1].Data), true
+    }
+    return Address{}, false
+}
+
+// Construct an output script paying to a pubkey hash.
+func PubkeyHashScript(hash [Hash160Size]byte) []byte {
+    output := []byte { OpDup, OpHash160, Hash160Size }
+    output = append(output, hash[:]...)
+    return append(output, OpEqualVerify, OpChecksig)
+}
@@ -0,0 +1,164 @@
+package bitcoin
+
+import (
+    "errors"
+    "math/big"
+)
+
+// Arithmetic on secp256k1, the curve of bitcoin keys.  Go's elliptic package
+// assumes curves with a = -3, but secp256k1 has a = 0, so the few operations
+// needed for key handling are implemented here in affine coordinates.  None
+// of this is constant time; it is meant for generating keys on a machine the
+// keys' owner controls, not for signing on a shared server.
+
+var (
+    // Error when bytes don't encode a point on the curve
+    ErrBadPubkey error = errors.New("malformed public key")
+
+    // field prime
+    curveP = fromHex(
+            "fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+    // group order
+    curveN = fromHex(
+            "fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+    curveB = big.NewInt(7)
+    curveGx = fromHex(
+            "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+    curveGy = fromHex(
+            "483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+)
+
+func fromHex(input string) *big.Int {
+    output, ok := new(big.Int).SetString(input, 16)
+    if !ok {
+        panic("bad curve constant " + input)
+    }
+    return output
+}
+
+// a point on the curve; nil coordinates are the point at infinity
+type curvePoint struct {
+    x *big.Int
+    y *big.Int
+}
+
+func (tt curvePoint) infinity() bool {
+    return tt.x == nil
+}
+
+func curveAdd(p1, p2 curvePoint) curvePoint {
+    if p1.infinity() {
+        return p2
+    }
+    if p2.infinity() {
+        return p1
+    }
+
+    var slope *big.Int
+    if p1.x.Cmp(p2.x) == 0 {
+        if p1.y.Cmp(p2.y) != 0 || p1.y.Sign() == 0 {
+            // p2 is -p1
+            return curvePoint{}
+        }
+        // tangent: 3x^2 / 2y
+        numer := new(big.Int).Mul(p1.x, p1.x)
+        numer.Mul(numer, big.NewInt(3))
+        denom := new(big.Int).Lsh(p1.y, 1)
+        slope = numer.Mul(numer, denom.ModInverse(denom, curveP))
+    } else {
+        numer := new(big.Int).Sub(p2.y, p1.y)
+        denom := new(big.Int).Sub(p2.x, p1.x)
+        denom.Mod(denom, curveP)
+        slope = numer.Mul(numer, denom.ModInverse(denom, curveP))
+    }
+    slope.Mod(slope, curveP)
+
+    x := new(big.Int).Mul(slope, slope)
+    x.Sub(x, p1.x)
+    x.Sub(x, p2.x)
+    x.Mod(x, curveP)
+    y := new(big.Int).Sub(p1.x, x)
+    y.Mul(y, slope)
+    y.Sub(y, p1.y)
+    y.Mod(y, curveP)
+    return curvePoint { x, y }
+}
+
+func curveMult(point curvePoint, scalar *big.Int) curvePoint {
+    output := curvePoint{}
+    for ii := scalar.BitLen() - 1; ii >= 0; ii-- {
+        output = curveAdd(output, output)
+        if scalar.Bit(ii) == 1 {
+            output = curveAdd(output, point)
+        }
+    }
+    return output
+}
+
+func curveBaseMult(scalar *big.Int) curvePoint {
+    return curveMult(curvePoint { curveGx, curveGy }, scalar)
+}
+
+// Serialize a point in 33 byte compressed form.
+func (tt curvePoint) compressed() []byte {
+    output := make([]byte, 33)
+    output[0] = 0x02 + byte(tt.y.Bit(0))
+    xBytes := tt.x.Bytes()
+    copy(output[33-len(xBytes):], xBytes)
+    return output
+}
+
+// Serialize a point in 65 byte uncompressed form.
+func (tt curvePoint) uncompressed() []byte {
+    output := make([]byte, 65)
+    output[0] = 0x04
+    xBytes := tt.x.Bytes()
+    yBytes := tt.y.Bytes()
+    copy(output[33-len(xBytes):33], xBytes)
+    copy(output[65-len(yBytes):], yBytes)
+    return output
+}
+
+// Parse a compressed or uncompressed public key into a curve point.
+func parsePoint(input []byte) (curvePoint, error) {
+    switch {
+    case len(input) == 33 && (input[0] == 0x02 || input[0] == 0x03):
+        x := new(big.Int).SetBytes(input[1:])
+        if x.Cmp(curveP) >= 0 {
+            return curvePoint{}, ErrBadPubkey
+        }
+        // y^2 = x^3 + 7; p = 3 mod 4 so a square root is c^((p+1)/4)
+        ySquared := new(big.Int).Exp(x, big.NewInt(3), curveP)
+        ySquared.Add(ySquared, curveB)
+        ySquared.Mod(ySquared, curveP)
+        exp := new(big.Int).Add(curveP, big.NewInt(1))
+        exp.Rsh(exp, 2)
+        y := new(big.Int).Exp(ySquared, exp, curveP)
+        if new(big.Int).Exp(y, big.NewInt(2), curveP).Cmp(ySquared) != 0 {
+            return curvePoint{}, ErrBadPubkey
+        }
+        if y.Bit(0) != uint(input[0] & 1) {
+            y.Sub(curveP, y)
+        }
+        return curvePoint { x, y }, nil
+    case len(input) == 65 && input[0] == 0x04:
+        point := curvePoint { new(big.Int).SetBytes(input[1:33]),
+                new(big.Int).SetBytes(input[33:]) }
+        if !point.onCurve() {
+            return curvePoint{}, ErrBadPubkey
+        }
+        return point, nil
+    }
+    return curvePoint{}, ErrBadPubkey
+}
+
+func (tt curvePoint) onCurve() bool {
+    if tt.x.Cmp(curveP) >= 0 || tt.y.Cmp(curveP) >= 0 {
+        return false
+    }
+    left := new(big.Int).Exp(tt.y, big.NewInt(2), curveP)
+    right := new(big.Int).Exp(tt.x, big.NewInt(3), curveP)
+    right.Add(right, curveB)
+    right.Mod(right, curveP)
+    return left.Cmp(right) == 0
+}
@@ -19,10 +19,18 @@ const (
     PubkeyLen = 65
     // The length of a compressed public key in bytes
     CompPubkeyLen = 33
+    // Largest script accepted when decoding a transaction
+    MaxScriptLen = 10000
+    // Most inputs or outputs accepted when decoding a transaction
+    MaxTxInOuts = 100000
 )
 
 // A bitcoin transaction
 type Tx struct {
+    // version and lockTime are only set for decoded transactions; zero values
+    // serialize as TxVersion and UnlockedTime
+    version uint32
+    lockTime uint32
     inputs [][]byte
     outputs [][]byte
 }
@@ -32,7 +40,11 @@ func (t *Tx) WriteBytes(out io.Writer) (int, error) {
     outCount := 0
 
     // version bytes
-    err := binary.Write(out, binary.LittleEndian, uint32(TxVersion))
+    version := t.version
+    if version == 0 {
+        version = TxVersion
+    }
+    err := binary.Write(out, binary.LittleEndian, version)
     if err != nil {
         return outCount, err
     }
@@ -55,7 +67,7 @@ func (t *Tx) WriteBytes(out io.Writer) (int, error) {
         }
     }
     // locktime
-    err = binary.Write(out, binary.LittleEndian, uint32(UnlockedTime))
+    err = binary.Write(out, binary.LittleEndian, t.lockTime)
     if err != nil {
         return outCount, err
     }
@@ -74,6 +86,11 @@ func (t *Tx) Bytes() []byte {
     return buf.Bytes()
 }
 
+// Compute the double SHA-256 hash of a transaction (the transaction's id).
+func (t *Tx) Hash() Hash {
+    return Sha256d(t.Bytes())
+}
+
 // Construct a new input and append it to the transaction.
 func (t *Tx) Input(srcTx Hash, outputIdx uint, scriptSig []byte) *Tx {
     buf := new(bytes.Buffer)
@@ -95,3 +112,126 @@ func (t *Tx) Output(value uint64, scriptPubKey []byte) *Tx {
     t.outputs = append(t.outputs, buf.Bytes())
     return t
 }
+
+// A decoded transaction input
+type TxIn struct {
+    // Hash of the transaction whose output is being spent
+    PrevTx Hash
+    // Index of the output being spent in the previous transaction
+    PrevIndex uint32
+    // Script satisfying the spent output's script
+    ScriptSig []byte
+    Sequence uint32
+}
+
+// Whether the input creates new coins rather than spending an output.
+func (tt TxIn) IsCoinbase() bool {
+    return tt.PrevTx == Hash{} && tt.PrevIndex == 0xffffffff
+}
+
+// A decoded transaction output
+type TxOut struct {
+    // Amount in value units (satoshis)
+    Value uint64
+    // Script that must be satisfied to spend the output
+    ScriptPubKey []byte
+}
+
+// Get the decoded inputs of a transaction.
+func (t *Tx) Inputs() []TxIn {
+    output := make([]TxIn, 0, len(t.inputs))
+    for _, raw := range t.inputs {
+        var in TxIn
+        reader := bytes.NewReader(raw)
+        reader.Read(in.PrevTx[:])
+        binary.Read(reader, binary.LittleEndian, &in.PrevIndex)
+        in.ScriptSig, _ = ReadVarBytes(reader, MaxScriptLen)
+        binary.Read(reader, binary.LittleEndian, &in.Sequence)
+        output = append(output, in)
+    }
+    return output
+}
+
+// Get the decoded outputs of a transaction.
+func (t *Tx) Outputs() []TxOut {
+    output := make([]TxOut, 0, len(t.outputs))
+    for _, raw := range t.outputs {
+        var out TxOut
+        reader := bytes.NewReader(raw)
+        binary.Read(reader, binary.LittleEndian, &out.Value)
+        out.ScriptPubKey, _ = ReadVarBytes(reader, MaxScriptLen)
+        output = append(output, out)
+    }
+    return output
+}
+
+// Get the transaction lock time.
+func (t *Tx) LockTime() uint32 {
+    return t.lockTime
+}
+
+// Read in the serialization of a transaction.
+func ReadTx(input io.Reader) (*Tx, error) {
+    output := new(Tx)
+
+    // version bytes
+    err := binary.Read(input, binary.LittleEndian, &output.version)
+    if err != nil {
+        return nil, err
+    }
+    // inputs: previous output, script, sequence
+    count, err := ReadVarint(input)
+    if err != nil {
+        return nil, err
+    }
+    if count > MaxTxInOuts {
+        return nil, ErrLengthOverflow
+    }
+    for ii := uint64(0); ii < count; ii++ {
+        buf := new(bytes.Buffer)
+        _, err = io.CopyN(buf, input, HashSize + 4)
+        if err != nil {
+            return nil, err
+        }
+        script, err := ReadVarBytes(input, MaxScriptLen)
+        if err != nil {
+            return nil, err
+        }
+        WriteVarint(buf, uint64(len(script)))
+        buf.Write(script)
+        _, err = io.CopyN(buf, input, 4)
+        if err != nil {
+            return nil, err
+        }
+        output.inputs = append(output.inputs, buf.Bytes())
+    }
+    // outputs: value, script
+    count, err = ReadVarint(input)
+    if err != nil {
+        return nil, err
+    }
+    if count > MaxTxInOuts {
+        return nil, ErrLengthOverflow
+    }
+    for ii := uint64(0); ii < count; ii++ {
+        buf := new(bytes.Buffer)
+        _, err = io.CopyN(buf, input, 8)
+        if err != nil {
+            return nil, err
+        }
+        script, err := ReadVarBytes(input, MaxScriptLen)
+        if err != nil {
+            return nil, err
+        }
+        WriteVarint(buf, uint64(len(script)))
+        buf.Write(script)
+        output.outputs = append(output.outputs, buf.Bytes())
+    }
+    // locktime
+    err = binary.Read(input, binary.LittleEndian, &output.lockTime)
+    if err != nil {
+        return nil, err
+    }
+
+    return output, nil
+}
@@ -3,6 +3,7 @@ package bitcoin
 import (
     "bytes"
     "encoding/binary"
+    "errors"
     "io"
 )
 
@@ -19,6 +20,11 @@ const (
     Precision = 8
 )
 
+var (
+    // Error when a serialized length prefix claims more data than is sane
+    ErrLengthOverflow = errors.New("serialized length is too large")
+)
+
 // Write out the bitcoin variable length int serialization of an integer.
 func WriteVarint(out io.Writer, value uint64) (int, error) {
     // 0xfd, 0xfe, 0xff are magic values; all lower values are serialized
@@ -75,3 +81,46 @@ func Varint(value uint64) []byte {
     }
     return buf.Bytes()
 }
+
+// Read in a bitcoin variable length int serialization of an integer.
+func ReadVarint(input io.Reader) (uint64, error) {
+    var magic [1]byte
+    _, err := io.ReadFull(input, magic[:])
+    if err != nil {
+        return 0, err
+    }
+    switch magic[0] {
+    case 0xfd:
+        var value uint16
+        err = binary.Read(input, binary.LittleEndian, &value)
+        return uint64(value), err
+    case 0xfe:
+        var value uint32
+        err = binary.Read(input, binary.LittleEndian, &value)
+        return uint64(value), err
+    case 0xff:
+        var value uint64
+        err = binary.Read(input, binary.LittleEndian, &value)
+        return value, err
+    default:
+        return uint64(magic[0]), nil
+    }
+}
+
+// Read in a varint length prefix followed by that many bytes, refusing
+// lengths beyond limit.
+func ReadVarBytes(input io.Reader, limit uint64) ([]byte, error) {
+    length, err := ReadVarint(input)
+    if err != nil {
+        return nil, err
+    }
+    if length > limit {
+        return nil, ErrLengthOverflow
+    }
+    output := make([]byte, length)
+    _, err = io.ReadFull(input, output)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
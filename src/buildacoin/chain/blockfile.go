@@ -0,0 +1,167 @@
+package chain
+
+import (
+    "bufio"
+    "buildacoin/bitcoin"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+const (
+    // Largest block record accepted from a block file, in bytes
+    MaxBlockRecordLen = 32 * 1024 * 1024
+    // Length of the magic + length prefix of each block record, in bytes
+    RecordHeaderLen = 8
+)
+
+var (
+    // Error when a network magic string isn't 4 bytes of hex
+    ErrBadMagic error = errors.New("network magic must be 4 bytes of hex")
+    // Error when a block location doesn't hold a block record
+    ErrBadRecord error = errors.New("no block record at location")
+)
+
+// Network magic bytes that prefix each block record in a coin's block files,
+// in file order
+type Magic [4]byte
+
+// Magic of bitcoin's main network
+var BitcoinMagic = Magic { 0xf9, 0xbe, 0xb4, 0xd9 }
+
+// Parse network magic from hex in file order, e.g. "fbc0b6db" for litecoin.
+func ParseMagic(input string) (Magic, error) {
+    var output Magic
+    raw, err := hex.DecodeString(input)
+    if err != nil || len(raw) != len(output) {
+        return output, ErrBadMagic
+    }
+    copy(output[:], raw)
+    return output, nil
+}
+
+// Where a block's serialization is kept among a coin's block files
+type BlockLoc struct {
+    // NNNNN in blkNNNNN.dat
+    File uint32
+    // Offset of the block serialization, just past the record header
+    Offset uint32
+    // Length of the block serialization
+    Len uint32
+}
+
+// Get the name of a block file by number.
+func BlockFileName(file uint32) string {
+    return fmt.Sprintf("blk%05d.dat", file)
+}
+
+// List the numbers of consecutive block files in dir, starting with 0.
+func BlockFiles(dir string) ([]uint32, error) {
+    output := []uint32 {}
+    for {
+        file := uint32(len(output))
+        _, err := os.Stat(filepath.Join(dir, BlockFileName(file)))
+        if os.IsNotExist(err) {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        output = append(output, file)
+    }
+    return output, nil
+}
+
+// Scan a block file for magic + length records and pass each record's body
+// to visit along with its location.  Anything between records, such as the
+// zero padding daemons preallocate files with or the tail of a record cut off
+// by a crash, is skipped over by searching for the next magic.
+func ScanBlockFile(input io.Reader, file uint32, magic Magic,
+        visit func(loc BlockLoc, raw []byte) error) error {
+    reader := bufio.NewReader(input)
+    offset := uint32(0)
+    matched := 0
+    for {
+        // find the next occurrence of magic a byte at a time
+        b, err := reader.ReadByte()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        offset++
+        if b == magic[matched] {
+            matched++
+        } else if b == magic[0] {
+            matched = 1
+        } else {
+            matched = 0
+        }
+        if matched < len(magic) {
+            continue
+        }
+        matched = 0
+
+        var length uint32
+        err = binary.Read(reader, binary.LittleEndian, &length)
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        offset += 4
+        if length < bitcoin.HeaderLen || length > MaxBlockRecordLen {
+            continue
+        }
+
+        raw := make([]byte, length)
+        n, err := io.ReadFull(reader, raw)
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            // truncated final record
+            return nil
+        } else if err != nil {
+            return err
+        }
+        err = visit(BlockLoc { file, offset, length }, raw)
+        if err != nil {
+            return err
+        }
+        offset += uint32(n)
+    }
+}
+
+// Read the block serialization at a location among the block files in dir.
+func ReadBlockAt(dir string, loc BlockLoc) (*bitcoin.Block, error) {
+    file, err := os.Open(filepath.Join(dir, BlockFileName(loc.File)))
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    if loc.Offset < RecordHeaderLen {
+        return nil, ErrBadRecord
+    }
+    raw := make([]byte, RecordHeaderLen + loc.Len)
+    _, err = file.ReadAt(raw, int64(loc.Offset - RecordHeaderLen))
+    if err != nil {
+        return nil, err
+    }
+    if binary.LittleEndian.Uint32(raw[4:RecordHeaderLen]) != loc.Len {
+        return nil, ErrBadRecord
+    }
+    return bitcoin.ParseBlock(raw[RecordHeaderLen:])
+}
+
+// Write a block as a magic + length record, as daemons do in block files.
+func WriteBlockRecord(output io.Writer, magic Magic,
+        block *bitcoin.Block) error {
+    raw := block.Bytes()
+    header := make([]byte, RecordHeaderLen)
+    copy(header, magic[:])
+    binary.LittleEndian.PutUint32(header[4:], uint32(len(raw)))
+    _, err := output.Write(append(header, raw...))
+    return err
+}
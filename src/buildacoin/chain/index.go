@@ -0,0 +1,519 @@
+package chain
+
+import (
+    "bufio"
+    "buildacoin/bitcoin"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "io"
+    "math/big"
+    "os"
+    "path/filepath"
+    "sort"
+)
+
+const (
+    // Name of the index file kept alongside block files by default
+    DefaultIndexName = "buildacoin.idx"
+    // Version of the index file format written by BuildIndex
+    IndexVersion = 1
+
+    indexMagic = "BACI"
+    // magic, version, then counts of heights, txs and address entries
+    indexHeaderLen = 4 + 4 * 4
+    // block hash, file, offset, length
+    heightEntryLen = bitcoin.HashSize + 3 * 4
+    // txid, height
+    txEntryLen = bitcoin.HashSize + 4
+    // pubkey or script hash, height, position in block
+    addrEntryLen = bitcoin.Hash160Size + 2 * 4
+)
+
+var (
+    // Error when an index file is not an index or of an unknown version
+    ErrBadIndex error = errors.New("not a block file index")
+    // Error when block files hold no chain connected to a genesis block
+    ErrNoGenesis error = errors.New("no genesis block in block files")
+)
+
+// a block header seen while scanning block files
+type scannedBlock struct {
+    hash bitcoin.Hash
+    prev bitcoin.Hash
+    loc BlockLoc
+    work *big.Int
+    // total work of the chain ending in this block, nil until known
+    chainWork *big.Int
+    connected bool
+}
+
+type txEntry struct {
+    txid bitcoin.Hash
+    height uint32
+}
+
+type addrEntry struct {
+    hash [bitcoin.Hash160Size]byte
+    height uint32
+    pos uint32
+}
+
+// Build an index of the best chain in the block files in dir and write it to
+// output.  Blocks are ordered by their prevBlock links rather than by file
+// position, since daemons may store blocks out of order; the best chain is
+// the connected chain with the most total work.
+func BuildIndex(dir string, magic Magic, output io.Writer) error {
+    files, err := BlockFiles(dir)
+    if err != nil {
+        return err
+    }
+
+    // first pass: collect headers only
+    blocks := make(map[bitcoin.Hash]*scannedBlock)
+    order := []*scannedBlock {}
+    for _, fileNum := range files {
+        file, err := os.Open(filepath.Join(dir, BlockFileName(fileNum)))
+        if err != nil {
+            return err
+        }
+        err = ScanBlockFile(file, fileNum, magic,
+                func(loc BlockLoc, raw []byte) error {
+            header, err := bitcoin.ReadHeader(bytes.NewReader(raw))
+            if err != nil {
+                // not a block after all; keep scanning
+                return nil
+            }
+            hash := header.Hash()
+            if _, ok := blocks[hash]; ok {
+                return nil
+            }
+            scanned := &scannedBlock {
+                hash: hash,
+                prev: header.PrevBlock(),
+                loc: loc,
+                work: blockWork(header.TargetBits()),
+            }
+            blocks[hash] = scanned
+            order = append(order, scanned)
+            return nil
+        })
+        file.Close()
+        if err != nil {
+            return err
+        }
+    }
+
+    best := bestChainTip(blocks, order)
+    if best == nil {
+        return ErrNoGenesis
+    }
+    chain := []*scannedBlock {}
+    for block := best; ; block = blocks[block.prev] {
+        chain = append(chain, block)
+        if block.prev == (bitcoin.Hash{}) {
+            break
+        }
+    }
+    for ii, jj := 0, len(chain)-1; ii < jj; ii, jj = ii+1, jj-1 {
+        chain[ii], chain[jj] = chain[jj], chain[ii]
+    }
+
+    // second pass: index the transactions of the best chain
+    txs := []txEntry {}
+    addrs := []addrEntry {}
+    for height, scanned := range chain {
+        block, err := ReadBlockAt(dir, scanned.loc)
+        if err != nil {
+            return err
+        }
+        for pos, tx := range block.Txs() {
+            txs = append(txs, txEntry { tx.Hash(), uint32(height) })
+            seen := make(map[[bitcoin.Hash160Size]byte]bool)
+            for _, out := range tx.Outputs() {
+                address, ok := bitcoin.ScriptAddress(out.ScriptPubKey, 0, 0)
+                if !ok || seen[address.Hash] {
+                    continue
+                }
+                seen[address.Hash] = true
+                addrs = append(addrs, addrEntry { address.Hash,
+                        uint32(height), uint32(pos) })
+            }
+        }
+    }
+    sort.Slice(txs, func(ii, jj int) bool {
+        return bytes.Compare(txs[ii].txid[:], txs[jj].txid[:]) < 0
+    })
+    sort.SliceStable(addrs, func(ii, jj int) bool {
+        return bytes.Compare(addrs[ii].hash[:], addrs[jj].hash[:]) < 0
+    })
+
+    return writeIndex(output, chain, txs, addrs)
+}
+
+// Get the expected number of hashes needed to find a block at target bits.
+func blockWork(bits uint32) *big.Int {
+    target := bitcoin.TargetFull(bits)
+    target.Add(target, big.NewInt(1))
+    return target.Div(new(big.Int).Lsh(big.NewInt(1), 256), target)
+}
+
+// Find the tip of the connected chain with the most work, preferring the tip
+// seen first among equals.
+func bestChainTip(blocks map[bitcoin.Hash]*scannedBlock,
+        order []*scannedBlock) *scannedBlock {
+    var best *scannedBlock
+    for _, tip := range order {
+        // walk back to a block of known chain work or a chain's end
+        path := []*scannedBlock {}
+        block := tip
+        for block != nil && block.chainWork == nil {
+            path = append(path, block)
+            if block.prev == (bitcoin.Hash{}) {
+                block.connected = true
+                block.chainWork = new(big.Int).Set(block.work)
+                path = path[:len(path)-1]
+                break
+            }
+            parent, ok := blocks[block.prev]
+            // orphans, whose ancestors never made it to disk, are cut off
+            if !ok {
+                block = nil
+                break
+            }
+            block = parent
+        }
+        // then fill in chain work forwards along the path
+        for ii := len(path) - 1; ii >= 0; ii-- {
+            if block == nil || !block.connected {
+                path[ii].chainWork = new(big.Int)
+                continue
+            }
+            path[ii].connected = true
+            path[ii].chainWork = new(big.Int).Add(block.chainWork,
+                    path[ii].work)
+            block = path[ii]
+        }
+        if tip.connected && (best == nil ||
+                tip.chainWork.Cmp(best.chainWork) > 0) {
+            best = tip
+        }
+    }
+    return best
+}
+
+func writeIndex(output io.Writer, chain []*scannedBlock, txs []txEntry,
+        addrs []addrEntry) error {
+    writer := bufio.NewWriter(output)
+    header := make([]byte, indexHeaderLen)
+    copy(header, indexMagic)
+    binary.LittleEndian.PutUint32(header[4:], IndexVersion)
+    binary.LittleEndian.PutUint32(header[8:], uint32(len(chain)))
+    binary.LittleEndian.PutUint32(header[12:], uint32(len(txs)))
+    binary.LittleEndian.PutUint32(header[16:], uint32(len(addrs)))
+    writer.Write(header)
+
+    entry := make([]byte, heightEntryLen)
+    for _, block := range chain {
+        copy(entry, block.hash[:])
+        binary.LittleEndian.PutUint32(entry[32:], block.loc.File)
+        binary.LittleEndian.PutUint32(entry[36:], block.loc.Offset)
+        binary.LittleEndian.PutUint32(entry[40:], block.loc.Len)
+        writer.Write(entry)
+    }
+    entry = make([]byte, txEntryLen)
+    for _, tx := range txs {
+        copy(entry, tx.txid[:])
+        binary.LittleEndian.PutUint32(entry[32:], tx.height)
+        writer.Write(entry)
+    }
+    entry = make([]byte, addrEntryLen)
+    for _, addr := range addrs {
+        copy(entry, addr.hash[:])
+        binary.LittleEndian.PutUint32(entry[20:], addr.height)
+        binary.LittleEndian.PutUint32(entry[24:], addr.pos)
+        writer.Write(entry)
+    }
+    return writer.Flush()
+}
+
+// Build the index of the block files in dir and store it at path, replacing
+// any existing index only once the new one is complete.
+func WriteIndexFile(dir string, magic Magic, path string) error {
+    tmpPath := path + ".tmp"
+    file, err := os.Create(tmpPath)
+    if err != nil {
+        return err
+    }
+    err = BuildIndex(dir, magic, file)
+    if err == nil {
+        err = file.Sync()
+    }
+    closeErr := file.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+    return os.Rename(tmpPath, path)
+}
+
+//
+// Index lookups
+//
+
+// An open block file index.  Block locations by height are held in memory;
+// transactions and addresses are binary searched in the file.
+type Index struct {
+    file *os.File
+    hashes []bitcoin.Hash
+    locs []BlockLoc
+    heights map[bitcoin.Hash]int64
+    txCount int
+    addrCount int
+    txStart int64
+    addrStart int64
+}
+
+// Open an index written by WriteIndexFile.
+func OpenIndex(path string) (*Index, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    output, err := readIndex(file)
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return output, nil
+}
+
+func readIndex(file *os.File) (*Index, error) {
+    reader := bufio.NewReader(file)
+    header := make([]byte, indexHeaderLen)
+    _, err := io.ReadFull(reader, header)
+    if err != nil {
+        return nil, ErrBadIndex
+    }
+    if string(header[:4]) != indexMagic ||
+            binary.LittleEndian.Uint32(header[4:]) != IndexVersion {
+        return nil, ErrBadIndex
+    }
+    heightCount := int(binary.LittleEndian.Uint32(header[8:]))
+
+    output := &Index {
+        file: file,
+        hashes: make([]bitcoin.Hash, heightCount),
+        locs: make([]BlockLoc, heightCount),
+        heights: make(map[bitcoin.Hash]int64, heightCount),
+        txCount: int(binary.LittleEndian.Uint32(header[12:])),
+        addrCount: int(binary.LittleEndian.Uint32(header[16:])),
+    }
+    entry := make([]byte, heightEntryLen)
+    for ii := 0; ii < heightCount; ii++ {
+        _, err = io.ReadFull(reader, entry)
+        if err != nil {
+            return nil, ErrBadIndex
+        }
+        copy(output.hashes[ii][:], entry)
+        output.locs[ii] = BlockLoc {
+            binary.LittleEndian.Uint32(entry[32:]),
+            binary.LittleEndian.Uint32(entry[36:]),
+            binary.LittleEndian.Uint32(entry[40:]),
+        }
+        output.heights[output.hashes[ii]] = int64(ii)
+    }
+    output.txStart = int64(indexHeaderLen + heightCount * heightEntryLen)
+    output.addrStart = output.txStart + int64(output.txCount * txEntryLen)
+
+    stat, err := file.Stat()
+    if err != nil {
+        return nil, err
+    }
+    if stat.Size() != output.addrStart +
+            int64(output.addrCount * addrEntryLen) {
+        return nil, ErrBadIndex
+    }
+    return output, nil
+}
+
+// Close the index file.
+func (tt *Index) Close() error {
+    return tt.file.Close()
+}
+
+// Get the height of the indexed chain's tip.
+func (tt *Index) BestHeight() int64 {
+    return int64(len(tt.hashes)) - 1
+}
+
+// Get the hash of the block at height.
+func (tt *Index) Hash(height int64) (bitcoin.Hash, bool) {
+    if height < 0 || height >= int64(len(tt.hashes)) {
+        return bitcoin.Hash{}, false
+    }
+    return tt.hashes[height], true
+}
+
+// Get the block file location of the block at height.
+func (tt *Index) Loc(height int64) (BlockLoc, bool) {
+    if height < 0 || height >= int64(len(tt.locs)) {
+        return BlockLoc{}, false
+    }
+    return tt.locs[height], true
+}
+
+// Get the height of a best chain block by hash.
+func (tt *Index) Height(hash bitcoin.Hash) (int64, bool) {
+    height, ok := tt.heights[hash]
+    return height, ok
+}
+
+// Get the height of the block containing a transaction.
+func (tt *Index) TxHeight(txid bitcoin.Hash) (int64, bool, error) {
+    entry := make([]byte, txEntryLen)
+    var readErr error
+    idx := sort.Search(tt.txCount, func(ii int) bool {
+        _, err := tt.file.ReadAt(entry, tt.txStart + int64(ii * txEntryLen))
+        if err != nil {
+            readErr = err
+            return true
+        }
+        return bytes.Compare(entry[:bitcoin.HashSize], txid[:]) >= 0
+    })
+    if readErr != nil {
+        return 0, false, readErr
+    }
+    if idx >= tt.txCount {
+        return 0, false, nil
+    }
+    _, err := tt.file.ReadAt(entry, tt.txStart + int64(idx * txEntryLen))
+    if err != nil {
+        return 0, false, err
+    }
+    if !bytes.Equal(entry[:bitcoin.HashSize], txid[:]) {
+        return 0, false, nil
+    }
+    return int64(binary.LittleEndian.Uint32(entry[bitcoin.HashSize:])), true,
+            nil
+}
+
+// Get the heights and in-block positions of transactions paying a pubkey or
+// script hash, oldest first.
+func (tt *Index) addressEntries(hash [bitcoin.Hash160Size]byte) ([]addrEntry,
+        error) {
+    entry := make([]byte, addrEntryLen)
+    var readErr error
+    readEntry := func(ii int) bool {
+        _, err := tt.file.ReadAt(entry,
+                tt.addrStart + int64(ii * addrEntryLen))
+        if err != nil {
+            readErr = err
+            return false
+        }
+        return true
+    }
+    idx := sort.Search(tt.addrCount, func(ii int) bool {
+        if !readEntry(ii) {
+            return true
+        }
+        return bytes.Compare(entry[:bitcoin.Hash160Size], hash[:]) >= 0
+    })
+
+    output := []addrEntry {}
+    for ; readErr == nil && idx < tt.addrCount && readEntry(idx); idx++ {
+        if !bytes.Equal(entry[:bitcoin.Hash160Size], hash[:]) {
+            break
+        }
+        output = append(output, addrEntry { hash,
+                binary.LittleEndian.Uint32(entry[20:]),
+                binary.LittleEndian.Uint32(entry[24:]) })
+    }
+    return output, readErr
+}
+
+//
+// Block file chain source
+//
+
+// Chain source reading a coin's block files directly through an index, for
+// chains with no live daemon
+type BlockFileSource struct {
+    dir string
+    index *Index
+}
+
+// Construct a chain source over the block files in dir.
+func NewBlockFileSource(dir string, index *Index) *BlockFileSource {
+    return &BlockFileSource { dir, index }
+}
+
+func (tt *BlockFileSource) BestHeight() (int64, error) {
+    return tt.index.BestHeight(), nil
+}
+
+func (tt *BlockFileSource) BlockHash(height int64) (bitcoin.Hash, error) {
+    hash, ok := tt.index.Hash(height)
+    if !ok {
+        return hash, ErrNotFound
+    }
+    return hash, nil
+}
+
+func (tt *BlockFileSource) Block(hash bitcoin.Hash) (*bitcoin.Block, int64,
+        error) {
+    height, ok := tt.index.Height(hash)
+    if !ok {
+        return nil, 0, ErrNotFound
+    }
+    block, err := tt.blockAt(height)
+    return block, height, err
+}
+
+func (tt *BlockFileSource) TxBlock(txid bitcoin.Hash) (bitcoin.Hash, error) {
+    height, ok, err := tt.index.TxHeight(txid)
+    if err != nil {
+        return bitcoin.Hash{}, err
+    }
+    if !ok {
+        return bitcoin.Hash{}, ErrNotFound
+    }
+    return tt.BlockHash(height)
+}
+
+func (tt *BlockFileSource) AddressTxs(
+        hash [bitcoin.Hash160Size]byte) ([]TxRef, error) {
+    entries, err := tt.index.addressEntries(hash)
+    if err != nil {
+        return nil, err
+    }
+    output := make([]TxRef, 0, len(entries))
+    var block *bitcoin.Block
+    for ii, entry := range entries {
+        height := int64(entry.height)
+        if ii == 0 || entries[ii-1].height != entry.height {
+            block, err = tt.blockAt(height)
+            if err != nil {
+                return nil, err
+            }
+        }
+        txs := block.Txs()
+        if int(entry.pos) >= len(txs) {
+            return nil, ErrBadIndex
+        }
+        output = append(output, TxRef { tt.index.hashes[height], height,
+                txs[entry.pos].Hash() })
+    }
+    return output, nil
+}
+
+func (tt *BlockFileSource) blockAt(height int64) (*bitcoin.Block, error) {
+    loc, ok := tt.index.Loc(height)
+    if !ok {
+        return nil, ErrNotFound
+    }
+    return ReadBlockAt(tt.dir, loc)
+}
@@ -0,0 +1,158 @@
+package chain
+
+import (
+    "buildacoin/bitcoin"
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+var testMagic = Magic { 0xfb, 0xc0, 0xb6, 0xdb }
+
+// Write blocks into numbered block files the messy way daemons do: out of
+// order, with a stale fork, an orphan and zero padding between records.
+func writeTestBlockFiles(t *testing.T, dir string, blocks []*bitcoin.Block) {
+    fork := bitcoin.NewBlock(1, bitcoin.Diff1Bits, 99, blocks[1].Hash(),
+            time.Unix(1317972999, 0))
+    fork.AddTx(new(bitcoin.Tx).Input(bitcoin.Hash{}, 4294967295,
+            []byte{ 0x02, 0x99 }).Output(bitcoin.Coin, []byte{ 0x51 }))
+    orphan := bitcoin.NewBlock(1, bitcoin.Diff1Bits, 7,
+            bitcoin.Sha256d([]byte("missing parent")), time.Unix(1317973000, 0))
+    orphan.AddTx(new(bitcoin.Tx).Input(bitcoin.Hash{}, 4294967295,
+            []byte{ 0x02, 0x07 }).Output(bitcoin.Coin, []byte{ 0x51 }))
+
+    files := [][]*bitcoin.Block {
+        { blocks[0], blocks[2], fork, blocks[1] },
+        { orphan, blocks[3] },
+    }
+    for fileNum, fileBlocks := range files {
+        buf := new(bytes.Buffer)
+        for _, block := range fileBlocks {
+            err := WriteBlockRecord(buf, testMagic, block)
+            if err != nil {
+                t.Fatal(err.Error())
+            }
+            buf.Write(make([]byte, 13))
+        }
+        err := ioutil.WriteFile(filepath.Join(dir,
+                BlockFileName(uint32(fileNum))), buf.Bytes(), 0644)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+    }
+}
+
+func TestScanBlockFile(t *testing.T) {
+    blocks := testChain(3)
+    buf := new(bytes.Buffer)
+    buf.Write([]byte{ 0xfb, 0xc0, 0x00 })
+    for _, block := range blocks {
+        WriteBlockRecord(buf, testMagic, block)
+    }
+    // truncated trailing record
+    WriteBlockRecord(buf, testMagic, blocks[0])
+    buf.Truncate(buf.Len() - 10)
+
+    found := []bitcoin.Hash {}
+    err := ScanBlockFile(bytes.NewReader(buf.Bytes()), 0, testMagic,
+            func(loc BlockLoc, raw []byte) error {
+        block, err := bitcoin.ParseBlock(raw)
+        if err != nil {
+            return err
+        }
+        if !bytes.Equal(buf.Bytes()[loc.Offset:loc.Offset + loc.Len], raw) {
+            t.Fatalf("wrong location %v\n", loc)
+        }
+        found = append(found, block.Hash())
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(found) != len(blocks) {
+        t.Fatalf("expected %d blocks, found %d\n", len(blocks), len(found))
+    }
+    for ii, block := range blocks {
+        if found[ii] != block.Hash() {
+            t.Fatalf("block %d mismatch\n", ii)
+        }
+    }
+}
+
+func TestBlockFileIndex(t *testing.T) {
+    dir, err := ioutil.TempDir("", "blockfiles")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer os.RemoveAll(dir)
+
+    blocks := testChain(4)
+    writeTestBlockFiles(t, dir, blocks)
+    indexPath := filepath.Join(dir, DefaultIndexName)
+    err = WriteIndexFile(dir, testMagic, indexPath)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    index, err := OpenIndex(indexPath)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer index.Close()
+
+    if index.BestHeight() != 3 {
+        t.Fatalf("expected height 3, got %d\n", index.BestHeight())
+    }
+    for ii, block := range blocks {
+        hash, ok := index.Hash(int64(ii))
+        if !ok || hash != block.Hash() {
+            t.Fatalf("block %d not indexed at its height\n", ii)
+        }
+    }
+
+    src := NewBlockFileSource(dir, index)
+    tx := blocks[2].Txs()[1]
+    blockHash, err := src.TxBlock(tx.Hash())
+    if err != nil || blockHash != blocks[2].Hash() {
+        t.Fatalf("tx lookup failed: %v\n", err)
+    }
+    _, err = src.TxBlock(bitcoin.Sha256d([]byte("nope")))
+    if err != ErrNotFound {
+        t.Fatalf("expected not found, got %v\n", err)
+    }
+
+    view, err := ViewTx(src, tx.Hash(), testVersions)
+    if err != nil || !view.MerkleOk || view.Height != 2 {
+        t.Fatalf("tx view failed: %v %v\n", err, view)
+    }
+
+    // every transaction of the test chain pays the same address
+    refs, err := src.AddressTxs(bitcoin.Hash160([]byte("payee")))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(refs) != 1 + 2 + 3 + 4 {
+        t.Fatalf("expected 10 payments, got %d\n", len(refs))
+    }
+    if refs[0].Height != 0 || refs[len(refs)-1].Height != 3 ||
+            refs[len(refs)-1].Txid != blocks[3].Txs()[3].Hash() {
+        t.Fatalf("payments out of order: %v\n", refs)
+    }
+}
+
+func TestBadIndex(t *testing.T) {
+    file, err := ioutil.TempFile("", "index")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer os.Remove(file.Name())
+    file.Write([]byte("BACI\x01\x00\x00\x00\x05\x00\x00\x00"))
+    file.Close()
+
+    _, err = OpenIndex(file.Name())
+    if err != ErrBadIndex {
+        t.Fatalf("expected bad index error, got %v\n", err)
+    }
+}
@@ -0,0 +1,153 @@
+package chain
+
+import (
+    "buildacoin/bitcoin"
+    "buildacoin/data"
+    "buildacoin/rpc"
+    "errors"
+    "os"
+    "path/filepath"
+)
+
+var (
+    // Error when a block or transaction isn't known to a chain source
+    ErrNotFound error = errors.New("not found in chain")
+    // Error when a chain source can't answer a kind of query at all
+    ErrUnsupported error = errors.New("not supported by chain source")
+    // Error when a chain configuration doesn't say where the chain is
+    ErrNoChain error = errors.New("no chain source configured")
+)
+
+// A read-only view of a coin's best block chain
+type Source interface {
+    // Get the height of the best chain's tip.
+    BestHeight() (int64, error)
+    // Get the hash of the best chain block at height.
+    BlockHash(height int64) (bitcoin.Hash, error)
+    // Get a best chain block and its height by hash.
+    Block(hash bitcoin.Hash) (*bitcoin.Block, int64, error)
+    // Get the hash of the block containing a transaction.
+    TxBlock(txid bitcoin.Hash) (bitcoin.Hash, error)
+}
+
+// A reference to a transaction by where it appears in the chain
+type TxRef struct {
+    Block bitcoin.Hash
+    Height int64
+    Txid bitcoin.Hash
+}
+
+// Chain sources that can also look transactions up by the address they pay
+type AddressIndex interface {
+    // Get the transactions with outputs paying to the hash of a pubkey or
+    // script, oldest first.
+    AddressTxs(hash [bitcoin.Hash160Size]byte) ([]TxRef, error)
+}
+
+// Open the chain source described by an explorer chain configuration.  Block
+// files are indexed on first use if their index doesn't exist yet.
+func Open(conf data.ChainConf) (Source, error) {
+    if conf.RPCURL != "" {
+        return NewRPCSource(rpc.NewClientURL(conf.RPCURL, conf.RPCUser,
+                conf.RPCPass)), nil
+    }
+    if conf.BlocksDir == "" {
+        return nil, ErrNoChain
+    }
+
+    indexPath := conf.IndexFile
+    if indexPath == "" {
+        indexPath = filepath.Join(conf.BlocksDir, DefaultIndexName)
+    }
+    index, err := OpenIndex(indexPath)
+    if os.IsNotExist(err) {
+        magic := BitcoinMagic
+        if conf.Magic != "" {
+            magic, err = ParseMagic(conf.Magic)
+            if err != nil {
+                return nil, err
+            }
+        }
+        err = WriteIndexFile(conf.BlocksDir, magic, indexPath)
+        if err != nil {
+            return nil, err
+        }
+        index, err = OpenIndex(indexPath)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return NewBlockFileSource(conf.BlocksDir, index), nil
+}
+
+// Chain source backed by a coin daemon's JSON-RPC API
+type RPCSource struct {
+    client *rpc.Client
+}
+
+// Construct a chain source that queries a coin daemon.
+func NewRPCSource(client *rpc.Client) *RPCSource {
+    return &RPCSource { client }
+}
+
+func (tt *RPCSource) BestHeight() (int64, error) {
+    info, err := tt.client.GetBlockchainInfo()
+    if err != nil {
+        return 0, err
+    }
+    return info.Blocks, nil
+}
+
+func (tt *RPCSource) BlockHash(height int64) (bitcoin.Hash, error) {
+    hash, err := tt.client.GetBlockHash(height)
+    return hash, notFound(err)
+}
+
+func (tt *RPCSource) Block(hash bitcoin.Hash) (*bitcoin.Block, int64, error) {
+    info, err := tt.client.GetBlock(hash)
+    if err != nil {
+        return nil, 0, notFound(err)
+    }
+    raw, err := tt.client.GetRawBlock(hash)
+    if err != nil {
+        return nil, 0, notFound(err)
+    }
+    block, err := bitcoin.ParseBlock(raw)
+    if err != nil {
+        return nil, 0, err
+    }
+    return block, info.Height, nil
+}
+
+func (tt *RPCSource) TxBlock(txid bitcoin.Hash) (bitcoin.Hash, error) {
+    info, err := tt.client.GetRawTransaction(txid)
+    if err != nil {
+        return bitcoin.Hash{}, notFound(err)
+    }
+    // unconfirmed transactions have no block
+    if info.BlockHash == "" {
+        return bitcoin.Hash{}, ErrNotFound
+    }
+    return bitcoin.HashFromHex(info.BlockHash)
+}
+
+// Translate daemon errors for missing things into ErrNotFound.
+func notFound(err error) error {
+    if rpcErr, ok := err.(rpc.ErrRPC); ok {
+        switch rpcErr.Code {
+        case rpc.ErrCodeBlockNotFound, rpc.ErrCodeInvalidParameter:
+            return ErrNotFound
+        }
+    }
+    return err
+}
+
+// Find a transaction in a block, returning it with its index.
+func FindTx(block *bitcoin.Block, txid bitcoin.Hash) (*bitcoin.Tx, int, bool) {
+    for idx, tx := range block.Txs() {
+        if tx.Hash() == txid {
+            return tx, idx, true
+        }
+    }
+    return nil, 0, false
+}
@@ -0,0 +1,298 @@
+package chain
+
+import (
+    "buildacoin/bitcoin"
+    "encoding/hex"
+    "strconv"
+)
+
+// The address version bytes of a coin, for rendering output scripts as
+// addresses
+type Versions struct {
+    Pubkey byte
+    Script byte
+}
+
+// Format an amount of value units (satoshis) as a decimal coin amount.
+func FormatCoins(value uint64) string {
+    fraction := strconv.FormatUint(value % bitcoin.Coin, 10)
+    for len(fraction) < bitcoin.Precision {
+        fraction = "0" + fraction
+    }
+    return strconv.FormatUint(value / bitcoin.Coin, 10) + "." + fraction
+}
+
+// Summary of a block for display
+type BlockView struct {
+    Hash string
+    Height int64
+    // Empty for the genesis block
+    Prev string
+    // Empty for the best chain's tip
+    Next string
+    Version uint32
+    Time string
+    Bits string
+    Difficulty float64
+    Nonce uint32
+    // The merkle root committed to by the header
+    MerkleRoot string
+    // The merkle root computed from the block's transactions
+    ComputedMerkleRoot string
+    MerkleOk bool
+    Txs []TxSummary
+}
+
+// One line summary of a transaction in a block
+type TxSummary struct {
+    Txid string
+    Inputs int
+    Outputs int
+    Value string
+    Coinbase bool
+}
+
+// Summary of a transaction for display
+type TxView struct {
+    Txid string
+    Block string
+    Height int64
+    // Position of the transaction in its block
+    Index int
+    LockTime uint32
+    // Sibling hashes from the transaction up to the block's merkle root
+    MerkleBranch []string
+    // Whether the branch leads to the root in the block header
+    MerkleOk bool
+    Inputs []InputView
+    Outputs []OutputView
+    Value string
+}
+
+// A transaction input for display
+type InputView struct {
+    Coinbase bool
+    PrevTx string
+    PrevIndex uint32
+    // Hex of coinbase data, since it is rarely valid script
+    CoinbaseHex string
+    ScriptSig string
+    Sequence uint32
+}
+
+// A transaction output for display
+type OutputView struct {
+    Value string
+    Script string
+    Class string
+    // Empty when the script doesn't pay a single address
+    Address string
+}
+
+// An address and whatever is known of the transactions paying it
+type AddressView struct {
+    Address string
+    Version byte
+    Hash160 string
+    // "pubkey hash", "script hash" or "" if the version byte isn't the coin's
+    Kind string
+    // Whether the chain source can list transactions by address
+    Indexed bool
+    Txs []TxRefView
+}
+
+// A transaction reference for display
+type TxRefView struct {
+    Txid string
+    Block string
+    Height int64
+}
+
+// The tip of a chain for display
+type ChainView struct {
+    Height int64
+    Recent []BlockView
+}
+
+// Build the display summary of the block with the given hash.
+func ViewBlock(src Source, hash bitcoin.Hash) (*BlockView, error) {
+    block, height, err := src.Block(hash)
+    if err != nil {
+        return nil, err
+    }
+
+    output := &BlockView {
+        Hash: hash.String(),
+        Height: height,
+        Version: block.Version(),
+        Time: block.Timestamp().UTC().String(),
+        Bits: strconv.FormatUint(uint64(block.TargetBits()), 16),
+        Difficulty: bitcoin.Difficulty(block.TargetBits()),
+        Nonce: block.Nonce(),
+        MerkleRoot: block.HeaderMerkleRoot().String(),
+        ComputedMerkleRoot: block.MerkleRoot().String(),
+    }
+    output.MerkleOk = output.MerkleRoot == output.ComputedMerkleRoot
+    if height > 0 {
+        output.Prev = block.PrevBlock().String()
+    }
+    if next, err := src.BlockHash(height + 1); err == nil {
+        output.Next = next.String()
+    }
+
+    for _, tx := range block.Txs() {
+        inputs := tx.Inputs()
+        outputs := tx.Outputs()
+        value := uint64(0)
+        for _, out := range outputs {
+            value += out.Value
+        }
+        output.Txs = append(output.Txs, TxSummary {
+            Txid: tx.Hash().String(),
+            Inputs: len(inputs),
+            Outputs: len(outputs),
+            Value: FormatCoins(value),
+            Coinbase: len(inputs) == 1 && inputs[0].IsCoinbase(),
+        })
+    }
+    return output, nil
+}
+
+// Build the display summary of the best chain block at height.
+func ViewBlockAt(src Source, height int64) (*BlockView, error) {
+    hash, err := src.BlockHash(height)
+    if err != nil {
+        return nil, err
+    }
+    return ViewBlock(src, hash)
+}
+
+// Build the display summary of a transaction.
+func ViewTx(src Source, txid bitcoin.Hash, versions Versions) (*TxView,
+        error) {
+    blockHash, err := src.TxBlock(txid)
+    if err != nil {
+        return nil, err
+    }
+    block, height, err := src.Block(blockHash)
+    if err != nil {
+        return nil, err
+    }
+    tx, index, ok := FindTx(block, txid)
+    if !ok {
+        return nil, ErrNotFound
+    }
+
+    output := &TxView {
+        Txid: txid.String(),
+        Block: blockHash.String(),
+        Height: height,
+        Index: index,
+        LockTime: tx.LockTime(),
+    }
+
+    // prove inclusion against the header's merkle root
+    txids := make([]bitcoin.Hash, 0, len(block.Txs()))
+    for _, blockTx := range block.Txs() {
+        txids = append(txids, blockTx.Hash())
+    }
+    branch := bitcoin.MerkleBranch(txids, index, bitcoin.HashSha256d)
+    for _, hash := range branch {
+        output.MerkleBranch = append(output.MerkleBranch, hash.String())
+    }
+    output.MerkleOk = bitcoin.MerkleRootFromBranch(txid, index, branch,
+            bitcoin.HashSha256d) == block.HeaderMerkleRoot()
+
+    for _, in := range tx.Inputs() {
+        view := InputView {
+            Coinbase: in.IsCoinbase(),
+            Sequence: in.Sequence,
+        }
+        if view.Coinbase {
+            view.CoinbaseHex = hex.EncodeToString(in.ScriptSig)
+        } else {
+            view.PrevTx = in.PrevTx.String()
+            view.PrevIndex = in.PrevIndex
+            view.ScriptSig = bitcoin.DisasmScript(in.ScriptSig)
+        }
+        output.Inputs = append(output.Inputs, view)
+    }
+
+    value := uint64(0)
+    for _, out := range tx.Outputs() {
+        value += out.Value
+        view := OutputView {
+            Value: FormatCoins(out.Value),
+            Script: bitcoin.DisasmScript(out.ScriptPubKey),
+            Class: bitcoin.ClassifyScript(out.ScriptPubKey).String(),
+        }
+        address, ok := bitcoin.ScriptAddress(out.ScriptPubKey,
+                versions.Pubkey, versions.Script)
+        if ok {
+            view.Address = address.String()
+        }
+        output.Outputs = append(output.Outputs, view)
+    }
+    output.Value = FormatCoins(value)
+
+    return output, nil
+}
+
+// Build the display summary of an address.
+func ViewAddress(src Source, addressStr string,
+        versions Versions) (*AddressView, error) {
+    address, err := bitcoin.ParseAddress(addressStr)
+    if err != nil {
+        return nil, err
+    }
+
+    output := &AddressView {
+        Address: address.String(),
+        Version: address.Version,
+        Hash160: hex.EncodeToString(address.Hash[:]),
+    }
+    switch address.Version {
+    case versions.Pubkey:
+        output.Kind = "pubkey hash"
+    case versions.Script:
+        output.Kind = "script hash"
+    }
+
+    index, ok := src.(AddressIndex)
+    if !ok {
+        return output, nil
+    }
+    refs, err := index.AddressTxs(address.Hash)
+    if err == ErrUnsupported {
+        return output, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    output.Indexed = true
+    for _, ref := range refs {
+        output.Txs = append(output.Txs, TxRefView {
+            Txid: ref.Txid.String(),
+            Block: ref.Block.String(),
+            Height: ref.Height,
+        })
+    }
+    return output, nil
+}
+
+// Build the display summary of the most recent count blocks of the chain.
+func ViewChain(src Source, count int) (*ChainView, error) {
+    height, err := src.BestHeight()
+    if err != nil {
+        return nil, err
+    }
+    output := &ChainView { Height: height }
+    for ii := height; ii >= 0 && ii > height - int64(count); ii-- {
+        block, err := ViewBlockAt(src, ii)
+        if err != nil {
+            return nil, err
+        }
+        output.Recent = append(output.Recent, *block)
+    }
+    return output, nil
+}
@@ -0,0 +1,115 @@
+package chain
+
+import (
+    "buildacoin/bitcoin"
+    "buildacoin/rpc"
+    "testing"
+    "time"
+)
+
+var testVersions = Versions { 0x30, 0x05 }
+
+// Build a small chain whose blocks pay a fixed pubkey hash and carry a few
+// extra transactions so that merkle branches are nontrivial.
+func testChain(length int) []*bitcoin.Block {
+    payee := bitcoin.PubkeyHashScript(bitcoin.Hash160([]byte("payee")))
+    output := make([]*bitcoin.Block, 0, length)
+    prev := bitcoin.Hash{}
+    for ii := 0; ii < length; ii++ {
+        block := bitcoin.NewBlock(1, bitcoin.Diff1Bits, uint32(ii), prev,
+                time.Unix(1317972665 + int64(ii) * 150, 0))
+        block.AddTx(new(bitcoin.Tx,
+                ).Input(bitcoin.Hash{}, 4294967295, []byte{ 0x01, byte(ii) },
+                ).Output(50 * bitcoin.Coin, payee))
+        for jj := 0; jj < ii; jj++ {
+            block.AddTx(new(bitcoin.Tx,
+                    ).Input(bitcoin.Sha256d([]byte{ byte(ii), byte(jj) }), 0,
+                            []byte{ 0x51 },
+                    ).Output(bitcoin.Coin, payee))
+        }
+        output = append(output, block)
+        prev = block.Hash()
+    }
+    return output
+}
+
+func testRPCSource(t *testing.T, blocks []*bitcoin.Block) (*RPCSource,
+        *rpc.MockServer) {
+    server := rpc.NewMockServer("user", "pass")
+    for _, block := range blocks {
+        server.AddBlock(block)
+    }
+    return NewRPCSource(server.Client()), server
+}
+
+func TestViewBlock(t *testing.T) {
+    blocks := testChain(4)
+    src, server := testRPCSource(t, blocks)
+    defer server.Close()
+
+    view, err := ViewBlockAt(src, 2)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if view.Hash != blocks[2].Hash().String() ||
+            view.Prev != blocks[1].Hash().String() ||
+            view.Next != blocks[3].Hash().String() {
+        t.Fatalf("block linkage mismatch: %v\n", *view)
+    }
+    if !view.MerkleOk || len(view.Txs) != 3 || !view.Txs[0].Coinbase ||
+            view.Txs[0].Value != "50.00000000" {
+        t.Fatalf("block contents mismatch: %v\n", *view)
+    }
+
+    chainView, err := ViewChain(src, 2)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if chainView.Height != 3 || len(chainView.Recent) != 2 ||
+            chainView.Recent[0].Next != "" {
+        t.Fatalf("chain view mismatch: %v\n", *chainView)
+    }
+}
+
+func TestViewTx(t *testing.T) {
+    blocks := testChain(4)
+    src, server := testRPCSource(t, blocks)
+    defer server.Close()
+
+    tx := blocks[3].Txs()[2]
+    view, err := ViewTx(src, tx.Hash(), testVersions)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if view.Height != 3 || view.Index != 2 || !view.MerkleOk ||
+            len(view.MerkleBranch) != 2 {
+        t.Fatalf("tx location mismatch: %v\n", *view)
+    }
+    expected := bitcoin.NewAddress(testVersions.Pubkey,
+            bitcoin.Hash160([]byte("payee"))).String()
+    if len(view.Outputs) != 1 || view.Outputs[0].Address != expected ||
+            view.Outputs[0].Class != "pubkeyhash" {
+        t.Fatalf("tx outputs mismatch: %v\n", view.Outputs)
+    }
+
+    _, err = ViewTx(src, bitcoin.Sha256d([]byte("nope")), testVersions)
+    if err != ErrNotFound {
+        t.Fatalf("expected not found, got %v\n", err)
+    }
+}
+
+func TestViewAddress(t *testing.T) {
+    src, server := testRPCSource(t, testChain(1))
+    defer server.Close()
+
+    address := bitcoin.NewAddress(testVersions.Script,
+            bitcoin.Hash160([]byte("payee"))).String()
+    view, err := ViewAddress(src, address, testVersions)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    // daemons have no address index
+    if view.Kind != "script hash" || view.Indexed {
+        t.Fatalf("address view mismatch: %v\n", *view)
+    }
+}
@@ -1,6 +1,8 @@
 package data
 
 import (
+    "crypto/ed25519"
+    "encoding/hex"
     "encoding/json"
     "errors"
     "io"
@@ -52,6 +54,24 @@ type conf_ struct {
     DBUser string `json:"database user"`
     DBPass string `json:"database password"`
     Proxied bool `json:"behind proxy"`
+    ExplorerChains map[string]ChainConf `json:"explorer chains"`
+    ManifestKey string `json:"manifest signing key"`
+}
+
+// Where the block explorer reads a hosted coin's block chain from
+type ChainConf struct {
+    // URL of the coin daemon's JSON-RPC API
+    RPCURL string `json:"rpc url"`
+    // JSON-RPC basic auth user
+    RPCUser string `json:"rpc user"`
+    // JSON-RPC basic auth password
+    RPCPass string `json:"rpc password"`
+    // Directory of blkNNNNN.dat files, read when there is no daemon to ask
+    BlocksDir string `json:"blocks dir"`
+    // Hex network magic prefixing records in the block files
+    Magic string `json:"network magic"`
+    // Path of the block file index; defaults to a file in BlocksDir
+    IndexFile string `json:"index file"`
 }
 
 // Immutable configuration type containing config data for all packages
@@ -175,6 +195,24 @@ func (tt *Conf) DBPass() string {
 func (tt *Conf) Proxied() bool {
     return tt.conf_.Proxied
 }
+// Get the block chain location for a hosted coin by hex coin ID, if the coin
+// is hosted by the explorer
+func (tt *Conf) ExplorerChain(coinId string) (ChainConf, bool) {
+    chainConf, ok := tt.conf_.ExplorerChains[coinId]
+    return chainConf, ok
+}
+// Get the ed25519 key signing the checksum manifests of generated archives, or
+// nil if manifests are unsigned.  It is configured as a hex 32 byte seed.
+func (tt *Conf) ManifestKey() (ed25519.PrivateKey, error) {
+    if tt.conf_.ManifestKey == "" {
+        return nil, nil
+    }
+    seed, err := hex.DecodeString(tt.conf_.ManifestKey)
+    if err != nil || len(seed) != ed25519.SeedSize {
+        return nil, ErrBadManifestKey
+    }
+    return ed25519.NewKeyFromSeed(seed), nil
+}
 
 //
 // Mutators: these functions return copies of the Conf they operate on rather
@@ -205,6 +243,12 @@ func (tt *Conf) WithDBPass(pass string) *Conf {
     output.conf_.DBPass = pass
     return output
 }
+// Return a new Conf with ManifestKey set to the hex seed
+func (tt *Conf) WithManifestKey(seed string) *Conf {
+    output := tt.dup()
+    output.conf_.ManifestKey = seed
+    return output
+}
 
 //
 // JSON serialization
@@ -239,5 +283,15 @@ var DefaultConf = &Conf {
         DefaultDBUser,
         "", // DBPass
         DefaultProxied,
+        nil, // ExplorerChains
+        "", // ManifestKey
     },
 }
+
+//
+// Errors
+//
+
+// The manifest signing key isn't a hex ed25519 seed
+var ErrBadManifestKey error = errors.New(
+        "manifest signing key must be a hex 32 byte ed25519 seed")
@@ -24,3 +24,23 @@ func TestLoadConf(t *testing.T) {
                 *conf)
     }
 }
+
+func TestManifestKey(t *testing.T) {
+    key, err := DefaultConf.ManifestKey()
+    if key != nil || err != nil {
+        t.Fatal("expected no manifest key by default")
+    }
+    key, err = DefaultConf.WithManifestKey(
+        "0707070707070707070707070707070707070707070707070707070707070707").
+        ManifestKey()
+    if err != nil || len(key) == 0 {
+        t.Fatal("failed to load manifest key")
+    }
+    for _, seed := range []string { "07", "not hex" } {
+        _, err = DefaultConf.WithManifestKey(seed).ManifestKey()
+        if err != ErrBadManifestKey {
+            t.Fatalf("Wrong error for '%s': expected '%v' / actual '%v'", seed,
+                    ErrBadManifestKey, err)
+        }
+    }
+}
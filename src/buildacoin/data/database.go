@@ -1,10 +1,13 @@
 package data
 
 import (
+    "bytes"
     "crypto/rand"
     _ "github.com/lib/pq"
     "database/sql"
+    "encoding/gob"
     "encoding/hex"
+    "errors"
     "strconv"
     "time"
 )
@@ -51,6 +54,9 @@ type CoinSummary struct {
     InitDiff float64
     EmbedMsg string
     Serialized []byte
+    // When the coin was made, which is also the time its values were produced
+    // at (see source.CoinContext)
+    Created time.Time
 }
 
 func (tt DB) GetCoinSummary(id CoinID) (CoinSummary, error) {
@@ -58,15 +64,25 @@ func (tt DB) GetCoinSummary(id CoinID) (CoinSummary, error) {
     row := tt.QueryRow("SELECT template_id, template_ver, label, " +
             "currency_code, addr_id, proto_port, initial_reward, " +
             "halving_interval, block_time, diff_time, initial_diff, " +
-            "embed_msg, subs_gob FROM coins WHERE id=$1",
+            "embed_msg, subs_gob, created FROM coins WHERE id=$1",
             hex.EncodeToString(id.Bytes()))
     err := row.Scan(&out.TemplateID, &out.TemplateVer, &out.Name, &out.Code,
             &out.AddrId, &out.ProtoPort, &out.InitReward, &out.Halving,
             &out.BlockTime, &out.DiffTime, &out.InitDiff, &out.EmbedMsg,
-            &out.Serialized)
+            &out.Serialized, &out.Created)
     return out, err
 }
 
+// Inflate the substitution map the coin was originally generated with.
+func (tt *CoinSummary) Subs() (map[uint][]byte, error) {
+    var output map[uint][]byte
+    err := gob.NewDecoder(bytes.NewBuffer(tt.Serialized)).Decode(&output)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
 func (tt DB) PutCoinSummary(input *CoinSummary, requestOrigin string,
         requestAgent string) error {
     requestOrigin_p := &requestOrigin
@@ -79,12 +95,12 @@ func (tt DB) PutCoinSummary(input *CoinSummary, requestOrigin string,
     }
 
     _, err := tt.Exec("INSERT INTO coins VALUES ( $1, $2, $3, $4, $5, $6, " +
-            "$7, $8, $9, $10, $11, $12, $13, $14, $15, $16 )",
+            "$7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17 )",
             hex.EncodeToString(input.ID.Bytes()), input.TemplateID,
             input.TemplateVer, input.Name, input.Code, input.AddrId,
             input.ProtoPort, input.InitReward, input.Halving, input.BlockTime,
             input.DiffTime, input.InitDiff, input.EmbedMsg, input.Serialized,
-            requestOrigin_p, requestAgent_p)
+            requestOrigin_p, requestAgent_p, input.Created)
     return err
 }
 
@@ -112,6 +128,9 @@ const CoinIDLen = 16
 type CoinID [CoinIDLen]byte
 var zeroCoinID CoinID
 
+// Error when a string isn't the hex encoding of a legal coin ID
+var ErrBadCoinID error = errors.New("malformed coin id")
+
 func NewCoinID() CoinID {
     var output CoinID
     n, err := rand.Read(output[:])
@@ -124,6 +143,20 @@ func NewCoinID() CoinID {
     return output
 }
 
+// Parse a coin ID from its hex encoding.
+func ParseCoinID(input string) (CoinID, error) {
+    var output CoinID
+    raw, err := hex.DecodeString(input)
+    if err != nil {
+        return output, err
+    }
+    if len(raw) != CoinIDLen || bytes.Equal(raw, zeroCoinID[:]) {
+        return output, ErrBadCoinID
+    }
+    copy(output[:], raw)
+    return output, nil
+}
+
 func (tt CoinID) Bytes() []byte {
     return tt[:]
 }
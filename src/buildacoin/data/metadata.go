@@ -25,12 +25,21 @@ var (
 // still use relatively easy un-JSONing.
 // see http://stackoverflow.com/questions/11126793/golang-json-and-dealing-with-unexported-fields
 type meta_ struct {
-    Id string
-    Label string
-    Version string
+    Id string `json:"id"`
+    Label string `json:"label"`
+    Version string `json:"version"`
     InGroups []string `json:"input groups"`
     Inputs []Input `json:"user inputs"`
     Subs []Sub `json:"substitutions"`
+    // Marker delimiters used by the base's template, if not the defaults
+    LeadMarker string `json:"lead marker,omitempty"`
+    TailMarker string `json:"tail marker,omitempty"`
+    // How coins made with earlier versions map onto this one
+    Migrations []Migration `json:"migrations,omitempty"`
+    // Which files of the template coins get, and under what names
+    FileRules *FileRules `json:"file rules,omitempty"`
+    // Images in the template drawn from a logo the user uploads
+    Logo *Logo `json:"logo,omitempty"`
 }
 
 // Metadata for a base coin, describing template inputs and outputs
@@ -43,28 +52,134 @@ type Meta struct {
 type Input struct {
     // The input group this input will be displayed with ("basic"/"advanced"
     // etc.)
-    Group string
+    Group string `json:"group"`
     // Unique terse name for the input
-    Id string
+    Id string `json:"id"`
     // Longer descriptive name to be shown to users
-    Label string
+    Label string `json:"label"`
     // Default value for this input if an explicit value is not supplied
-    Default string
+    Default string `json:"default"`
+    // Kind of value, deciding how the form offers the input and what it
+    // accepts; "" for InputText
+    Type string `json:"type,omitempty"`
+    // Explanation shown with the input
+    Help string `json:"help,omitempty"`
+    // Bounds of InputInt and InputNumber values, if any
+    Min *float64 `json:"min,omitempty"`
+    Max *float64 `json:"max,omitempty"`
+    // Values an InputEnum takes
+    Choices []string `json:"choices,omitempty"`
+    // Regular expression InputText values must match in full, if any
+    Pattern string `json:"pattern,omitempty"`
+    // Unit values are given in ("seconds", "coins"), shown after the input
+    Unit string `json:"unit,omitempty"`
 }
+// Input types
+const (
+    InputText = "text"
+    InputBool = "bool"
+    InputInt = "int"
+    InputNumber = "number"
+    InputEnum = "enum"
+    // A PNG image uploaded as a file
+    InputImage = "image"
+)
 // A single template substitution field in a base coin (an output)
 type Sub struct {
     // Numeric identifier for this substitution as it will appear in the
     // template stream
     Idx uint `json:"substitution index"`
     // Input to pull value from or "" to always use default
-    Input string
+    Input string `json:"input,omitempty"`
     // Description of field for human maintainers
-    Comment string
+    Comment string `json:"comment,omitempty"`
     // Value to use if no input is associated
-    Default string
+    Default string `json:"default,omitempty"`
     // Type that the value must conform to (see buildacoin/source/types)
-    Type string
-    Deps []uint `json:"dependencies"`
+    Type string `json:"type"`
+    Deps []uint `json:"dependencies,omitempty"`
+}
+
+// The changes to a base's substitutions between two of its versions, which
+// carry a coin's values from one to the other
+type Migration struct {
+    FromVersion string `json:"from version"`
+    ToVersion string `json:"to version"`
+    // substitutions whose indices changed
+    Renamed []RenamedSub `json:"renamed,omitempty"`
+    // substitutions new in ToVersion
+    Added []AddedSub `json:"added,omitempty"`
+    // indices of substitutions no longer in ToVersion
+    Removed []uint `json:"removed,omitempty"`
+}
+// A substitution moved to a new index by a migration
+type RenamedSub struct {
+    From uint `json:"from"`
+    To uint `json:"to"`
+}
+// A substitution added by a migration
+type AddedSub struct {
+    Idx uint `json:"substitution index"`
+    // Value for coins made before it existed; if "", the substitution's own
+    // default is produced
+    Default string `json:"default,omitempty"`
+}
+
+// Rules for the files of a base's template beyond filtering them.  Paths are
+// regular expressions matched against names as they are in the template,
+// markers and all.
+type FileRules struct {
+    Exclude []ExcludeRule `json:"exclude,omitempty"`
+    Rename []RenameRule `json:"rename,omitempty"`
+    Generate []GeneratedFile `json:"generate,omitempty"`
+}
+// Template files left out of coins, such as the GUI or packaging of a coin
+// that doesn't want them
+type ExcludeRule struct {
+    Path string `json:"path"`
+    // Boolean inputs which keep the files if all true; if none, the files
+    // are always left out
+    Unless []string `json:"unless,omitempty"`
+}
+// A change to the names of template files, made before markers in them are
+// replaced
+type RenameRule struct {
+    Path string `json:"path"`
+    // Replacement of each match, which may refer to submatches as $1 and
+    // contain markers
+    To string `json:"to"`
+}
+// A file added to coins, such as a README of the coin's parameters
+type GeneratedFile struct {
+    // Name and contents, both template text
+    Name string `json:"name"`
+    Contents string `json:"contents"`
+    // Boolean inputs which must all be true for the file to be added
+    If []string `json:"if,omitempty"`
+}
+
+// A base's images drawn from a coin's logo, in place of the template's
+// placeholders
+type Logo struct {
+    // Input taking the logo as a PNG image, through a substitution of type
+    // "png"
+    Input string `json:"input"`
+    Assets []Asset `json:"assets"`
+}
+// An image in a base's template drawn from the logo
+type Asset struct {
+    // Name of the file in the template
+    Path string `json:"path"`
+    // Image format: "png", "ico" or "xpm"
+    Format string `json:"format"`
+    // Sizes in pixels of the square icon, one per image in an ico
+    Sizes []int `json:"sizes,omitempty"`
+    // Size of the canvas of a png without Sizes, such as a splash screen,
+    // which the logo is centred on
+    Width int `json:"width,omitempty"`
+    Height int `json:"height,omitempty"`
+    // Colour of the canvas as "#rrggbb", or "" for transparent
+    Background string `json:"background,omitempty"`
 }
 
 // Load a metadata file for the named base coin.
@@ -114,6 +229,16 @@ func (tt *Meta) Id() string {
 func (tt *Meta) Version() string {
     return tt.meta_.Version
 }
+// Get the lead marker declared by the base coin's template, or "" for the
+// default.
+func (tt *Meta) LeadMarker() string {
+    return tt.meta_.LeadMarker
+}
+// Get the tail marker declared by the base coin's template, or "" for the
+// default.
+func (tt *Meta) TailMarker() string {
+    return tt.meta_.TailMarker
+}
 // Get the base coin's input groups.  Input groups group user options logically
 // ("basic"/"advanced" etc).
 func (tt *Meta) InGroups() []string {
@@ -158,6 +283,39 @@ func (tt *Meta) Sub(idx int) Sub {
     return tt.meta_.Subs[idx]
 }
 
+// Find the substitution with the given comment.  Comments are how code outside
+// the template identifies substitutions with special meaning (the coin ID, the
+// address version bytes etc).
+func (tt *Meta) SubByComment(comment string) (Sub, bool) {
+    for _, sub := range tt.meta_.Subs {
+        if sub.Comment == comment {
+            return sub, true
+        }
+    }
+    return Sub{}, false
+}
+// Get the rules for which files coins of this base coin get and their names.
+func (tt *Meta) FileRules() FileRules {
+    if tt.meta_.FileRules == nil {
+        return FileRules{}
+    }
+    return *tt.meta_.FileRules
+}
+// Get the images of this base coin drawn from a coin's logo, and whether it
+// has any.
+func (tt *Meta) Logo() (Logo, bool) {
+    if tt.meta_.Logo == nil {
+        return Logo{}, false
+    }
+    return *tt.meta_.Logo, true
+}
+// Get the migrations from earlier versions of this base coin.
+func (tt *Meta) Migrations() []Migration {
+    output := make([]Migration, len(tt.meta_.Migrations))
+    copy(output, tt.meta_.Migrations)
+    return output
+}
+
 //
 // JSON serialization
 //
@@ -182,6 +340,35 @@ func NewMeta(id, label, version string, inGroups []string, inputs []Input,
     // literal format.  Having it is nice because it will break the build if
     // another field is added to the struct, which makes me think twice about
     // accessors and other new-field necessities.
-    return &Meta { meta_ { id, label, version, inGroups, inputs, subs },
-            nil }
+    return &Meta { meta_ { id, label, version, inGroups, inputs, subs, "",
+            "", nil, nil, nil }, nil }
+}
+// Produce a copy of a Meta with migrations from earlier versions.
+func (tt *Meta) WithMigrations(migrations []Migration) *Meta {
+    output := new(Meta)
+    *output = *tt
+    output.meta_.Migrations = migrations
+    return output
+}
+// Produce a copy of a Meta with its own template markers.
+func (tt *Meta) WithMarkers(lead, tail string) *Meta {
+    output := new(Meta)
+    *output = *tt
+    output.meta_.LeadMarker = lead
+    output.meta_.TailMarker = tail
+    return output
+}
+// Produce a copy of a Meta with rules for the files of its template.
+func (tt *Meta) WithFileRules(rules FileRules) *Meta {
+    output := new(Meta)
+    *output = *tt
+    output.meta_.FileRules = &rules
+    return output
+}
+// Produce a copy of a Meta with images drawn from a logo.
+func (tt *Meta) WithLogo(logo Logo) *Meta {
+    output := new(Meta)
+    *output = *tt
+    output.meta_.Logo = &logo
+    return output
 }
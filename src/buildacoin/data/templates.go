@@ -12,6 +12,10 @@ import (
 const (
     // Filename suffix indicating gzip compression
     GzipSuffix = ".gz"
+    // Name of the unpacked template tree within a base coin directory
+    TemplateSrcDirName = "template-src"
+    // Name of the template archive packed from the template tree
+    PackedTemplateName = "template.tar.gz"
 )
 
 var (
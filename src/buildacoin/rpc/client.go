@@ -0,0 +1,169 @@
+package rpc
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+const (
+    // Default host to contact a coin daemon at
+    DefaultHost = "127.0.0.1"
+    // Default time to wait for a coin daemon to respond to a call
+    DefaultTimeout = 30 * time.Second
+    // JSON-RPC protocol version spoken by bitcoin-derived daemons
+    ProtocolVersion = "1.0"
+)
+
+var (
+    // Error when the daemon rejects the configured credentials
+    ErrUnauthorized error = errors.New("rpc credentials rejected by daemon")
+    // Error when a response does not answer the request it was given for
+    ErrIdMismatch error = errors.New("rpc response id does not match request")
+)
+
+// JSON-RPC client for the API port of a bitcoin-derived coin daemon
+type Client struct {
+    url string
+    user string
+    pass string
+    http *http.Client
+
+    idLock sync.Mutex
+    nextId uint64
+}
+
+// Construct a new client that calls the daemon listening at host:port using
+// HTTP basic authentication with user and pass.
+func NewClient(host string, port uint16, user, pass string) *Client {
+    if host == "" {
+        host = DefaultHost
+    }
+    return NewClientURL("http://" + host + ":" +
+            strconv.FormatUint(uint64(port), 10) + "/", user, pass)
+}
+
+// Construct a new client that calls the daemon at an explicit URL.
+func NewClientURL(url, user, pass string) *Client {
+    return &Client {
+        url: url,
+        user: user,
+        pass: pass,
+        http: &http.Client { Timeout: DefaultTimeout },
+    }
+}
+
+// Construct a new client from a coin daemon's configuration.  defaultPort is
+// used when the configuration doesn't name an API port (typically the value of
+// the coin's "api port" input).
+func NewClientFromConf(conf *CoinConf, defaultPort uint16) *Client {
+    port := conf.Port
+    if port == 0 {
+        port = defaultPort
+    }
+    return NewClient(conf.Host, port, conf.User, conf.Pass)
+}
+
+// Get the URL the client sends calls to.
+func (tt *Client) URL() string {
+    return tt.url
+}
+
+type request struct {
+    Version string `json:"jsonrpc"`
+    Id uint64 `json:"id"`
+    Method string `json:"method"`
+    Params []interface{} `json:"params"`
+}
+
+type response struct {
+    Id uint64 `json:"id"`
+    Result json.RawMessage `json:"result"`
+    Error *ErrRPC `json:"error"`
+}
+
+// Call a method on the daemon, decoding its result into result.  A nil result
+// discards the daemon's result value.
+func (tt *Client) Call(method string, result interface{},
+        params ...interface{}) error {
+    if params == nil {
+        params = []interface{} {}
+    }
+
+    tt.idLock.Lock()
+    tt.nextId++
+    id := tt.nextId
+    tt.idLock.Unlock()
+
+    body, err := json.Marshal(&request { ProtocolVersion, id, method, params })
+    if err != nil {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", tt.url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.SetBasicAuth(tt.user, tt.pass)
+
+    resp, err := tt.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusUnauthorized {
+        return ErrUnauthorized
+    }
+
+    // bitcoind reports method errors with a 500 status and a normal JSON-RPC
+    // body, so only give up on the status if the body doesn't parse
+    respBody, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return err
+    }
+    var decoded response
+    err = json.Unmarshal(respBody, &decoded)
+    if err != nil {
+        if resp.StatusCode != http.StatusOK {
+            return ErrHTTPStatus { resp.StatusCode }
+        }
+        return err
+    }
+
+    if decoded.Error != nil {
+        return *decoded.Error
+    }
+    if decoded.Id != id {
+        return ErrIdMismatch
+    }
+    if result == nil {
+        return nil
+    }
+    return json.Unmarshal(decoded.Result, result)
+}
+
+//
+// Errors
+//
+
+// Error reported by the daemon in answer to a call
+type ErrRPC struct {
+    Code int `json:"code"`
+    Message string `json:"message"`
+}
+func (tt ErrRPC) Error() string {
+    return "rpc error " + strconv.Itoa(tt.Code) + ": " + tt.Message
+}
+// Error when the daemon answers with an HTTP failure and no JSON-RPC body
+type ErrHTTPStatus struct { Status int }
+func (tt ErrHTTPStatus) Error() string {
+    return "rpc http status " + strconv.Itoa(tt.Status) + " " +
+            http.StatusText(tt.Status)
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+    "buildacoin/bitcoin"
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+)
+
+func testChain(length int) []*bitcoin.Block {
+    output := make([]*bitcoin.Block, 0, length)
+    prev := bitcoin.Hash{}
+    for ii := 0; ii < length; ii++ {
+        block := bitcoin.NewBlock(1, bitcoin.Diff1Bits, uint32(ii), prev,
+                time.Unix(1317972665 + int64(ii) * 150, 0),
+                ).AddTx(new(bitcoin.Tx,
+                    ).Input(bitcoin.Hash{}, 4294967295, []byte{ byte(ii) },
+                    ).Output(50 * bitcoin.Coin, []byte{ 0x51 }))
+        output = append(output, block)
+        prev = block.Hash()
+    }
+    return output
+}
+
+func TestChainCalls(t *testing.T) {
+    server := NewMockServer("user", "pass")
+    defer server.Close()
+    chain := testChain(3)
+    for _, block := range chain {
+        server.AddBlock(block)
+    }
+    client := server.Client()
+
+    info, err := client.GetBlockchainInfo()
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if info.Blocks != 2 || info.BestBlockHash != chain[2].Hash().String() {
+        t.Fatalf("chain info mismatch: %v\n", *info)
+    }
+
+    hash, err := client.GetBlockHash(1)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if !hash.Equals(chain[1].Hash()) {
+        t.Fatal("block hash mismatch: expected " + chain[1].Hash().String() +
+                " / actual " + hash.String())
+    }
+
+    block, err := client.GetBlock(hash)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if block.Height != 1 || block.NextBlockHash != chain[2].Hash().String() ||
+            len(block.Tx) != 1 {
+        t.Fatalf("block info mismatch: %v\n", *block)
+    }
+
+    raw, err := client.GetRawBlock(hash)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    decoded, err := bitcoin.ParseBlock(raw)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if !decoded.Hash().Equals(hash) {
+        t.Fatal("raw block hash mismatch: " + decoded.Hash().String())
+    }
+
+    _, err = client.GetBlockHash(7)
+    if rpcErr, ok := err.(ErrRPC); !ok ||
+            rpcErr.Code != ErrCodeInvalidParameter {
+        t.Fatalf("expected out of range error, got %v\n", err)
+    }
+}
+
+func TestMining(t *testing.T) {
+    server := NewMockServer("user", "pass")
+    defer server.Close()
+    chain := testChain(2)
+    server.AddBlock(chain[0])
+    client := server.Client()
+
+    template, err := client.GetBlockTemplate()
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    prev, err := template.PrevBlock()
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if template.Height != 1 || !prev.Equals(chain[0].Hash()) {
+        t.Fatalf("template mismatch: %v\n", *template)
+    }
+
+    err = client.SubmitBlock(chain[1].Bytes())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    // the same block no longer extends the tip
+    err = client.SubmitBlock(chain[1].Bytes())
+    if _, ok := err.(ErrBlockRejected); !ok {
+        t.Fatalf("expected rejection, got %v\n", err)
+    }
+}
+
+func TestAuth(t *testing.T) {
+    server := NewMockServer("user", "pass")
+    defer server.Close()
+
+    client := NewClientURL(server.URL(), "user", "wrong")
+    _, err := client.GetBlockchainInfo()
+    if err != ErrUnauthorized {
+        t.Fatalf("expected auth failure, got %v\n", err)
+    }
+}
+
+func TestHandlerOverride(t *testing.T) {
+    server := NewMockServer("user", "pass")
+    defer server.Close()
+    server.Handle("getblockhash", func(params []json.RawMessage) (interface{},
+            *ErrRPC) {
+        return nil, &ErrRPC { -1, "nope" }
+    })
+
+    _, err := server.Client().GetBlockHash(0)
+    if rpcErr, ok := err.(ErrRPC); !ok || rpcErr.Message != "nope" {
+        t.Fatalf("expected override error, got %v\n", err)
+    }
+}
+
+func TestCoinConf(t *testing.T) {
+    conf, err := ReadCoinConf(strings.NewReader("# bestcoin.conf\n" +
+            "rpcuser=miner\nrpcpassword = hunter2 # shh\nrpcport=9332\n"))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if conf.User != "miner" || conf.Pass != "hunter2" || conf.Port != 9332 {
+        t.Fatalf("coin conf mismatch: %v\n", *conf)
+    }
+
+    _, err = ReadCoinConf(strings.NewReader("server=1\n"))
+    if err != ErrNoCredentials {
+        t.Fatalf("expected missing credentials, got %v\n", err)
+    }
+}
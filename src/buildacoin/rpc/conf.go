@@ -0,0 +1,81 @@
+package rpc
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "os"
+    "strconv"
+    "strings"
+)
+
+var (
+    // Error when a coin daemon configuration has no RPC credentials
+    ErrNoCredentials error = errors.New("coin config has no rpcuser/rpcpassword")
+)
+
+// The RPC-relevant parts of a coin daemon's configuration file (the
+// bitcoin.conf analog a generated coin reads at startup)
+type CoinConf struct {
+    // Host the daemon's API port is reachable at (rpcconnect)
+    Host string
+    // API port (rpcport); 0 if the config leaves it at the coin's default
+    Port uint16
+    // Basic auth user name (rpcuser)
+    User string
+    // Basic auth password (rpcpassword)
+    Pass string
+}
+
+// Load the RPC settings from a coin daemon configuration file.
+func LoadCoinConf(path string) (*CoinConf, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return ReadCoinConf(file)
+}
+
+// Read the RPC settings from a coin daemon configuration stream.  The format
+// is the bitcoin.conf one of key=value lines with # comments.
+func ReadCoinConf(input io.Reader) (*CoinConf, error) {
+    output := new(CoinConf)
+
+    scanner := bufio.NewScanner(input)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if comment := strings.Index(line, "#"); comment >= 0 {
+            line = line[:comment]
+        }
+        parts := strings.SplitN(line, "=", 2)
+        if len(parts) != 2 {
+            continue
+        }
+        key := strings.TrimSpace(parts[0])
+        value := strings.TrimSpace(parts[1])
+
+        switch key {
+        case "rpcconnect":
+            output.Host = value
+        case "rpcport":
+            port, err := strconv.ParseUint(value, 10, 16)
+            if err != nil {
+                return nil, err
+            }
+            output.Port = uint16(port)
+        case "rpcuser":
+            output.User = value
+        case "rpcpassword":
+            output.Pass = value
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    if output.User == "" || output.Pass == "" {
+        return nil, ErrNoCredentials
+    }
+    return output, nil
+}
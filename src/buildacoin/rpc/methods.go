@@ -0,0 +1,177 @@
+package rpc
+
+import (
+    "buildacoin/bitcoin"
+    "encoding/hex"
+    "errors"
+)
+
+var (
+    // Error when the daemon answers getblocktemplate without a usable
+    // template
+    ErrNoTemplate error = errors.New("daemon returned an empty block template")
+)
+
+// A transaction offered for inclusion by getblocktemplate
+type TemplateTx struct {
+    // Hex serialization of the transaction
+    Data string `json:"data"`
+    // Hex transaction id
+    Hash string `json:"hash"`
+    // 1-based indices of transactions in the template this one depends on
+    Depends []int `json:"depends"`
+    // Fee paid by the transaction in value units (satoshis)
+    Fee int64 `json:"fee"`
+    // Signature operation count for the transaction
+    Sigops int `json:"sigops"`
+}
+
+// Result of getblocktemplate
+type BlockTemplate struct {
+    Version uint32 `json:"version"`
+    PreviousBlockHash string `json:"previousblockhash"`
+    Transactions []TemplateTx `json:"transactions"`
+    CoinbaseAux map[string]string `json:"coinbaseaux"`
+    CoinbaseValue int64 `json:"coinbasevalue"`
+    Target string `json:"target"`
+    MinTime int64 `json:"mintime"`
+    Mutable []string `json:"mutable"`
+    NonceRange string `json:"noncerange"`
+    SigopLimit int `json:"sigoplimit"`
+    SizeLimit int `json:"sizelimit"`
+    CurTime int64 `json:"curtime"`
+    Bits string `json:"bits"`
+    Height int64 `json:"height"`
+}
+
+// Get the hash of the block a block built from this template would extend.
+func (tt *BlockTemplate) PrevBlock() (bitcoin.Hash, error) {
+    return bitcoin.HashFromHex(tt.PreviousBlockHash)
+}
+
+// Result of getblockchaininfo
+type ChainInfo struct {
+    Chain string `json:"chain"`
+    Blocks int64 `json:"blocks"`
+    Headers int64 `json:"headers"`
+    BestBlockHash string `json:"bestblockhash"`
+    Difficulty float64 `json:"difficulty"`
+    MedianTime int64 `json:"mediantime"`
+    VerificationProgress float64 `json:"verificationprogress"`
+    ChainWork string `json:"chainwork"`
+}
+
+// Result of a verbose getblock
+type BlockInfo struct {
+    Hash string `json:"hash"`
+    Confirmations int64 `json:"confirmations"`
+    Size int `json:"size"`
+    Height int64 `json:"height"`
+    Version uint32 `json:"version"`
+    MerkleRoot string `json:"merkleroot"`
+    Tx []string `json:"tx"`
+    Time int64 `json:"time"`
+    Nonce uint32 `json:"nonce"`
+    Bits string `json:"bits"`
+    Difficulty float64 `json:"difficulty"`
+    PreviousBlockHash string `json:"previousblockhash,omitempty"`
+    NextBlockHash string `json:"nextblockhash,omitempty"`
+}
+
+// Result of a verbose getrawtransaction
+type TxInfo struct {
+    // Hex serialization of the transaction
+    Hex string `json:"hex"`
+    Txid string `json:"txid"`
+    // Hash of the containing block; empty for mempool transactions
+    BlockHash string `json:"blockhash,omitempty"`
+    Confirmations int64 `json:"confirmations"`
+}
+
+// Ask the daemon for a template to mine a new block from.
+func (tt *Client) GetBlockTemplate() (*BlockTemplate, error) {
+    output := new(BlockTemplate)
+    err := tt.Call("getblocktemplate", output, map[string]interface{} {
+        "capabilities": []string { "coinbasetxn", "workid",
+                "coinbase/append" },
+    })
+    if err != nil {
+        return nil, err
+    }
+    if output.Bits == "" {
+        return nil, ErrNoTemplate
+    }
+    return output, nil
+}
+
+// Submit a serialized, solved block to the daemon.  A block the daemon
+// refuses results in an ErrBlockRejected.
+func (tt *Client) SubmitBlock(block []byte) error {
+    // a null result is acceptance; anything else is a rejection reason
+    var reason *string
+    err := tt.Call("submitblock", &reason, hex.EncodeToString(block))
+    if err != nil {
+        return err
+    }
+    if reason != nil {
+        return ErrBlockRejected { *reason }
+    }
+    return nil
+}
+
+// Get a summary of the daemon's view of the block chain.
+func (tt *Client) GetBlockchainInfo() (*ChainInfo, error) {
+    output := new(ChainInfo)
+    err := tt.Call("getblockchaininfo", output)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
+// Get the hash of the block at height in the daemon's best chain.
+func (tt *Client) GetBlockHash(height int64) (bitcoin.Hash, error) {
+    var hashHex string
+    err := tt.Call("getblockhash", &hashHex, height)
+    if err != nil {
+        return bitcoin.Hash{}, err
+    }
+    return bitcoin.HashFromHex(hashHex)
+}
+
+// Get the decoded summary of the block with the given hash.
+func (tt *Client) GetBlock(hash bitcoin.Hash) (*BlockInfo, error) {
+    output := new(BlockInfo)
+    err := tt.Call("getblock", output, hash.String(), true)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
+// Get the serialization of the block with the given hash.
+func (tt *Client) GetRawBlock(hash bitcoin.Hash) ([]byte, error) {
+    var blockHex string
+    err := tt.Call("getblock", &blockHex, hash.String(), false)
+    if err != nil {
+        return nil, err
+    }
+    return hex.DecodeString(blockHex)
+}
+
+// Get a transaction and the block containing it.  Daemons only know the
+// location of transactions outside their wallet when run with -txindex.
+func (tt *Client) GetRawTransaction(txid bitcoin.Hash) (*TxInfo, error) {
+    output := new(TxInfo)
+    err := tt.Call("getrawtransaction", output, txid.String(), 1)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
+// Error when the daemon refuses a submitted block
+type ErrBlockRejected struct { Reason string }
+func (tt ErrBlockRejected) Error() string {
+    return "block rejected: " + tt.Reason
+}
@@ -0,0 +1,301 @@
+package rpc
+
+import (
+    "buildacoin/bitcoin"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "sync"
+)
+
+// JSON-RPC error codes used by bitcoin-derived daemons
+const (
+    ErrCodeMethodNotFound = -32601
+    ErrCodeInvalidParams = -32602
+    ErrCodeInvalidParameter = -8
+    ErrCodeBlockNotFound = -5
+    ErrCodeNoTx = -5
+)
+
+// Handler for one method of a MockServer.  params are the raw JSON call
+// parameters; the returned result is JSON-encoded into the response unless an
+// error is returned.
+type MockHandler func(params []json.RawMessage) (interface{}, *ErrRPC)
+
+// In-process stand-in for a coin daemon's API port.  By default it answers
+// getblocktemplate, submitblock, getblockchaininfo, getblockhash, getblock and
+// getrawtransaction from an in-memory chain of blocks; individual methods can
+// be overridden with Handle.
+type MockServer struct {
+    user string
+    pass string
+    server *httptest.Server
+
+    lock sync.Mutex
+    handlers map[string]MockHandler
+    chain []*bitcoin.Block
+    heights map[bitcoin.Hash]int
+}
+
+// Start a new mock daemon requiring basic auth with user and pass.
+func NewMockServer(user, pass string) *MockServer {
+    output := &MockServer {
+        user: user,
+        pass: pass,
+        handlers: make(map[string]MockHandler),
+        heights: make(map[bitcoin.Hash]int),
+    }
+    output.handlers["getblocktemplate"] = output.getBlockTemplate
+    output.handlers["submitblock"] = output.submitBlock
+    output.handlers["getblockchaininfo"] = output.getBlockchainInfo
+    output.handlers["getblockhash"] = output.getBlockHash
+    output.handlers["getblock"] = output.getBlock
+    output.handlers["getrawtransaction"] = output.getRawTransaction
+    output.server = httptest.NewServer(output)
+    return output
+}
+
+// Get the URL the mock daemon listens at.
+func (tt *MockServer) URL() string {
+    return tt.server.URL + "/"
+}
+
+// Construct a client with the mock daemon's credentials.
+func (tt *MockServer) Client() *Client {
+    return NewClientURL(tt.URL(), tt.user, tt.pass)
+}
+
+// Stop the mock daemon.
+func (tt *MockServer) Close() {
+    tt.server.Close()
+}
+
+// Override or add the handler for a method.
+func (tt *MockServer) Handle(method string, handler MockHandler) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    tt.handlers[method] = handler
+}
+
+// Append a block to the mock daemon's chain.
+func (tt *MockServer) AddBlock(block *bitcoin.Block) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    tt.heights[block.Hash()] = len(tt.chain)
+    tt.chain = append(tt.chain, block)
+}
+
+func (tt *MockServer) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+    user, pass, ok := req.BasicAuth()
+    if !ok || user != tt.user || pass != tt.pass {
+        out.WriteHeader(http.StatusUnauthorized)
+        return
+    }
+
+    var call struct {
+        Id uint64 `json:"id"`
+        Method string `json:"method"`
+        Params []json.RawMessage `json:"params"`
+    }
+    err := json.NewDecoder(req.Body).Decode(&call)
+    if err != nil {
+        out.WriteHeader(http.StatusBadRequest)
+        return
+    }
+
+    tt.lock.Lock()
+    handler, ok := tt.handlers[call.Method]
+    tt.lock.Unlock()
+
+    var result interface{}
+    var rpcErr *ErrRPC
+    if ok {
+        result, rpcErr = handler(call.Params)
+    } else {
+        rpcErr = &ErrRPC { ErrCodeMethodNotFound, "Method not found" }
+    }
+
+    // mirror bitcoind, which marks failed calls with a server error status
+    out.Header().Set("Content-Type", "application/json")
+    if rpcErr != nil {
+        out.WriteHeader(http.StatusInternalServerError)
+    }
+    json.NewEncoder(out).Encode(map[string]interface{} {
+        "id": call.Id,
+        "result": result,
+        "error": rpcErr,
+    })
+}
+
+//
+// Default chain-backed handlers
+//
+
+func (tt *MockServer) getBlockTemplate(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    output := map[string]interface{} {
+        "version": 1,
+        "transactions": []TemplateTx {},
+        "coinbaseaux": map[string]string { "flags": "" },
+        "coinbasevalue": 50 * bitcoin.Coin,
+        "mutable": []string { "time", "transactions", "prevblock" },
+        "noncerange": "00000000ffffffff",
+        "sigoplimit": 20000,
+        "sizelimit": 1000000,
+        "bits": strconv.FormatUint(bitcoin.Diff1Bits, 16),
+        "height": len(tt.chain),
+    }
+    if len(tt.chain) > 0 {
+        tip := tt.chain[len(tt.chain)-1]
+        output["previousblockhash"] = tip.Hash().String()
+        output["curtime"] = tip.Timestamp().Unix() + 1
+        output["mintime"] = tip.Timestamp().Unix() + 1
+        output["bits"] = strconv.FormatUint(uint64(tip.TargetBits()), 16)
+    }
+    return output, nil
+}
+
+func (tt *MockServer) submitBlock(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    var blockHex string
+    if len(params) < 1 || json.Unmarshal(params[0], &blockHex) != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParams, "expected block hex" }
+    }
+    blockBytes, err := hex.DecodeString(blockHex)
+    if err != nil || len(blockBytes) < bitcoin.HeaderLen {
+        return nil, &ErrRPC { ErrCodeInvalidParameter, "Block decode failed" }
+    }
+    block, err := bitcoin.ParseBlock(blockBytes)
+    if err != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParameter, "Block decode failed" }
+    }
+
+    tt.lock.Lock()
+    tipOk := len(tt.chain) == 0 ||
+            tt.chain[len(tt.chain)-1].Hash() == block.PrevBlock()
+    tt.lock.Unlock()
+    if !tipOk {
+        return "inconclusive-not-best-prevblk", nil
+    }
+    tt.AddBlock(block)
+    return nil, nil
+}
+
+func (tt *MockServer) getBlockchainInfo(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    output := &ChainInfo {
+        Chain: "main",
+        Blocks: int64(len(tt.chain)) - 1,
+        Headers: int64(len(tt.chain)) - 1,
+        VerificationProgress: 1.0,
+    }
+    if len(tt.chain) > 0 {
+        tip := tt.chain[len(tt.chain)-1]
+        output.BestBlockHash = tip.Hash().String()
+        output.Difficulty = bitcoin.Difficulty(tip.TargetBits())
+        output.MedianTime = tip.Timestamp().Unix()
+    }
+    return output, nil
+}
+
+func (tt *MockServer) getBlockHash(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    var height int64
+    if len(params) < 1 || json.Unmarshal(params[0], &height) != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParams, "expected block height" }
+    }
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    if height < 0 || height >= int64(len(tt.chain)) {
+        return nil, &ErrRPC { ErrCodeInvalidParameter,
+                "Block height out of range" }
+    }
+    return tt.chain[height].Hash().String(), nil
+}
+
+func (tt *MockServer) getBlock(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    var hashHex string
+    if len(params) < 1 || json.Unmarshal(params[0], &hashHex) != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParams, "expected block hash" }
+    }
+    verbose := true
+    if len(params) > 1 {
+        json.Unmarshal(params[1], &verbose)
+    }
+    hash, err := bitcoin.HashFromHex(hashHex)
+    if err != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParameter, "Invalid block hash" }
+    }
+
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    height, ok := tt.heights[hash]
+    if !ok {
+        return nil, &ErrRPC { ErrCodeBlockNotFound, "Block not found" }
+    }
+    block := tt.chain[height]
+    blockBytes := block.Bytes()
+    if !verbose {
+        return hex.EncodeToString(blockBytes), nil
+    }
+
+    output := &BlockInfo {
+        Hash: hash.String(),
+        Confirmations: int64(len(tt.chain) - height),
+        Size: len(blockBytes),
+        Height: int64(height),
+        Version: block.Version(),
+        MerkleRoot: block.MerkleRoot().String(),
+        Time: block.Timestamp().Unix(),
+        Nonce: block.Nonce(),
+        Bits: strconv.FormatUint(uint64(block.TargetBits()), 16),
+        Difficulty: bitcoin.Difficulty(block.TargetBits()),
+    }
+    for _, tx := range block.Txs() {
+        output.Tx = append(output.Tx, tx.Hash().String())
+    }
+    if height > 0 {
+        output.PreviousBlockHash = block.PrevBlock().String()
+    }
+    if height < len(tt.chain) - 1 {
+        output.NextBlockHash = tt.chain[height+1].Hash().String()
+    }
+    return output, nil
+}
+
+func (tt *MockServer) getRawTransaction(params []json.RawMessage) (interface{},
+        *ErrRPC) {
+    var txidHex string
+    if len(params) < 1 || json.Unmarshal(params[0], &txidHex) != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParams, "expected txid" }
+    }
+    txid, err := bitcoin.HashFromHex(txidHex)
+    if err != nil {
+        return nil, &ErrRPC { ErrCodeInvalidParameter, "Invalid txid" }
+    }
+
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    for height, block := range tt.chain {
+        for _, tx := range block.Txs() {
+            if tx.Hash() != txid {
+                continue
+            }
+            return &TxInfo {
+                Hex: hex.EncodeToString(tx.Bytes()),
+                Txid: txidHex,
+                BlockHash: block.Hash().String(),
+                Confirmations: int64(len(tt.chain) - height),
+            }, nil
+        }
+    }
+    return nil, &ErrRPC { ErrCodeNoTx,
+            "No information available about transaction" }
+}
@@ -0,0 +1,232 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/template"
+    "encoding/json"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Spellings of a literal that can be substituted along with it
+const (
+    LowerVariant = "lower"
+    UpperVariant = "upper"
+    TitleVariant = "title"
+
+    // Input group of skeleton inputs when the map declares none
+    DefaultAuthorGroup = "basics"
+)
+
+// Types of the substitutions of case variants, which take the same input as
+// their literal
+var variantTypes = map[string]string {
+    LowerVariant: "str-alpha-lower",
+    UpperVariant: "str-alpha-upper",
+    TitleVariant: "str-alpha",
+}
+
+// How to turn an upstream source tree into a base coin: the literal values in
+// the upstream source to replace with substitution markers, and enough about
+// the base to make a skeleton of its metadata
+type AuthorMap struct {
+    Id string `json:"id"`
+    Label string `json:"label"`
+    Version string `json:"version"`
+    InGroups []string `json:"input groups"`
+    LeadMarker string `json:"lead marker"`
+    TailMarker string `json:"tail marker"`
+    Literals []AuthorLiteral `json:"literals"`
+}
+
+// A literal value in upstream source and the substitution replacing it
+type AuthorLiteral struct {
+    Literal string `json:"literal"`
+    Idx uint `json:"substitution index"`
+    // whether only occurrences that aren't part of a longer word are replaced,
+    // as for numbers
+    WholeWord bool `json:"whole word"`
+    // the user input the substitution takes its value from, if any
+    Input string `json:"input"`
+    // input group of the input, if not the first declared
+    Group string `json:"group"`
+    Comment string `json:"comment"`
+    Type string `json:"type"`
+    // substitution indices of other spellings of the literal ("lower",
+    // "upper", "title"), which are replaced too and take the same input
+    Variants map[string]uint `json:"case variants"`
+}
+
+// Load an authoring map from a JSON file.
+func LoadAuthorMap(path string) (*AuthorMap, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    output := new(AuthorMap)
+    err = json.NewDecoder(file).Decode(output)
+    if err != nil {
+        return nil, err
+    }
+    return output, nil
+}
+
+// Get the markers the base's template will use.
+func (tt *AuthorMap) Markers() template.Markers {
+    return template.Markers { Lead: tt.LeadMarker, Tail: tt.TailMarker }
+}
+
+// Expand the literals to replace to include their case variants, each with
+// the substitution describing it.  It is an error for a spelling or an index
+// to be mapped twice.
+func (tt *AuthorMap) Expand() ([]template.Literal, []data.Sub, error) {
+    literals := make([]template.Literal, 0, len(tt.Literals))
+    subs := make([]data.Sub, 0, len(tt.Literals))
+    spellings := make(map[string]bool)
+    indices := make(map[uint]bool)
+    add := func(value string, idx uint, wholeWord bool, sub data.Sub) error {
+        if value == "" || spellings[value] {
+            return ErrDuplicateLiteral { value }
+        }
+        if indices[idx] {
+            return ErrDuplicateIdx { idx }
+        }
+        spellings[value] = true
+        indices[idx] = true
+        literals = append(literals,
+                template.Literal { Value: value, Idx: idx,
+                WholeWord: wholeWord })
+        sub.Idx = idx
+        sub.Default = value
+        subs = append(subs, sub)
+        return nil
+    }
+
+    for _, literal := range tt.Literals {
+        err := add(literal.Literal, literal.Idx, literal.WholeWord, data.Sub {
+            Input: literal.Input,
+            Comment: literal.Comment,
+            Type: literal.Type,
+        })
+        if err != nil {
+            return nil, nil, err
+        }
+        variants := make([]string, 0, len(literal.Variants))
+        for variant := range literal.Variants {
+            variants = append(variants, variant)
+        }
+        sort.Strings(variants)
+        for _, variant := range variants {
+            value, ok := caseVariant(literal.Literal, variant)
+            if !ok {
+                return nil, nil, ErrUnknownVariant { variant }
+            }
+            comment := variant + " case " + literal.Literal
+            if literal.Comment != "" {
+                comment = literal.Comment + " (" + variant + " case)"
+            }
+            err = add(value, literal.Variants[variant], literal.WholeWord,
+                    data.Sub {
+                Input: literal.Input,
+                Comment: comment,
+                Type: variantTypes[variant],
+            })
+            if err != nil {
+                return nil, nil, err
+            }
+        }
+    }
+    return literals, subs, nil
+}
+
+// Make the skeleton metadata of the base: a substitution for each literal, an
+// input for each input named, with the upstream values as defaults so the
+// base builds its upstream coin until they are changed.
+func (tt *AuthorMap) Skeleton() (*data.Meta, error) {
+    _, subs, err := tt.Expand()
+    if err != nil {
+        return nil, err
+    }
+    sort.Stable(subsByIdx(subs))
+
+    groups := tt.InGroups
+    if len(groups) < 1 {
+        groups = []string { DefaultAuthorGroup }
+    }
+    inputs := make([]data.Input, 0)
+    seen := make(map[string]bool)
+    for _, literal := range tt.Literals {
+        if literal.Input == "" || seen[literal.Input] {
+            continue
+        }
+        seen[literal.Input] = true
+        group := literal.Group
+        if group == "" {
+            group = groups[0]
+        }
+        inputs = append(inputs, data.Input {
+            Group: group,
+            Id: literal.Input,
+            Label: literal.Input,
+            Default: literal.Literal,
+        })
+    }
+
+    meta := data.NewMeta(tt.Id, tt.Label, tt.Version, groups, inputs, subs)
+    return meta.WithMarkers(tt.LeadMarker, tt.TailMarker), nil
+}
+
+// Get a spelling of a literal.
+func caseVariant(literal, variant string) (string, bool) {
+    switch variant {
+    case LowerVariant:
+        return strings.ToLower(literal), true
+    case UpperVariant:
+        return strings.ToUpper(literal), true
+    case TitleVariant:
+        lower := strings.ToLower(literal)
+        if lower == "" {
+            return "", true
+        }
+        return strings.ToUpper(lower[:1]) + lower[1:], true
+    }
+    return "", false
+}
+
+// sort.Interface ordering subs by index
+type subsByIdx []data.Sub
+func (tt subsByIdx) Len() int {
+    return len(tt)
+}
+func (tt subsByIdx) Less(ii, jj int) bool {
+    return tt[ii].Idx < tt[jj].Idx
+}
+func (tt subsByIdx) Swap(ii, jj int) {
+    tt[ii], tt[jj] = tt[jj], tt[ii]
+}
+
+//
+// Errors
+//
+
+type ErrDuplicateLiteral struct { literal string }
+func (tt ErrDuplicateLiteral) Error() string {
+    if tt.literal == "" {
+        return "empty literal"
+    }
+    return "literal '" + tt.literal + "' is mapped more than once"
+}
+type ErrDuplicateIdx struct { idx uint }
+func (tt ErrDuplicateIdx) Error() string {
+    return "substitution index " + strconv.Itoa(int(tt.idx)) +
+            " is mapped more than once"
+}
+type ErrUnknownVariant struct { variant string }
+func (tt ErrUnknownVariant) Error() string {
+    return "unknown case variant '" + tt.variant + "' (expected '" +
+            LowerVariant + "', '" + UpperVariant + "' or '" + TitleVariant +
+            "')"
+}
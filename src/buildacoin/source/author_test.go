@@ -0,0 +1,69 @@
+package source
+
+import (
+    "testing"
+)
+
+func TestAuthorMap(t *testing.T) {
+    authorMap := &AuthorMap {
+        Id: "tinycoin",
+        Literals: []AuthorLiteral {
+            AuthorLiteral { Literal: "Litecoin", Idx: 3, Input: "name",
+                    Type: "str-alpha", Variants: map[string]uint {
+                    UpperVariant: 21, LowerVariant: 20 } },
+            AuthorLiteral { Literal: "9333", Idx: 19, Input: "port",
+                    Type: "uint16", WholeWord: true },
+        },
+    }
+    literals, _, err := authorMap.Expand()
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := []string { "Litecoin", "litecoin", "LITECOIN", "9333" }
+    if len(literals) != len(expected) {
+        t.Fatalf("expected %d literals, got %v\n", len(expected), literals)
+    }
+    for ii, value := range expected {
+        if literals[ii].Value != value {
+            t.Fatalf("expected literal '%s', got '%s'\n", value,
+                    literals[ii].Value)
+        }
+    }
+
+    // the skeleton builds the upstream coin and lints clean but for markers
+    meta, err := authorMap.Skeleton()
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if meta.InputCount() != 2 || meta.Input(0).Group != DefaultAuthorGroup ||
+            meta.Sub(1).Idx != 19 || meta.Sub(2).Type != "str-alpha-lower" {
+        t.Fatalf("unexpected skeleton %v\n", meta)
+    }
+    filterMap, err := BuildFilterMap(meta, map[string]string {})
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if string(filterMap[21]) != "LITECOIN" || string(filterMap[19]) != "9333" {
+        t.Fatalf("unexpected filter map %v\n", filterMap)
+    }
+    markers := map[uint][]string {}
+    for _, literal := range literals {
+        markers[literal.Idx] = []string { "main.h" }
+    }
+    if problems := Lint(meta, markers); len(problems) != 0 {
+        t.Fatalf("unexpected problems %v\n", problems)
+    }
+
+    // spellings and indices are mapped once
+    authorMap.Literals = append(authorMap.Literals,
+            AuthorLiteral { Literal: "LITECOIN", Idx: 40 })
+    _, _, err = authorMap.Expand()
+    if _, ok := err.(ErrDuplicateLiteral); !ok {
+        t.Fatalf("expected duplicate literal, got %v\n", err)
+    }
+    authorMap.Literals[2] = AuthorLiteral { Literal: "Bestcoin", Idx: 20 }
+    _, _, err = authorMap.Expand()
+    if _, ok := err.(ErrDuplicateIdx); !ok {
+        t.Fatalf("expected duplicate index, got %v\n", err)
+    }
+}
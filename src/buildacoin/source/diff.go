@@ -0,0 +1,45 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/source/types"
+    "buildacoin/template"
+    "io"
+)
+
+// Write a unified diff of the source of two coins made from a base coin, by
+// filtering its template with both sets of values and applying its file rules
+// to each.
+func Diff(dst io.Writer, meta *data.Meta,
+        before, after template.FilterMap) error {
+    rules, err := FileRules(meta)
+    if err != nil {
+        return err
+    }
+    base, streamType, err := meta.Template()
+    if err != nil {
+        return err
+    }
+    defer base.Close()
+    format, err := template.ParseFormat(streamType)
+    if err != nil {
+        return err
+    }
+    return template.DiffArchive(dst, base, format, Markers(meta), rules,
+            HexValues(meta), before, after)
+}
+
+// Write a unified diff of the source of a coin made from a base coin against
+// a coin made with the base's default inputs.  The defaults' random and
+// time-dependent values are drawn from ctx, so in the coin's own context (see
+// CoinContext) they match the coin's and the diff is the same every time.
+// Keys the defaults generate still come from crypto/rand, so they and the
+// substitutions derived from them always differ.
+func DiffDefaults(ctx *types.Context, dst io.Writer, meta *data.Meta,
+        values template.FilterMap) error {
+    defaults, _, err := BuildFilterMapKeys(ctx, meta, DefaultValues(meta))
+    if err != nil {
+        return err
+    }
+    return Diff(dst, meta, defaults, values)
+}
@@ -1,16 +1,22 @@
 package source
 
 import (
+    "buildacoin/bitcoin"
     "buildacoin/data"
+    "buildacoin/source/icons"
     "buildacoin/source/types"
     "buildacoin/template"
-    "errors"
+    "bytes"
+    "image"
+    "image/png"
     "io"
-)
-
-var (
-    // Error when no new dependencies can be met
-    ErrDepDeadlock error = errors.New("dependency deadlock")
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
 )
 
 // Return a reader of the source archive for a new coin.
@@ -27,51 +33,278 @@ func Generate(meta *data.Meta, base io.Reader,
         return nil, err
     }
 
-    return template.NewFilter(base, filterMap), nil
+    return template.NewMarkedFilter(base, filterMap,
+            Markers(meta)).Hex(HexValues(meta)), nil
+}
+
+// Get the markers delimiting substitutions in a base coin's template.
+func Markers(meta *data.Meta) template.Markers {
+    return template.Markers { Lead: meta.LeadMarker(),
+            Tail: meta.TailMarker() }
+}
+
+// Get the indices of a base coin's substitutions whose types produce hex
+// bytes, which template encodings mustn't read as numbers.
+func HexValues(meta *data.Meta) map[uint]bool {
+    output := make(map[uint]bool)
+    for _, sub := range meta.Subs() {
+        if types.HexTypes[sub.Type] {
+            output[sub.Idx] = true
+        }
+    }
+    return output
+}
+
+// Get the runner filtering a base coin's template into coins output as
+// outputType, applying its file rules.
+func Runner(meta *data.Meta, archiveType,
+        outputType string) (template.ArchiveRunner, error) {
+    output, err := template.GetRunner(archiveType, outputType, Markers(meta))
+    if err != nil {
+        return output, err
+    }
+    output.HexValues = HexValues(meta)
+    output.Rules, err = FileRules(meta)
+    return output, err
+}
+
+// Get the runner filtering a base coin's template into a git repository output
+// as outputType, whose first commit names the base and its version.
+func GitRunner(meta *data.Meta, archiveType,
+        outputType string) (template.GitRunner, error) {
+    output, err := template.GetGitRunner(archiveType, outputType,
+            Markers(meta))
+    if err != nil {
+        return output, err
+    }
+    output.BaseMessage = "Add " + meta.Id() + " " + meta.Version() +
+            " base template"
+    output.HexValues = HexValues(meta)
+    output.Rules, err = FileRules(meta)
+    return output, err
+}
+
+// Get a base coin's file rules as runners apply them, with their paths
+// compiled and their inputs found as the boolean substitutions taking them.
+func FileRules(meta *data.Meta) (template.FileRules, error) {
+    rules := meta.FileRules()
+    output := template.FileRules{}
+    for _, rule := range rules.Exclude {
+        path, err := rulePath(rule.Path)
+        if err != nil {
+            return output, err
+        }
+        unless, err := boolSubs(meta, rule.Unless)
+        if err != nil {
+            return output, err
+        }
+        output.Exclude = append(output.Exclude,
+                template.ExcludeRule { Path: path, Unless: unless })
+    }
+    for _, rule := range rules.Rename {
+        path, err := rulePath(rule.Path)
+        if err != nil {
+            return output, err
+        }
+        output.Rename = append(output.Rename,
+                template.RenameRule { Path: path, To: rule.To })
+    }
+    for _, file := range rules.Generate {
+        cond, err := boolSubs(meta, file.If)
+        if err != nil {
+            return output, err
+        }
+        output.Generate = append(output.Generate, template.GeneratedFile {
+                Name: file.Name, Contents: file.Contents, If: cond })
+    }
+    var err error
+    output.Replace, err = logoAssets(meta)
+    return output, err
+}
+
+// Get a base coin's images drawn from the logo as the files replacing them.
+// The logo is decoded once however many images are drawn from it.
+func logoAssets(meta *data.Meta) ([]template.ReplacedFile, error) {
+    logo, ok := meta.Logo()
+    if !ok {
+        return nil, nil
+    }
+    idx, ok := subTaking(meta, logo.Input, "png")
+    if !ok {
+        return nil, ErrLogoInput { logo.Input }
+    }
+    var decoded image.Image
+    var decodedFrom string
+    output := make([]template.ReplacedFile, 0, len(logo.Assets))
+    for _, asset := range logo.Assets {
+        icon, err := iconAsset(asset)
+        if err != nil {
+            return nil, err
+        }
+        draw := func(value []byte) ([]byte, error) {
+            if decoded == nil || decodedFrom != string(value) {
+                logoImage, err := png.Decode(bytes.NewReader(value))
+                if err != nil {
+                    return nil, err
+                }
+                decoded, decodedFrom = logoImage, string(value)
+            }
+            return icon.Draw(decoded)
+        }
+        output = append(output, template.ReplacedFile { Name: asset.Path,
+                Idx: idx, Draw: draw })
+    }
+    return output, nil
+}
+
+// Get the image a base coin's asset describes.  Xpms are named for their
+// files, as the placeholders are.
+func iconAsset(asset data.Asset) (icons.Asset, error) {
+    output := icons.Asset { Format: asset.Format, Sizes: asset.Sizes,
+            Width: asset.Width, Height: asset.Height,
+            Name: strings.TrimSuffix(path.Base(asset.Path),
+            path.Ext(asset.Path)) }
+    var err error
+    if asset.Background != "" {
+        output.Background, err = icons.ParseColour(asset.Background)
+    }
+    if err == nil {
+        err = output.Check()
+    }
+    if err != nil {
+        return output, ErrBadAsset { asset.Path, err }
+    }
+    return output, nil
+}
+
+func rulePath(path string) (*regexp.Regexp, error) {
+    output, err := regexp.Compile(path)
+    if err != nil {
+        return nil, ErrBadRulePath { path, err }
+    }
+    return output, nil
+}
+
+// Find the indices of the boolean substitutions taking each of inputs.
+func boolSubs(meta *data.Meta, inputs []string) ([]uint, error) {
+    output := make([]uint, 0, len(inputs))
+    for _, input := range inputs {
+        idx, ok := subTaking(meta, input, "bool")
+        if !ok {
+            return nil, ErrNotBoolInput { input }
+        }
+        output = append(output, idx)
+    }
+    return output, nil
+}
+
+// Find the index of the substitution of the type taking an input.
+func subTaking(meta *data.Meta, input, typeName string) (uint, bool) {
+    for _, sub := range meta.Subs() {
+        if sub.Input == input && sub.Type == typeName {
+            return sub.Idx, true
+        }
+    }
+    return 0, false
+}
+
+// A private key generated while building a filter map, along with the
+// substitution whose value is derived from it
+type GeneratedKey struct {
+    Sub data.Sub
+    Key bitcoin.PrivateKey
 }
 
 // Build a mapping acceptable to the template filter for given metadata and
 // user values 
 func BuildFilterMap(meta *data.Meta,
         values map[string]string) (template.FilterMap, error) {
+    output, _, err := BuildFilterMapKeys(nil, meta, values)
+    return output, err
+}
+
+// Get the values of a base coin's inputs when its form is left as it is.
+func DefaultValues(meta *data.Meta) map[string]string {
+    output := make(map[string]string)
+    for _, input := range meta.Inputs() {
+        output[input.Id] = input.Default
+    }
+    return output
+}
+
+// Get the context a coin's values are produced in: seeded by its ID, with the
+// clock stopped at its creation.  Building a coin's filter map again in its
+// context gives the same values, keys aside.
+func CoinContext(id data.CoinID, created time.Time) *types.Context {
+    return types.SeededContext(id.Bytes(), created)
+}
+
+// Build a filter map as BuildFilterMap does, also returning the private keys
+// behind any substitutions whose types generated them.  The keys are not kept
+// anywhere else, so the caller is responsible for getting them to the coin's
+// creator.  Random and time-dependent values are drawn from ctx; a nil ctx
+// gives ones nobody can reproduce.  Bad values don't stop the build: every one
+// found is returned together in an ErrBadValues, against the inputs it came
+// from.
+func BuildFilterMapKeys(ctx *types.Context, meta *data.Meta,
+        values map[string]string) (template.FilterMap, []GeneratedKey, error) {
 
     output := make(template.FilterMap)
+    keys := []GeneratedKey {}
 
     allSubs := meta.Subs()
-    subs := make([]data.Sub, len(allSubs))
-    unmet := make([]data.Sub, 0, len(allSubs))
-    depArgs := make([]string, 10)
-
-    // for each pass over the substitutions, subs with unmet dependencies are
-    // left until either the list of subs doesn't shrink or shrinks to zero
-    copy(subs, allSubs)
-    deploop:
-    for {
-        // for each substitution field described by the base coin metadata. . .
-        passloop:
-        for _, sub := range subs {
-            // does this substitution field have dependencies?
-            depArgs = depArgs[:0]
-            deps := sub.Deps
-            if len(deps) > 0 {
-                for _, depIdx := range deps {
-                    // if any dependency isn't available, add this sub to the
-                    // unmet list and move on
-                    depBytes, ok := output[depIdx]
-                    if !ok {
-                        unmet = append(unmet, sub)
-                        continue passloop
-                    }
-                    depArgs = append(depArgs, string(depBytes))
+    byIdx := make(map[uint]data.Sub, len(allSubs))
+    for _, sub := range allSubs {
+        byIdx[sub.Idx] = sub
+    }
+    levels, err := depLevels(allSubs)
+    if err != nil {
+        return nil, nil, err
+    }
+    // every type is known before any value is produced, so no level is left
+    // with producers still running
+    for _, sub := range allSubs {
+        if _, ok := types.Map[sub.Type]; !ok {
+            return nil, nil, ErrUnknownType { sub.Input, sub.Type }
+        }
+    }
+
+    // values which fail their inputs' own checks are reported as such rather
+    // than by the substitutions taking them
+    bad := CheckInputs(meta, values)
+    badInputs := make(map[string]bool, len(bad))
+    for _, err := range bad {
+        badInputs[err.Input] = true
+    }
+    // substitutions without values, whose failures (or whose dependencies')
+    // have been reported
+    failed := make(map[uint]bool)
+
+    // the subs of a level depend only on those of earlier levels, so the
+    // expensive ones among them are produced concurrently
+    for _, level := range levels {
+        results := make([]produced, len(level))
+        var wait sync.WaitGroup
+        levelloop:
+        for ii, sub := range level {
+            if sub.Input != "" && badInputs[sub.Input] {
+                failed[sub.Idx] = true
+                continue
+            }
+            // a sub can't do without a failed dependency, and has nothing of
+            // its own to report
+            depArgs := make([]string, 0, len(sub.Deps))
+            for _, depIdx := range sub.Deps {
+                if failed[depIdx] {
+                    failed[sub.Idx] = true
+                    continue levelloop
                 }
+                depArgs = append(depArgs, string(output[depIdx]))
             }
 
             // find the type of the field (specifically the string -> type
             // conversion func)
-            valueType, ok := types.Map[sub.Type]
-            if !ok {
-                return nil, ErrUnknownType { sub.Input, sub.Type }
-            }
+            valueType := types.Map[sub.Type]
 
             // look for user input for the field in the supplied values map,
             // and use the default value if it wasn't given
@@ -82,42 +315,257 @@ func BuildFilterMap(meta *data.Meta,
                 input = sub.Default
             }
 
-            // convert the string input to a string representing the typed
-            // value
             inputs := append([]string { input }, depArgs...)
-            valueString, err := valueType.Produce(inputs...)
-            if err != nil {
-                return nil, ErrBadFieldValue { sub.Input, input, sub.Type }
+            subCtx := ctx.Sub(sub.Idx)
+            if _, ok := valueType.(types.Expensive); ok {
+                wait.Add(1)
+                go func(result *produced) {
+                    defer wait.Done()
+                    *result = produce(subCtx, valueType, inputs)
+                }(&results[ii])
+            } else {
+                results[ii] = produce(subCtx, valueType, inputs)
             }
+        }
+        wait.Wait()
 
+        // results are gathered in the order of the metadata, whatever order
+        // they were produced in
+        for ii, sub := range level {
+            result := results[ii]
+            if failed[sub.Idx] {
+                continue
+            }
+            if result.err != nil {
+                failed[sub.Idx] = true
+                bad = append(bad, subFailure(byIdx, sub, result.inputs[0],
+                        result.err)...)
+                continue
+            }
+            if result.key != nil {
+                keys = append(keys, GeneratedKey { sub, *result.key })
+            }
             // place the vetted value in the filter map at the appropriate
             // substitution index
-            output[sub.Idx] = []byte(valueString)
+            output[sub.Idx] = []byte(result.value)
         }
-        if len(unmet) < 1 {
-            break deploop
+    }
+
+    if len(bad) > 0 {
+        // in the order the form shows the inputs, failures of the base's own
+        // values last
+        order := make(map[string]int)
+        for ii, input := range meta.Inputs() {
+            order[input.Id] = ii
         }
-        if len(unmet) >= len(subs) {
-            return nil, ErrDepDeadlock
+        order[""] = meta.InputCount()
+        sort.SliceStable(bad, func(ii, jj int) bool {
+            return order[bad[ii].Input] < order[bad[jj].Input]
+        })
+        return nil, nil, bad
+    }
+    return output, keys, nil
+}
+
+// The value of a substitution produced by its type from its input and the
+// values of its dependencies
+type produced struct {
+    inputs []string
+    value string
+    key *bitcoin.PrivateKey
+    err error
+}
+
+// Convert the string input of a substitution, followed by the values of its
+// dependencies, to a string representing the typed value.
+func produce(ctx *types.Context, valueType types.Type,
+        inputs []string) produced {
+    output := produced { inputs: inputs }
+    if keyType, ok := valueType.(types.KeyProducer); ok {
+        output.value, output.key, output.err = keyType.ProduceKey(ctx,
+                inputs...)
+    } else {
+        output.value, output.err = valueType.Produce(ctx, inputs...)
+    }
+    return output
+}
+
+// Order a base coin's substitutions so each comes after its dependencies, as
+// levels whose subs depend only on those of earlier levels.  Subs keep their
+// order within a level.
+func depLevels(subs []data.Sub) ([][]data.Sub, error) {
+    byIdx := make(map[uint]data.Sub, len(subs))
+    for _, sub := range subs {
+        byIdx[sub.Idx] = sub
+    }
+    for _, sub := range subs {
+        for _, dep := range sub.Deps {
+            if _, ok := byIdx[dep]; !ok {
+                return nil, ErrDanglingDep { sub, dep }
+            }
         }
-        // the subs whose dependencies were unmet this pass are the source of
-        // the next pass
-        subs = subs[:len(unmet)]
-        copy(subs, unmet)
-        unmet = unmet[:0]
     }
 
+    output := make([][]data.Sub, 0)
+    placed := make(map[uint]bool, len(subs))
+    for len(subs) > 0 {
+        level := make([]data.Sub, 0, len(subs))
+        rest := make([]data.Sub, 0, len(subs))
+        subloop:
+        for _, sub := range subs {
+            for _, dep := range sub.Deps {
+                if !placed[dep] {
+                    rest = append(rest, sub)
+                    continue subloop
+                }
+            }
+            level = append(level, sub)
+        }
+        if len(level) == 0 {
+            return nil, ErrDepCycle { depCycle(byIdx, rest) }
+        }
+        for _, sub := range level {
+            placed[sub.Idx] = true
+        }
+        output = append(output, level)
+        subs = rest
+    }
     return output, nil
 }
 
+// Find a cycle among substitutions none of which can be placed after its
+// dependencies, as the path around it back to where it starts.  Each such sub
+// depends on another of them, so following dependencies among them must come
+// round to a sub already passed.
+func depCycle(byIdx map[uint]data.Sub, stuck []data.Sub) []data.Sub {
+    isStuck := make(map[uint]bool, len(stuck))
+    for _, sub := range stuck {
+        isStuck[sub.Idx] = true
+    }
+    path := make([]data.Sub, 0)
+    pathIdx := make(map[uint]int)
+    sub := stuck[0]
+    for {
+        if start, ok := pathIdx[sub.Idx]; ok {
+            return append(path[start:], sub)
+        }
+        pathIdx[sub.Idx] = len(path)
+        path = append(path, sub)
+        for _, dep := range sub.Deps {
+            if isStuck[dep] {
+                sub = byIdx[dep]
+                break
+            }
+        }
+    }
+}
+
+// Attribute the failure of a substitution's value to the inputs it came from:
+// its own if it has one, or else those its dependencies came from.  Values
+// which come from no input are the base's own, and are attributed to "".
+func subFailure(byIdx map[uint]data.Sub, sub data.Sub, value string,
+        cause error) []InputError {
+    if sub.Input != "" {
+        return []InputError { InputError { sub.Input,
+                ErrNotOfType { value, sub.Type, cause } } }
+    }
+    derived := ErrDerivedValue { sub, cause }
+    inputs := originInputs(byIdx, sub, make(map[uint]bool))
+    if len(inputs) == 0 {
+        return []InputError { InputError { "", derived } }
+    }
+    output := make([]InputError, 0, len(inputs))
+    for _, input := range inputs {
+        output = append(output, InputError { input, derived })
+    }
+    return output
+}
+
+// Find the inputs a substitution's dependencies take, however indirectly, in
+// the order they're first met.
+func originInputs(byIdx map[uint]data.Sub, sub data.Sub,
+        seen map[uint]bool) []string {
+    output := make([]string, 0)
+    for _, depIdx := range sub.Deps {
+        dep, ok := byIdx[depIdx]
+        if !ok || seen[depIdx] {
+            continue
+        }
+        seen[depIdx] = true
+        if dep.Input != "" && !inStrings(output, dep.Input) {
+            output = append(output, dep.Input)
+        }
+        for _, input := range originInputs(byIdx, dep, seen) {
+            if !inStrings(output, input) {
+                output = append(output, input)
+            }
+        }
+    }
+    return output
+}
+
+func inStrings(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
 // Error when a provided substitution field is of an unknown type.
 type ErrUnknownType struct { field string; typeName string }
 func (tt ErrUnknownType) Error() string {
     return "field '" + tt.field + "' has unknown type '" + tt.typeName + "'"
 }
-// Error when the conversion for a substitution field from a string fails.
-type ErrBadFieldValue struct { field string; value string; typeName string }
-func (tt ErrBadFieldValue) Error() string {
-    return "bad value '" + tt.value + "' for field '" + tt.field +
-            "' of type '" + tt.typeName + "'"
+// Error when a value doesn't convert to its substitution's type
+type ErrNotOfType struct { value string; typeName string; cause error }
+func (tt ErrNotOfType) Error() string {
+    return "has value '" + tt.value + "' not of type '" + tt.typeName + "': " +
+            tt.cause.Error()
+}
+// Error when a substitution derived from inputs' values fails its type
+type ErrDerivedValue struct { sub data.Sub; cause error }
+func (tt ErrDerivedValue) Error() string {
+    return "breaks " + describeSub(tt.sub) + " of type '" +
+            tt.sub.Type + "': " + tt.cause.Error()
+}
+// Error when substitutions depend on each other in a cycle, given as the path
+// around it
+type ErrDepCycle struct { cycle []data.Sub }
+func (tt ErrDepCycle) Error() string {
+    path := make([]string, len(tt.cycle))
+    for ii, sub := range tt.cycle {
+        path[ii] = strconv.Itoa(int(sub.Idx))
+        if sub.Comment != "" {
+            path[ii] += " (" + sub.Comment + ")"
+        }
+    }
+    return "dependency cycle " + strings.Join(path, " -> ")
+}
+// Error when a substitution depends on one that doesn't exist
+type ErrDanglingDep struct { sub data.Sub; dep uint }
+func (tt ErrDanglingDep) Error() string {
+    return describeSub(tt.sub) + " depends on missing substitution " +
+            strconv.Itoa(int(tt.dep))
+}
+// Error when a file rule's path isn't a regular expression.
+type ErrBadRulePath struct { path string; cause error }
+func (tt ErrBadRulePath) Error() string {
+    return "bad file rule path '" + tt.path + "': " + tt.cause.Error()
+}
+// Error when a file rule depends on an input no boolean substitution takes.
+type ErrNotBoolInput struct { input string }
+func (tt ErrNotBoolInput) Error() string {
+    return "file rule input '" + tt.input + "' has no boolean substitution"
+}
+// Error when a base's logo input has no substitution of type "png".
+type ErrLogoInput struct { input string }
+func (tt ErrLogoInput) Error() string {
+    return "logo input '" + tt.input + "' has no png substitution"
+}
+// Error when an image drawn from a base's logo can't be.
+type ErrBadAsset struct { path string; cause error }
+func (tt ErrBadAsset) Error() string {
+    return "bad logo asset '" + tt.path + "': " + tt.cause.Error()
 }
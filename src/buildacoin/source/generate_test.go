@@ -4,7 +4,9 @@ import (
     "buildacoin/data"
     "buildacoin/template"
     "bytes"
+    "encoding/hex"
     "testing"
+    "time"
 )
 
 func TestSimpleGenerate(t *testing.T) {
@@ -35,3 +37,155 @@ var simpleMeta *data.Meta = data.NewMeta("", "", "", make([]string, 0),
         []data.Sub { data.Sub { 2, "first", "", "-", "literal", nil },
             data.Sub { 13, "third", "", "!", "", nil },
             data.Sub { 9, "second", "", "-", "literal", nil } })
+
+func TestBadValues(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "a", Type: "int" },
+            data.Input { Group: "basics", Id: "b" },
+            data.Input { Group: "basics", Id: "c" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Input: "a", Type: "uint16" },
+            data.Sub { Idx: 2, Input: "b", Type: "uint16" },
+            // depends on a failed sub, so reports nothing itself
+            data.Sub { Idx: 3, Type: "literal", Deps: []uint { 2 } },
+            // derived from c, failing with the wrong number of arguments
+            data.Sub { Idx: 4, Type: "uint16", Deps: []uint { 5 } },
+            data.Sub { Idx: 5, Input: "c", Type: "literal" },
+            data.Sub { Idx: 6, Default: "x", Type: "uint16" },
+        })
+    values := map[string]string { "a": "x", "b": "70000", "c": "1" }
+    _, err := BuildFilterMap(meta, values)
+    bad, ok := err.(ErrBadValues)
+    if !ok {
+        t.Fatalf("expected ErrBadValues, got %v\n", err)
+    }
+    expected := []string { "a", "b", "c", "" }
+    if len(bad) != len(expected) {
+        t.Fatalf("expected %d errors, got %v\n", len(expected), bad)
+    }
+    for ii, input := range expected {
+        if bad[ii].Input != input {
+            t.Fatalf("error %d: expected input '%s' / actual %v\n", ii,
+                    input, bad[ii])
+        }
+    }
+    if _, ok := bad[2].Cause.(ErrDerivedValue); !ok {
+        t.Fatalf("expected derived value error, got %v\n", bad[2])
+    }
+}
+
+func TestDepLevels(t *testing.T) {
+    subs := []data.Sub {
+        data.Sub { Idx: 1, Deps: []uint { 2, 3 } },
+        data.Sub { Idx: 2, Deps: []uint { 3 } },
+        data.Sub { Idx: 3 },
+        data.Sub { Idx: 4 },
+    }
+    levels, err := depLevels(subs)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := [][]uint { { 3, 4 }, { 2 }, { 1 } }
+    if len(levels) != len(expected) {
+        t.Fatalf("expected %v, got %v\n", expected, levels)
+    }
+    for ii, level := range expected {
+        if len(levels[ii]) != len(level) {
+            t.Fatalf("level %d: expected %v, got %v\n", ii, level, levels[ii])
+        }
+        for jj, idx := range level {
+            if levels[ii][jj].Idx != idx {
+                t.Fatalf("level %d: expected %v, got %v\n", ii, level,
+                        levels[ii])
+            }
+        }
+    }
+
+    subs = []data.Sub {
+        data.Sub { Idx: 1, Deps: []uint { 2 } },
+        data.Sub { Idx: 2, Comment: "two", Deps: []uint { 3 } },
+        data.Sub { Idx: 3, Deps: []uint { 4, 2 } },
+        data.Sub { Idx: 4 },
+    }
+    _, err = depLevels(subs)
+    if err == nil || err.Error() != "dependency cycle 2 (two) -> 3 -> 2 (two)" {
+        t.Fatalf("bad cycle error %v\n", err)
+    }
+
+    subs = []data.Sub { data.Sub { Idx: 1, Deps: []uint { 7 } } }
+    _, err = depLevels(subs)
+    if err == nil ||
+            err.Error() != "substitution 1 depends on missing substitution 7" {
+        t.Fatalf("bad dangling dependency error %v\n", err)
+    }
+}
+
+func TestExpensiveSubs(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string {}, []data.Input {},
+        []data.Sub {
+            data.Sub { Idx: 1, Type: "generated-pubkey" },
+            data.Sub { Idx: 2, Type: "literal", Default: "-" },
+            data.Sub { Idx: 3, Type: "generated-pubkey" },
+        })
+    filterMap, keys, err := BuildFilterMapKeys(nil, meta,
+            map[string]string {})
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(keys) != 2 || keys[0].Sub.Idx != 1 || keys[1].Sub.Idx != 3 {
+        t.Fatalf("expected keys for 1 and 3 in order, got %v\n", keys)
+    }
+    for _, key := range keys {
+        if string(filterMap[key.Sub.Idx]) !=
+                hex.EncodeToString(key.Key.UncompressedPublicKey()) {
+            t.Fatalf("substitution %d isn't its key's pubkey\n", key.Sub.Idx)
+        }
+    }
+
+    // an unknown type is found before any key is generated
+    meta = data.NewMeta("", "", "", []string {}, []data.Input {},
+        []data.Sub {
+            data.Sub { Idx: 1, Type: "generated-pubkey" },
+            data.Sub { Idx: 2, Type: "nope" },
+        })
+    filterMap, keys, err = BuildFilterMapKeys(nil, meta,
+            map[string]string {})
+    if _, ok := err.(ErrUnknownType); !ok || keys != nil {
+        t.Fatalf("expected unknown type and no keys, got %v, %v\n", err,
+                keys)
+    }
+}
+
+func TestCoinContext(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string {}, []data.Input {},
+        []data.Sub {
+            data.Sub { Idx: 1, Type: "random-hash" },
+            data.Sub { Idx: 2, Type: "random-uint32" },
+            data.Sub { Idx: 3, Type: "unixtime-current" },
+            data.Sub { Idx: 4, Type: "generated-pubkey" },
+        })
+    id := data.NewCoinID()
+    created := time.Unix(1400000000, 0)
+    build := func(id data.CoinID) template.FilterMap {
+        filterMap, _, err := BuildFilterMapKeys(CoinContext(id, created),
+                meta, map[string]string {})
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        return filterMap
+    }
+    first, second, other := build(id), build(id), build(data.NewCoinID())
+    for _, idx := range []uint { 1, 2, 3 } {
+        if !bytes.Equal(first[idx], second[idx]) {
+            t.Fatalf("substitution %d differs between builds\n", idx)
+        }
+    }
+    if bytes.Equal(first[1], other[1]) {
+        t.Fatal("coins with different ids have the same random hash")
+    }
+    if bytes.Equal(first[4], second[4]) {
+        t.Fatal("generated key reproduced from the coin id")
+    }
+}
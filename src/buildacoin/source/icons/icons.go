@@ -0,0 +1,442 @@
+package icons
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "image"
+    "image/color"
+    "image/draw"
+    "image/png"
+    "math"
+    "strconv"
+)
+
+// Image formats drawn from a logo
+const (
+    PNGFormat = "png"
+    ICOFormat = "ico"
+    XPMFormat = "xpm"
+)
+
+const (
+    // Largest side of an image drawn from a logo
+    MaxSide = 1024
+    // Icons at least this size are stored in icos as PNG rather than bitmaps
+    icoPNGSize = 256
+    // Alpha below which a pixel of an xpm is transparent
+    xpmAlphaCutoff = 0x8000
+    // Characters naming the colours of an xpm, without '"' or '\'
+    xpmChars = " .+@#$%&*=-;>,')!~{]^/(_:<[}|1234567890abcdefghijklmnopqrstu"
+)
+
+var (
+    ErrUnknFormat error = errors.New("unknown image format")
+    ErrBadSize error = errors.New("bad image size")
+    ErrBadColour error = errors.New("colour must be \"#rrggbb\"")
+)
+
+// An image drawn from a logo: an icon of one size, or a png canvas with the
+// logo centred on it
+type Asset struct {
+    Format string
+    // Sizes of the square icon, one per image in an ico
+    Sizes []int
+    // Size of the canvas of a png without Sizes
+    Width int
+    Height int
+    // Colour of the canvas, or nil for transparent
+    Background color.Color
+    // C identifier of the array holding an xpm
+    Name string
+}
+
+// Check that the asset can be drawn.
+func (tt Asset) Check() error {
+    checkSide := func(side int) error {
+        if side < 1 || side > MaxSide {
+            return ErrBadSize
+        }
+        return nil
+    }
+    switch tt.Format {
+    case PNGFormat:
+        if len(tt.Sizes) == 0 {
+            err := checkSide(tt.Width)
+            if err != nil {
+                return err
+            }
+            return checkSide(tt.Height)
+        }
+        fallthrough
+    case XPMFormat:
+        if len(tt.Sizes) != 1 {
+            return ErrBadSize
+        }
+    case ICOFormat:
+        // ico directories count images in a 16 bit field
+        if len(tt.Sizes) < 1 || len(tt.Sizes) > 0xffff {
+            return ErrBadSize
+        }
+    default:
+        return ErrUnknFormat
+    }
+    for _, size := range tt.Sizes {
+        err := checkSide(size)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Draw the asset from a logo.  Logos are scaled to fit, keeping their aspect
+// ratio, and centred; on canvases they fill three quarters of either side at
+// most.
+func (tt Asset) Draw(logo image.Image) ([]byte, error) {
+    err := tt.Check()
+    if err != nil {
+        return nil, err
+    }
+    switch tt.Format {
+    case ICOFormat:
+        icons := make([]*image.NRGBA, len(tt.Sizes))
+        for ii, size := range tt.Sizes {
+            icons[ii] = fit(logo, size, size, size, size, nil)
+        }
+        return encodeICO(icons)
+    case XPMFormat:
+        size := tt.Sizes[0]
+        return encodeXPM(fit(logo, size, size, size, size, nil),
+                tt.Name), nil
+    }
+    var icon *image.NRGBA
+    if len(tt.Sizes) > 0 {
+        size := tt.Sizes[0]
+        icon = fit(logo, size, size, size, size, nil)
+    } else {
+        icon = fit(logo, tt.Width, tt.Height, tt.Width * 3 / 4,
+                tt.Height * 3 / 4, tt.Background)
+    }
+    return encodePNG(icon)
+}
+
+// Parse a colour written "#rrggbb".
+func ParseColour(text string) (color.Color, error) {
+    if len(text) != 7 || text[0] != '#' {
+        return nil, ErrBadColour
+    }
+    value, err := strconv.ParseUint(text[1:], 16, 32)
+    if err != nil {
+        return nil, ErrBadColour
+    }
+    return color.NRGBA { uint8(value >> 16), uint8(value >> 8),
+            uint8(value), 0xff }, nil
+}
+
+// Draw a logo scaled to fit within boxW x boxH, centred on a canvas of
+// width x height filled with background, or transparent if nil.
+func fit(logo image.Image, width, height, boxW, boxH int,
+        background color.Color) *image.NRGBA {
+    bounds := logo.Bounds()
+    logoW, logoH := bounds.Dx(), bounds.Dy()
+    scaledW, scaledH := boxW, boxH
+    // the side that fills the box is the one with less room to grow
+    if logoW * boxH > logoH * boxW {
+        scaledH = (logoH * boxW + logoW / 2) / logoW
+    } else {
+        scaledW = (logoW * boxH + logoH / 2) / logoH
+    }
+    if scaledW < 1 {
+        scaledW = 1
+    }
+    if scaledH < 1 {
+        scaledH = 1
+    }
+
+    canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+    if background != nil {
+        draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background),
+                image.ZP, draw.Src)
+    }
+    left, top := (width - scaledW) / 2, (height - scaledH) / 2
+    draw.Draw(canvas, image.Rect(left, top, left + scaledW, top + scaledH),
+            scale(logo, scaledW, scaledH), image.ZP, draw.Over)
+    return canvas
+}
+
+// A source pixel's share of a scaled pixel
+type weight struct {
+    idx int
+    share float64
+}
+
+// Find the source pixels making up each of the to pixels scaled from from
+// along one axis: the pixels each covers when shrinking, and the two nearest
+// its centre when growing.
+func axisWeights(from, to int) [][]weight {
+    output := make([][]weight, to)
+    ratio := float64(from) / float64(to)
+    for ii := range output {
+        if to <= from {
+            start, end := float64(ii) * ratio, float64(ii + 1) * ratio
+            for jj := int(start); float64(jj) < end && jj < from; jj++ {
+                share := math.Min(end, float64(jj + 1)) -
+                        math.Max(start, float64(jj))
+                output[ii] = append(output[ii], weight { jj, share / ratio })
+            }
+            continue
+        }
+        centre := (float64(ii) + 0.5) * ratio - 0.5
+        below := math.Floor(centre)
+        frac := centre - below
+        clamp := func(jj int) int {
+            if jj < 0 {
+                return 0
+            }
+            if jj >= from {
+                return from - 1
+            }
+            return jj
+        }
+        output[ii] = []weight { weight { clamp(int(below)), 1 - frac },
+                weight { clamp(int(below) + 1), frac } }
+    }
+    return output
+}
+
+// Scale an image to width x height, averaging in premultiplied alpha so
+// transparent pixels don't bleed their colour.
+func scale(src image.Image, width, height int) *image.NRGBA {
+    bounds := src.Bounds()
+    srcW, srcH := bounds.Dx(), bounds.Dy()
+    pixels := make([][4]float64, srcW * srcH)
+    for yy := 0; yy < srcH; yy++ {
+        for xx := 0; xx < srcW; xx++ {
+            rr, gg, bb, aa := src.At(bounds.Min.X + xx,
+                    bounds.Min.Y + yy).RGBA()
+            pixels[yy * srcW + xx] = [4]float64 { float64(rr), float64(gg),
+                    float64(bb), float64(aa) }
+        }
+    }
+
+    // across, then down
+    across := make([][4]float64, width * srcH)
+    acrossWeights := axisWeights(srcW, width)
+    for yy := 0; yy < srcH; yy++ {
+        for xx, weights := range acrossWeights {
+            for _, wt := range weights {
+                for cc := 0; cc < 4; cc++ {
+                    across[yy * width + xx][cc] +=
+                            pixels[yy * srcW + wt.idx][cc] * wt.share
+                }
+            }
+        }
+    }
+    output := image.NewNRGBA(image.Rect(0, 0, width, height))
+    downWeights := axisWeights(srcH, height)
+    for yy, weights := range downWeights {
+        for xx := 0; xx < width; xx++ {
+            var sum [4]float64
+            for _, wt := range weights {
+                for cc := 0; cc < 4; cc++ {
+                    sum[cc] += across[wt.idx * width + xx][cc] * wt.share
+                }
+            }
+            output.Set(xx, yy, color.RGBA64 { clamp16(sum[0]),
+                    clamp16(sum[1]), clamp16(sum[2]), clamp16(sum[3]) })
+        }
+    }
+    return output
+}
+
+func clamp16(value float64) uint16 {
+    return uint16(math.Max(0, math.Min(0xffff, value + 0.5)))
+}
+
+func encodePNG(icon image.Image) ([]byte, error) {
+    output := new(bytes.Buffer)
+    encoder := png.Encoder { CompressionLevel: png.BestCompression }
+    err := encoder.Encode(output, icon)
+    return output.Bytes(), err
+}
+
+// Encode icons as an ico, holding the large ones as PNGs and the rest as
+// 32 bit bitmaps for older versions of Windows.
+func encodeICO(icons []*image.NRGBA) ([]byte, error) {
+    images := make([][]byte, len(icons))
+    for ii, icon := range icons {
+        var err error
+        if icon.Bounds().Dx() >= icoPNGSize {
+            images[ii], err = encodePNG(icon)
+        } else {
+            images[ii] = icoBitmap(icon)
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    output := new(bytes.Buffer)
+    write := func(values ...interface{}) {
+        for _, value := range values {
+            binary.Write(output, binary.LittleEndian, value)
+        }
+    }
+    // reserved, type 1 for icons, count
+    write(uint16(0), uint16(1), uint16(len(icons)))
+    offset := 6 + 16 * len(icons)
+    for ii, icon := range icons {
+        // sides of 256 are written 0
+        side := uint8(icon.Bounds().Dx())
+        // width, height, palette size, reserved, planes, bits per pixel,
+        // size, offset
+        write(side, side, uint8(0), uint8(0), uint16(1), uint16(32),
+                uint32(len(images[ii])), uint32(offset))
+        offset += len(images[ii])
+    }
+    for _, body := range images {
+        output.Write(body)
+    }
+    return output.Bytes(), nil
+}
+
+// Encode an icon as the bitmap of an ico: a header, its pixels bottom up as
+// BGRA, and a mask of its transparent pixels.
+func icoBitmap(icon *image.NRGBA) []byte {
+    side := icon.Bounds().Dx()
+    // mask rows are padded to 32 bits
+    maskStride := (side + 31) / 32 * 4
+    output := new(bytes.Buffer)
+    write := func(values ...interface{}) {
+        for _, value := range values {
+            binary.Write(output, binary.LittleEndian, value)
+        }
+    }
+    // header size, width, height of pixels and mask together, planes, bits
+    // per pixel, compression, image size, resolution, palette
+    write(uint32(40), int32(side), int32(2 * side), uint16(1), uint16(32),
+            uint32(0), uint32(side * side * 4 + maskStride * side),
+            int32(0), int32(0), uint32(0), uint32(0))
+    for yy := side - 1; yy >= 0; yy-- {
+        for xx := 0; xx < side; xx++ {
+            pixel := icon.NRGBAAt(xx, yy)
+            output.Write([]byte { pixel.B, pixel.G, pixel.R, pixel.A })
+        }
+    }
+    for yy := side - 1; yy >= 0; yy-- {
+        row := make([]byte, maskStride)
+        for xx := 0; xx < side; xx++ {
+            if icon.NRGBAAt(xx, yy).A == 0 {
+                row[xx / 8] |= 0x80 >> uint(xx % 8)
+            }
+        }
+        output.Write(row)
+    }
+    return output.Bytes()
+}
+
+// Encode an icon as an xpm.  Pixels are opaque or transparent, and colours
+// are rounded until there are few enough to name with two characters.
+func encodeXPM(icon *image.NRGBA, name string) []byte {
+    side := icon.Bounds().Dx()
+    maxColours := len(xpmChars) * len(xpmChars)
+    var pixels []string
+    var colours []string
+    for bits := uint(8); ; bits-- {
+        pixels, colours = xpmColours(icon, bits)
+        if len(colours) <= maxColours {
+            break
+        }
+    }
+    perPixel := 1
+    if len(colours) > len(xpmChars) {
+        perPixel = 2
+    }
+    code := func(idx int) string {
+        if perPixel == 1 {
+            return xpmChars[idx:idx + 1]
+        }
+        return xpmChars[idx / len(xpmChars):idx / len(xpmChars) + 1] +
+                xpmChars[idx % len(xpmChars):idx % len(xpmChars) + 1]
+    }
+    codes := make(map[string]string, len(colours))
+    output := new(bytes.Buffer)
+    output.WriteString("/* XPM */\nstatic char *" + cIdentifier(name) +
+            "[] = {\n/* columns rows colors chars-per-pixel */\n\"" +
+            strconv.Itoa(side) + " " + strconv.Itoa(side) + " " +
+            strconv.Itoa(len(colours)) + " " + strconv.Itoa(perPixel) +
+            " \",\n")
+    for ii, colour := range colours {
+        codes[colour] = code(ii)
+        output.WriteString("\"" + codes[colour] + " c " + colour + "\",\n")
+    }
+    output.WriteString("/* pixels */\n")
+    for yy := 0; yy < side; yy++ {
+        output.WriteString("\"")
+        for xx := 0; xx < side; xx++ {
+            output.WriteString(codes[pixels[yy * side + xx]])
+        }
+        output.WriteString("\"")
+        if yy < side - 1 {
+            output.WriteString(",")
+        }
+        output.WriteString("\n")
+    }
+    output.WriteString("};\n")
+    return output.Bytes()
+}
+
+// Get the xpm colour of each pixel of an icon with its channels rounded to
+// bits, and the colours in the order they first appear.
+func xpmColours(icon *image.NRGBA, bits uint) ([]string, []string) {
+    side := icon.Bounds().Dx()
+    pixels := make([]string, 0, side * side)
+    colours := make([]string, 0)
+    seen := make(map[string]bool)
+    round := func(channel uint8) uint8 {
+        step := 1 << (8 - bits)
+        value := (int(channel) + step / 2) / step * step
+        if value > 0xff {
+            value = 0xff
+        }
+        return uint8(value)
+    }
+    for yy := 0; yy < side; yy++ {
+        for xx := 0; xx < side; xx++ {
+            pixel := icon.NRGBAAt(xx, yy)
+            colour := "None"
+            if uint32(pixel.A) * 0x101 >= xpmAlphaCutoff {
+                colour = "#" + hexByte(round(pixel.R)) +
+                        hexByte(round(pixel.G)) + hexByte(round(pixel.B))
+            }
+            if !seen[colour] {
+                seen[colour] = true
+                colours = append(colours, colour)
+            }
+            pixels = append(pixels, colour)
+        }
+    }
+    return pixels, colours
+}
+
+func hexByte(value uint8) string {
+    const digits = "0123456789ABCDEF"
+    return string([]byte { digits[value >> 4], digits[value & 0xf] })
+}
+
+// Make a name into a C identifier.
+func cIdentifier(name string) string {
+    output := []byte(name)
+    for ii, char := range output {
+        if !(char == '_' || (char >= 'a' && char <= 'z') ||
+                (char >= 'A' && char <= 'Z') ||
+                (ii > 0 && char >= '0' && char <= '9')) {
+            output[ii] = '_'
+        }
+    }
+    if len(output) == 0 {
+        return "icon"
+    }
+    return string(output)
+}
@@ -0,0 +1,130 @@
+package icons
+
+import (
+    "bytes"
+    "encoding/binary"
+    "image"
+    "image/color"
+    "image/png"
+    "testing"
+)
+
+// A wide logo: opaque red on the left half, transparent on the right.
+func testLogo() image.Image {
+    logo := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+    for yy := 0; yy < 20; yy++ {
+        for xx := 0; xx < 20; xx++ {
+            logo.Set(xx, yy, color.NRGBA { 0xff, 0, 0, 0xff })
+        }
+    }
+    return logo
+}
+
+func TestDrawPNG(t *testing.T) {
+    body, err := Asset { Format: PNGFormat, Sizes: []int { 16 } }.Draw(
+            testLogo())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    icon, err := png.Decode(bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if icon.Bounds().Dx() != 16 || icon.Bounds().Dy() != 16 {
+        t.Fatalf("expected 16x16, got %v\n", icon.Bounds())
+    }
+    // the logo is 16x8, centred
+    cases := []struct { xx, yy int; alpha uint32 } {
+        { 2, 8, 0xffff },
+        { 12, 8, 0 },
+        { 2, 1, 0 },
+    }
+    for _, pixel := range cases {
+        _, _, _, alpha := icon.At(pixel.xx, pixel.yy).RGBA()
+        if alpha != pixel.alpha {
+            t.Fatalf("pixel %d,%d: expected alpha %x / actual %x\n",
+                    pixel.xx, pixel.yy, pixel.alpha, alpha)
+        }
+    }
+
+    body, err = Asset { Format: PNGFormat, Width: 100, Height: 50,
+            Background: color.White }.Draw(testLogo())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    canvas, err := png.Decode(bytes.NewReader(body))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if canvas.Bounds().Dx() != 100 || canvas.Bounds().Dy() != 50 {
+        t.Fatalf("expected 100x50, got %v\n", canvas.Bounds())
+    }
+    rr, gg, _, _ := canvas.At(0, 0).RGBA()
+    if rr != 0xffff || gg != 0xffff {
+        t.Fatal("canvas isn't its background colour")
+    }
+}
+
+func TestDrawICO(t *testing.T) {
+    sizes := []int { 16, 256 }
+    body, err := Asset { Format: ICOFormat, Sizes: sizes }.Draw(testLogo())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    var header struct { Reserved, Type, Count uint16 }
+    binary.Read(bytes.NewReader(body), binary.LittleEndian, &header)
+    if header.Type != 1 || int(header.Count) != len(sizes) {
+        t.Fatalf("bad ico header %v\n", header)
+    }
+    for ii, size := range sizes {
+        entry := body[6 + 16 * ii:]
+        offset := binary.LittleEndian.Uint32(entry[12:16])
+        if int(entry[0]) != size % 256 {
+            t.Fatalf("image %d: expected size %d / actual %d\n", ii, size,
+                    entry[0])
+        }
+        isPNG := bytes.HasPrefix(body[offset:], []byte("\x89PNG"))
+        if isPNG != (size >= 256) {
+            t.Fatalf("image %d: expected PNG %v\n", ii, size >= 256)
+        }
+    }
+}
+
+func TestDrawXPM(t *testing.T) {
+    body, err := Asset { Format: XPMFormat, Sizes: []int { 4 },
+            Name: "bitcoin4" }.Draw(testLogo())
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := "/* XPM */\nstatic char *bitcoin4[] = {\n" +
+            "/* columns rows colors chars-per-pixel */\n\"4 4 2 1 \",\n" +
+            "\"  c None\",\n\". c #FF0000\",\n/* pixels */\n" +
+            "\"    \",\n\"..  \",\n\"..  \",\n\"    \"\n};\n"
+    if string(body) != expected {
+        t.Fatalf("expected:\n%s\nactual:\n%s\n", expected, body)
+    }
+}
+
+func TestCheck(t *testing.T) {
+    cases := []struct { asset Asset; err error } {
+        { Asset { Format: "gif", Sizes: []int { 16 } }, ErrUnknFormat },
+        { Asset { Format: XPMFormat }, ErrBadSize },
+        { Asset { Format: PNGFormat, Width: 10 }, ErrBadSize },
+        { Asset { Format: ICOFormat, Sizes: []int { 16, MaxSide + 1 } },
+                ErrBadSize },
+        { Asset { Format: ICOFormat, Sizes: []int { 16, 32 } }, nil },
+    }
+    for _, test := range cases {
+        if err := test.asset.Check(); err != test.err {
+            t.Fatalf("%v: expected %v / actual %v\n", test.asset, test.err,
+                    err)
+        }
+    }
+    if _, err := ParseColour("white"); err != ErrBadColour {
+        t.Fatal("bad colour accepted")
+    }
+    colour, err := ParseColour("#102030")
+    if err != nil || colour != (color.NRGBA { 0x10, 0x20, 0x30, 0xff }) {
+        t.Fatalf("bad colour parsed %v\n", colour)
+    }
+}
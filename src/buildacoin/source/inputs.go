@@ -0,0 +1,151 @@
+package source
+
+import (
+    "buildacoin/data"
+    "encoding/json"
+    "errors"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+var (
+    // Errors when a value doesn't suit its input's type
+    ErrNotBool error = errors.New("must be true or false")
+    ErrNotInt error = errors.New("must be a whole number")
+    ErrNotNumber error = errors.New("must be a number")
+    ErrNoMatch error = errors.New("isn't in the expected form")
+)
+
+// Check the values given for a base coin's inputs against their types and
+// constraints, returning an InputError for each that fails, in the order of
+// the inputs.  Inputs without values take their defaults and aren't checked.
+func CheckInputs(meta *data.Meta, values map[string]string) ErrBadValues {
+    output := make(ErrBadValues, 0)
+    for _, input := range meta.Inputs() {
+        value, ok := values[input.Id]
+        if !ok {
+            continue
+        }
+        err := CheckInput(input, value)
+        if err != nil {
+            output = append(output, InputError { input.Id, err })
+        }
+    }
+    return output
+}
+
+// Check a value against its input's type and constraints.
+func CheckInput(input data.Input, value string) error {
+    switch input.Type {
+    case "", data.InputText:
+        if input.Pattern == "" {
+            return nil
+        }
+        pattern, err := regexp.Compile("^(?:" + input.Pattern + ")$")
+        if err != nil {
+            return err
+        }
+        if !pattern.MatchString(value) {
+            return ErrNoMatch
+        }
+    case data.InputBool:
+        if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+            return ErrNotBool
+        }
+    case data.InputInt:
+        number, err := strconv.ParseInt(strings.TrimSpace(value), 0, 64)
+        if err != nil {
+            return ErrNotInt
+        }
+        return checkRange(input, float64(number))
+    case data.InputNumber:
+        number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+        if err != nil {
+            return ErrNotNumber
+        }
+        return checkRange(input, number)
+    case data.InputEnum:
+        for _, choice := range input.Choices {
+            if value == choice {
+                return nil
+            }
+        }
+        return ErrNotChoice { input.Choices }
+    case data.InputImage:
+        // images are checked by the types of the substitutions taking them
+        return nil
+    default:
+        return ErrUnknownInputType { input.Type }
+    }
+    return nil
+}
+
+func checkRange(input data.Input, number float64) error {
+    if (input.Min != nil && number < *input.Min) ||
+            (input.Max != nil && number > *input.Max) {
+        return ErrOutOfRange { input.Min, input.Max }
+    }
+    return nil
+}
+
+// Format an input's bound as the form shows it, or "" if it has none.
+func FormatBound(bound *float64) string {
+    if bound == nil {
+        return ""
+    }
+    return strconv.FormatFloat(*bound, 'f', -1, 64)
+}
+
+//
+// Errors
+//
+
+// Error when a value given for an input fails its checks, or makes a
+// substitution fail its type.  Failures of the base's own values have no
+// input.
+type InputError struct { Input string; Cause error }
+func (tt InputError) Error() string {
+    if tt.Input == "" {
+        return "default value " + tt.Cause.Error()
+    }
+    return "input '" + tt.Input + "' " + tt.Cause.Error()
+}
+// Produce a JSON representation of the error, as an object of the input and
+// the error's cause.
+func (tt InputError) MarshalJSON() ([]byte, error) {
+    return json.Marshal(struct {
+        Input string `json:"input,omitempty"`
+        Error string `json:"error"`
+    } { tt.Input, tt.Cause.Error() })
+}
+// Error holding every failure of the values given for a base coin's inputs
+type ErrBadValues []InputError
+func (tt ErrBadValues) Error() string {
+    messages := make([]string, len(tt))
+    for ii, err := range tt {
+        messages[ii] = err.Error()
+    }
+    return strings.Join(messages, "; ")
+}
+// Error when a number is outside its input's bounds
+type ErrOutOfRange struct { min *float64; max *float64 }
+func (tt ErrOutOfRange) Error() string {
+    switch {
+    case tt.min == nil:
+        return "must be at most " + FormatBound(tt.max)
+    case tt.max == nil:
+        return "must be at least " + FormatBound(tt.min)
+    }
+    return "must be from " + FormatBound(tt.min) + " to " + FormatBound(tt.max)
+}
+// Error when a value isn't one of its input's choices
+type ErrNotChoice struct { choices []string }
+func (tt ErrNotChoice) Error() string {
+    return "must be one of " + strings.Join(tt.choices, ", ")
+}
+// Error when an input is of an unknown type
+type ErrUnknownInputType struct { typeName string }
+func (tt ErrUnknownInputType) Error() string {
+    return "has unknown type '" + tt.typeName + "'"
+}
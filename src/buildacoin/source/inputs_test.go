@@ -0,0 +1,59 @@
+package source
+
+import (
+    "buildacoin/data"
+    "testing"
+)
+
+func TestCheckInput(t *testing.T) {
+    one, ten := 1.0, 10.0
+    cases := []struct { input data.Input; value string; err error } {
+        { data.Input { Pattern: "[a-z]+" }, "abc", nil },
+        { data.Input { Pattern: "[a-z]+" }, "abc1", ErrNoMatch },
+        { data.Input { Type: "bool" }, "true", nil },
+        { data.Input { Type: "bool" }, "yes", ErrNotBool },
+        { data.Input { Type: "int", Min: &one, Max: &ten }, "0x0a", nil },
+        { data.Input { Type: "int" }, "1.5", ErrNotInt },
+        { data.Input { Type: "int", Min: &one, Max: &ten }, "11",
+                ErrOutOfRange { &one, &ten } },
+        { data.Input { Type: "number", Min: &one }, "1.5", nil },
+        { data.Input { Type: "number", Min: &one }, "0.5",
+                ErrOutOfRange { &one, nil } },
+        { data.Input { Type: "number" }, "lots", ErrNotNumber },
+        { data.Input { Type: "enum", Choices: []string { "a", "b" } }, "b",
+                nil },
+        { data.Input { Type: "image" }, "", nil },
+        { data.Input { Type: "colour" }, "red",
+                ErrUnknownInputType { "colour" } },
+    }
+    for _, test := range cases {
+        err := CheckInput(test.input, test.value)
+        if (err == nil) != (test.err == nil) ||
+                (err != nil && err.Error() != test.err.Error()) {
+            t.Fatalf("'%s' as %v: expected %v / actual %v\n", test.value,
+                    test.input, test.err, err)
+        }
+    }
+    err := CheckInput(data.Input { Type: "enum",
+            Choices: []string { "a", "b" } }, "c")
+    if err == nil || err.Error() != "must be one of a, b" {
+        t.Fatalf("bad enum error %v\n", err)
+    }
+}
+
+func TestCheckInputs(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "a", Type: "int" },
+            data.Input { Group: "basics", Id: "b", Type: "bool" },
+            data.Input { Group: "basics", Id: "c", Type: "int" },
+        },
+        []data.Sub {})
+    errs := CheckInputs(meta, map[string]string { "a": "x", "b": "true" })
+    if len(errs) != 1 {
+        t.Fatalf("expected 1 error, got %v\n", errs)
+    }
+    if errs[0].Error() != "input 'a' must be a whole number" {
+        t.Fatalf("unexpected error '%s'\n", errs[0].Error())
+    }
+}
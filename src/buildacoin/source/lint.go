@@ -0,0 +1,350 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/source/types"
+    "buildacoin/template"
+    "io"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Kinds of problem found by Lint
+const (
+    LintUnknownMarker = "marker without substitution"
+    LintUnusedSub = "unused substitution"
+    LintDuplicateIdx = "duplicate substitution index"
+    LintUnknownType = "unknown type"
+    LintDepCycle = "dependency cycle"
+    LintDanglingDep = "dangling dependency"
+    LintUngroupedInput = "input without group"
+    LintBadDefault = "bad default"
+    LintBadRule = "bad file rule"
+    LintBadLogo = "bad logo"
+    LintBadInput = "bad input"
+)
+
+// A mistake in a base coin found by Lint
+type LintProblem struct {
+    Kind string
+    Detail string
+}
+func (tt LintProblem) String() string {
+    return tt.Kind + ": " + tt.Detail
+}
+
+// Check a base coin's metadata against itself and against the markers found
+// in its template (by index, with the files each is in), returning the
+// problems found in the order of the checks.
+func Lint(meta *data.Meta, markers map[uint][]string) []LintProblem {
+    problems := make([]LintProblem, 0)
+    report := func(kind, detail string) {
+        problems = append(problems, LintProblem { kind, detail })
+    }
+    markers = withGenerated(meta, markers)
+    subs := meta.Subs()
+    byIdx := make(map[uint]data.Sub, len(subs))
+    for _, sub := range subs {
+        byIdx[sub.Idx] = sub
+    }
+
+    // markers and subs without each other
+    for _, idx := range sortedIndices(markers) {
+        if _, ok := byIdx[idx]; !ok {
+            files := append([]string {}, markers[idx]...)
+            sort.Strings(files)
+            report(LintUnknownMarker, strconv.Itoa(int(idx)) + " in " +
+                    strings.Join(files, ", "))
+        }
+    }
+    depended := make(map[uint]bool)
+    for _, sub := range subs {
+        for _, dep := range sub.Deps {
+            depended[dep] = true
+        }
+    }
+    for _, input := range ruleInputs(meta.FileRules()) {
+        if indices, err := boolSubs(meta, []string { input }); err == nil {
+            depended[indices[0]] = true
+        }
+    }
+    logo, hasLogo := meta.Logo()
+    if idx, ok := subTaking(meta, logo.Input, "png"); hasLogo && ok {
+        depended[idx] = true
+    }
+    for _, sub := range subs {
+        if _, ok := markers[sub.Idx]; !ok && !depended[sub.Idx] {
+            report(LintUnusedSub, describeSub(sub) +
+                    " is in no marker, dependency, file rule or logo")
+        }
+    }
+
+    // subs themselves
+    seen := make(map[uint]bool, len(subs))
+    for _, sub := range subs {
+        if seen[sub.Idx] {
+            report(LintDuplicateIdx, describeSub(sub))
+        }
+        seen[sub.Idx] = true
+    }
+    for _, sub := range subs {
+        if _, ok := types.Map[sub.Type]; !ok {
+            report(LintUnknownType, describeSub(sub) + " has type '" +
+                    sub.Type + "'")
+        }
+    }
+    for _, cycle := range depCycles(byIdx) {
+        path := make([]string, len(cycle))
+        for ii, idx := range cycle {
+            path[ii] = strconv.Itoa(int(idx))
+        }
+        report(LintDepCycle, strings.Join(path, " -> "))
+    }
+    for _, sub := range subs {
+        for _, dep := range sub.Deps {
+            if _, ok := byIdx[dep]; !ok {
+                report(LintDanglingDep, describeSub(sub) +
+                        " depends on missing " + strconv.Itoa(int(dep)))
+            }
+        }
+    }
+
+    // inputs
+    groups := make(map[string]bool)
+    for _, group := range meta.InGroups() {
+        groups[group] = true
+    }
+    for _, input := range meta.Inputs() {
+        if !groups[input.Group] {
+            report(LintUngroupedInput, "'" + input.Id + "' is in group '" +
+                    input.Group + "'")
+        }
+    }
+
+    for _, input := range meta.Inputs() {
+        for _, err := range inputProblems(input) {
+            report(LintBadInput, "'" + input.Id + "' " + err)
+        }
+    }
+
+    // file rules
+    rules := meta.FileRules()
+    paths := make([]string, 0)
+    for _, rule := range rules.Exclude {
+        paths = append(paths, rule.Path)
+    }
+    for _, rule := range rules.Rename {
+        paths = append(paths, rule.Path)
+    }
+    for _, path := range paths {
+        if _, err := rulePath(path); err != nil {
+            report(LintBadRule, err.Error())
+        }
+    }
+    for _, input := range ruleInputs(rules) {
+        if _, err := boolSubs(meta, []string { input }); err != nil {
+            report(LintBadRule, err.Error())
+        }
+    }
+    for _, file := range rules.Generate {
+        _, err := template.ScanMarkers(generatedText(file), Markers(meta))
+        if err != nil {
+            report(LintBadRule, "generated file '" + file.Name + "': " +
+                    err.Error())
+        }
+    }
+
+    // logo
+    if _, ok := subTaking(meta, logo.Input, "png"); hasLogo && !ok {
+        report(LintBadLogo, ErrLogoInput { logo.Input }.Error())
+    }
+    for _, input := range meta.Inputs() {
+        if hasLogo && input.Id == logo.Input && input.Type != data.InputImage {
+            report(LintBadLogo, "input '" + input.Id + "' isn't of type '" +
+                    data.InputImage + "'")
+        }
+    }
+    for _, asset := range logo.Assets {
+        if _, err := iconAsset(asset); err != nil {
+            report(LintBadLogo, err.Error())
+        }
+    }
+
+    // default values, as the form offers them
+    values := make(map[uint]string)
+    failed := make(map[uint]bool)
+    var produce func(sub data.Sub, path map[uint]bool) (string, bool)
+    produce = func(sub data.Sub, path map[uint]bool) (string, bool) {
+        if value, ok := values[sub.Idx]; ok {
+            return value, true
+        }
+        valueType, ok := types.Map[sub.Type]
+        if failed[sub.Idx] || path[sub.Idx] || !ok {
+            return "", false
+        }
+        // problems with dependencies are reported for them
+        path[sub.Idx] = true
+        defer delete(path, sub.Idx)
+        input := subDefault(meta, sub)
+        args := []string { input }
+        for _, dep := range sub.Deps {
+            depSub, ok := byIdx[dep]
+            if !ok {
+                return "", false
+            }
+            value, ok := produce(depSub, path)
+            if !ok {
+                return "", false
+            }
+            args = append(args, value)
+        }
+        value, err := valueType.Produce(nil, args...)
+        if err != nil {
+            failed[sub.Idx] = true
+            report(LintBadDefault, describeSub(sub) + " default '" + input +
+                    "' fails type '" + sub.Type + "': " + err.Error())
+            return "", false
+        }
+        values[sub.Idx] = value
+        return value, true
+    }
+    for _, sub := range subs {
+        produce(sub, make(map[uint]bool))
+    }
+
+    return problems
+}
+
+// Find what's wrong with how an input is declared.
+func inputProblems(input data.Input) []string {
+    output := make([]string, 0)
+    switch input.Type {
+    case "", data.InputText, data.InputBool, data.InputInt, data.InputNumber,
+            data.InputEnum, data.InputImage:
+    default:
+        return append(output, ErrUnknownInputType { input.Type }.Error())
+    }
+    if _, err := regexp.Compile(input.Pattern); err != nil {
+        output = append(output, "has bad pattern: " + err.Error())
+    }
+    if input.Min != nil && input.Max != nil && *input.Min > *input.Max {
+        output = append(output, "has min " + FormatBound(input.Min) +
+                " above max " + FormatBound(input.Max))
+    }
+    if input.Type == data.InputEnum && len(input.Choices) == 0 {
+        output = append(output, "is an enum without choices")
+    }
+    if len(output) > 0 || input.Type == data.InputImage {
+        return output
+    }
+    if err := CheckInput(input, input.Default); err != nil {
+        output = append(output, "default '" + input.Default + "' " +
+                err.Error())
+    }
+    return output
+}
+
+// Add the markers in the names and contents of a base coin's generated files
+// to those found in its template.
+func withGenerated(meta *data.Meta,
+        markers map[uint][]string) map[uint][]string {
+    files := meta.FileRules().Generate
+    if len(files) == 0 {
+        return markers
+    }
+    output := make(map[uint][]string, len(markers))
+    for idx, names := range markers {
+        output[idx] = names
+    }
+    for _, file := range files {
+        // bad generated files are reported with the rules
+        found, err := template.ScanMarkers(generatedText(file), Markers(meta))
+        if err != nil {
+            continue
+        }
+        for idx := range found {
+            output[idx] = append(output[idx], "generated " + file.Name)
+        }
+    }
+    return output
+}
+
+// Get the inputs file rules depend on.
+func ruleInputs(rules data.FileRules) []string {
+    output := make([]string, 0)
+    for _, rule := range rules.Exclude {
+        output = append(output, rule.Unless...)
+    }
+    for _, file := range rules.Generate {
+        output = append(output, file.If...)
+    }
+    return output
+}
+
+func generatedText(file data.GeneratedFile) io.Reader {
+    return strings.NewReader(file.Name + "\n" + file.Contents)
+}
+
+// Find the dependency cycles among subs by index, each as the path of indices
+// around it starting and ending at its lowest index.
+func depCycles(byIdx map[uint]data.Sub) [][]uint {
+    cycles := make([][]uint, 0)
+    // depth first from each sub, following only higher indices, so each cycle
+    // is found once from its lowest index
+    var visit func(start uint, path []uint)
+    visit = func(start uint, path []uint) {
+        for _, dep := range byIdx[path[len(path) - 1]].Deps {
+            if dep == start {
+                cycles = append(cycles, append(append([]uint {}, path...),
+                        start))
+                continue
+            }
+            if _, ok := byIdx[dep]; !ok || dep < start || inPath(path, dep) {
+                continue
+            }
+            visit(start, append(path, dep))
+        }
+    }
+    indices := make([]int, 0, len(byIdx))
+    for idx := range byIdx {
+        indices = append(indices, int(idx))
+    }
+    sort.Ints(indices)
+    for _, idx := range indices {
+        visit(uint(idx), []uint { uint(idx) })
+    }
+    return cycles
+}
+
+func inPath(path []uint, idx uint) bool {
+    for _, step := range path {
+        if step == idx {
+            return true
+        }
+    }
+    return false
+}
+
+func sortedIndices(markers map[uint][]string) []uint {
+    indices := make([]int, 0, len(markers))
+    for idx := range markers {
+        indices = append(indices, int(idx))
+    }
+    sort.Ints(indices)
+    output := make([]uint, len(indices))
+    for ii, idx := range indices {
+        output[ii] = uint(idx)
+    }
+    return output
+}
+
+// Describe a sub by index and comment for humans.
+func describeSub(sub data.Sub) string {
+    if sub.Comment == "" {
+        return "substitution " + strconv.Itoa(int(sub.Idx))
+    }
+    return "substitution " + strconv.Itoa(int(sub.Idx)) + " (" + sub.Comment +
+            ")"
+}
@@ -0,0 +1,196 @@
+package source
+
+import (
+    "buildacoin/data"
+    "testing"
+)
+
+func TestLint(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "name", Default: "Bestcoin" },
+            data.Input { Group: "extras", Id: "ticker", Default: "BST" },
+            data.Input { Group: "basics", Id: "port", Default: "lots" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Input: "name", Type: "str" },
+            data.Sub { Idx: 2, Input: "ticker", Comment: "ticker",
+                    Type: "str-alpha-upper" },
+            data.Sub { Idx: 2, Comment: "again", Type: "literal" },
+            data.Sub { Idx: 3, Input: "port", Type: "uint16" },
+            data.Sub { Idx: 4, Type: "nonsense" },
+            data.Sub { Idx: 5, Type: "literal", Deps: []uint { 6 } },
+            data.Sub { Idx: 6, Type: "literal", Deps: []uint { 5 } },
+            data.Sub { Idx: 7, Type: "literal", Deps: []uint { 99 } },
+            data.Sub { Idx: 8, Default: "x", Type: "literal" },
+        })
+    markers := map[uint][]string {
+        1: []string { "src/main.h" },
+        2: []string { "src/main.h" },
+        3: []string { "src/net.h" },
+        4: []string { "src/net.h" },
+        5: []string { "src/net.h" },
+        7: []string { "src/net.h" },
+        10: []string { "src/b.h", "src/a.h" },
+    }
+
+    expected := []LintProblem {
+        { LintUnknownMarker, "10 in src/a.h, src/b.h" },
+        { LintUnusedSub, "substitution 8 is in no marker, " +
+                "dependency, file rule or logo" },
+        { LintDuplicateIdx, "substitution 2 (again)" },
+        { LintUnknownType, "substitution 4 has type 'nonsense'" },
+        { LintDepCycle, "5 -> 6 -> 5" },
+        { LintDanglingDep, "substitution 7 depends on missing 99" },
+        { LintUngroupedInput, "'ticker' is in group 'extras'" },
+        { LintBadDefault, "substitution 3 default 'lots' fails type 'uint16'" },
+    }
+    actual := Lint(meta, markers)
+    if len(actual) != len(expected) {
+        t.Fatalf("expected %d problems, got %v\n", len(expected), actual)
+    }
+    for ii, problem := range expected {
+        // bad default details end with the type's own error
+        detail := actual[ii].Detail
+        if problem.Kind == LintBadDefault && len(detail) > len(problem.Detail) {
+            detail = detail[:len(problem.Detail)]
+        }
+        if actual[ii].Kind != problem.Kind || detail != problem.Detail {
+            t.Fatalf("problem %d: expected '%s' / actual '%s'\n", ii,
+                    problem.String(), actual[ii].String())
+        }
+    }
+
+    problems := Lint(simpleMeta, map[uint][]string {})
+    if len(problems) != 3 {
+        t.Fatalf("expected 3 unused subs, got %v\n", problems)
+    }
+}
+
+func TestLintFileRules(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "gui", Default: "true" },
+            data.Input { Group: "basics", Id: "name", Default: "Bestcoin" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Input: "name", Type: "str" },
+            data.Sub { Idx: 2, Input: "gui", Type: "bool" },
+        }).WithFileRules(data.FileRules {
+            Exclude: []data.ExcludeRule {
+                data.ExcludeRule { Path: "src/qt/",
+                        Unless: []string { "gui" } },
+                data.ExcludeRule { Path: "(", Unless: []string { "name" } },
+            },
+            Generate: []data.GeneratedFile {
+                data.GeneratedFile { Name: "PARAMETERS",
+                        Contents: "name: __._1-\n" },
+            },
+        })
+
+    rules, err := FileRules(meta)
+    if _, ok := err.(ErrBadRulePath); !ok {
+        t.Fatalf("expected ErrBadRulePath, got %v\n", err)
+    }
+    expected := []string {
+        "bad file rule: bad file rule path '(': error parsing regexp: " +
+                "missing closing ): `(`",
+        "bad file rule: file rule input 'name' has no boolean substitution",
+    }
+    // the generated file's marker uses substitution 1, and a rule 2
+    actual := Lint(meta, map[uint][]string {})
+    if len(actual) != len(expected) {
+        t.Fatalf("expected %d problems, got %v\n", len(expected), actual)
+    }
+    for ii, problem := range expected {
+        if actual[ii].String() != problem {
+            t.Fatalf("problem %d: expected '%s' / actual '%s'\n", ii,
+                    problem, actual[ii].String())
+        }
+    }
+
+    meta = meta.WithFileRules(data.FileRules {
+        Exclude: []data.ExcludeRule {
+            data.ExcludeRule { Path: "src/qt/", Unless: []string { "gui" } },
+        },
+    })
+    rules, err = FileRules(meta)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(rules.Exclude) != 1 || len(rules.Exclude[0].Unless) != 1 ||
+            rules.Exclude[0].Unless[0] != 2 {
+        t.Fatalf("unexpected rules %v\n", rules)
+    }
+}
+
+func TestLintLogo(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "logo", Type: "image" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Input: "logo", Type: "literal" },
+        }).WithLogo(data.Logo {
+            Input: "logo",
+            Assets: []data.Asset {
+                data.Asset { Path: "icon16.png", Format: "png",
+                        Sizes: []int { 16 } },
+                data.Asset { Path: "icon.gif", Format: "gif",
+                        Sizes: []int { 16 } },
+            },
+        })
+    expected := []string {
+        "unused substitution: substitution 1 is in no marker, dependency, " +
+                "file rule or logo",
+        "bad logo: logo input 'logo' has no png substitution",
+        "bad logo: bad logo asset 'icon.gif': unknown image format",
+    }
+    actual := Lint(meta, map[uint][]string {})
+    if len(actual) != len(expected) {
+        t.Fatalf("expected %d problems, got %v\n", len(expected), actual)
+    }
+    for ii, problem := range expected {
+        if actual[ii].String() != problem {
+            t.Fatalf("problem %d: expected '%s' / actual '%s'\n", ii,
+                    problem, actual[ii].String())
+        }
+    }
+    if _, err := FileRules(meta); err == nil {
+        t.Fatal("logo without png substitution accepted")
+    }
+}
+
+func TestLintInputs(t *testing.T) {
+    one, two := 1.0, 2.0
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "a", Type: "colour" },
+            data.Input { Group: "basics", Id: "b", Type: "int",
+                    Min: &two, Max: &one },
+            data.Input { Group: "basics", Id: "c", Type: "enum" },
+            data.Input { Group: "basics", Id: "d", Pattern: "[a-z" },
+            data.Input { Group: "basics", Id: "e", Type: "bool",
+                    Default: "maybe" },
+            data.Input { Group: "basics", Id: "f", Type: "image" },
+        },
+        []data.Sub {})
+    expected := []string {
+        "bad input: 'a' has unknown type 'colour'",
+        "bad input: 'b' has min 2 above max 1",
+        "bad input: 'c' is an enum without choices",
+        "bad input: 'd' has bad pattern: error parsing regexp: missing " +
+                "closing ]: `[a-z`",
+        "bad input: 'e' default 'maybe' must be true or false",
+    }
+    actual := Lint(meta, map[uint][]string {})
+    if len(actual) != len(expected) {
+        t.Fatalf("expected %d problems, got %v\n", len(expected), actual)
+    }
+    for ii, problem := range expected {
+        if actual[ii].String() != problem {
+            t.Fatalf("problem %d: expected '%s' / actual '%s'\n", ii,
+                    problem, actual[ii].String())
+        }
+    }
+}
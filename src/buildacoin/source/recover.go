@@ -0,0 +1,77 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/source/types"
+    "buildacoin/template"
+    "sort"
+    "strconv"
+)
+
+// Work out the user inputs that give the values recovered from a coin's
+// source.  Each input takes whichever of its substitutions' values, or its
+// default, reproduces the most of them.  Returns the inputs and notes on those
+// that couldn't be recovered or don't reproduce every value.
+func RecoverInputs(meta *data.Meta,
+        values template.FilterMap) (map[string]string, []string) {
+    inputs := DefaultValues(meta)
+    notes := make([]string, 0)
+
+    // the substitutions taking each input directly
+    bySubs := make(map[string][]data.Sub)
+    for _, sub := range meta.Subs() {
+        // keys can be recovered too: inputs of key types take the public
+        // key found instead of generating one
+        _, ok := types.Map[sub.Type]
+        if !ok || sub.Input == "" || len(sub.Deps) > 0 {
+            continue
+        }
+        bySubs[sub.Input] = append(bySubs[sub.Input], sub)
+    }
+    reproduces := func(sub data.Sub, input string) bool {
+        value, ok := values[sub.Idx]
+        if !ok {
+            return false
+        }
+        produced, err := types.Map[sub.Type].Produce(nil, input)
+        return err == nil && produced == string(value)
+    }
+
+    for _, input := range meta.Inputs() {
+        subs := bySubs[input.Id]
+        candidates := []string { input.Default }
+        for _, sub := range subs {
+            if value, ok := values[sub.Idx]; ok {
+                candidates = append(candidates, string(value))
+            }
+        }
+        best, bestCount := "", 0
+        for _, candidate := range candidates {
+            count := 0
+            for _, sub := range subs {
+                if reproduces(sub, candidate) {
+                    count++
+                }
+            }
+            if count > bestCount {
+                best, bestCount = candidate, count
+            }
+        }
+        if bestCount == 0 {
+            notes = append(notes, "input '" + input.Id + "' wasn't " +
+                    "recovered; kept default '" + input.Default + "'")
+            continue
+        }
+        inputs[input.Id] = best
+        for _, sub := range subs {
+            if _, ok := values[sub.Idx]; ok && !reproduces(sub, best) {
+                notes = append(notes, "input '" + input.Id + "' = '" + best +
+                        "' doesn't give the value '" +
+                        string(values[sub.Idx]) + "' of substitution " +
+                        strconv.Itoa(int(sub.Idx)))
+            }
+        }
+    }
+    sort.Strings(notes)
+    return inputs, notes
+}
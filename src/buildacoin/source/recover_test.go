@@ -0,0 +1,53 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/template"
+    "testing"
+)
+
+func TestRecoverInputs(t *testing.T) {
+    meta := data.NewMeta("", "", "", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "name", Default: "Bestcoin" },
+            data.Input { Group: "basics", Id: "port", Default: "9333" },
+            data.Input { Group: "basics", Id: "reward", Default: "50" },
+            data.Input { Group: "basics", Id: "motto", Default: "hi" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Input: "name", Type: "str-alpha-lower" },
+            data.Sub { Idx: 2, Input: "name", Type: "str" },
+            data.Sub { Idx: 3, Input: "port", Type: "uint16" },
+            data.Sub { Idx: 4, Input: "reward", Type: "coins" },
+            data.Sub { Idx: 5, Input: "motto", Type: "str" },
+            data.Sub { Idx: 6, Input: "port", Type: "uint16",
+                    Deps: []uint { 3 } },
+        })
+    values := template.FilterMap {
+        1: []byte("zorkcoin"),
+        2: []byte("Zorkcoin"),
+        3: []byte("7777"),
+        4: []byte("5000000000"),
+    }
+    inputs, notes := RecoverInputs(meta, values)
+    expected := map[string]string { "name": "Zorkcoin", "port": "7777",
+            "reward": "50", "motto": "hi" }
+    for id, value := range expected {
+        if inputs[id] != value {
+            t.Fatalf("input %s: expected '%s' / actual '%s'\n", id, value,
+                    inputs[id])
+        }
+    }
+    if len(notes) != 1 || notes[0] != "input 'motto' wasn't recovered; " +
+            "kept default 'hi'" {
+        t.Fatalf("unexpected notes %v\n", notes)
+    }
+
+    values[4] = []byte("100")
+    _, notes = RecoverInputs(meta, values)
+    if len(notes) != 2 || notes[0] != "input 'motto' wasn't recovered; " +
+            "kept default 'hi'" || notes[1] != "input 'reward' wasn't " +
+            "recovered; kept default '50'" {
+        t.Fatalf("unexpected notes %v\n", notes)
+    }
+}
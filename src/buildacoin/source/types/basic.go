@@ -1,16 +1,17 @@
 package types
 
 import (
+    "buildacoin/template"
+    "encoding/binary"
     "errors"
-    "math/rand"
+    "image/png"
     "regexp"
     "strconv"
     "strings"
-    "time"
 )
 
 type literalType struct{}
-func (tt literalType) Produce(inputs ...string) (string, error) {
+func (tt literalType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -36,49 +37,49 @@ func doInt(input string, size int) (string, error) {
 var ErrWrongArity error = errors.New("wrong arity for type")
 
 type byteType struct{}
-func (tt byteType) Produce(inputs ...string) (string, error) {
+func (tt byteType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doUint(inputs[0], 8)
 }
 type sevenBitType struct{}
-func (tt sevenBitType) Produce(inputs ...string) (string, error) {
+func (tt sevenBitType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doUint(inputs[0], 7)
 }
 type uint16Type struct{}
-func (tt uint16Type) Produce(inputs ...string) (string, error) {
+func (tt uint16Type) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doUint(inputs[0], 16)
 }
 type uint32Type struct{}
-func (tt uint32Type) Produce(inputs ...string) (string, error) {
+func (tt uint32Type) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doUint(inputs[0], 32)
 }
 type uint64Type struct{}
-func (tt uint64Type) Produce(inputs ...string) (string, error) {
+func (tt uint64Type) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doUint(inputs[0], 64)
 }
 type int32Type struct{}
-func (tt int32Type) Produce(inputs ...string) (string, error) {
+func (tt int32Type) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
     return doInt(inputs[0], 32)
 }
 type int64Type struct{}
-func (tt int64Type) Produce(inputs ...string) (string, error) {
+func (tt int64Type) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -86,7 +87,7 @@ func (tt int64Type) Produce(inputs ...string) (string, error) {
 }
 
 type doubleType struct{}
-func (tt doubleType) Produce(inputs ...string) (string, error) {
+func (tt doubleType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -97,11 +98,71 @@ func (tt doubleType) Produce(inputs ...string) (string, error) {
     return strconv.FormatFloat(val, 'f', -1, 64), nil
 }
 
+type boolType struct{}
+func (tt boolType) Produce(ctx *Context, inputs ...string) (string, error) {
+    if len(inputs) != 1 {
+        return "", ErrWrongArity
+    }
+    val, err := strconv.ParseBool(strings.TrimSpace(inputs[0]))
+    if err != nil {
+        return "", err
+    }
+    return strconv.FormatBool(val), nil
+}
+
+type listType struct{}
+func (tt listType) Produce(ctx *Context, inputs ...string) (string, error) {
+    if len(inputs) != 1 {
+        return "", ErrWrongArity
+    }
+    items := make([]string, 0)
+    for _, line := range strings.Split(inputs[0], "\n") {
+        for _, item := range strings.Split(line, ",") {
+            item = strings.TrimSpace(item)
+            if item != "" {
+                items = append(items, item)
+            }
+        }
+    }
+    return strings.Join(items, template.ListSeparator), nil
+}
+
+var ErrNotPNG error = errors.New("not a PNG image")
+var ErrImageTooLarge error = errors.New("image too large")
+// Largest PNG accepted, in bytes and in pixels on either side
+const MaxPNGLen = 1024 * 1024
+const MaxPNGSide = 2048
+type pngType struct{}
+func (tt pngType) Produce(ctx *Context, inputs ...string) (string, error) {
+    if len(inputs) != 1 {
+        return "", ErrWrongArity
+    }
+    if inputs[0] == "" {
+        return "", nil
+    }
+    if len(inputs[0]) > MaxPNGLen {
+        return "", ErrImageTooLarge
+    }
+    // the header is checked before the image is decompressed
+    config, err := png.DecodeConfig(strings.NewReader(inputs[0]))
+    if err != nil {
+        return "", ErrNotPNG
+    }
+    if config.Width > MaxPNGSide || config.Height > MaxPNGSide {
+        return "", ErrImageTooLarge
+    }
+    _, err = png.Decode(strings.NewReader(inputs[0]))
+    if err != nil {
+        return "", ErrNotPNG
+    }
+    return inputs[0], nil
+}
+
 var ErrIllegalChar error = errors.New("illegal characters in string")
 var ErrStrTooLong error = errors.New("string too long")
 const MaxStrLen = 256
 type strType struct{}
-func (tt strType) Produce(inputs ...string) (string, error) {
+func (tt strType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -119,7 +180,7 @@ func (tt strType) Produce(inputs ...string) (string, error) {
 
 var strAlphaRegex *regexp.Regexp = regexp.MustCompile("^[a-zA-Z]*$")
 func checkStrAlpha(input string) error {
-    if _, err := Str.Produce(input); err != nil {
+    if _, err := Str.Produce(nil, input); err != nil {
         return err
     }
     if !strAlphaRegex.MatchString(input) {
@@ -129,7 +190,7 @@ func checkStrAlpha(input string) error {
 }
 
 type strAlphaType struct{}
-func (tt strAlphaType) Produce(inputs ...string) (string, error) {
+func (tt strAlphaType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -139,7 +200,8 @@ func (tt strAlphaType) Produce(inputs ...string) (string, error) {
     return inputs[0], nil
 }
 type strAlphaLowerType struct{}
-func (tt strAlphaLowerType) Produce(inputs ...string) (string, error) {
+func (tt strAlphaLowerType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -149,7 +211,8 @@ func (tt strAlphaLowerType) Produce(inputs ...string) (string, error) {
     return strings.ToLower(inputs[0]), nil
 }
 type strAlphaUpperType struct{}
-func (tt strAlphaUpperType) Produce(inputs ...string) (string, error) {
+func (tt strAlphaUpperType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -160,12 +223,18 @@ func (tt strAlphaUpperType) Produce(inputs ...string) (string, error) {
 }
 
 type unixtimeCurrentType struct{}
-func (tt unixtimeCurrentType) Produce(inputs ...string) (string, error) {
-    now := time.Now().UTC()
-    return strconv.FormatInt(now.Unix(), 10), nil
+func (tt unixtimeCurrentType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    return strconv.FormatInt(ctx.Now().Unix(), 10), nil
 }
 
 type randomUint32Type struct{}
-func (tt randomUint32Type) Produce(inputs ...string) (string, error) {
-    return strconv.FormatUint(uint64(rand.Uint32()), 10), nil
+func (tt randomUint32Type) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    var output uint32
+    err := binary.Read(ctx.Random(), binary.BigEndian, &output)
+    if err != nil {
+        return "", ErrNoEntropy
+    }
+    return strconv.FormatUint(uint64(output), 10), nil
 }
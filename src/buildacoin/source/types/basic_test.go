@@ -0,0 +1,27 @@
+package types
+
+import "testing"
+
+func TestStrAlphaCase(t *testing.T) {
+    cases := []struct { valueType Type; input, expected string } {
+        { StrAlphaUpper, "ABC", "ABC" },
+        { StrAlphaUpper, "Bitcoin", "BITCOIN" },
+        { StrAlphaLower, "ABC", "abc" },
+        { StrAlphaLower, "Bitcoin", "bitcoin" },
+    }
+    for _, c := range cases {
+        value, err := c.valueType.Produce(nil, c.input)
+        if err != nil || value != c.expected {
+            t.Fatalf("'%s': expected '%s' / actual '%s' (%v)\n", c.input,
+                    c.expected, value, err)
+        }
+    }
+
+    for _, valueType := range []Type { StrAlphaUpper, StrAlphaLower } {
+        for _, input := range []string { "abc1", "a b" } {
+            if _, err := valueType.Produce(nil, input); err == nil {
+                t.Fatalf("'%s' should have been rejected\n", input)
+            }
+        }
+    }
+}
@@ -6,6 +6,7 @@ import (
     "crypto/rand"
     "encoding/hex"
     "errors"
+    "io"
     "math/big"
     "strconv"
     "strings"
@@ -20,7 +21,7 @@ var (
 const maxCoins = (1 << 63 - 1) / bitcoin.Coin
 
 type coinsType struct {}
-func (tt coinsType) Produce(inputs ...string) (string, error) {
+func (tt coinsType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -37,7 +38,8 @@ func (tt coinsType) Produce(inputs ...string) (string, error) {
 }
 
 type difficultyType struct {}
-func (tt difficultyType) Produce(inputs ...string) (string, error) {
+func (tt difficultyType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -52,7 +54,7 @@ func (tt difficultyType) Produce(inputs ...string) (string, error) {
 
 var ErrBadPubkeyLen error = errors.New("bad length for public key")
 type pubkeyType struct {}
-func (tt pubkeyType) Produce(inputs ...string) (string, error) {
+func (tt pubkeyType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 1 {
         return "", ErrWrongArity
     }
@@ -73,9 +75,9 @@ func (tt pubkeyType) Produce(inputs ...string) (string, error) {
 var ErrNoEntropy error = errors.New("insufficient entropy to create " +
         "random variable")
 
-func doRandomBytes(length int) (string, error) {
+func doRandomBytes(ctx *Context, length int) (string, error) {
     bytes := make([]byte, length)
-    n, err := rand.Read(bytes)
+    n, err := io.ReadFull(ctx.Random(), bytes)
     if n < len(bytes) || err != nil {
         return "", ErrNoEntropy
     }
@@ -83,17 +85,64 @@ func doRandomBytes(length int) (string, error) {
 }
 
 type randomPubkeyType struct {}
-func (tt randomPubkeyType) Produce(inputs ...string) (string, error) {
-    return doRandomBytes(bitcoin.PubkeyLen)
+func (tt randomPubkeyType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    return doRandomBytes(ctx, bitcoin.PubkeyLen)
+}
+
+// Input to PubkeyOrGenerated asking for a new key
+const GenerateKeyInput = "generate"
+
+// Keys come from crypto/rand rather than the context, whose seed isn't secret.
+func doGeneratedPubkey() (string, *bitcoin.PrivateKey, error) {
+    key, err := bitcoin.NewPrivateKey(rand.Reader)
+    if err != nil {
+        return "", nil, ErrNoEntropy
+    }
+    return hex.EncodeToString(key.UncompressedPublicKey()), &key, nil
+}
+
+type generatedPubkeyType struct{}
+func (tt generatedPubkeyType) Expensive() {}
+func (tt generatedPubkeyType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    output, _, err := tt.ProduceKey(ctx, inputs...)
+    return output, err
+}
+func (tt generatedPubkeyType) ProduceKey(ctx *Context,
+        inputs ...string) (string, *bitcoin.PrivateKey, error) {
+    return doGeneratedPubkey()
+}
+
+type pubkeyOrGeneratedType struct{}
+func (tt pubkeyOrGeneratedType) Expensive() {}
+func (tt pubkeyOrGeneratedType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    output, _, err := tt.ProduceKey(ctx, inputs...)
+    return output, err
+}
+func (tt pubkeyOrGeneratedType) ProduceKey(ctx *Context,
+        inputs ...string) (string, *bitcoin.PrivateKey, error) {
+    if len(inputs) != 1 {
+        return "", nil, ErrWrongArity
+    }
+    if strings.TrimSpace(inputs[0]) == GenerateKeyInput {
+        return doGeneratedPubkey()
+    }
+    output, err := Pubkey.Produce(ctx, inputs...)
+    return output, nil, err
 }
 
 type randomHashType struct{}
-func (tt randomHashType) Produce(inputs ...string) (string, error) {
-    return doRandomBytes(bitcoin.HashSize)
+func (tt randomHashType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
+    return doRandomBytes(ctx, bitcoin.HashSize)
 }
 
 type genesisMerkleRootType struct{}
-func (tt genesisMerkleRootType) Produce(inputs ...string) (string, error) {
+func (tt genesisMerkleRootType) Expensive() {}
+func (tt genesisMerkleRootType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 4 {
         return "", ErrWrongArity
     }
@@ -122,7 +171,9 @@ func (tt genesisMerkleRootType) Produce(inputs ...string) (string, error) {
 }
 
 type genesisBlockHashType struct{}
-func (tt genesisBlockHashType) Produce(inputs ...string) (string, error) {
+func (tt genesisBlockHashType) Expensive() {}
+func (tt genesisBlockHashType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 5 {
         return "", ErrWrongArity
     }
@@ -169,7 +220,7 @@ func (tt genesisBlockHashType) Produce(inputs ...string) (string, error) {
 }
 
 type coinsMaxType struct{}
-func (tt coinsMaxType) Produce(inputs ...string) (string, error) {
+func (tt coinsMaxType) Produce(ctx *Context, inputs ...string) (string, error) {
     if len(inputs) != 3 {
         return "", ErrWrongArity
     }
@@ -189,7 +240,8 @@ func (tt coinsMaxType) Produce(inputs ...string) (string, error) {
 }
 
 type doubleCoinsType struct{}
-func (tt doubleCoinsType) Produce(inputs ...string) (string, error) {
+func (tt doubleCoinsType) Produce(ctx *Context,
+        inputs ...string) (string, error) {
     if len(inputs) != 2 {
         return "", ErrWrongArity
     }
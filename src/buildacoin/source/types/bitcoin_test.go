@@ -37,3 +37,21 @@ func TestGenesisHash(t *testing.T) {
                 actual)
     }
 }
+
+func TestGeneratedPubkey(t *testing.T) {
+    var keyType KeyProducer = PubkeyOrGenerated
+    output, key, err := keyType.ProduceKey(nil, GenerateKeyInput)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if key == nil ||
+            output != hex.EncodeToString(key.UncompressedPublicKey()) {
+        t.Fatalf("generated pubkey doesn't match its key\n")
+    }
+
+    given := hex.EncodeToString(key.PublicKey())
+    output, key, err = keyType.ProduceKey(nil, given)
+    if err != nil || key != nil || output != given {
+        t.Fatalf("given pubkey not passed through: %v\n", err)
+    }
+}
@@ -0,0 +1,89 @@
+package types
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "io"
+    "strconv"
+    "time"
+)
+
+// What types draw on beyond their inputs to produce values: a source of random
+// bytes and a clock.  Types with random or time-dependent values take them
+// only from their context, so the same inputs in the same seeded context
+// always produce the same values.  A nil *Context draws on crypto/rand and the
+// system clock.
+//
+// Private keys are the exception, and always come from crypto/rand: seeds are
+// derived from values like coin IDs which end up in a coin's source, and would
+// give the keys away.
+type Context struct {
+    seed []byte
+    clock time.Time
+    random io.Reader
+}
+
+// Get a context whose random bytes are a stream derived from seed, and whose
+// clock is stopped at clock.  The seed should itself be random and at least
+// 16 bytes, such as a coin's ID.
+func SeededContext(seed []byte, clock time.Time) *Context {
+    return &Context { seed, clock, newHMACStream(seed) }
+}
+
+// Get the context for producing the value of the substitution at idx.  Each
+// substitution's random bytes are a stream of its own, so that values don't
+// depend on the order substitutions are produced in.
+func (tt *Context) Sub(idx uint) *Context {
+    if tt == nil {
+        return nil
+    }
+    mac := hmac.New(sha256.New, tt.seed)
+    mac.Write([]byte("substitution " + strconv.Itoa(int(idx))))
+    return SeededContext(mac.Sum(nil), tt.clock)
+}
+
+// Get the context's source of random bytes, which isn't safe to read from
+// concurrently unless the context is nil.
+func (tt *Context) Random() io.Reader {
+    if tt == nil {
+        return rand.Reader
+    }
+    return tt.random
+}
+
+// Get the time the context takes as the current time, in UTC.
+func (tt *Context) Now() time.Time {
+    if tt == nil {
+        return time.Now().UTC()
+    }
+    return tt.clock.UTC()
+}
+
+// Stream of the HMAC-SHA256s of a counter keyed by a seed, which is as
+// unpredictable as the seed is
+type hmacStream struct {
+    key []byte
+    counter uint64
+    block []byte
+}
+
+func newHMACStream(key []byte) *hmacStream {
+    return &hmacStream { key: key }
+}
+
+func (tt *hmacStream) Read(buf []byte) (int, error) {
+    for read := 0; read < len(buf); {
+        if len(tt.block) == 0 {
+            mac := hmac.New(sha256.New, tt.key)
+            binary.Write(mac, binary.BigEndian, tt.counter)
+            tt.block = mac.Sum(nil)
+            tt.counter++
+        }
+        copied := copy(buf[read:], tt.block)
+        tt.block = tt.block[copied:]
+        read += copied
+    }
+    return len(buf), nil
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+    "testing"
+    "time"
+)
+
+func TestSeededContext(t *testing.T) {
+    seed := []byte("0123456789abcdef")
+    clock := time.Unix(1400000000, 0)
+    produceAll := func(ctx *Context) []string {
+        output := make([]string, 0)
+        for _, valueType := range []Type { RandomUint32, RandomHash,
+                RandomPubkey, UnixtimeCurrent } {
+            value, err := valueType.Produce(ctx, "")
+            if err != nil {
+                t.Fatal(err.Error())
+            }
+            output = append(output, value)
+        }
+        return output
+    }
+
+    first := produceAll(SeededContext(seed, clock).Sub(1))
+    second := produceAll(SeededContext(seed, clock).Sub(1))
+    other := produceAll(SeededContext(seed, clock).Sub(2))
+    for ii := range first {
+        if first[ii] != second[ii] {
+            t.Fatalf("value %d: same seed gave '%s' and '%s'\n", ii, first[ii],
+                    second[ii])
+        }
+    }
+    // the clock is the same for every substitution
+    for ii := 0; ii < 3; ii++ {
+        if first[ii] == other[ii] {
+            t.Fatalf("value %d: substitutions 1 and 2 both gave '%s'\n", ii,
+                    first[ii])
+        }
+    }
+    if first[3] != "1400000000" {
+        t.Fatalf("expected clock 1400000000 / actual %s\n", first[3])
+    }
+
+    // keys never come from the seed
+    _, key, err := GeneratedPubkey.ProduceKey(SeededContext(seed, clock))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    _, otherKey, err := GeneratedPubkey.ProduceKey(SeededContext(seed, clock))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if string(key.PublicKey()) == string(otherKey.PublicKey()) {
+        t.Fatal("generated keys came from the seed")
+    }
+}
@@ -1,10 +1,31 @@
 package types
 
+import "buildacoin/bitcoin"
+
 // A type constrains template inputs to legal values for a field
 type Type interface {
     // Take a user input string and produce a conforming source code string or
-    // an error.
-    Produce(input ...string) (string, error)
+    // an error, drawing on ctx for any random or time-dependent values.
+    Produce(ctx *Context, input ...string) (string, error)
+}
+
+// A type whose values can come with a newly generated private key, which the
+// coin's creator must be given since it exists nowhere else
+type KeyProducer interface {
+    Type
+    // Like Produce, but also return the private key behind the produced value
+    // if one was generated, or nil if the value came from the input.  Keys
+    // are generated from crypto/rand whatever the context.
+    ProduceKey(ctx *Context, input ...string) (string, *bitcoin.PrivateKey,
+            error)
+}
+
+// A type whose values take long enough to produce (hashing, key generation)
+// that they're worth producing alongside others which don't depend on them
+type Expensive interface {
+    Type
+    // Does nothing but mark the type.
+    Expensive()
 }
 
 var (
@@ -12,6 +33,15 @@ var (
     // alias for this type.
     Literal literalType
     Byte byteType
+    // accepts the usual spellings of true and false and produces "true" or
+    // "false", for conditional template sections
+    Bool boolType
+    // accepts items separated by commas or newlines and produces them as a
+    // template list value, for repeated template sections
+    List listType
+    // accepts a PNG image, or nothing, and produces it as it is, for images
+    // drawn from it
+    PNG pngType
     // Hex values less than 128 (0x80)
     SevenBit sevenBitType
     Uint16 uint16Type
@@ -28,7 +58,7 @@ var (
     // accepts strAlpha inputs and produces all lowercase strings
     StrAlphaLower strAlphaLowerType
     // accepts strAlpha inputs and produces all uppercase strings
-    StrAlphaUpper strAlphaLowerType
+    StrAlphaUpper strAlphaUpperType
     // produces a random 32-bit unsigned integer
     RandomUint32 randomUint32Type
     // accepts floating representations of coin values and returns integer
@@ -41,9 +71,15 @@ var (
     Pubkey pubkeyType
     // produces a random hex-encoded uncompressed public key
     RandomPubkey randomPubkeyType
+    // produces the hex-encoded uncompressed public key of a newly generated
+    // private key
+    GeneratedPubkey generatedPubkeyType
+    // like Pubkey, but generates a key as GeneratedPubkey does if the input is
+    // "generate"
+    PubkeyOrGenerated pubkeyOrGeneratedType
     // produces a random hex-encoded hash value
     RandomHash randomHashType
-    // produces a unix timestamp of the current time
+    // produces a unix timestamp of the context's current time
     UnixtimeCurrent unixtimeCurrentType
     // computes the merkle root of a genesis block from the genesis message and
     // the coinbase tx output pubkey
@@ -61,6 +97,9 @@ var (
         "literal": Literal,
         "": Literal,
         "byte": Byte,
+        "bool": Bool,
+        "list": List,
+        "png": PNG,
         "7bit": SevenBit,
         "uint16": Uint16,
         "uint32": Uint32,
@@ -77,6 +116,8 @@ var (
         "difficulty": Difficulty,
         "pubkey": Pubkey,
         "random-pubkey": RandomPubkey,
+        "generated-pubkey": GeneratedPubkey,
+        "pubkey-or-generated": PubkeyOrGenerated,
         "random-hash": RandomHash,
         "unixtime-current": UnixtimeCurrent,
         "genesis-merkle-root": GenesisMerkleRoot,
@@ -84,4 +125,15 @@ var (
         "coins-max": CoinsMax,
         "double-coins": DoubleCoins,
     }
+    // Names of the types whose values are hex-encoded bytes, which template
+    // encodings take as bytes even when they're all digits
+    HexTypes = map[string]bool {
+        "pubkey": true,
+        "random-pubkey": true,
+        "generated-pubkey": true,
+        "pubkey-or-generated": true,
+        "random-hash": true,
+        "genesis-merkle-root": true,
+        "genesis-block-hash": true,
+    }
 )
@@ -0,0 +1,217 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/source/types"
+    "buildacoin/template"
+    "sort"
+    "strconv"
+)
+
+// Kinds of change made by UpgradeFilterMap
+const (
+    UpgradeRenamed = "renamed"
+    UpgradeAdded = "added"
+    UpgradeRemoved = "removed"
+    // values at indices the new base has no substitution for, with no
+    // migration removing them
+    UpgradeDropped = "dropped"
+)
+
+// A change made to a coin's values by an upgrade
+type UpgradeChange struct {
+    Kind string
+    // versions of the migration making the change, "from -> to"
+    Migration string
+    Idx uint
+    // the index moved from, for renamed substitutions
+    From uint
+    Value string
+}
+func (tt UpgradeChange) String() string {
+    idx := strconv.Itoa(int(tt.Idx))
+    switch tt.Kind {
+    case UpgradeRenamed:
+        idx = strconv.Itoa(int(tt.From)) + " -> " + idx
+    case UpgradeDropped:
+        return tt.Kind + " " + idx + " ('" + tt.Value + "')"
+    }
+    return tt.Migration + ": " + tt.Kind + " " + idx + " ('" + tt.Value + "')"
+}
+
+// Carry the values of a coin made with an earlier version of a base onto the
+// base as meta describes it now, following the migrations in its metadata from
+// fromVersion.  Values of added substitutions are produced in ctx, as they
+// are by BuildFilterMapKeys.  Returns the new values and every change made to
+// them.
+func UpgradeFilterMap(ctx *types.Context, meta *data.Meta, fromVersion string,
+        values template.FilterMap) (template.FilterMap, []UpgradeChange,
+        error) {
+    output := make(template.FilterMap, len(values))
+    for idx, value := range values {
+        output[idx] = value
+    }
+    changes := make([]UpgradeChange, 0)
+
+    // follow the chain of migrations to the current version
+    migrations := meta.Migrations()
+    version := fromVersion
+    for steps := 0; version != meta.Version(); steps++ {
+        migration, ok := findMigration(migrations, version)
+        if !ok || steps >= len(migrations) {
+            return nil, nil, ErrNoMigration { version, meta.Version() }
+        }
+        name := migration.FromVersion + " -> " + migration.ToVersion
+        note := func(kind string, idx, from uint, value []byte) {
+            changes = append(changes,
+                    UpgradeChange { kind, name, idx, from, string(value) })
+        }
+
+        for _, idx := range migration.Removed {
+            note(UpgradeRemoved, idx, idx, output[idx])
+            delete(output, idx)
+        }
+        // renames happen together, so indices can be swapped
+        moved := make(template.FilterMap, len(migration.Renamed))
+        for _, rename := range migration.Renamed {
+            value, ok := output[rename.From]
+            if !ok {
+                return nil, nil, ErrMissingValue { name, rename.From }
+            }
+            moved[rename.To] = value
+            note(UpgradeRenamed, rename.To, rename.From, value)
+        }
+        for _, rename := range migration.Renamed {
+            delete(output, rename.From)
+        }
+        for idx, value := range moved {
+            output[idx] = value
+        }
+        for _, added := range migration.Added {
+            value, err := addedValue(ctx, meta, added, output)
+            if err != nil {
+                return nil, nil, err
+            }
+            output[added.Idx] = value
+            note(UpgradeAdded, added.Idx, added.Idx, value)
+        }
+        version = migration.ToVersion
+    }
+    if fromVersion == meta.Version() {
+        return output, changes, nil
+    }
+
+    // every substitution of the base needs a value, and values without one
+    // are of no use
+    known := make(map[uint]bool)
+    for _, sub := range meta.Subs() {
+        known[sub.Idx] = true
+        if _, ok := output[sub.Idx]; !ok {
+            return nil, nil, ErrUnmigratedSub { sub.Idx }
+        }
+    }
+    dropped := make([]int, 0)
+    for idx := range output {
+        if !known[idx] {
+            dropped = append(dropped, int(idx))
+        }
+    }
+    sort.Ints(dropped)
+    for _, idx := range dropped {
+        changes = append(changes, UpgradeChange { UpgradeDropped, "",
+                uint(idx), uint(idx), string(output[uint(idx)]) })
+        delete(output, uint(idx))
+    }
+    return output, changes, nil
+}
+
+func findMigration(migrations []data.Migration,
+        version string) (data.Migration, bool) {
+    for _, migration := range migrations {
+        if migration.FromVersion == version {
+            return migration, true
+        }
+    }
+    return data.Migration{}, false
+}
+
+// Get the value of a substitution added by a migration: the migration's
+// default, or the substitution's own default produced from the values so far.
+// Keys generated now would reach nobody, so those need migration defaults.
+func addedValue(ctx *types.Context, meta *data.Meta, added data.AddedSub,
+        values template.FilterMap) ([]byte, error) {
+    if added.Default != "" {
+        return []byte(added.Default), nil
+    }
+    var sub data.Sub
+    found := false
+    for _, candidate := range meta.Subs() {
+        if candidate.Idx == added.Idx {
+            sub, found = candidate, true
+        }
+    }
+    if !found {
+        return nil, ErrUnmigratedSub { added.Idx }
+    }
+    valueType, ok := types.Map[sub.Type]
+    if !ok {
+        return nil, ErrUnknownType { sub.Input, sub.Type }
+    }
+    if _, ok := valueType.(types.KeyProducer); ok {
+        return nil, ErrAddedKey { added.Idx }
+    }
+    args := []string { subDefault(meta, sub) }
+    for _, dep := range sub.Deps {
+        value, ok := values[dep]
+        if !ok {
+            return nil, ErrMissingValue { "dependency of " +
+                    strconv.Itoa(int(sub.Idx)), dep }
+        }
+        args = append(args, string(value))
+    }
+    value, err := valueType.Produce(ctx.Sub(sub.Idx), args...)
+    if err != nil {
+        byIdx := make(map[uint]data.Sub)
+        for _, other := range meta.Subs() {
+            byIdx[other.Idx] = other
+        }
+        return nil, ErrBadValues(subFailure(byIdx, sub, args[0], err))
+    }
+    return []byte(value), nil
+}
+
+// Get the value a substitution has when the form is left as it is: its input's
+// default, or its own if it has no input.
+func subDefault(meta *data.Meta, sub data.Sub) string {
+    for _, input := range meta.Inputs() {
+        if sub.Input != "" && input.Id == sub.Input {
+            return input.Default
+        }
+    }
+    return sub.Default
+}
+
+//
+// Errors
+//
+
+type ErrNoMigration struct { from string; to string }
+func (tt ErrNoMigration) Error() string {
+    return "no migration from version " + tt.from + " towards " + tt.to
+}
+type ErrMissingValue struct { where string; idx uint }
+func (tt ErrMissingValue) Error() string {
+    return tt.where + ": coin has no value for substitution " +
+            strconv.Itoa(int(tt.idx))
+}
+type ErrUnmigratedSub struct { idx uint }
+func (tt ErrUnmigratedSub) Error() string {
+    return "substitution " + strconv.Itoa(int(tt.idx)) +
+            " has no value after migrating; add it in a migration"
+}
+type ErrAddedKey struct { idx uint }
+func (tt ErrAddedKey) Error() string {
+    return "added substitution " + strconv.Itoa(int(tt.idx)) +
+            " would generate a key nobody receives; give it a default in " +
+            "the migration"
+}
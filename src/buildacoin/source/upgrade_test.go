@@ -0,0 +1,119 @@
+package source
+
+import (
+    "buildacoin/data"
+    "buildacoin/template"
+    "testing"
+)
+
+func TestUpgradeFilterMap(t *testing.T) {
+    meta := data.NewMeta("simple", "", "3", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "port", Default: "9333" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 1, Type: "literal" },
+            data.Sub { Idx: 2, Type: "literal" },
+            data.Sub { Idx: 5, Input: "port", Type: "uint16" },
+            data.Sub { Idx: 6, Type: "literal" },
+            data.Sub { Idx: 7, Type: "generated-pubkey" },
+        }).WithMigrations([]data.Migration {
+            data.Migration {
+                FromVersion: "2",
+                ToVersion: "3",
+                Added: []data.AddedSub {
+                    data.AddedSub { Idx: 6, Default: "six" },
+                    data.AddedSub { Idx: 7, Default: "04abcd" },
+                },
+            },
+            data.Migration {
+                FromVersion: "1",
+                ToVersion: "2",
+                // swap 1 and 2
+                Renamed: []data.RenamedSub {
+                    data.RenamedSub { From: 1, To: 2 },
+                    data.RenamedSub { From: 2, To: 1 },
+                },
+                Added: []data.AddedSub { data.AddedSub { Idx: 5 } },
+                Removed: []uint { 3 },
+            },
+        })
+    old := template.FilterMap {
+        1: []byte("one"),
+        2: []byte("two"),
+        3: []byte("three"),
+        4: []byte("four"),
+    }
+
+    upgraded, changes, err := UpgradeFilterMap(nil, meta, "1", old)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := map[uint]string {
+        1: "two", 2: "one", 5: "9333", 6: "six", 7: "04abcd" }
+    if len(upgraded) != len(expected) {
+        t.Fatalf("unexpected values %v\n", upgraded)
+    }
+    for idx, value := range expected {
+        if string(upgraded[idx]) != value {
+            t.Fatalf("substitution %d: expected '%s' / actual '%s'\n", idx,
+                    value, upgraded[idx])
+        }
+    }
+    // removed, two renames and an addition, two additions, then the value
+    // nothing claimed
+    if len(changes) != 7 || changes[0].String() != "1 -> 2: removed 3 " +
+            "('three')" || changes[6].String() != "dropped 4 ('four')" {
+        t.Fatalf("unexpected changes %v\n", changes)
+    }
+    if len(old) != 4 {
+        t.Fatal("old values were modified")
+    }
+
+    _, _, err = UpgradeFilterMap(nil, meta, "0", old)
+    if _, ok := err.(ErrNoMigration); !ok {
+        t.Fatalf("expected no migration, got %v\n", err)
+    }
+
+    // keys can't be generated for old coins
+    migrations := meta.Migrations()
+    migrations[0].Added = migrations[0].Added[:1]
+    migrations[0].Added = append(migrations[0].Added,
+            data.AddedSub { Idx: 7 })
+    _, _, err = UpgradeFilterMap(nil, meta.WithMigrations(migrations), "2",
+            template.FilterMap { 1: nil, 2: nil, 5: nil })
+    if _, ok := err.(ErrAddedKey); !ok {
+        t.Fatalf("expected added key, got %v\n", err)
+    }
+
+    // nor are values made up for substitutions no migration covers
+    migrations[0].Added = migrations[0].Added[:1]
+    _, _, err = UpgradeFilterMap(nil, meta.WithMigrations(migrations), "2",
+            template.FilterMap { 1: nil, 2: nil, 5: nil })
+    if _, ok := err.(ErrUnmigratedSub); !ok {
+        t.Fatalf("expected unmigrated sub, got %v\n", err)
+    }
+
+    // added values that fail their types are blamed on their inputs
+    meta = data.NewMeta("simple", "", "2", []string { "basics" },
+        []data.Input {
+            data.Input { Group: "basics", Id: "port", Default: "99999" },
+        },
+        []data.Sub {
+            data.Sub { Idx: 5, Input: "port", Type: "uint16" },
+        }).WithMigrations([]data.Migration {
+            data.Migration {
+                FromVersion: "1",
+                ToVersion: "2",
+                Added: []data.AddedSub { data.AddedSub { Idx: 5 } },
+            },
+        })
+    _, _, err = UpgradeFilterMap(nil, meta, "1", template.FilterMap {})
+    bad, ok := err.(ErrBadValues)
+    if !ok || len(bad) != 1 || bad[0].Input != "port" {
+        t.Fatalf("expected bad value of port, got %v\n", err)
+    }
+    if _, ok := bad[0].Cause.(ErrNotOfType); !ok {
+        t.Fatalf("expected value not of type, got %v\n", bad[0].Cause)
+    }
+}
@@ -0,0 +1,392 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "crypto/ed25519"
+    "io"
+    "io/ioutil"
+    "strings"
+    "time"
+)
+
+const (
+    // Archive kinds
+    TarArchive = "tar"
+    ZipArchive = "zip"
+
+    // Compressions of tar archives
+    Uncompressed = ""
+    GzipCompression = "gz"
+    Bzip2Compression = "bz2"
+    XzCompression = "xz"
+    ZstdCompression = "zst"
+
+    // Bytes read from the start of each file to decide whether it's binary
+    SniffLen = 8 * 1024
+)
+
+// An archive format a base's template is stored in or a coin is output as
+type Format struct {
+    Archive string
+    Compression string
+}
+
+// Parse an archive format from its file extension ("zip", "tar.gz").
+func ParseFormat(ext string) (Format, error) {
+    parts := strings.Split(strings.ToLower(ext), ".")
+    switch {
+    case parts[0] == ZipArchive && len(parts) == 1:
+        return Format { ZipArchive, Uncompressed }, nil
+    case parts[0] == TarArchive && len(parts) == 1:
+        return Format { TarArchive, Uncompressed }, nil
+    case parts[0] == TarArchive && len(parts) == 2:
+        switch parts[1] {
+        case GzipCompression, Bzip2Compression, XzCompression,
+                ZstdCompression:
+            return Format { TarArchive, parts[1] }, nil
+        }
+        return Format{}, ErrUnknCompress { parts[1] }
+    }
+    return Format{}, ErrUnknFormat { ext }
+}
+
+// Check whether archives can be written in the format.  The standard library
+// only reads bzip2.
+func (tt Format) Writable() bool {
+    return tt.Compression != Bzip2Compression
+}
+
+// Get the file extension of the format.
+func (tt Format) String() string {
+    if tt.Compression == Uncompressed {
+        return tt.Archive
+    }
+    return tt.Archive + "." + tt.Compression
+}
+
+// Get the runner filtering templates stored as archiveType into coins output
+// as outputType, or as archiveType if outputType is "".  Its archives are
+// deterministic; set SigningKey to sign their manifests.
+func GetRunner(archiveType, outputType string, markers Markers) (ArchiveRunner,
+        error) {
+    if outputType == "" {
+        outputType = archiveType
+    }
+    input, err := ParseFormat(archiveType)
+    if err != nil {
+        return ArchiveRunner{}, err
+    }
+    output, err := ParseFormat(outputType)
+    if err != nil {
+        return ArchiveRunner{}, err
+    }
+    if !output.Writable() {
+        return ArchiveRunner{}, ErrUnwritableFormat { outputType }
+    }
+    return ArchiveRunner { Input: input, Output: output, Markers: markers,
+            Deterministic: true }, nil
+}
+
+// The entries of an archive being read, described by tar headers whatever the
+// archive's format.  Reads are of the current entry's contents.
+type archiveReader interface {
+    Next() (*tar.Header, error)
+    Read([]byte) (int, error)
+}
+
+// The entries of an archive being written, described by tar headers whatever
+// the archive's format.  Writes are to the current entry's contents.  Closing
+// finishes the archive and any compression, but not the underlying writer.
+type archiveWriter interface {
+    WriteHeader(*tar.Header) error
+    Write([]byte) (int, error)
+    Close() error
+}
+
+// Runner filtering each file of an archive into another archive, ending with
+// a SHA256SUMS manifest of its files
+type ArchiveRunner struct {
+    Input Format
+    Output Format
+    Markers Markers
+    // Size above which files being filtered are held in temporary files
+    // rather than memory; 0 for DefaultSpillThreshold
+    SpillThreshold int64
+    // Whether the same template and values always give a byte-identical
+    // archive: entries are sorted by name, with fixed times, ownership and
+    // permissions, and compression headers carry no time or name
+    Deterministic bool
+    // Key signing the manifest, or nil to leave it unsigned
+    SigningKey ed25519.PrivateKey
+    // Files left out, renamed or added
+    Rules FileRules
+    // Substitutions whose values are hex bytes
+    HexValues map[uint]bool
+}
+
+func (tt ArchiveRunner) Run(dst io.Writer, src io.Reader,
+        values FilterMap) error {
+    err := tt.Markers.Check()
+    if err != nil {
+        return err
+    }
+    threshold := tt.SpillThreshold
+    if threshold == 0 {
+        threshold = DefaultSpillThreshold
+    }
+
+    // open archive streams
+    archiveIn, inCloser, err := openArchive(src, tt.Input, threshold)
+    if err != nil {
+        return err
+    }
+    defer inCloser.Close()
+    archiveOut, err := createArchive(dst, tt.Output, tt.Deterministic)
+    if err != nil {
+        return err
+    }
+    modTime := time.Now()
+    if tt.Deterministic {
+        modTime = DeterministicModTime
+    }
+    archiveOut = newManifestWriter(archiveOut, tt.SigningKey, modTime)
+    if tt.Deterministic {
+        archiveOut = newDeterministicWriter(archiveOut, threshold)
+    }
+
+    err = tt.filterEntries(archiveOut, archiveIn, values, threshold)
+    if err == nil {
+        err = tt.writeGenerated(archiveOut, values, modTime)
+    }
+    closeErr := archiveOut.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+func (tt ArchiveRunner) filterEntries(archiveOut archiveWriter,
+        archiveIn archiveReader, values FilterMap, threshold int64) error {
+    // initialize filter with a null reader; it will be reset for each stream
+    // in the archive
+    filter := NewMarkedFilter(nil, values, tt.Markers).Hex(tt.HexValues)
+
+    // a tar header gives its file's size before the file, so the raw and
+    // filtered text of a file are held in spills until it is known
+    raw, filtered := newSpill(threshold), newSpill(threshold)
+    defer func() {
+        raw.Close()
+        filtered.Close()
+    }()
+    lead := []byte(tt.Markers.orDefault().Lead)
+    sniff := make([]byte, SniffLen)
+
+    for {
+        // get next file from archive
+        header, err := archiveIn.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+
+        // filter header
+        templName := header.Name
+        excluded, err := tt.Rules.excluded(templName, values)
+        if err != nil {
+            return err
+        }
+        if excluded {
+            continue
+        }
+        header.Name, err = filterName(filter, tt.Rules.rename(header.Name))
+        if err != nil {
+            return err
+        }
+        header.Linkname, err = filterName(filter, header.Linkname)
+        if err != nil {
+            return err
+        }
+
+        if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+            err = archiveOut.WriteHeader(header)
+            if err != nil {
+                return err
+            }
+            continue
+        }
+        replacement, replaced, err := tt.Rules.replacement(templName, values)
+        if err != nil {
+            return err
+        }
+        if replaced {
+            header.Size = int64(len(replacement))
+            err = archiveOut.WriteHeader(header)
+            if err != nil {
+                return err
+            }
+            _, err = archiveOut.Write(replacement)
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        // binary files stream through untouched
+        count, err := io.ReadFull(archiveIn, sniff)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return ErrFilterFailure { templName, err }
+        }
+        start := sniff[:count]
+        if IsBinary(start) {
+            err = archiveOut.WriteHeader(header)
+            if err != nil {
+                return err
+            }
+            _, err = archiveOut.Write(start)
+            if err != nil {
+                return err
+            }
+            _, err = io.Copy(archiveOut, archiveIn)
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        // text files are read through, and filtered only if a marker turns
+        // up; the rest are written as they were read
+        err = raw.Reset()
+        if err != nil {
+            return err
+        }
+        detector := &seqDetector { seq: lead }
+        _, err = io.Copy(io.MultiWriter(raw, detector),
+                io.MultiReader(bytes.NewReader(start), archiveIn))
+        if err != nil {
+            return ErrFilterFailure { templName, err }
+        }
+        body := raw
+        if detector.seen {
+            err = filtered.Reset()
+            if err != nil {
+                return err
+            }
+            rawReader, err := raw.Reader()
+            if err != nil {
+                return err
+            }
+            // template errors already name the file and offset
+            _, err = io.Copy(filtered,
+                    filter.Reset(rawReader).Named(templName))
+            if _, ok := err.(*TemplateError); ok {
+                return err
+            }
+            if err != nil {
+                return ErrFilterFailure { templName, err }
+            }
+            body = filtered
+        }
+
+        header.Size = body.Size()
+        err = archiveOut.WriteHeader(header)
+        if err != nil {
+            return err
+        }
+        bodyReader, err := body.Reader()
+        if err != nil {
+            return err
+        }
+        _, err = io.Copy(archiveOut, bodyReader)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// Write the files the rules add after the template's own.
+func (tt ArchiveRunner) writeGenerated(archiveOut archiveWriter,
+        values FilterMap, modTime time.Time) error {
+    files, err := tt.Rules.generated(values, tt.Markers, tt.HexValues)
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        err = archiveOut.WriteHeader(&tar.Header { Name: file.name,
+                Mode: file.mode, Size: int64(len(file.body)),
+                Typeflag: file.typeflag, ModTime: modTime })
+        if err != nil {
+            return err
+        }
+        _, err = archiveOut.Write(file.body)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Open an archive for reading.  The closer releases anything held open for
+// the archive, but not src.
+func openArchive(src io.Reader, format Format,
+        threshold int64) (archiveReader, io.Closer, error) {
+    if format.Archive == ZipArchive {
+        return openZip(src, threshold)
+    }
+    return openTar(src, format.Compression)
+}
+
+// Create an archive for writing, compressing it deterministically if asked.
+func createArchive(dst io.Writer, format Format,
+        deterministic bool) (archiveWriter, error) {
+    if format.Archive == ZipArchive {
+        return createZip(dst), nil
+    }
+    return createTar(dst, format.Compression, deterministic)
+}
+
+// Check whether data from the start of a file looks binary.  Text files don't
+// contain NUL bytes.
+func IsBinary(start []byte) bool {
+    return bytes.IndexByte(start, 0) >= 0
+}
+
+// Filter the name of an entry, or its link's target.  Template errors already
+// name the text and offset.
+func filterName(filter *Filter, name string) (string, error) {
+    buf, err := ioutil.ReadAll(
+            filter.Reset(strings.NewReader(name)).Named(name))
+    if _, ok := err.(*TemplateError); ok || err == nil {
+        return string(buf), err
+    }
+    return "", ErrFilterFailure { name, err }
+}
+
+//
+// Errors
+//
+
+type ErrUnknFormat struct { Format string }
+func (tt ErrUnknFormat) Error() string {
+    return "unknown archive format '" + tt.Format + "'"
+}
+type ErrUnknCompress struct { Compression string }
+func (tt ErrUnknCompress) Error() string {
+    return "unknown compression format '" + tt.Compression + "'"
+}
+type ErrUnwritableFormat struct { Format string }
+func (tt ErrUnwritableFormat) Error() string {
+    return "archive format '" + tt.Format + "' can be read but not written"
+}
+type ErrUnsupportedEntry struct { Name string; Format string }
+func (tt ErrUnsupportedEntry) Error() string {
+    return "can't store '" + tt.Name + "' in a " + tt.Format + " archive"
+}
+type ErrFilterFailure struct { templName string; cause error }
+func (tt ErrFilterFailure) Error() string {
+    return "failed to filter '" + tt.templName + "': " + tt.cause.Error()
+}
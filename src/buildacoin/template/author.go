@@ -0,0 +1,166 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// A literal value in upstream source to be replaced by a marker
+type Literal struct {
+    Value string
+    Idx uint
+    // whether only occurrences that aren't part of a longer word are replaced
+    WholeWord bool
+}
+
+// A literal replaced by a marker while turning upstream source into a template
+type Replacement struct {
+    // path of the file in the upstream tree
+    File string
+    // line of the file the literal was on, or 0 if it was in the file's name
+    Line int
+    Literal string
+    Idx uint
+}
+
+// Replace the literals in upstream text with markers, returning the template
+// text and the replacements made, with their lines.  Where literals overlap
+// the longest is replaced.  Lead markers already in the text are escaped.
+func Templatize(text []byte, literals []Literal,
+        markers Markers) ([]byte, []Replacement) {
+    markers = markers.orDefault()
+    lead := []byte(markers.Lead)
+    // candidates by first byte, longest first
+    byFirst := make(map[byte][]Literal)
+    for _, literal := range literals {
+        if literal.Value != "" {
+            first := literal.Value[0]
+            byFirst[first] = append(byFirst[first], literal)
+        }
+    }
+    for _, candidates := range byFirst {
+        sort.Stable(byLength(candidates))
+    }
+
+    output := make([]byte, 0, len(text))
+    replacements := make([]Replacement, 0)
+    line := 1
+    ii := 0
+    scan:
+    for ii < len(text) {
+        if bytes.HasPrefix(text[ii:], lead) {
+            output = append(output, lead...)
+            output = append(output, EscapeDirective)
+            ii += len(lead)
+            continue
+        }
+        for _, literal := range byFirst[text[ii]] {
+            end := ii + len(literal.Value)
+            if !bytes.HasPrefix(text[ii:], []byte(literal.Value)) {
+                continue
+            }
+            if literal.WholeWord && ((ii > 0 && isWordByte(text[ii - 1])) ||
+                    (end < len(text) && isWordByte(text[end]))) {
+                continue
+            }
+            output = append(output, lead...)
+            output = strconv.AppendUint(output, uint64(literal.Idx), 10)
+            output = append(output, markers.Tail...)
+            replacements = append(replacements,
+                    Replacement { "", line, literal.Value, literal.Idx })
+            line += bytes.Count([]byte(literal.Value), []byte { '\n' })
+            ii = end
+            continue scan
+        }
+        if text[ii] == '\n' {
+            line++
+        }
+        output = append(output, text[ii])
+        ii++
+    }
+    return output, replacements
+}
+
+// Copy an upstream source tree to a new template directory, replacing the
+// literals in names, symlink targets and text files with markers.  Binary
+// files are copied as they are, since the runners won't filter them.
+func TemplatizeDir(src, dst string, literals []Literal,
+        markers Markers) ([]Replacement, error) {
+    replacements := make([]Replacement, 0)
+    note := func(file string, found []Replacement, inName bool) {
+        for _, replacement := range found {
+            replacement.File = file
+            if inName {
+                replacement.Line = 0
+            }
+            replacements = append(replacements, replacement)
+        }
+    }
+
+    err := os.MkdirAll(dst, 0755)
+    if err != nil {
+        return nil, err
+    }
+    err = walkTemplate(src, func(path, name string, header *tar.Header) error {
+        outName, found := Templatize([]byte(name), literals, markers)
+        note(name, found, true)
+        outPath := filepath.Join(dst, filepath.FromSlash(string(outName)))
+
+        switch header.Typeflag {
+        case tar.TypeDir:
+            return os.MkdirAll(outPath, 0755)
+        case tar.TypeSymlink:
+            target, found := Templatize([]byte(header.Linkname), literals,
+                    markers)
+            note(name, found, true)
+            return os.Symlink(string(target), outPath)
+        case tar.TypeReg:
+            break
+        default:
+            // devices and the like have no place in source trees
+            return ErrUnsupportedEntry { name, "template" }
+        }
+
+        text, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        start := text
+        if len(start) > SniffLen {
+            start = start[:SniffLen]
+        }
+        if !IsBinary(start) {
+            text, found = Templatize(text, literals, markers)
+            note(name, found, false)
+        }
+        return ioutil.WriteFile(outPath, text,
+                header.FileInfo().Mode().Perm())
+    })
+    if err != nil {
+        return nil, err
+    }
+    return replacements, nil
+}
+
+// Check whether a byte can be part of a word, as in identifiers and numbers.
+func isWordByte(char byte) bool {
+    return char == '_' || (char >= '0' && char <= '9') ||
+            (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+// sort.Interface ordering literals longest first
+type byLength []Literal
+func (tt byLength) Len() int {
+    return len(tt)
+}
+func (tt byLength) Less(ii, jj int) bool {
+    return len(tt[ii].Value) > len(tt[jj].Value)
+}
+func (tt byLength) Swap(ii, jj int) {
+    tt[ii], tt[jj] = tt[jj], tt[ii]
+}
@@ -0,0 +1,164 @@
+package template
+
+import (
+    "archive/tar"
+    "io"
+    "sort"
+    "time"
+)
+
+// Modification time of every entry of deterministic archives.  Zip can't
+// store times before 1980.
+var DeterministicModTime = time.Date(2000, time.January, 1, 0, 0, 0, 0,
+        time.UTC)
+
+const (
+    // Permissions of entries in deterministic archives
+    deterministicFileMode = 0644
+    deterministicExecMode = 0755
+    deterministicDirMode = 0755
+    deterministicLinkMode = 0777
+)
+
+// an entry held by a deterministicWriter until the archive is complete
+type heldEntry struct {
+    header *tar.Header
+    offset int64
+    // name the entry is sorted under: its own, or for a hard link the name
+    // of the file it links to, however many links away
+    sortName string
+    // how many links away the entry is from that file
+    depth int
+}
+
+// Archive writer normalizing entry headers and holding entries until it is
+// closed, when it writes them to the underlying archive sorted by name, hard
+// links just after their targets.
+// Bodies are kept in a spill, so only headers are held in memory.
+type deterministicWriter struct {
+    archive archiveWriter
+    entries []heldEntry
+    bodies *spill
+}
+
+// Wrap an archive writer to make its archive deterministic.
+func newDeterministicWriter(archive archiveWriter,
+        threshold int64) *deterministicWriter {
+    return &deterministicWriter {
+        archive: archive,
+        entries: make([]heldEntry, 0),
+        bodies: newSpill(threshold),
+    }
+}
+
+func (tt *deterministicWriter) WriteHeader(header *tar.Header) error {
+    tt.entries = append(tt.entries,
+            heldEntry { normalizeHeader(header), tt.bodies.Size(), "", 0 })
+    return nil
+}
+
+func (tt *deterministicWriter) Write(buf []byte) (int, error) {
+    return tt.bodies.Write(buf)
+}
+
+func (tt *deterministicWriter) Close() error {
+    defer tt.bodies.Close()
+    err := tt.flush()
+    closeErr := tt.archive.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+// Write the held entries to the underlying archive, sorted by name.  Readers
+// only resolve hard links to entries before them, so each follows its target.
+func (tt *deterministicWriter) flush() error {
+    // each body runs to the start of the next in the spill
+    links := make(map[string]string)
+    for ii := range tt.entries {
+        end := tt.bodies.Size()
+        if ii + 1 < len(tt.entries) {
+            end = tt.entries[ii + 1].offset
+        }
+        header := tt.entries[ii].header
+        header.Size = end - tt.entries[ii].offset
+        if header.Typeflag == tar.TypeLink {
+            links[header.Name] = header.Linkname
+        }
+    }
+    for ii := range tt.entries {
+        // links to links are sorted with the file at the end of the chain
+        name, depth := tt.entries[ii].header.Name, 0
+        for depth <= len(links) {
+            target, ok := links[name]
+            if !ok {
+                break
+            }
+            name = target
+            depth++
+        }
+        tt.entries[ii].sortName, tt.entries[ii].depth = name, depth
+    }
+    sort.Stable(byName(tt.entries))
+
+    bodies := tt.bodies.ReaderAt()
+    for _, entry := range tt.entries {
+        err := tt.archive.WriteHeader(entry.header)
+        if err != nil {
+            return err
+        }
+        _, err = io.Copy(tt.archive, io.NewSectionReader(bodies, entry.offset,
+                entry.header.Size))
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Get a copy of a header with everything but its name, type, size, link and
+// whether it is executable set to fixed values.
+func normalizeHeader(header *tar.Header) *tar.Header {
+    output := &tar.Header {
+        Name: header.Name,
+        Linkname: header.Linkname,
+        Typeflag: header.Typeflag,
+        Size: header.Size,
+        ModTime: DeterministicModTime,
+    }
+    switch header.Typeflag {
+    case tar.TypeReg, tar.TypeRegA:
+        output.Typeflag = tar.TypeReg
+        output.Mode = deterministicFileMode
+        if header.Mode & 0111 != 0 {
+            output.Mode = deterministicExecMode
+        }
+    case tar.TypeDir:
+        output.Mode = deterministicDirMode
+    case tar.TypeSymlink:
+        output.Mode = deterministicLinkMode
+    default:
+        output.Mode = deterministicFileMode
+    }
+    return output
+}
+
+// sort.Interface ordering held entries by name, hard links after the entries
+// they link to
+type byName []heldEntry
+func (tt byName) Len() int {
+    return len(tt)
+}
+func (tt byName) Less(ii, jj int) bool {
+    if tt[ii].sortName != tt[jj].sortName {
+        return tt[ii].sortName < tt[jj].sortName
+    }
+    if tt[ii].depth != tt[jj].depth {
+        return tt[ii].depth < tt[jj].depth
+    }
+    return tt[ii].header.Name < tt[jj].header.Name
+}
+func (tt byName) Swap(ii, jj int) {
+    tt[ii], tt[jj] = tt[jj], tt[ii]
+}
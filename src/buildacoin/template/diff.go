@@ -0,0 +1,488 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "io"
+    "io/ioutil"
+    "strconv"
+)
+
+const (
+    // Unchanged lines shown around each change in a unified diff
+    DiffContext = 3
+)
+
+// Write the differences between a template filtered with two sets of values
+// as a unified diff per file, reading the template archive once.  Files are
+// only filtered if they contain markers; the rest can't differ unless the
+// rules exclude or replace them for one set of values and not the other.
+// Files only one side has are diffed against /dev/null.  Encodings take the
+// values of the substitutions in hexValues as hex bytes.
+func DiffArchive(dst io.Writer, src io.Reader, format Format, markers Markers,
+        rules FileRules, hexValues map[uint]bool,
+        before, after FilterMap) error {
+    err := markers.Check()
+    if err != nil {
+        return err
+    }
+    archiveIn, inCloser, err := openArchive(src, format,
+            DefaultSpillThreshold)
+    if err != nil {
+        return err
+    }
+    defer inCloser.Close()
+
+    sides := []FilterMap { before, after }
+    filters := []*Filter {
+        NewMarkedFilter(nil, before, markers).Hex(hexValues),
+        NewMarkedFilter(nil, after, markers).Hex(hexValues),
+    }
+    raw := newSpill(DefaultSpillThreshold)
+    defer raw.Close()
+    lead := []byte(markers.orDefault().Lead)
+    sniff := make([]byte, SniffLen)
+
+    for {
+        header, err := archiveIn.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        templName := header.Name
+        switch header.Typeflag {
+        case tar.TypeReg, tar.TypeRegA, tar.TypeSymlink:
+            break
+        default:
+            continue
+        }
+
+        // each side's name for the file, or "" if it's excluded there
+        names := make([]string, 2)
+        for ii, values := range sides {
+            excluded, err := rules.excluded(templName, values)
+            if err != nil {
+                return err
+            }
+            if !excluded {
+                names[ii], err = filterName(filters[ii],
+                        rules.rename(templName))
+                if err != nil {
+                    return err
+                }
+            }
+        }
+        if names[0] == "" && names[1] == "" {
+            continue
+        }
+
+        if header.Typeflag == tar.TypeSymlink {
+            // symlinks are diffed as their targets, as git does
+            targets := make([][]byte, 2)
+            for ii, filter := range filters {
+                if names[ii] != "" {
+                    target, err := filterName(filter, header.Linkname)
+                    if err != nil {
+                        return err
+                    }
+                    targets[ii] = []byte(target)
+                }
+            }
+            err = UnifiedDiff(dst, names[0], names[1], targets[0], targets[1])
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        // the contents each side replaces the file's with, if any
+        replaced := make([][]byte, 2)
+        for ii, values := range sides {
+            if names[ii] == "" {
+                continue
+            }
+            body, ok, err := rules.replacement(templName, values)
+            if err != nil {
+                return err
+            }
+            if ok {
+                replaced[ii] = body
+            }
+        }
+        // files both sides have as the template does
+        plain := names[0] != "" && names[1] != "" &&
+                replaced[0] == nil && replaced[1] == nil
+
+        count, err := io.ReadFull(archiveIn, sniff)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return ErrFilterFailure { templName, err }
+        }
+        start := sniff[:count]
+        binary := IsBinary(start)
+        if binary && plain {
+            err = diffRename(dst, names[0], names[1])
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        err = raw.Reset()
+        if err != nil {
+            return err
+        }
+        detector := &seqDetector { seq: lead }
+        _, err = io.Copy(io.MultiWriter(raw, detector),
+                io.MultiReader(bytes.NewReader(start), archiveIn))
+        if err != nil {
+            return ErrFilterFailure { templName, err }
+        }
+        if !detector.seen && plain {
+            err = diffRename(dst, names[0], names[1])
+            if err != nil {
+                return err
+            }
+            continue
+        }
+
+        texts := make([][]byte, 2)
+        for ii, filter := range filters {
+            if names[ii] == "" {
+                continue
+            }
+            if replaced[ii] != nil {
+                texts[ii] = replaced[ii]
+                continue
+            }
+            rawReader, err := raw.Reader()
+            if err != nil {
+                return err
+            }
+            if binary || !detector.seen {
+                texts[ii], err = ioutil.ReadAll(rawReader)
+                if err != nil {
+                    return ErrFilterFailure { templName, err }
+                }
+                continue
+            }
+            // template errors already name the file and offset
+            texts[ii], err = ioutil.ReadAll(
+                    filter.Reset(rawReader).Named(templName))
+            if _, ok := err.(*TemplateError); ok {
+                return err
+            }
+            if err != nil {
+                return ErrFilterFailure { templName, err }
+            }
+        }
+        if binary || IsBinary(sniffed(texts[0])) ||
+                IsBinary(sniffed(texts[1])) {
+            err = diffBinary(dst, names[0], names[1], texts[0], texts[1])
+        } else {
+            err = UnifiedDiff(dst, names[0], names[1], texts[0], texts[1])
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    // files the rules add, paired by name
+    generated := make([][]recoverEntry, 2)
+    for ii, values := range sides {
+        generated[ii], err = rules.generated(values, markers, hexValues)
+        if err != nil {
+            return err
+        }
+    }
+    for _, entry := range generated[0] {
+        var body []byte
+        name := ""
+        for _, other := range generated[1] {
+            if other.name == entry.name {
+                name, body = other.name, other.body
+                break
+            }
+        }
+        err = UnifiedDiff(dst, entry.name, name, entry.body, body)
+        if err != nil {
+            return err
+        }
+    }
+    for _, entry := range generated[1] {
+        found := false
+        for _, other := range generated[0] {
+            found = found || other.name == entry.name
+        }
+        if !found {
+            err = UnifiedDiff(dst, "", entry.name, nil, entry.body)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Get the name of a side of a diff, or /dev/null for a file the side doesn't
+// have.
+func diffName(prefix, name string) string {
+    if name == "" {
+        return "/dev/null"
+    }
+    return prefix + name
+}
+
+// Note a binary file whose contents differ, or that only one side has.
+func diffBinary(dst io.Writer, nameBefore, nameAfter string,
+        before, after []byte) error {
+    if nameBefore != "" && nameAfter != "" && bytes.Equal(before, after) {
+        return diffRename(dst, nameBefore, nameAfter)
+    }
+    _, err := io.WriteString(dst, "Binary files " +
+            diffName("a/", nameBefore) + " and " +
+            diffName("b/", nameAfter) + " differ\n")
+    return err
+}
+
+// Note a file whose contents are the same either way but whose name isn't.
+func diffRename(dst io.Writer, nameBefore, nameAfter string) error {
+    if nameBefore == nameAfter {
+        return nil
+    }
+    _, err := io.WriteString(dst, "rename from " + nameBefore +
+            "\nrename to " + nameAfter + "\n")
+    return err
+}
+
+// Write a unified diff of two versions of a file, with DiffContext lines of
+// context.  Files that are the same are only noted if they were renamed.  A
+// side named "" doesn't have the file, and is shown as /dev/null.
+func UnifiedDiff(dst io.Writer, nameBefore, nameAfter string,
+        before, after []byte) error {
+    present := nameBefore != "" && nameAfter != ""
+    if present && bytes.Equal(before, after) {
+        return diffRename(dst, nameBefore, nameAfter)
+    }
+    ops := diffLines(splitLines(before), splitLines(after))
+
+    out := new(bytes.Buffer)
+    out.WriteString("--- " + diffName("a/", nameBefore) + "\n+++ " +
+            diffName("b/", nameAfter) + "\n")
+    // positions in ops of the changed lines
+    changes := make([]int, 0)
+    for ii, op := range ops {
+        if op.kind != ' ' {
+            changes = append(changes, ii)
+        }
+    }
+    // line numbers before and after at the start of ops[ii]
+    lineBefore, lineAfter := make([]int, len(ops) + 1),
+            make([]int, len(ops) + 1)
+    for ii, op := range ops {
+        lineBefore[ii + 1], lineAfter[ii + 1] = lineBefore[ii], lineAfter[ii]
+        if op.kind != '+' {
+            lineBefore[ii + 1]++
+        }
+        if op.kind != '-' {
+            lineAfter[ii + 1]++
+        }
+    }
+
+    for ii := 0; ii < len(changes); {
+        // changes close enough that their contexts touch share a hunk
+        jj := ii
+        for jj + 1 < len(changes) &&
+                changes[jj + 1] - changes[jj] <= 2 * DiffContext {
+            jj++
+        }
+        first := changes[ii] - DiffContext
+        if first < 0 {
+            first = 0
+        }
+        last := changes[jj] + DiffContext + 1
+        if last > len(ops) {
+            last = len(ops)
+        }
+        out.WriteString("@@ -" + hunkRange(lineBefore[first],
+                lineBefore[last] - lineBefore[first]) + " +" +
+                hunkRange(lineAfter[first],
+                lineAfter[last] - lineAfter[first]) + " @@\n")
+        for _, op := range ops[first:last] {
+            out.WriteByte(op.kind)
+            out.Write(op.line)
+            if !bytes.HasSuffix(op.line, []byte { '\n' }) {
+                out.WriteString("\n\\ No newline at end of file\n")
+            }
+        }
+        ii = jj + 1
+    }
+    _, err := dst.Write(out.Bytes())
+    return err
+}
+
+// Format the line range of one side of a hunk.  Empty ranges are given as
+// the line before them.
+func hunkRange(start, count int) string {
+    if count == 0 {
+        return strconv.Itoa(start) + ",0"
+    }
+    if count == 1 {
+        return strconv.Itoa(start + 1)
+    }
+    return strconv.Itoa(start + 1) + "," + strconv.Itoa(count)
+}
+
+// Split text into lines, keeping their line endings.
+func splitLines(text []byte) [][]byte {
+    output := make([][]byte, 0)
+    for len(text) > 0 {
+        end := bytes.IndexByte(text, '\n') + 1
+        if end == 0 {
+            end = len(text)
+        }
+        output = append(output, text[:end])
+        text = text[end:]
+    }
+    return output
+}
+
+// A line of a diff: ' ' for a line in both versions, '-' for one only before,
+// '+' for one only after, with its indices in the versions it's in
+type diffOp struct {
+    kind byte
+    line []byte
+    before int
+    after int
+}
+
+// Find the shortest edit turning one list of lines into another.
+func diffLines(before, after [][]byte) []diffOp {
+    ops := editScript(len(before), len(after), func(ii, jj int) bool {
+        return bytes.Equal(before[ii], after[jj])
+    })
+    for ii, op := range ops {
+        if op.kind == '+' {
+            ops[ii].line = after[op.after]
+        } else {
+            ops[ii].line = before[op.before]
+        }
+    }
+    return ops
+}
+
+// Find the shortest edit turning a list of nn lines into one of mm, by Myers'
+// algorithm, where equal tells whether the lines at two indices match.  Lines
+// common to the start and end are set aside first, since filtering tends to
+// change a few lines of long files.
+func editScript(nn, mm int, equal func(ii, jj int) bool) []diffOp {
+    head := 0
+    for head < nn && head < mm && equal(head, head) {
+        head++
+    }
+    tail := 0
+    for tail < nn - head && tail < mm - head &&
+            equal(nn - 1 - tail, mm - 1 - tail) {
+        tail++
+    }
+
+    output := make([]diffOp, 0, nn + mm)
+    for ii := 0; ii < head; ii++ {
+        output = append(output, diffOp { ' ', nil, ii, ii })
+    }
+    output = append(output, myers(nn - head - tail, mm - head - tail,
+            func(ii, jj int) bool {
+        return equal(head + ii, head + jj)
+    })...)
+    for ii := head; ii < len(output); ii++ {
+        if output[ii].before >= 0 {
+            output[ii].before += head
+        }
+        if output[ii].after >= 0 {
+            output[ii].after += head
+        }
+    }
+    for ii := 0; ii < tail; ii++ {
+        output = append(output, diffOp { ' ', nil, nn - tail + ii,
+                mm - tail + ii })
+    }
+    return output
+}
+
+func myers(nn, mm int, equal func(ii, jj int) bool) []diffOp {
+    offset := nn + mm + 1
+    // furthest x reached on each diagonal k = x - y, and a copy of the
+    // diagonals -d..d as they were before each step d
+    vv := make([]int, 2 * offset + 1)
+    trace := make([][]int, 0)
+
+    steps := 0
+    search:
+    for dd := 0; dd <= nn + mm; dd++ {
+        trace = append(trace,
+                append([]int(nil), vv[offset - dd:offset + dd + 1]...))
+        for kk := -dd; kk <= dd; kk += 2 {
+            var xx int
+            if kk == -dd || (kk != dd &&
+                    vv[offset + kk - 1] < vv[offset + kk + 1]) {
+                xx = vv[offset + kk + 1]
+            } else {
+                xx = vv[offset + kk - 1] + 1
+            }
+            yy := xx - kk
+            for xx < nn && yy < mm && equal(xx, yy) {
+                xx++
+                yy++
+            }
+            vv[offset + kk] = xx
+            if xx >= nn && yy >= mm {
+                steps = dd
+                break search
+            }
+        }
+    }
+
+    // walk back from the end, collecting the edit in reverse
+    reversed := make([]diffOp, 0, nn + mm)
+    xx, yy := nn, mm
+    for dd := steps; dd >= 0; dd-- {
+        saved := trace[dd]
+        at := func(kk int) int {
+            return saved[kk + dd]
+        }
+        kk := xx - yy
+        var prevK int
+        if kk == -dd || (kk != dd && at(kk - 1) < at(kk + 1)) {
+            prevK = kk + 1
+        } else {
+            prevK = kk - 1
+        }
+        prevX := 0
+        if dd > 0 {
+            prevX = at(prevK)
+        }
+        prevY := prevX - prevK
+        for xx > prevX && yy > prevY {
+            reversed = append(reversed,
+                    diffOp { ' ', nil, xx - 1, yy - 1 })
+            xx--
+            yy--
+        }
+        if dd == 0 {
+            break
+        }
+        if xx == prevX {
+            reversed = append(reversed, diffOp { '+', nil, -1, yy - 1 })
+        } else {
+            reversed = append(reversed, diffOp { '-', nil, xx - 1, -1 })
+        }
+        xx, yy = prevX, prevY
+    }
+
+    output := make([]diffOp, len(reversed))
+    for ii, op := range reversed {
+        output[len(reversed) - 1 - ii] = op
+    }
+    return output
+}
@@ -0,0 +1,115 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "regexp"
+    "testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+    before := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16"
+    after := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n"
+    expected := "--- a/f\n+++ b/f\n" +
+            "@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+            "@@ -13,4 +13,5 @@\n 13\n 14\n 15\n-16\n" +
+            "\\ No newline at end of file\n+16\n+17\n"
+    out := new(bytes.Buffer)
+    err := UnifiedDiff(out, "f", "f", []byte(before), []byte(after))
+    if err != nil || out.String() != expected {
+        t.Fatalf("expected:\n%s\nactual:\n%s\n", expected, out.String())
+    }
+
+    out.Reset()
+    err = UnifiedDiff(out, "f", "f", nil, []byte("new\n"))
+    if err != nil || out.String() != "--- a/f\n+++ b/f\n@@ -0,0 +1 @@\n+new\n" {
+        t.Fatalf("unexpected diff of empty file:\n%s\n", out.String())
+    }
+    out.Reset()
+    err = UnifiedDiff(out, "f", "f", []byte(before), []byte(before))
+    if err != nil || out.Len() != 0 {
+        t.Fatalf("unexpected diff of same file:\n%s\n", out.String())
+    }
+}
+
+func TestDiffArchive(t *testing.T) {
+    archive := testTar([][]string {
+        []string { "__._1-/main.h", "#define NAME __._1-\nint x;\n" },
+        []string { "README", "no markers here\n" },
+        []string { "src/net.h", "#define PORT __._2-\n" },
+    })
+    before := FilterMap { 1: []byte("Bestcoin"), 2: []byte("9333") }
+    after := FilterMap { 1: []byte("Bettercoin"), 2: []byte("9333") }
+    out := new(bytes.Buffer)
+    err := DiffArchive(out, archive, Format { TarArchive, Uncompressed },
+            DefaultMarkers, FileRules {}, nil, before, after)
+    expected := "--- a/Bestcoin/main.h\n+++ b/Bettercoin/main.h\n" +
+            "@@ -1,2 +1,2 @@\n-#define NAME Bestcoin\n" +
+            "+#define NAME Bettercoin\n int x;\n"
+    if err != nil || out.String() != expected {
+        t.Fatalf("expected:\n%s\nactual:\n%s\n%v\n", expected, out.String(),
+                err)
+    }
+
+    // names that can't be filtered are errors, not excluded files
+    for _, header := range []*tar.Header {
+        &tar.Header { Name: "__._1x/main.h", Typeflag: tar.TypeReg },
+        &tar.Header { Name: "link", Linkname: "__._1x",
+                Typeflag: tar.TypeSymlink },
+    } {
+        archive := new(bytes.Buffer)
+        tarOut := tar.NewWriter(archive)
+        tarOut.WriteHeader(header)
+        tarOut.Close()
+        err = DiffArchive(new(bytes.Buffer), archive,
+                Format { TarArchive, Uncompressed }, DefaultMarkers,
+                FileRules {}, nil, before, after)
+        if _, ok := err.(*TemplateError); !ok {
+            t.Fatalf("%s: expected template error, got %v\n", header.Name,
+                    err)
+        }
+    }
+}
+
+func TestDiffArchiveRules(t *testing.T) {
+    archive := testTar([][]string {
+        []string { "coin/qt/main.cpp", "int main();\n" },
+        []string { "coin/bitcoin.pro", "TARGET = __._1-\n" },
+        []string { "coin/icon.png", "\x89PNG\x00old" },
+    })
+    rules := FileRules {
+        Exclude: []ExcludeRule {
+            ExcludeRule { regexp.MustCompile("^coin/qt/"), []uint { 2 } },
+        },
+        Rename: []RenameRule {
+            RenameRule { regexp.MustCompile("/bitcoin\\.pro$"),
+                    "/__._1-.pro" },
+        },
+        Generate: []GeneratedFile {
+            GeneratedFile { "coin/PARAMETERS", "name: __._1-\n",
+                    []uint { 2 } },
+        },
+        Replace: []ReplacedFile {
+            ReplacedFile { "coin/icon.png", 3,
+                    func(value []byte) ([]byte, error) {
+                return append([]byte("\x89PNG\x00"), value...), nil
+            } },
+        },
+    }
+    before := FilterMap { 1: []byte("best"), 2: []byte("false"),
+            3: []byte("") }
+    after := FilterMap { 1: []byte("best"), 2: []byte("true"),
+            3: []byte("new") }
+    out := new(bytes.Buffer)
+    err := DiffArchive(out, archive, Format { TarArchive, Uncompressed },
+            DefaultMarkers, rules, nil, before, after)
+    expected := "--- /dev/null\n+++ b/coin/qt/main.cpp\n" +
+            "@@ -0,0 +1 @@\n+int main();\n" +
+            "Binary files a/coin/icon.png and b/coin/icon.png differ\n" +
+            "--- /dev/null\n+++ b/coin/PARAMETERS\n" +
+            "@@ -0,0 +1 @@\n+name: best\n"
+    if err != nil || out.String() != expected {
+        t.Fatalf("expected:\n%s\nactual:\n%s\n%v\n", expected, out.String(),
+                err)
+    }
+}
@@ -0,0 +1,177 @@
+package template
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+// Output encodings, named after the index of a marker with EncodingSeparator
+// ("__._12.hex-").  Encodings of numbers take substitution values that parse
+// as unsigned integers (decimal, or hex with "0x"); encodings of bytes take
+// any other value as hex, with or without "0x".  Values of substitutions whose
+// types produce hex bytes are always taken as hex, even when they're all
+// digits.
+
+const (
+    // Separator between a marker's index and its encoding
+    EncodingSeparator = '.'
+    // Maximum length of an encoding name
+    MaxEncodingLen = 16
+)
+
+var (
+    // Error when a value can't be read as hex bytes
+    ErrNotHex error = errors.New("value is not hex")
+)
+
+// A transformation of a substitution value applied as it is output.  isHex
+// is whether the value is hex bytes whatever it looks like.
+type Encoding func(value []byte, isHex bool) ([]byte, error)
+
+// Mapping from the names of encodings to the encodings themselves
+var Encodings = map[string]Encoding {
+    // hex digits of a number, or of the bytes of a hex value
+    "hex": encodeHex,
+    // hex digits as "hex" does, with a "0x" prefix
+    "0x": encode0x,
+    // C byte array initializer ("0xfb, 0xc0"): the bytes of a hex value in
+    // order, or of a number in little-endian order, in the fewest of 1, 2, 4
+    // or 8 bytes that hold it
+    "bytes": encodeBytes,
+    // a hex value with its byte order reversed, to convert hashes between
+    // internal and display order
+    "rhash": encodeReversedHash,
+    // double-quoted C string literal
+    "cstr": encodeCString,
+    // single-quoted shell word
+    "shell": encodeShell,
+    // JSON string
+    "json": encodeJSON,
+}
+
+// Check whether a character may be part of an encoding name.
+func isEncodingChar(char byte) bool {
+    return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9')
+}
+
+// Read a value as a number, unless it's hex bytes.
+func valueNumber(value []byte, isHex bool) (uint64, bool) {
+    if isHex {
+        return 0, false
+    }
+    number, err := strconv.ParseUint(string(value), 0, 64)
+    return number, err == nil
+}
+
+// Read a value as hex bytes.
+func valueHex(value []byte) ([]byte, error) {
+    bytes, err := hex.DecodeString(strings.TrimPrefix(string(value), "0x"))
+    if err != nil {
+        return nil, ErrNotHex
+    }
+    return bytes, nil
+}
+
+// Get the bytes of a number or hex value, numbers in little-endian order.
+func valueBytes(value []byte, isHex bool) ([]byte, error) {
+    number, ok := valueNumber(value, isHex)
+    if !ok {
+        return valueHex(value)
+    }
+    buf := make([]byte, 8)
+    binary.LittleEndian.PutUint64(buf, number)
+    switch {
+    case number <= 0xff:
+        return buf[:1], nil
+    case number <= 0xffff:
+        return buf[:2], nil
+    case number <= 0xffffffff:
+        return buf[:4], nil
+    }
+    return buf, nil
+}
+
+func encodeHex(value []byte, isHex bool) ([]byte, error) {
+    if number, ok := valueNumber(value, isHex); ok {
+        return []byte(strconv.FormatUint(number, 16)), nil
+    }
+    bytes, err := valueHex(value)
+    if err != nil {
+        return nil, err
+    }
+    return []byte(hex.EncodeToString(bytes)), nil
+}
+
+func encode0x(value []byte, isHex bool) ([]byte, error) {
+    output, err := encodeHex(value, isHex)
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte("0x"), output...), nil
+}
+
+func encodeBytes(value []byte, isHex bool) ([]byte, error) {
+    bytes, err := valueBytes(value, isHex)
+    if err != nil {
+        return nil, err
+    }
+    output := make([]byte, 0, len(bytes) * 6)
+    for ii, byte_ := range bytes {
+        if ii > 0 {
+            output = append(output, ", "...)
+        }
+        output = append(output, "0x"...)
+        output = append(output, hex.EncodeToString([]byte { byte_ })...)
+    }
+    return output, nil
+}
+
+func encodeReversedHash(value []byte, isHex bool) ([]byte, error) {
+    bytes, err := valueHex(value)
+    if err != nil {
+        return nil, err
+    }
+    for ii, jj := 0, len(bytes) - 1; ii < jj; ii, jj = ii + 1, jj - 1 {
+        bytes[ii], bytes[jj] = bytes[jj], bytes[ii]
+    }
+    return []byte(hex.EncodeToString(bytes)), nil
+}
+
+func encodeCString(value []byte, isHex bool) ([]byte, error) {
+    output := []byte { '"' }
+    for _, char := range value {
+        switch char {
+        case '"', '\\':
+            output = append(output, '\\', char)
+        case '\n':
+            output = append(output, '\\', 'n')
+        case '\t':
+            output = append(output, '\\', 't')
+        case '\r':
+            output = append(output, '\\', 'r')
+        default:
+            if char < 0x20 || char > 0x7e {
+                // octal escapes stop after three digits, where hex escapes
+                // would run on into following hex-like characters
+                output = append(output, '\\', '0' + (char >> 6),
+                        '0' + ((char >> 3) & 7), '0' + (char & 7))
+            } else {
+                output = append(output, char)
+            }
+        }
+    }
+    return append(output, '"'), nil
+}
+
+func encodeShell(value []byte, isHex bool) ([]byte, error) {
+    return []byte("'" + strings.Replace(string(value), "'", "'\\''", -1) +
+            "'"), nil
+}
+
+func encodeJSON(value []byte, isHex bool) ([]byte, error) {
+    return json.Marshal(string(value))
+}
@@ -2,7 +2,9 @@ package template
 
 import (
     "bytes"
+    "errors"
     "io"
+    "io/ioutil"
     "strconv"
 )
 
@@ -12,17 +14,78 @@ const (
     // Maximum number of digits representing index a substitution marker can
     // contain
     MaxMarkDigits = 8
-    // Magic string indicating the beginning of a substitution marker
+    // Magic string indicating the beginning of a substitution marker, unless
+    // a base declares its own
     LeadMarker = "__._"
-    // Magic string indicating the end of a substitution marker
+    // Magic string indicating the end of a substitution marker, unless a base
+    // declares its own
     TailMarker = "-"
+    // Maximum length of the template text of a repeated section
+    MaxRepeatLen = 1024 * 1024
+    // Separator of the items of a list substitution value
+    ListSeparator = "\n"
 )
+
+// Section directives, placed between the lead marker and the index of a
+// marker.  A marker without one is replaced by its substitution value.
+const (
+    // Open a section output only if the boolean substitution is true
+    IfDirective = '?'
+    // Open a section output only if the boolean substitution is false
+    UnlessDirective = '!'
+    // Switch the innermost conditional section, which must be on the same
+    // substitution, between output and omission
+    ElseDirective = ':'
+    // Open a section output once per item of the list substitution, with the
+    // substitution taking the value of the item within the section
+    RepeatDirective = '*'
+    // Close the innermost section, which must be on the same substitution
+    EndDirective = '/'
+    // Output the lead marker itself.  Takes no index or tail marker, so a
+    // literal "__._1-" in a template is written "__._\1-".
+    EscapeDirective = '\\'
+)
+
 var (
-    leadMarker []byte = []byte(LeadMarker)
-    tailMarker []byte = []byte(TailMarker)
-    maxMarkLen uint = uint(len(leadMarker) + MaxMarkDigits + len(tailMarker))
+    // Error when a base's markers can't be told apart from marker contents
+    ErrBadMarkers error = errors.New("tail marker must not start with a " +
+            "digit, lowercase letter, directive or encoding separator")
 )
 
+// The strings delimiting substitution markers in a template
+type Markers struct {
+    Lead string
+    Tail string
+}
+
+// The markers of templates that don't declare their own
+var DefaultMarkers = Markers { LeadMarker, TailMarker }
+
+// Check that markers can be parsed unambiguously.
+func (tt Markers) Check() error {
+    tt = tt.orDefault()
+    switch tt.Tail[0] {
+    case IfDirective, UnlessDirective, ElseDirective, RepeatDirective,
+            EndDirective, EscapeDirective, EncodingSeparator:
+        return ErrBadMarkers
+    }
+    if isEncodingChar(tt.Tail[0]) {
+        return ErrBadMarkers
+    }
+    return nil
+}
+
+// Get the markers with the default in place of each empty one.
+func (tt Markers) orDefault() Markers {
+    if tt.Lead == "" {
+        tt.Lead = LeadMarker
+    }
+    if tt.Tail == "" {
+        tt.Tail = TailMarker
+    }
+    return tt
+}
+
 // filter state machine info
 const (
     // Filter state when skipping over non-mark data
@@ -33,13 +96,44 @@ const (
     S_COLLECTING_DIGITS
     // Filter state when attempting to match a tail marker
     S_MATCHING_TAIL
+    // Filter state when reading in the name of an output encoding
+    S_COLLECTING_ENCODING
 )
 type filterState struct {
     state uint
     inOverflow []byte
+    // stream offset of the first byte of inOverflow
+    overflowOffset uint64
     outOverflow []byte
     matchOffset int
+    // stream offset of the marker being read
+    markOffset uint64
     digitBuf []byte
+    // output encoding name of the marker being read
+    encoding []byte
+    // directive of the marker being read, 0 for none
+    directive byte
+    // open sections, innermost last
+    sections []section
+    // whether output is omitted by a section
+    omit bool
+    // whether the text of a repeated section is being collected into body
+    capturing bool
+    captureIdx uint
+    // depth of sections opened within the repeated section being collected
+    captureDepth int
+    body []byte
+}
+// an open conditional or omitted repeated section
+type section struct {
+    directive byte
+    idx uint
+    // whether the section's own contents are output
+    include bool
+    // whether output was omitted outside the section
+    parentOmit bool
+    // whether an else marker was seen
+    switched bool
 }
 
 // Mapping of substitution marker indices to the bytes that will replace them
@@ -51,25 +145,56 @@ type Filter struct {
     substitutions FilterMap
     input io.Reader
     buf []byte
-    bytesProcessed uint64
+    bytesRead uint64
+    lead []byte
+    tail []byte
+    // name of the template file being filtered, for errors
+    name string
+    // if not nil, markers are noted here by index rather than substituted
+    indices map[uint]bool
+    // substitutions whose values encodings take as hex bytes
+    hexValues map[uint]bool
 }
 
 // Construct a new filter that replaces markers in the input stream with values
 // in the substitutions map.
 func NewFilter(input io.Reader, substitutions FilterMap) *Filter {
+    return NewMarkedFilter(input, substitutions, DefaultMarkers)
+}
+// Construct a new filter for a template with its own markers.  Empty markers
+// are the defaults.
+func NewMarkedFilter(input io.Reader, substitutions FilterMap,
+        markers Markers) *Filter {
+    markers = markers.orDefault()
     return &Filter{filterState{}, substitutions, input, make([]byte,
-            InitBufSize), 0}
+            InitBufSize), 0, []byte(markers.Lead), []byte(markers.Tail), "",
+            nil, nil}
 }
 // Construct a new filter with an explicit buffer size for performance testing.
 func newFilterDebug(input io.Reader, subs FilterMap,
         initBufSize uint) *Filter {
-    return &Filter{filterState{}, subs, input, make([]byte, initBufSize), 0}
+    return &Filter{filterState{}, subs, input, make([]byte, initBufSize), 0,
+            []byte(LeadMarker), []byte(TailMarker), "", nil, nil}
 }
 
 func (tt *Filter) Reset(input io.Reader) *Filter {
     tt.lastState = filterState{}
-    tt.bytesProcessed = 0
+    tt.bytesRead = 0
     tt.input = input
+    tt.name = ""
+    return tt
+}
+
+// Name the template being filtered in errors.
+func (tt *Filter) Named(name string) *Filter {
+    tt.name = name
+    return tt
+}
+
+// Have encodings take the values of the substitutions in hexValues as hex
+// bytes, even when they're all digits.
+func (tt *Filter) Hex(hexValues map[uint]bool) *Filter {
+    tt.hexValues = hexValues
     return tt
 }
 
@@ -80,18 +205,36 @@ type BadStateError struct {
     index uint64
 }
 func (e *BadStateError) Error() string {
-    return "bad filter state @" + string(e.index) + " " + e.stateName + ": " +
-            e.reason
+    return "bad filter state @" + strconv.FormatUint(e.index, 10) + " " +
+            e.stateName + ": " + e.reason
 }
 // Error when an issue with the template stream prevents the filter from
 // producing output
 type TemplateError struct {
     reason string
     index uint64
+    file string
 }
 func (e *TemplateError) Error() string {
-    return "templating interrupted @" + strconv.FormatUint(e.index, 10) +
-            ": " + e.reason
+    where := "@"
+    if e.file != "" {
+        where = "in '" + e.file + "' @"
+    }
+    return "templating interrupted " + where +
+            strconv.FormatUint(e.index, 10) + ": " + e.reason
+}
+// Get the name of the template file the error is in, if known.
+func (e *TemplateError) File() string {
+    return e.file
+}
+// Get the offset in the template file of the error.
+func (e *TemplateError) Offset() uint64 {
+    return e.index
+}
+
+// Construct an error at offset in the template being filtered.
+func (f *Filter) templateError(reason string, index uint64) *TemplateError {
+    return &TemplateError{reason, index, f.name}
 }
 
 func (f *Filter) Read(out []byte) (int, error) {
@@ -110,10 +253,11 @@ func (f *Filter) Read(out []byte) (int, error) {
     var in []byte
     var readErr error = nil
     inCount, outCount, inIdx, outIdx := 0, len(out), 0, 0
+    // stream offset of in[0]
+    var inOffset uint64
 
     // clean up on exit
     defer func() {
-        f.bytesProcessed += uint64(inIdx)
         f.lastState = s
     }()
 
@@ -136,11 +280,46 @@ func (f *Filter) Read(out []byte) (int, error) {
         if len(s.inOverflow) > 0 {
             in = s.inOverflow
             inCount = len(s.inOverflow)
+            inOffset = s.overflowOffset
             s.inOverflow = make([]byte, 0)
         } else {
             in = f.buf
             inCount, readErr = f.input.Read(in)
+            inOffset = f.bytesRead
+            if inCount > 0 {
+                f.bytesRead += uint64(inCount)
+            }
             if inCount < 1 {
+                if readErr == io.EOF {
+                    switch s.state {
+                    // a partial lead marker at the end is plain text
+                    case S_MATCHING_LEAD:
+                        s.state = S_SEEK
+                        partial := f.lead[:s.matchOffset]
+                        if s.capturing {
+                            s.body = append(s.body, partial...)
+                        } else if !s.omit {
+                            count := copy(out[outIdx:outCount], partial)
+                            s.outOverflow = partial[count:]
+                            outIdx += count
+                        }
+                    // but a marker that was started has to be finished
+                    case S_COLLECTING_DIGITS, S_COLLECTING_ENCODING,
+                            S_MATCHING_TAIL:
+                        s.state = S_SEEK
+                        return outIdx, f.templateError("unfinished marker",
+                                s.markOffset)
+                    }
+                    // the end comes once any overflow has been read
+                    if len(s.outOverflow) > 0 {
+                        return outIdx, nil
+                    }
+                }
+                if readErr == io.EOF &&
+                        (len(s.sections) > 0 || s.capturing) {
+                    return outIdx, f.templateError("unterminated section",
+                            f.bytesRead)
+                }
                 return outIdx, readErr
             }
         }
@@ -151,12 +330,27 @@ func (f *Filter) Read(out []byte) (int, error) {
             // the seek state copies bytes from in to out unless the beginning
             // of a lead marker is encountered
             case S_SEEK:
-                if in[inIdx] == leadMarker[0] {
+                if in[inIdx] == f.lead[0] {
                     s.state = S_MATCHING_LEAD
                     s.matchOffset = 0
+                    s.markOffset = inOffset + uint64(inIdx)
                     inIdx--
                     break
                 }
+                // bytes of a repeated section are kept for its expansion,
+                // and bytes of an omitted section are dropped
+                if s.capturing {
+                    s.body = append(s.body, in[inIdx])
+                    if len(s.body) > MaxRepeatLen {
+                        return outIdx, f.templateError(
+                                "repeated section too long",
+                                inOffset + uint64(inIdx))
+                    }
+                    break
+                }
+                if s.omit {
+                    break
+                }
                 out[outIdx] = in[inIdx]
                 outIdx++
                 // if this seek write fills the output buffer, save the rest of
@@ -164,6 +358,7 @@ func (f *Filter) Read(out []byte) (int, error) {
                 if outIdx >= outCount {
                     if inIdx < inCount {
                         s.inOverflow = in[inIdx+1:inCount]
+                        s.overflowOffset = inOffset + uint64(inIdx + 1)
                     }
                     return outCount, readErr
                 }
@@ -171,38 +366,45 @@ func (f *Filter) Read(out []byte) (int, error) {
             // or dumps the partial match to out if not
             case S_MATCHING_LEAD:
                 // check for overshoot.
-                if s.matchOffset >= len(leadMarker) {
+                if s.matchOffset >= len(f.lead) {
                     s.state = S_SEEK
                     return outIdx, &BadStateError{"MATCHING_LEAD",
                             "impossible match offset (too big)",
-                            f.bytesProcessed + uint64(inIdx)}
+                            inOffset + uint64(inIdx)}
                 }
                 // is there a break in the match?  Prepend the partial match to
                 // input buffer.
-                if in[inIdx] != leadMarker[s.matchOffset] {
+                if in[inIdx] != f.lead[s.matchOffset] {
                     s.state = S_SEEK
-                    //inIdx -= s.matchOffset
-                    out[outIdx] = leadMarker[0]
-                    outIdx++
-                    if len (leadMarker) > 1 {
+                    if s.capturing {
+                        s.body = append(s.body, f.lead[0])
+                    } else if !s.omit {
+                        out[outIdx] = f.lead[0]
+                        outIdx++
+                    }
+                    if len(f.lead) > 1 {
                         in = bytes.Join([][]byte {
-                            []byte(leadMarker[1:s.matchOffset]),
+                            f.lead[1:s.matchOffset],
                             in[inIdx:inCount],
                             }, nil)
                         inIdx = 0
                         inCount = len(in)
+                        // the partial match resumes right after its first
+                        // byte
+                        inOffset = s.markOffset + 1
                     }
                     inIdx--
                     s.matchOffset = -1
                     if outIdx >= outCount {
                         if inIdx < inCount {
                             s.inOverflow = in[inIdx+1:inCount]
+                            s.overflowOffset = inOffset + uint64(inIdx + 1)
                         }
                         return outCount, readErr
                     }
                 } else {
                     s.matchOffset++
-                    if s.matchOffset == len(leadMarker) {
+                    if s.matchOffset == len(f.lead) {
                         s.state = S_COLLECTING_DIGITS
                     }
                 }
@@ -213,40 +415,104 @@ func (f *Filter) Read(out []byte) (int, error) {
                 case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
                     if len(s.digitBuf) >= MaxMarkDigits {
                         s.state = S_SEEK
-                        return outIdx, &TemplateError{
+                        return outIdx, f.templateError(
                                 "too many digits in map specifier",
-                                f.bytesProcessed + uint64(inIdx)}
+                                s.markOffset)
                     }
                     s.digitBuf = append(s.digitBuf, in[inIdx])
-                case tailMarker[0]:
+                case IfDirective, UnlessDirective, ElseDirective,
+                        RepeatDirective, EndDirective, EscapeDirective:
+                    // a directive may only lead the index digits
+                    if len(s.digitBuf) > 0 || s.directive != 0 {
+                        s.state = S_SEEK
+                        return outIdx, f.templateError("misplaced " +
+                                    "directive '" + string(in[inIdx]) +
+                                    "' in template substitution specifier",
+                                s.markOffset)
+                    }
+                    if in[inIdx] != EscapeDirective {
+                        s.directive = in[inIdx]
+                        break
+                    }
+                    // an escaped lead marker is output as is
+                    s.state = S_SEEK
+                    if s.capturing {
+                        s.body = append(s.body, f.lead...)
+                        s.body = append(s.body, EscapeDirective)
+                        break
+                    }
+                    if s.omit {
+                        break
+                    }
+                    count := copy(out[outIdx:outCount], f.lead)
+                    if count < len(f.lead) {
+                        s.outOverflow = f.lead[count:]
+                    }
+                    outIdx += count
+                    if outIdx >= outCount {
+                        if inIdx < inCount {
+                            s.inOverflow = in[inIdx+1:inCount]
+                            s.overflowOffset = inOffset + uint64(inIdx + 1)
+                        }
+                        return outCount, readErr
+                    }
+                case EncodingSeparator:
+                    if len(s.digitBuf) < 1 {
+                        s.state = S_SEEK
+                        return outIdx, f.templateError(
+                                "encoding without substitution specifier",
+                                s.markOffset)
+                    }
+                    s.state = S_COLLECTING_ENCODING
+                case f.tail[0]:
                     s.state = S_MATCHING_TAIL
                     s.matchOffset = 0
                     inIdx--
                 default:
                     s.state = S_SEEK
-                    return outIdx, &TemplateError{"illegal character '" +
-                                string(in[inIdx]) +
-                                "' in template substitution specifier",
-                            f.bytesProcessed + uint64(inIdx)}
+                    return outIdx, f.templateError("ambiguous marker: " +
+                                "illegal character '" + string(in[inIdx]) +
+                                "' in template substitution specifier " +
+                                "(escape a literal lead marker with '" +
+                                string(f.lead) + string(EscapeDirective) +
+                                "')",
+                            s.markOffset)
                 }
+            // the collecting encoding state reads in the name of the output
+            // encoding following the index
+            case S_COLLECTING_ENCODING:
+                if in[inIdx] == f.tail[0] {
+                    s.state = S_MATCHING_TAIL
+                    s.matchOffset = 0
+                    inIdx--
+                    break
+                }
+                if !isEncodingChar(in[inIdx]) ||
+                        len(s.encoding) >= MaxEncodingLen {
+                    s.state = S_SEEK
+                    return outIdx, f.templateError("ambiguous marker: " +
+                            "illegal encoding in template substitution " +
+                            "specifier", s.markOffset)
+                }
+                s.encoding = append(s.encoding, in[inIdx])
             case S_MATCHING_TAIL:
                 // check for overshoot.
-                if s.matchOffset >= len(tailMarker) {
+                if s.matchOffset >= len(f.tail) {
                     s.state = S_SEEK
                     return outIdx, &BadStateError{"MATCHING_TAIL",
                             "impossible match offset (too big)",
-                            f.bytesProcessed + uint64(inIdx)}
+                            inOffset + uint64(inIdx)}
                 }
                 // is there a break in the match? this time it's fatal
-                if in[inIdx] != tailMarker[s.matchOffset] {
+                if in[inIdx] != f.tail[s.matchOffset] {
                     s.state = S_SEEK
-                    return outIdx, &TemplateError{"malformed tail marker",
-                            f.bytesProcessed + uint64(inIdx)}
+                    return outIdx, f.templateError("ambiguous marker: " +
+                            "malformed tail marker", s.markOffset)
                 }
                 s.matchOffset++
                 // once the whole tail marker is matched it's time to output
                 // the substituted value
-                if s.matchOffset >= len(tailMarker) {
+                if s.matchOffset >= len(f.tail) {
                     s.state = S_SEEK
                     // condense the collected digit bytes into an unsigned
                     // integer
@@ -254,17 +520,13 @@ func (f *Filter) Read(out []byte) (int, error) {
                     sub_id := uint(sub_int)
                     s.digitBuf = s.digitBuf[:0]
                     if err != nil {
-                        return outIdx, &TemplateError{
+                        return outIdx, f.templateError(
                                 "failed to determine substitution specifier: " +
-                                err.Error(),
-                                f.bytesProcessed + uint64(inIdx)}
+                                err.Error(), s.markOffset)
                     }
-                    subValue, ok := f.substitutions[sub_id]
-                    if !ok {
-                        return outIdx, &TemplateError{
-                                "unknown substitution specifier " +
-                                    strconv.Itoa(int(sub_id)),
-                                f.bytesProcessed + uint64(inIdx)}
+                    subValue, err := f.marker(&s, sub_id, s.markOffset)
+                    if err != nil {
+                        return outIdx, err
                     }
                     outSlice := out[outIdx:outCount]
                     count := copy(outSlice, subValue)
@@ -275,6 +537,7 @@ func (f *Filter) Read(out []byte) (int, error) {
                     if outIdx >= outCount {
                         if inIdx < inCount {
                             s.inOverflow = in[inIdx+1:inCount]
+                            s.overflowOffset = inOffset + uint64(inIdx + 1)
                         }
                         return outCount, readErr
                     }
@@ -282,11 +545,235 @@ func (f *Filter) Read(out []byte) (int, error) {
             }
         }
     }
-    panic("template filter loop exited; this should never happen")
 }
 
-type FilterRunner struct {}
+// Handle a complete marker with index subId, returning the bytes that replace
+// it in the output.
+func (f *Filter) marker(s *filterState, subId uint, index uint64) ([]byte,
+        error) {
+    directive := s.directive
+    s.directive = 0
+    encoding := string(s.encoding)
+    s.encoding = s.encoding[:0]
+    if encoding != "" && directive != 0 {
+        return nil, f.templateError("encoding on section marker", index)
+    }
+    if f.indices != nil {
+        f.indices[subId] = true
+        if _, ok := Encodings[encoding]; encoding != "" && !ok {
+            return nil, f.templateError("unknown encoding '" + encoding + "'",
+                    index)
+        }
+        return nil, nil
+    }
+
+    // within a repeated section, markers are kept as text for the expansion
+    // of each item; only the depth of nested sections is followed, to find
+    // the end of the repeated section
+    if s.capturing {
+        switch directive {
+        case IfDirective, UnlessDirective, RepeatDirective:
+            s.captureDepth++
+        case ElseDirective:
+            if s.captureDepth == 0 {
+                return nil, f.templateError("else outside conditional section",
+                        index)
+            }
+        case EndDirective:
+            if s.captureDepth == 0 {
+                if subId != s.captureIdx {
+                    return nil, f.templateError("section marker " +
+                            strconv.Itoa(int(subId)) + " doesn't match " +
+                            "section " + strconv.Itoa(int(s.captureIdx)),
+                            index)
+                }
+                return f.repeat(s, index)
+            }
+            s.captureDepth--
+        }
+        s.body = append(s.body, f.lead...)
+        if directive != 0 {
+            s.body = append(s.body, directive)
+        }
+        s.body = strconv.AppendUint(s.body, uint64(subId), 10)
+        if encoding != "" {
+            s.body = append(s.body, EncodingSeparator)
+            s.body = append(s.body, encoding...)
+        }
+        s.body = append(s.body, f.tail...)
+        if len(s.body) > MaxRepeatLen {
+            return nil, f.templateError("repeated section too long", index)
+        }
+        return nil, nil
+    }
+
+    switch directive {
+    case IfDirective, UnlessDirective:
+        include := false
+        if !s.omit {
+            value, err := f.lookup(subId, index)
+            if err != nil {
+                return nil, err
+            }
+            include, err = strconv.ParseBool(string(value))
+            if err != nil {
+                return nil, f.templateError("substitution " +
+                        strconv.Itoa(int(subId)) + " is not a boolean",
+                        index)
+            }
+            if directive == UnlessDirective {
+                include = !include
+            }
+        }
+        s.sections = append(s.sections,
+                section { directive, subId, include, s.omit, false })
+        s.omit = s.omit || !include
+    case ElseDirective:
+        top, err := f.innermost(s, subId, index)
+        if err != nil {
+            return nil, err
+        }
+        if top.directive == RepeatDirective || top.switched {
+            return nil, f.templateError("else outside conditional section",
+                    index)
+        }
+        top.switched = true
+        // an omitted parent decides the section both ways, so it wasn't
+        // evaluated and stays omitted
+        top.include = !top.parentOmit && !top.include
+        s.omit = top.parentOmit || !top.include
+    case RepeatDirective:
+        if s.omit {
+            s.sections = append(s.sections,
+                    section { directive, subId, false, s.omit, false })
+            break
+        }
+        s.capturing = true
+        s.captureIdx = subId
+        s.captureDepth = 0
+        s.body = make([]byte, 0)
+    case EndDirective:
+        top, err := f.innermost(s, subId, index)
+        if err != nil {
+            return nil, err
+        }
+        s.omit = top.parentOmit
+        s.sections = s.sections[:len(s.sections) - 1]
+    default:
+        if s.omit {
+            return nil, nil
+        }
+        value, err := f.lookup(subId, index)
+        if err != nil || encoding == "" {
+            return value, err
+        }
+        encode, ok := Encodings[encoding]
+        if !ok {
+            return nil, f.templateError("unknown encoding '" + encoding + "'",
+                    index)
+        }
+        value, err = encode(value, f.hexValues[subId])
+        if err != nil {
+            return nil, f.templateError("can't encode substitution " +
+                    strconv.Itoa(int(subId)) + " as " + encoding + ": " +
+                    err.Error(), index)
+        }
+        return value, nil
+    }
+    return nil, nil
+}
+
+// Get the value of a substitution.
+func (f *Filter) lookup(subId uint, index uint64) ([]byte, error) {
+    value, ok := f.substitutions[subId]
+    if !ok {
+        return nil, f.templateError("unknown substitution specifier " +
+                strconv.Itoa(int(subId)), index)
+    }
+    return value, nil
+}
+
+// Get the innermost open section, which must be on substitution subId.
+func (f *Filter) innermost(s *filterState, subId uint, index uint64) (*section,
+        error) {
+    if len(s.sections) < 1 {
+        return nil, f.templateError("no open section for " +
+                strconv.Itoa(int(subId)), index)
+    }
+    top := &s.sections[len(s.sections) - 1]
+    if top.idx != subId {
+        return nil, f.templateError("section marker " +
+                strconv.Itoa(int(subId)) + " doesn't match section " +
+                strconv.Itoa(int(top.idx)), index)
+    }
+    return top, nil
+}
+
+// Expand the collected repeated section once for each item of its list.
+func (f *Filter) repeat(s *filterState, index uint64) ([]byte, error) {
+    s.capturing = false
+    body := s.body
+    s.body = nil
+    value, err := f.lookup(s.captureIdx, index)
+    if err != nil {
+        return nil, err
+    }
+    expansion := make([]byte, 0)
+    for _, item := range ListItems(value) {
+        subs := make(FilterMap, len(f.substitutions))
+        for idx, sub := range f.substitutions {
+            subs[idx] = sub
+        }
+        subs[s.captureIdx] = item
+        itemOutput, err := ioutil.ReadAll(NewMarkedFilter(
+                bytes.NewReader(body), subs, Markers { string(f.lead),
+                string(f.tail) }).Hex(f.hexValues))
+        if err != nil {
+            return nil, f.templateError("in repeated section " +
+                    strconv.Itoa(int(s.captureIdx)) + ": " + err.Error(),
+                    index)
+        }
+        expansion = append(expansion, itemOutput...)
+    }
+    return expansion, nil
+}
+
+// Get the indices of the markers in a template, without substituting them.
+// Sections aren't evaluated, so the markers of every branch are found.
+func ScanMarkers(input io.Reader, markers Markers) (map[uint]bool, error) {
+    return scanMarkers(input, markers, "")
+}
+func scanMarkers(input io.Reader, markers Markers, name string) (map[uint]bool,
+        error) {
+    filter := NewMarkedFilter(input, nil, markers).Named(name)
+    filter.indices = make(map[uint]bool)
+    _, err := io.Copy(ioutil.Discard, filter)
+    if err != nil {
+        return nil, err
+    }
+    return filter.indices, nil
+}
+
+// Split a list substitution value into its items.  The empty value is the
+// empty list.
+func ListItems(value []byte) [][]byte {
+    if len(value) < 1 {
+        return [][]byte {}
+    }
+    return bytes.Split(value, []byte(ListSeparator))
+}
+
+type FilterRunner struct {
+    Markers Markers
+    // Substitutions whose values are hex bytes
+    HexValues map[uint]bool
+}
 func (tt FilterRunner) Run(dst io.Writer, src io.Reader, values FilterMap) error {
-    _, err := io.Copy(dst, NewFilter(src, values))
+    err := tt.Markers.Check()
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(dst, NewMarkedFilter(src, values,
+            tt.Markers).Hex(tt.HexValues))
     return err
 }
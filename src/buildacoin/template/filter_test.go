@@ -1,7 +1,11 @@
 package template
 
 import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
     "io"
+    "io/ioutil"
     "strings"
     "testing"
 )
@@ -135,6 +139,257 @@ func TestDistinctSubs(t *testing.T) {
     }
 }
 
+func filterString(template string, subs FilterMap,
+        bufSize uint) (string, error) {
+    filter := newFilterDebug(strings.NewReader(template), subs, bufSize)
+    buf := make([]byte, bufSize)
+    res := ""
+    for {
+        n, err := filter.Read(buf)
+        res += string(buf[:n])
+        if err == io.EOF {
+            return res, nil
+        }
+        if err != nil {
+            return res, err
+        }
+    }
+}
+
+func TestSections(t *testing.T) {
+    subs := FilterMap {
+        1 : []byte("true"),
+        2 : []byte("false"),
+        3 : []byte("a\nb\nc"),
+        4 : []byte(""),
+        5 : []byte("x"),
+    }
+    cases := [][]string {
+        { "[__._?1-yes__._/1-]", "[yes]" },
+        { "[__._?2-yes__._/2-]", "[]" },
+        { "[__._!2-no__._/2-]", "[no]" },
+        { "[__._?2-yes__._:2-no__._/2-]", "[no]" },
+        { "[__._?1-yes__._:1-no__._/1-]", "[yes]" },
+        { "[__._?1-1__._?2-2__._:2-3__._/2-__._/1-]", "[13]" },
+        { "[__._?2-__._?1-1__._:1-2__._/1-__._/2-]", "[]" },
+        { "[__._*3-(__._3-__._5-)__._/3-]", "[(ax)(bx)(cx)]" },
+        { "[__._*4-(__._4-)__._/4-]", "[]" },
+        { "[__._*3-__._?1-__._3-__._/1-__._/3-]", "[abc]" },
+        { "[__._?2-__._*9-__._9-__._/9-__._/2-]", "[]" },
+        { "[__._*3-__._*3-__._3-__._/3-__._/3-]", "[abc]" },
+        { "[__._?1-__.x__._/1-]", "[__.x]" },
+        { "[__._?2-__.x__._/2-]", "[]" },
+        { "[__._*3-_.__._/3-]", "[_._._.]" },
+    }
+    for _, c := range cases {
+        for bufSize := uint(1); bufSize < 48; bufSize++ {
+            res, err := filterString(c[0], subs, bufSize)
+            if err != nil {
+                t.Fatalf("'%s' failed with buffer %d: %s\n", c[0], bufSize,
+                        err.Error())
+            }
+            if res != c[1] {
+                t.Fatalf("'%s' with buffer %d: expected '%s' got '%s'\n",
+                        c[0], bufSize, c[1], res)
+            }
+        }
+    }
+}
+
+func TestBadSections(t *testing.T) {
+    subs := FilterMap {
+        1 : []byte("true"),
+        2 : []byte("maybe"),
+        3 : []byte("a\nb"),
+    }
+    templates := []string {
+        "__._?1-unterminated",
+        "__._*3-unterminated",
+        "__._?1-__._/3-",
+        "__._/1-",
+        "__._:1-",
+        "__._?1-__._:1-__._:1-__._/1-",
+        "__._*3-__._:3-__._/3-",
+        "__._?2-__._/2-",
+        "__._?9-__._/9-",
+        "__._1?-",
+        "__._??1-",
+        "__._*3-__._9-__._/3-",
+    }
+    for _, template := range templates {
+        _, err := filterString(template, subs, InitBufSize)
+        if err == nil {
+            t.Fatalf("'%s' should have failed\n", template)
+        }
+    }
+}
+
+func TestEscapedMarker(t *testing.T) {
+    subs := FilterMap {
+        1 : []byte("x"),
+        2 : []byte("a\nb"),
+    }
+    cases := [][]string {
+        { "__._\\1- __._1-", "__._1- x" },
+        { "___._\\", "___._" },
+        { "__._*2-__._\\2-__._2-__._/2-", "__._2-a__._2-b" },
+    }
+    for _, c := range cases {
+        for bufSize := uint(1); bufSize < 32; bufSize++ {
+            res, err := filterString(c[0], subs, bufSize)
+            if err != nil || res != c[1] {
+                t.Fatalf("'%s' with buffer %d: expected '%s' got '%s' (%v)\n",
+                        c[0], bufSize, c[1], res, err)
+            }
+        }
+    }
+}
+
+func TestCustomMarkers(t *testing.T) {
+    subs := FilterMap {
+        1 : []byte("x"),
+        2 : []byte("true"),
+    }
+    markers := Markers { "{{", "}}" }
+    if markers.Check() != nil {
+        t.Fatal("good markers failed check")
+    }
+    template := "{__._1-{{1}} {{?2}}{{\\1}}{{/2}}"
+    filter := NewMarkedFilter(strings.NewReader(template), subs, markers)
+    res, err := ioutil.ReadAll(filter)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if string(res) != "{__._1-x {{1}}" {
+        t.Fatalf("bad template result '%s'\n", string(res))
+    }
+
+    if (Markers { "{{", "1}" }).Check() != ErrBadMarkers {
+        t.Fatal("ambiguous tail marker passed check")
+    }
+}
+
+func TestAmbiguousMarker(t *testing.T) {
+    template := "some text\n__._1- then __._ x"
+    for bufSize := uint(1); bufSize < 32; bufSize++ {
+        _, err := filterString(template, FilterMap { 1 : []byte("a") },
+                bufSize)
+        templErr, ok := err.(*TemplateError)
+        if !ok {
+            t.Fatalf("expected template error, got %v\n", err)
+        }
+        if templErr.Offset() != 22 {
+            t.Fatalf("buffer %d: expected offset 22, got %d\n", bufSize,
+                    templErr.Offset())
+        }
+    }
+
+    // the tar runner names the file
+    tarBuf := new(bytes.Buffer)
+    gzOut := gzip.NewWriter(tarBuf)
+    tarOut := tar.NewWriter(gzOut)
+    tarOut.WriteHeader(&tar.Header { Name: "src/main.cpp", Mode: 0644,
+            Size: int64(len(template)) })
+    tarOut.Write([]byte(template))
+    tarOut.Close()
+    gzOut.Close()
+    runner, _ := GetRunner("tar.gz", "tar", DefaultMarkers)
+    err := runner.Run(ioutil.Discard, tarBuf, FilterMap { 1 : []byte("a") })
+    templErr, ok := err.(*TemplateError)
+    if !ok || templErr.File() != "src/main.cpp" || templErr.Offset() != 22 {
+        t.Fatalf("expected error in src/main.cpp at 22, got %v\n", err)
+    }
+}
+
+func TestUnfinishedMarker(t *testing.T) {
+    subs := FilterMap { 1 : []byte("true") }
+    // partial lead markers at the end are kept
+    cases := [][]string {
+        { "abc_", "abc_" },
+        { "abc__.", "abc__." },
+    }
+    for _, c := range cases {
+        for bufSize := uint(1); bufSize < 16; bufSize++ {
+            res, err := filterString(c[0], subs, bufSize)
+            if err != nil || res != c[1] {
+                t.Fatalf("'%s' with buffer %d: expected '%s' got '%s' (%v)\n",
+                        c[0], bufSize, c[1], res, err)
+            }
+        }
+    }
+
+    // but started markers must be finished
+    for _, template := range []string { "abc__._", "abc__._12",
+            "abc__._1.hex", "abc__._?" } {
+        for bufSize := uint(1); bufSize < 16; bufSize++ {
+            _, err := filterString(template, subs, bufSize)
+            templErr, ok := err.(*TemplateError)
+            if !ok || templErr.Offset() != 3 {
+                t.Fatalf("'%s' with buffer %d: expected template error at " +
+                        "3, got %v\n", template, bufSize, err)
+            }
+        }
+    }
+}
+
+func TestEncodings(t *testing.T) {
+    subs := FilterMap {
+        1 : []byte("3054"),
+        2 : []byte("fbc0b6db"),
+        3 : []byte("say \"it's\"\n\x01"),
+        4 : []byte("0x8000000"),
+        5 : []byte("1\n2"),
+    }
+    cases := [][]string {
+        { "__._1.hex-", "bee" },
+        { "__._1.0x-", "0xbee" },
+        { "__._4.hex-", "8000000" },
+        { "__._2.0x-", "0xfbc0b6db" },
+        { "{ __._2.bytes- }", "{ 0xfb, 0xc0, 0xb6, 0xdb }" },
+        { "{ __._1.bytes- }", "{ 0xee, 0x0b }" },
+        { "__._2.rhash-", "dbb6c0fb" },
+        { "__._3.cstr-", "\"say \\\"it's\\\"\\n\\001\"" },
+        { "__._3.shell-", "'say \"it'\\''s\"\n\x01'" },
+        { "__._3.json-", "\"say \\\"it's\\\"\\n\\u0001\"" },
+        { "__._*5-[__._5.0x-]__._/5-", "[0x1][0x2]" },
+    }
+    for _, c := range cases {
+        for bufSize := uint(1); bufSize < 32; bufSize++ {
+            res, err := filterString(c[0], subs, bufSize)
+            if err != nil || res != c[1] {
+                t.Fatalf("'%s' with buffer %d: expected '%s' got '%s' (%v)\n",
+                        c[0], bufSize, c[1], res, err)
+            }
+        }
+    }
+
+    // all digits, but hex bytes by its substitution's type
+    hexSubs := FilterMap { 1: []byte("1234"), 2: []byte("1234") }
+    hexValues := map[uint]bool { 2: true }
+    for template, expected := range map[string]string {
+        "__._1.0x-": "0x4d2",
+        "__._2.0x-": "0x1234",
+        "{ __._1.bytes- }": "{ 0xd2, 0x04 }",
+        "{ __._2.bytes- }": "{ 0x12, 0x34 }",
+        "__._*2-[__._2.hex-]__._/2-": "[1234]",
+    } {
+        res, err := ioutil.ReadAll(NewFilter(strings.NewReader(template),
+                hexSubs).Hex(hexValues))
+        if err != nil || string(res) != expected {
+            t.Fatalf("'%s': expected '%s' got '%s' (%v)\n", template,
+                    expected, res, err)
+        }
+    }
+
+    for _, template := range []string { "__._3.hex-", "__._3.rhash-",
+            "__._1.nope-", "__._1.HEX-", "__._.hex-", "__._?1.hex-" } {
+        _, err := filterString(template, subs, InitBufSize)
+        if err == nil {
+            t.Fatalf("'%s' should have failed\n", template)
+        }
+    }
+}
+
 //
 // benchmarking
 //
@@ -235,3 +490,59 @@ func BenchmarkSubstitution16KBuf(b *testing.B) {
 func BenchmarkSubstitution1MBuf(b *testing.B) {
     benchmarkSubstitution(b, 1 * 1024 * 1024)
 }
+
+func TestScanMarkers(t *testing.T) {
+    // every branch of sections, escapes and encodings
+    template := "__._1- __._?2-a__._3-__._:2-__._4.hex-__._/2- " +
+            "__._*5-__._5-__._/5- __._\\6-"
+    indices, err := ScanMarkers(strings.NewReader(template), DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(indices) != 5 || !indices[1] || !indices[2] || !indices[3] ||
+            !indices[4] || !indices[5] {
+        t.Fatalf("unexpected indices %v\n", indices)
+    }
+
+    _, err = ScanMarkers(strings.NewReader("__._1.nope-"), DefaultMarkers)
+    if _, ok := err.(*TemplateError); !ok {
+        t.Fatalf("expected unknown encoding error, got %v\n", err)
+    }
+}
+
+func TestTemplatize(t *testing.T) {
+    upstream := "Litecoin Core: litecoind on 9333, not 19333\n" +
+            "__._ LTC LTCD\n"
+    literals := []Literal {
+        { "litecoin", 20, false },
+        { "Litecoin", 3, false },
+        { "Litecoin Core", 4, false },
+        { "9333", 19, true },
+        { "LTC", 17, true },
+    }
+    expected := "__._4-: __._20-d on __._19-, not 19333\n" +
+            "__._\\ __._17- LTCD\n"
+    actual, replacements := Templatize([]byte(upstream), literals,
+            DefaultMarkers)
+    if string(actual) != expected {
+        t.Fatalf("templatize mismatch:\nexpected\n%s\nactual\n%s\n",
+                expected, actual)
+    }
+    if len(replacements) != 4 || replacements[3].Line != 2 ||
+            replacements[3].Literal != "LTC" {
+        t.Fatalf("unexpected replacements %v\n", replacements)
+    }
+
+    // filtering with the literals gives back the upstream text
+    subs := FilterMap {}
+    for _, literal := range literals {
+        subs[literal.Idx] = []byte(literal.Value)
+    }
+    output, err := ioutil.ReadAll(NewFilter(bytes.NewReader(actual), subs))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if string(output) != upstream {
+        t.Fatalf("round trip mismatch:\n%s\n", output)
+    }
+}
@@ -0,0 +1,518 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/zlib"
+    "crypto/sha1"
+    "encoding/binary"
+    "encoding/hex"
+    "io"
+    "io/ioutil"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    // Output format of a coin as a git bundle of its repository
+    BundleFormat = "bundle"
+    // Prefix of the archive formats holding a coin's repository along with
+    // its work tree, as in "git.tar.gz"
+    GitFormatPrefix = "git."
+    // Branch of a coin's repository holding its history
+    GitBranch = "master"
+    // Author and committer of the commits in coins' repositories
+    GitIdentity = "build-a-coin <build-a-coin@localhost>"
+)
+
+// Check whether an output format is a git repository rather than an archive
+// of the coin's source alone.
+func IsGitFormat(outputType string) bool {
+    outputType = strings.ToLower(outputType)
+    return outputType == BundleFormat ||
+            strings.HasPrefix(outputType, GitFormatPrefix)
+}
+
+// Get the runner filtering templates stored as archiveType into git
+// repositories output as outputType: BundleFormat, or GitFormatPrefix and the
+// format of an archive holding the repository and its work tree.
+func GetGitRunner(archiveType, outputType string, markers Markers) (GitRunner,
+        error) {
+    input, err := ParseFormat(archiveType)
+    if err != nil {
+        return GitRunner{}, err
+    }
+    output := GitRunner { Input: input, Markers: markers,
+            BaseMessage: "Add base template",
+            CoinMessage: "Apply coin parameters" }
+    lower := strings.ToLower(outputType)
+    if lower == BundleFormat {
+        output.Bundle = true
+        return output, nil
+    }
+    if !strings.HasPrefix(lower, GitFormatPrefix) {
+        return GitRunner{}, ErrUnknFormat { outputType }
+    }
+    output.Output, err = ParseFormat(outputType[len(GitFormatPrefix):])
+    if err != nil {
+        return GitRunner{}, err
+    }
+    if !output.Output.Writable() {
+        return GitRunner{}, ErrUnwritableFormat { outputType }
+    }
+    return output, nil
+}
+
+// Runner filtering a template into a git repository, so a coin can be forked
+// and kept up with its base.  The first commit is the template as it is, the
+// same for every coin made from it, and the second applies the values.
+// Templates whose entries are all in one directory are repositories of that
+// directory.  Everything is written deterministically, so the same template
+// and values always give the same commits.
+type GitRunner struct {
+    Input Format
+    // Archive holding the repository and its work tree, unless Bundle
+    Output Format
+    // Whether the repository is output as a git bundle
+    Bundle bool
+    Markers Markers
+    // Messages of the commit of the template and the one applying the values
+    BaseMessage string
+    CoinMessage string
+    // Files left out of, renamed in or added to the commit of the values
+    Rules FileRules
+    // Substitutions whose values are hex bytes
+    HexValues map[uint]bool
+}
+
+func (tt GitRunner) Run(dst io.Writer, src io.Reader,
+        values FilterMap) error {
+    err := tt.Markers.Check()
+    if err != nil {
+        return err
+    }
+    entries, err := readEntries(src, tt.Input)
+    if err != nil {
+        return err
+    }
+
+    filter := NewMarkedFilter(nil, values, tt.Markers).Hex(tt.HexValues)
+    lead := []byte(tt.Markers.orDefault().Lead)
+    root := commonRoot(entries)
+    coinRoot, err := filterName(filter, root)
+    if err != nil {
+        return err
+    }
+
+    repo := newGitRepo()
+    baseFiles := make([]gitFile, 0, len(entries))
+    coinFiles := make([]gitFile, 0, len(entries))
+    for _, entry := range entries {
+        mode := gitFileMode
+        body := entry.body
+        switch {
+        case entry.typeflag == tar.TypeSymlink:
+            mode = gitLinkMode
+            body = []byte(entry.linkname)
+        case entry.mode & 0111 != 0:
+            mode = gitExecMode
+        }
+        baseFiles = append(baseFiles, gitFile {
+                strings.TrimPrefix(entry.name, root), mode,
+                repo.add(gitBlob, body), len(body) })
+
+        excluded, err := tt.Rules.excluded(entry.name, values)
+        if err != nil {
+            return err
+        }
+        if excluded {
+            continue
+        }
+        replacement, replaced, err := tt.Rules.replacement(entry.name,
+                values)
+        if err != nil {
+            return err
+        }
+        coinBody := body
+        switch {
+        case entry.typeflag == tar.TypeSymlink:
+            target, err := filterName(filter, entry.linkname)
+            if err != nil {
+                return err
+            }
+            coinBody = []byte(target)
+        case replaced:
+            coinBody = replacement
+        case !IsBinary(sniffed(body)) && bytes.Contains(body, lead):
+            // template errors already name the file and offset
+            coinBody, err = ioutil.ReadAll(filter.Reset(
+                    bytes.NewReader(body)).Named(entry.name))
+            if _, ok := err.(*TemplateError); ok {
+                return err
+            }
+            if err != nil {
+                return ErrFilterFailure { entry.name, err }
+            }
+        }
+        coinName, err := filterName(filter, tt.Rules.rename(entry.name))
+        if err != nil {
+            return err
+        }
+        coinFiles = append(coinFiles, gitFile {
+                strings.TrimPrefix(coinName, coinRoot), mode,
+                repo.add(gitBlob, coinBody), len(coinBody) })
+    }
+    generated, err := tt.Rules.generated(values, tt.Markers, tt.HexValues)
+    if err != nil {
+        return err
+    }
+    for _, file := range generated {
+        coinFiles = append(coinFiles, gitFile {
+                strings.TrimPrefix(file.name, coinRoot), gitFileMode,
+                repo.add(gitBlob, file.body), len(file.body) })
+    }
+
+    base := repo.addCommit(repo.addTree(baseFiles), nil, tt.BaseMessage)
+    head := repo.addCommit(repo.addTree(coinFiles), &base, tt.CoinMessage)
+    if tt.Bundle {
+        return repo.writeBundle(dst, head)
+    }
+    return repo.writeArchive(dst, tt.Output, coinRoot, coinFiles, head)
+}
+
+// Get the directory, with its trailing slash, holding every entry of a
+// template, or "" if they aren't all in one.
+func commonRoot(entries []recoverEntry) string {
+    if len(entries) == 0 {
+        return ""
+    }
+    slash := strings.Index(entries[0].name, "/")
+    if slash < 0 {
+        return ""
+    }
+    root := entries[0].name[:slash + 1]
+    for _, entry := range entries {
+        if !strings.HasPrefix(entry.name, root) {
+            return ""
+        }
+    }
+    return root
+}
+
+const (
+    // Kinds of git object
+    gitBlob = "blob"
+    gitTree = "tree"
+    gitCommit = "commit"
+
+    // Modes of git tree entries
+    gitFileMode = "100644"
+    gitExecMode = "100755"
+    gitLinkMode = "120000"
+    gitDirMode = "40000"
+)
+
+// Type codes of the kinds of git object in packs
+var gitPackTypes = map[string]byte { gitCommit: 1, gitTree: 2, gitBlob: 3 }
+
+type gitHash [sha1.Size]byte
+
+// A file of a git tree: its path within the tree, mode, blob and size
+type gitFile struct {
+    path string
+    mode string
+    hash gitHash
+    size int
+}
+
+type gitObject struct {
+    kind string
+    hash gitHash
+    body []byte
+}
+
+// Writer of the objects of a git repository, in the order they're added
+type gitRepo struct {
+    objects []gitObject
+    // positions in objects by hash
+    byHash map[gitHash]int
+}
+
+func newGitRepo() *gitRepo {
+    return &gitRepo { make([]gitObject, 0), make(map[gitHash]int) }
+}
+
+// Add an object to the repository, returning its hash.
+func (tt *gitRepo) add(kind string, body []byte) gitHash {
+    hash := gitHash(sha1.Sum(append(gitObjectHeader(kind, len(body)),
+            body...)))
+    if _, ok := tt.byHash[hash]; !ok {
+        tt.byHash[hash] = len(tt.objects)
+        tt.objects = append(tt.objects, gitObject { kind, hash, body })
+    }
+    return hash
+}
+
+func gitObjectHeader(kind string, size int) []byte {
+    return []byte(kind + " " + strconv.Itoa(size) + "\x00")
+}
+
+// Add the tree of files and the trees of their directories, returning its
+// hash.
+func (tt *gitRepo) addTree(files []gitFile) gitHash {
+    type treeEntry struct {
+        name string
+        mode string
+        hash gitHash
+    }
+    entries := make([]treeEntry, 0)
+    dirs := make(map[string][]gitFile)
+    for _, file := range files {
+        slash := strings.Index(file.path, "/")
+        if slash < 0 {
+            entries = append(entries,
+                    treeEntry { file.path, file.mode, file.hash })
+            continue
+        }
+        dir := file.path[:slash]
+        file.path = file.path[slash + 1:]
+        dirs[dir] = append(dirs[dir], file)
+    }
+    dirNames := make([]string, 0, len(dirs))
+    for dir := range dirs {
+        dirNames = append(dirNames, dir)
+    }
+    sort.Strings(dirNames)
+    for _, dir := range dirNames {
+        entries = append(entries,
+                treeEntry { dir, gitDirMode, tt.addTree(dirs[dir]) })
+    }
+
+    // git orders directories as if their names ended with a slash
+    sortKey := func(entry treeEntry) string {
+        if entry.mode == gitDirMode {
+            return entry.name + "/"
+        }
+        return entry.name
+    }
+    sort.Slice(entries, func(ii, jj int) bool {
+        return sortKey(entries[ii]) < sortKey(entries[jj])
+    })
+    body := new(bytes.Buffer)
+    for _, entry := range entries {
+        body.WriteString(entry.mode + " " + entry.name + "\x00")
+        body.Write(entry.hash[:])
+    }
+    return tt.add(gitTree, body.Bytes())
+}
+
+// Add a commit of a tree, returning its hash.
+func (tt *gitRepo) addCommit(tree gitHash, parent *gitHash,
+        message string) gitHash {
+    signature := GitIdentity + " " +
+            strconv.FormatInt(DeterministicModTime.Unix(), 10) + " +0000"
+    body := "tree " + hex.EncodeToString(tree[:]) + "\n"
+    if parent != nil {
+        body += "parent " + hex.EncodeToString(parent[:]) + "\n"
+    }
+    body += "author " + signature + "\ncommitter " + signature + "\n\n" +
+            message + "\n"
+    return tt.add(gitCommit, []byte(body))
+}
+
+// Write the repository as a bundle whose branch ends at head.
+func (tt *gitRepo) writeBundle(dst io.Writer, head gitHash) error {
+    headHex := hex.EncodeToString(head[:])
+    _, err := io.WriteString(dst, "# v2 git bundle\n" + headHex +
+            " refs/heads/" + GitBranch + "\n" + headHex + " HEAD\n\n")
+    if err != nil {
+        return err
+    }
+    return tt.writePack(dst)
+}
+
+// Write the objects as a pack, none of them deltas.
+func (tt *gitRepo) writePack(dst io.Writer) error {
+    sum := sha1.New()
+    out := io.MultiWriter(dst, sum)
+    header := make([]byte, 12)
+    copy(header, "PACK")
+    binary.BigEndian.PutUint32(header[4:], 2)
+    binary.BigEndian.PutUint32(header[8:], uint32(len(tt.objects)))
+    _, err := out.Write(header)
+    if err != nil {
+        return err
+    }
+    for _, object := range tt.objects {
+        // the type and size, seven bits of size at a time after the first
+        // four
+        size := len(object.body)
+        objectHeader := []byte { gitPackTypes[object.kind] << 4 |
+                byte(size & 0x0f) }
+        for size >>= 4; size > 0; size >>= 7 {
+            objectHeader[len(objectHeader) - 1] |= 0x80
+            objectHeader = append(objectHeader, byte(size & 0x7f))
+        }
+        _, err = out.Write(objectHeader)
+        if err != nil {
+            return err
+        }
+        err = writeZlib(out, object.body)
+        if err != nil {
+            return err
+        }
+    }
+    _, err = dst.Write(sum.Sum(nil))
+    return err
+}
+
+func writeZlib(dst io.Writer, body []byte) error {
+    writer := zlib.NewWriter(dst)
+    _, err := writer.Write(body)
+    closeErr := writer.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+// Write the repository as an archive of its work tree in root, checked out at
+// head, with the repository itself in root's .git directory.
+func (tt *gitRepo) writeArchive(dst io.Writer, format Format, root string,
+        files []gitFile, head gitHash) error {
+    archive, err := createArchive(dst, format, true)
+    if err != nil {
+        return err
+    }
+    archiveOut := newDeterministicWriter(archive, DefaultSpillThreshold)
+    err = tt.writeEntries(archiveOut, root, files, head)
+    closeErr := archiveOut.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+func (tt *gitRepo) writeEntries(archiveOut archiveWriter, root string,
+        files []gitFile, head gitHash) error {
+    body := func(hash gitHash) []byte {
+        return tt.objects[tt.byHash[hash]].body
+    }
+    dirs := make(map[string]bool)
+    write := func(name string, typeflag byte, mode int64, linkname string,
+            body []byte) error {
+        for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+            dirs[dir + "/"] = true
+        }
+        err := archiveOut.WriteHeader(&tar.Header { Name: name,
+                Typeflag: typeflag, Mode: mode, Linkname: linkname })
+        if err != nil || typeflag != tar.TypeReg {
+            return err
+        }
+        _, err = archiveOut.Write(body)
+        return err
+    }
+
+    for _, file := range files {
+        var err error
+        switch file.mode {
+        case gitLinkMode:
+            err = write(root + file.path, tar.TypeSymlink, 0,
+                    string(body(file.hash)), nil)
+        case gitExecMode:
+            err = write(root + file.path, tar.TypeReg, 0755, "",
+                    body(file.hash))
+        default:
+            err = write(root + file.path, tar.TypeReg, 0644, "",
+                    body(file.hash))
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    gitDir := root + ".git/"
+    headHex := hex.EncodeToString(head[:])
+    meta := [][]string {
+        []string { "HEAD", "ref: refs/heads/" + GitBranch + "\n" },
+        []string { "config", "[core]\n\trepositoryformatversion = 0\n" +
+                "\tfilemode = true\n\tbare = false\n" },
+        []string { "refs/heads/" + GitBranch, headHex + "\n" },
+        []string { "index", string(gitIndex(files)) },
+    }
+    for _, file := range meta {
+        err := write(gitDir + file[0], tar.TypeReg, 0644, "",
+                []byte(file[1]))
+        if err != nil {
+            return err
+        }
+    }
+    for _, object := range tt.objects {
+        hashHex := hex.EncodeToString(object.hash[:])
+        compressed := new(bytes.Buffer)
+        err := writeZlib(compressed, append(gitObjectHeader(object.kind,
+                len(object.body)), object.body...))
+        if err != nil {
+            return err
+        }
+        err = write(gitDir + "objects/" + hashHex[:2] + "/" + hashHex[2:],
+                tar.TypeReg, 0444, "", compressed.Bytes())
+        if err != nil {
+            return err
+        }
+    }
+
+    dirNames := make([]string, 0, len(dirs))
+    for dir := range dirs {
+        dirNames = append(dirNames, dir)
+    }
+    sort.Strings(dirNames)
+    for _, dir := range dirNames {
+        err := archiveOut.WriteHeader(&tar.Header { Name: dir,
+                Typeflag: tar.TypeDir, Mode: 0755 })
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Build the index of a work tree of files, so git sees it as checked out.
+// Stat details other than times and sizes are unknown, and git fills them in
+// on its first look at the files.
+func gitIndex(files []gitFile) []byte {
+    sorted := make([]gitFile, len(files))
+    copy(sorted, files)
+    sort.Slice(sorted, func(ii, jj int) bool {
+        return sorted[ii].path < sorted[jj].path
+    })
+
+    index := new(bytes.Buffer)
+    index.WriteString("DIRC")
+    binary.Write(index, binary.BigEndian, uint32(2))
+    binary.Write(index, binary.BigEndian, uint32(len(sorted)))
+    modTime := uint32(DeterministicModTime.Unix())
+    for _, file := range sorted {
+        mode, _ := strconv.ParseUint(file.mode, 8, 32)
+        flags := len(file.path)
+        if flags > 0xfff {
+            flags = 0xfff
+        }
+        // ctime and mtime, then dev, inode, mode, uid, gid and size
+        for _, field := range []uint32 { modTime, 0, modTime, 0, 0, 0,
+                uint32(mode), 0, 0, uint32(file.size) } {
+            binary.Write(index, binary.BigEndian, field)
+        }
+        index.Write(file.hash[:])
+        binary.Write(index, binary.BigEndian, uint16(flags))
+        index.WriteString(file.path)
+        // entries are padded with at least one NUL to a multiple of 8
+        entryLen := 62 + len(file.path)
+        index.Write(make([]byte, (entryLen + 8) &^ 7 - entryLen))
+    }
+    sum := sha1.Sum(index.Bytes())
+    index.Write(sum[:])
+    return index.Bytes()
+}
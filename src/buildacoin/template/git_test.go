@@ -0,0 +1,117 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "testing"
+)
+
+func TestGitRunner(t *testing.T) {
+    tmpl := func() *bytes.Buffer {
+        return testTar([][]string {
+            []string { "__._1-/main.h", "#define NAME __._1-\n" },
+            []string { "__._1-/README", "no markers here\n" },
+        })
+    }
+    bundle := func(name string) []byte {
+        runner, err := GetGitRunner(TarArchive, BundleFormat, DefaultMarkers)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        out := new(bytes.Buffer)
+        err = runner.Run(out, tmpl(), FilterMap { 1: []byte(name) })
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        return out.Bytes()
+    }
+    first, again, other := bundle("bestcoin"), bundle("bestcoin"),
+            bundle("bettercoin")
+    header := []byte("# v2 git bundle\n")
+    if !bytes.HasPrefix(first, header) || !bytes.Contains(first,
+            []byte("\n\nPACK")) {
+        t.Fatalf("not a git bundle:\n%q\n", first)
+    }
+    if !bytes.Equal(first, again) {
+        t.Fatal("git bundles of the same values differ")
+    }
+    if bytes.Equal(first, other) {
+        t.Fatal("git bundles of different values are the same")
+    }
+
+    runner, err := GetGitRunner(TarArchive, "git.tar", DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    out := new(bytes.Buffer)
+    err = runner.Run(out, tmpl(), FilterMap { 1: []byte("bestcoin") })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    files := readArchive(t, out.Bytes(), Format { TarArchive, Uncompressed })
+    expected := map[string]string {
+        "bestcoin/main.h": "#define NAME bestcoin\n",
+        "bestcoin/README": "no markers here\n",
+        "bestcoin/.git/HEAD": "ref: refs/heads/" + GitBranch + "\n",
+    }
+    for name, contents := range expected {
+        if files[name] != contents {
+            t.Fatalf("%s: expected '%s' / actual '%s'\n", name, contents,
+                    files[name])
+        }
+    }
+    if _, ok := files["bestcoin/.git/index"]; !ok {
+        t.Fatal("git index missing")
+    }
+
+    // hard links are copies of the files they link to
+    entries := []*tar.Header {
+        &tar.Header { Name: "__._1-/main.h", Mode: 0644, Size: 20,
+                Typeflag: tar.TypeReg },
+        &tar.Header { Name: "__._1-/alias.h", Linkname: "__._1-/main.h",
+                Mode: 0644, Typeflag: tar.TypeLink },
+        &tar.Header { Name: "__._1-/lost.h", Linkname: "__._1-/gone.h",
+                Mode: 0644, Typeflag: tar.TypeLink },
+        &tar.Header { Name: "__._1x/main.h", Mode: 0644,
+                Typeflag: tar.TypeReg },
+    }
+    linked := func(count int) *bytes.Buffer {
+        out := new(bytes.Buffer)
+        tarOut := tar.NewWriter(out)
+        for _, header := range entries[:count] {
+            tarOut.WriteHeader(header)
+            if header.Size > 0 {
+                tarOut.Write([]byte("#define NAME __._1-\n"))
+            }
+        }
+        tarOut.Close()
+        return out
+    }
+    out.Reset()
+    err = runner.Run(out, linked(2), FilterMap { 1: []byte("bestcoin") })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    files = readArchive(t, out.Bytes(), Format { TarArchive, Uncompressed })
+    if files["bestcoin/alias.h"] != "#define NAME bestcoin\n" {
+        t.Fatalf("unexpected hard link contents '%s'\n",
+                files["bestcoin/alias.h"])
+    }
+    err = runner.Run(new(bytes.Buffer), linked(3),
+            FilterMap { 1: []byte("bestcoin") })
+    if _, ok := err.(ErrLinkTarget); !ok {
+        t.Fatalf("expected dangling link error, got %v\n", err)
+    }
+
+    // names that can't be filtered are errors
+    entries = append(entries[:1], entries[3])
+    err = runner.Run(new(bytes.Buffer), linked(2),
+            FilterMap { 1: []byte("bestcoin") })
+    if _, ok := err.(*TemplateError); !ok {
+        t.Fatalf("expected template error, got %v\n", err)
+    }
+    _, err = GetGitRunner(TarArchive, "git.nope", DefaultMarkers)
+    if err == nil {
+        t.Fatal("unknown git archive format accepted")
+    }
+}
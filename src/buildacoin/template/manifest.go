@@ -0,0 +1,258 @@
+package template
+
+import (
+    "archive/tar"
+    "bufio"
+    "bytes"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "hash"
+    "io"
+    "io/ioutil"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    // Name of the checksum manifest entry ending each generated archive, in
+    // the format of sha256sum
+    ManifestName = "SHA256SUMS"
+    // Name of the entry following the manifest with its hex ed25519 signature,
+    // when the archive is signed
+    SignatureName = ManifestName + ".sig"
+)
+
+// Archive writer noting the SHA-256 of each regular file and link written
+// through it, and adding the manifest of them, and its signature if there is
+// a key, as the last entries when it is closed
+type manifestWriter struct {
+    archive archiveWriter
+    key ed25519.PrivateKey
+    modTime time.Time
+    sums map[string][]byte
+    name string
+    hash hash.Hash
+}
+
+// Wrap an archive writer to end its archive with a manifest, signed with key
+// if it isn't nil.  The manifest entries are stamped with modTime.
+func newManifestWriter(archive archiveWriter, key ed25519.PrivateKey,
+        modTime time.Time) *manifestWriter {
+    return &manifestWriter {
+        archive: archive,
+        key: key,
+        modTime: modTime,
+        sums: make(map[string][]byte),
+    }
+}
+
+func (tt *manifestWriter) WriteHeader(header *tar.Header) error {
+    tt.finishEntry()
+    switch header.Typeflag {
+    case tar.TypeReg, tar.TypeRegA:
+        tt.name = header.Name
+        tt.hash = sha256.New()
+    case tar.TypeSymlink, tar.TypeLink:
+        tt.sums[header.Name] = linkSum(header)
+    }
+    return tt.archive.WriteHeader(header)
+}
+
+func (tt *manifestWriter) Write(buf []byte) (int, error) {
+    if tt.hash != nil {
+        tt.hash.Write(buf)
+    }
+    return tt.archive.Write(buf)
+}
+
+func (tt *manifestWriter) Close() error {
+    tt.finishEntry()
+    manifest := FormatManifest(tt.sums)
+    err := tt.writeEntry(ManifestName, manifest)
+    if err == nil && tt.key != nil {
+        signature := hex.EncodeToString(ed25519.Sign(tt.key, manifest))
+        err = tt.writeEntry(SignatureName, []byte(signature + "\n"))
+    }
+    closeErr := tt.archive.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+// Note the sum of the file being written, if any.
+func (tt *manifestWriter) finishEntry() {
+    if tt.hash != nil {
+        tt.sums[tt.name] = tt.hash.Sum(nil)
+        tt.hash = nil
+    }
+}
+
+func (tt *manifestWriter) writeEntry(name string, contents []byte) error {
+    err := tt.archive.WriteHeader(&tar.Header {
+        Name: name,
+        Mode: deterministicFileMode,
+        Size: int64(len(contents)),
+        Typeflag: tar.TypeReg,
+        ModTime: tt.modTime,
+    })
+    if err != nil {
+        return err
+    }
+    _, err = tt.archive.Write(contents)
+    return err
+}
+
+// Get the sum a manifest lists for a symlink or hard link: the SHA-256 of its
+// kind and target, which no file's contents can be mistaken for.
+func linkSum(header *tar.Header) []byte {
+    kind := "symlink"
+    if header.Typeflag == tar.TypeLink {
+        kind = "hardlink"
+    }
+    sum := sha256.Sum256([]byte(kind + " -> " + header.Linkname))
+    return sum[:]
+}
+
+// Format a manifest of SHA-256 sums by file name, as sha256sum does, sorted
+// by name.
+func FormatManifest(sums map[string][]byte) []byte {
+    names := make([]string, 0, len(sums))
+    for name := range sums {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    output := new(bytes.Buffer)
+    for _, name := range names {
+        output.WriteString(hex.EncodeToString(sums[name]) + "  " + name +
+                "\n")
+    }
+    return output.Bytes()
+}
+
+// Parse a manifest as written by FormatManifest into SHA-256 sums by file
+// name.
+func ParseManifest(manifest []byte) (map[string][]byte, error) {
+    sums := make(map[string][]byte)
+    scanner := bufio.NewScanner(bytes.NewReader(manifest))
+    for scanner.Scan() {
+        parts := strings.SplitN(scanner.Text(), "  ", 2)
+        if len(parts) != 2 {
+            return nil, ErrBadManifest
+        }
+        sum, err := hex.DecodeString(parts[0])
+        if err != nil || len(sum) != sha256.Size {
+            return nil, ErrBadManifest
+        }
+        sums[parts[1]] = sum
+    }
+    return sums, scanner.Err()
+}
+
+// Check that every entry of an archive but its directories matches its
+// manifest, and that the manifest is signed by key if it isn't nil.  Links are
+// checked by their targets, and entries of other kinds are never listed.
+func VerifyArchive(src io.Reader, format Format, key ed25519.PublicKey) error {
+    archiveIn, inCloser, err := openArchive(src, format,
+            DefaultSpillThreshold)
+    if err != nil {
+        return err
+    }
+    defer inCloser.Close()
+
+    sums := make(map[string][]byte)
+    var manifest, signature []byte
+    for {
+        header, err := archiveIn.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        switch header.Typeflag {
+        case tar.TypeReg, tar.TypeRegA:
+            break
+        case tar.TypeSymlink, tar.TypeLink:
+            sums[header.Name] = linkSum(header)
+            continue
+        case tar.TypeDir:
+            continue
+        default:
+            return ErrManifestMismatch { header.Name,
+                    "is not in the manifest" }
+        }
+        switch header.Name {
+        case ManifestName:
+            manifest, err = ioutil.ReadAll(archiveIn)
+        case SignatureName:
+            signature, err = ioutil.ReadAll(archiveIn)
+        default:
+            hash := sha256.New()
+            _, err = io.Copy(hash, archiveIn)
+            sums[header.Name] = hash.Sum(nil)
+        }
+        if err != nil {
+            return err
+        }
+    }
+
+    if manifest == nil {
+        return ErrNoManifest
+    }
+    if key != nil {
+        if signature == nil {
+            return ErrNoSignature
+        }
+        sig, err := hex.DecodeString(strings.TrimSpace(string(signature)))
+        if err != nil || !ed25519.Verify(key, manifest, sig) {
+            return ErrBadSignature
+        }
+    }
+
+    listed, err := ParseManifest(manifest)
+    if err != nil {
+        return err
+    }
+    for name, sum := range sums {
+        listedSum, ok := listed[name]
+        if !ok {
+            return ErrManifestMismatch { name, "is not in the manifest" }
+        }
+        if !bytes.Equal(sum, listedSum) {
+            return ErrManifestMismatch { name, "does not match its checksum" }
+        }
+    }
+    for name := range listed {
+        if _, ok := sums[name]; !ok {
+            return ErrManifestMismatch { name, "is missing from the archive" }
+        }
+    }
+    return nil
+}
+
+//
+// Errors
+//
+
+var (
+    // The archive has no manifest entry
+    ErrNoManifest error = errors.New("archive has no " + ManifestName +
+            " manifest")
+    // A manifest line isn't a hex SHA-256 sum and a file name
+    ErrBadManifest error = errors.New("malformed " + ManifestName +
+            " manifest")
+    // A signature was expected but the archive has none
+    ErrNoSignature error = errors.New("archive manifest is not signed")
+    // The manifest's signature isn't valid for the key
+    ErrBadSignature error = errors.New(
+            "archive manifest signature does not match the key")
+)
+
+type ErrManifestMismatch struct { Name string; Problem string }
+func (tt ErrManifestMismatch) Error() string {
+    return "'" + tt.Name + "' " + tt.Problem
+}
@@ -0,0 +1,174 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Get the indices of the markers in the names, symlink targets and text files
+// of a template directory, with the files each is found in.
+func ScanDir(dir string, markers Markers) (map[uint][]string, error) {
+    scanner := newMarkerScanner(markers)
+    err := walkTemplate(dir, func(path, name string, header *tar.Header) error {
+        if header.Typeflag != tar.TypeReg {
+            return scanner.scan(header, nil)
+        }
+        file, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        return scanner.scan(header, file)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return scanner.found, nil
+}
+
+// Get the indices of the markers in a template archive as ScanDir does.
+func ScanArchive(src io.Reader, format Format,
+        markers Markers) (map[uint][]string, error) {
+    archiveIn, inCloser, err := openArchive(src, format,
+            DefaultSpillThreshold)
+    if err != nil {
+        return nil, err
+    }
+    defer inCloser.Close()
+
+    scanner := newMarkerScanner(markers)
+    for {
+        header, err := archiveIn.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+        err = scanner.scan(header, archiveIn)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return scanner.found, nil
+}
+
+// collector of the markers in the entries of a template
+type markerScanner struct {
+    markers Markers
+    found map[uint][]string
+    sniff []byte
+}
+
+func newMarkerScanner(markers Markers) *markerScanner {
+    return &markerScanner {
+        markers: markers,
+        found: make(map[uint][]string),
+        sniff: make([]byte, SniffLen),
+    }
+}
+
+// Note the markers of an entry, reading the body of regular files as the
+// runners would filter them: binary files aren't.
+func (tt *markerScanner) scan(header *tar.Header, body io.Reader) error {
+    indices, err := ScanMarkers(strings.NewReader(header.Name + "\n" +
+            header.Linkname), tt.markers)
+    if err != nil {
+        return ErrFilterFailure { header.Name, err }
+    }
+    tt.note(header.Name, indices)
+    if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+        return nil
+    }
+
+    count, err := io.ReadFull(body, tt.sniff)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return ErrFilterFailure { header.Name, err }
+    }
+    start := tt.sniff[:count]
+    if IsBinary(start) {
+        return nil
+    }
+    // template errors already name the file and offset
+    indices, err = scanMarkers(io.MultiReader(bytes.NewReader(start), body),
+            tt.markers, header.Name)
+    if _, ok := err.(*TemplateError); ok {
+        return err
+    }
+    if err != nil {
+        return ErrFilterFailure { header.Name, err }
+    }
+    tt.note(header.Name, indices)
+    return nil
+}
+
+func (tt *markerScanner) note(name string, indices map[uint]bool) {
+    for idx := range indices {
+        tt.found[idx] = append(tt.found[idx], name)
+    }
+}
+
+// Pack a template directory into a deterministic archive, so the same tree
+// always gives the same bytes.
+func PackDir(dst io.Writer, dir string, format Format) error {
+    archive, err := createArchive(dst, format, true)
+    if err != nil {
+        return err
+    }
+    archiveOut := newDeterministicWriter(archive, DefaultSpillThreshold)
+
+    err = walkTemplate(dir, func(path, name string, header *tar.Header) error {
+        err := archiveOut.WriteHeader(header)
+        if err != nil || header.Typeflag != tar.TypeReg {
+            return err
+        }
+        file, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        _, err = io.Copy(archiveOut, file)
+        return err
+    })
+    closeErr := archiveOut.Close()
+    if err != nil {
+        return err
+    }
+    return closeErr
+}
+
+// Call visit with the path, archive entry name and header of everything in a
+// template directory.
+func walkTemplate(dir string,
+        visit func(path, name string, header *tar.Header) error) error {
+    return filepath.Walk(dir, func(path string, info os.FileInfo,
+            err error) error {
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(dir, path)
+        if err != nil || rel == "." {
+            return err
+        }
+        link := ""
+        if info.Mode() & os.ModeSymlink != 0 {
+            link, err = os.Readlink(path)
+            if err != nil {
+                return err
+            }
+        }
+        header, err := tar.FileInfoHeader(info, link)
+        if err != nil {
+            return err
+        }
+        header.Name = filepath.ToSlash(rel)
+        if info.IsDir() {
+            header.Name += "/"
+        }
+        return visit(path, header.Name, header)
+    })
+}
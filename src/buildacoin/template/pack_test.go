@@ -0,0 +1,60 @@
+package template
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestPackDir(t *testing.T) {
+    dir, err := ioutil.TempDir("", "buildacoin-pack-")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer os.RemoveAll(dir)
+    os.MkdirAll(filepath.Join(dir, "__._1-", "src"), 0755)
+    ioutil.WriteFile(filepath.Join(dir, "__._1-", "src", "main.h"),
+            []byte("#define NAME __._2-\n"), 0600)
+    ioutil.WriteFile(filepath.Join(dir, "logo.png"),
+            []byte("\x00__._3-"), 0644)
+
+    found, err := ScanDir(dir, DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    // markers in binary files aren't substituted
+    if len(found) != 2 || len(found[1]) != 3 ||
+            found[2][0] != "__._1-/src/main.h" {
+        t.Fatalf("unexpected markers %v\n", found)
+    }
+
+    format := Format { TarArchive, GzipCompression }
+    packs := make([][]byte, 0)
+    for ii := 0; ii < 2; ii++ {
+        output := new(bytes.Buffer)
+        err = PackDir(output, dir, format)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        packs = append(packs, output.Bytes())
+        // a different time mustn't change the archive
+        os.Chtimes(filepath.Join(dir, "logo.png"), time.Now(),
+                time.Unix(1400000000, 0))
+    }
+    if !bytes.Equal(packs[0], packs[1]) {
+        t.Fatal("packed archives differ")
+    }
+    packedFound, err := ScanArchive(bytes.NewReader(packs[0]), format,
+            DefaultMarkers)
+    if err != nil || len(packedFound) != 2 || len(packedFound[1]) != 3 {
+        t.Fatalf("unexpected markers in archive %v %v\n", packedFound, err)
+    }
+    contents := readArchive(t, packs[0], format)
+    if contents["__._1-/src/main.h"] != "#define NAME __._2-\n" ||
+            contents["__._1-/"] != "drwxr-xr-x" {
+        t.Fatalf("unexpected contents %v\n", contents)
+    }
+}
@@ -0,0 +1,568 @@
+package template
+
+import (
+    "archive/tar"
+    "bytes"
+    "errors"
+    "io"
+    "io/ioutil"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Kinds of drift found while recovering values from a coin's source
+const (
+    // lines of a file that aren't the template's, or a binary file or symlink
+    // that isn't the template's
+    DriftChanged = "changed"
+    // a template file with no counterpart in the source
+    DriftMissing = "missing"
+    // a source file with no counterpart in the template
+    DriftExtra = "extra"
+    // a substitution whose value differs from place to place
+    DriftConflict = "conflict"
+    // a template file with sections, which can't be aligned line by line
+    DriftSections = "sections"
+)
+
+// A place where a coin's source differs from its template other than at the
+// markers
+type Drift struct {
+    Kind string
+    // name of the file in the source, or in the template if it's missing
+    File string
+    // first line of the drift in the source, or 0 for the whole file
+    Line int
+    Detail string
+}
+func (tt Drift) String() string {
+    where := tt.File
+    if tt.Line > 0 {
+        where += ":" + strconv.Itoa(tt.Line)
+    }
+    if tt.Detail == "" {
+        return tt.Kind + " " + where
+    }
+    return tt.Kind + " " + where + ": " + tt.Detail
+}
+
+// The values recovered from a coin's source, and where it drifted from its
+// template
+type Recovery struct {
+    Values FilterMap
+    Drift []Drift
+}
+
+// Recover the values a coin was made with from its source, by aligning each
+// of its files with the template file whose name matches and taking the text
+// found at each marker.  Values are taken from plain markers; encoded markers
+// and repeated values are checked against them.  Values containing line
+// breaks can't be recovered, and the lines around them drift.
+//
+// Template files are matched under the names the rules give them, and the
+// files the rules generate are matched as if the template had them.  Files the
+// rules may exclude or generate aren't missing if the source lacks them, and
+// files they may replace are matched but not aligned.  Encoded markers of the
+// substitutions in hexValues are checked taking their values as hex bytes.
+func Recover(tmpl io.Reader, tmplFormat Format, src io.Reader,
+        srcFormat Format, markers Markers, rules FileRules,
+        hexValues map[uint]bool) (*Recovery, error) {
+    err := markers.Check()
+    if err != nil {
+        return nil, err
+    }
+    markers = markers.orDefault()
+    tmplEntries, err := readEntries(tmpl, tmplFormat)
+    if err != nil {
+        return nil, err
+    }
+    srcEntries, err := readEntries(src, srcFormat)
+    if err != nil {
+        return nil, err
+    }
+    // whether the source may lack each template file, or have it replaced
+    optional := make([]bool, len(tmplEntries))
+    replaced := make([]bool, len(tmplEntries))
+    for ii, entry := range tmplEntries {
+        for _, rule := range rules.Exclude {
+            optional[ii] = optional[ii] || rule.Path.MatchString(entry.name)
+        }
+        for _, file := range rules.Replace {
+            replaced[ii] = replaced[ii] || file.Name == entry.name
+        }
+        tmplEntries[ii].name = rules.rename(entry.name)
+    }
+    for _, file := range rules.Generate {
+        tmplEntries = append(tmplEntries, recoverEntry { name: file.Name,
+                typeflag: tar.TypeReg, mode: 0644,
+                body: []byte(file.Contents) })
+        optional = append(optional, len(file.If) > 0)
+        replaced = append(replaced, false)
+    }
+    recovery := &recoverer { markers: markers, hexValues: hexValues,
+            found: make(map[uint][]observation) }
+    output := &Recovery { Values: make(FilterMap) }
+
+    // template names are patterns matching source names
+    namePatterns := make([][]piece, len(tmplEntries))
+    candidates := make([][]int, len(tmplEntries))
+    for ii, entry := range tmplEntries {
+        namePatterns[ii], err = recovery.parse([]byte(entry.name), entry.name)
+        if err != nil {
+            return nil, err
+        }
+        for jj, srcEntry := range srcEntries {
+            _, ok := matchPattern(namePatterns[ii], []byte(srcEntry.name))
+            if ok && srcEntry.typeflag == entry.typeflag {
+                candidates[ii] = append(candidates[ii], jj)
+            }
+        }
+    }
+    // names only one source file matches decide which file others go with
+    for ii, matches := range candidates {
+        if len(matches) == 1 {
+            recovery.observeLine(namePatterns[ii],
+                    []byte(srcEntries[matches[0]].name), "", 0)
+        }
+    }
+    provisional, _ := recovery.resolve()
+    recovery.found = make(map[uint][]observation)
+
+    claimed := make([]bool, len(srcEntries))
+    for ii, entry := range tmplEntries {
+        match := -1
+        name, ok := render(namePatterns[ii], provisional)
+        for _, jj := range candidates[ii] {
+            if claimed[jj] {
+                continue
+            }
+            if ok && srcEntries[jj].name == string(name) {
+                match = jj
+                break
+            }
+            if match < 0 {
+                match = jj
+            }
+        }
+        if match < 0 {
+            if !optional[ii] {
+                output.Drift = append(output.Drift,
+                        Drift { DriftMissing, entry.name, 0, "" })
+            }
+            continue
+        }
+        claimed[match] = true
+        srcEntry := srcEntries[match]
+        recovery.observeLine(namePatterns[ii], []byte(srcEntry.name),
+                srcEntry.name, 0)
+        if replaced[ii] {
+            continue
+        }
+        drift, err := recovery.align(entry, srcEntry)
+        if err != nil {
+            return nil, err
+        }
+        output.Drift = append(output.Drift, drift...)
+    }
+    extras := make([]string, 0)
+    for jj, srcEntry := range srcEntries {
+        if !claimed[jj] {
+            extras = append(extras, srcEntry.name)
+        }
+    }
+    sort.Strings(extras)
+    for _, name := range extras {
+        output.Drift = append(output.Drift,
+                Drift { DriftExtra, name, 0, "" })
+    }
+
+    var conflicts []Drift
+    output.Values, conflicts = recovery.resolve()
+    output.Drift = append(output.Drift, conflicts...)
+    return output, nil
+}
+
+// A file or symlink of a template or a coin's source
+type recoverEntry struct {
+    name string
+    typeflag byte
+    linkname string
+    mode int64
+    body []byte
+}
+
+// Read the files and symlinks of an archive.  Directories follow from the
+// names of their contents, and the manifest of a generated coin is no part of
+// its template.  Hard links are read as copies of the files they link to,
+// which must come before them.
+func readEntries(src io.Reader, format Format) ([]recoverEntry, error) {
+    archiveIn, inCloser, err := openArchive(src, format,
+            DefaultSpillThreshold)
+    if err != nil {
+        return nil, err
+    }
+    defer inCloser.Close()
+
+    output := make([]recoverEntry, 0)
+    // positions in output of the regular files, by name
+    files := make(map[string]int)
+    for {
+        header, err := archiveIn.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+        // archives made by hand often name entries from "."
+        name := strings.TrimPrefix(header.Name, "./")
+        if name == ManifestName || name == SignatureName {
+            continue
+        }
+        entry := recoverEntry { name, header.Typeflag,
+                header.Linkname, header.Mode, nil }
+        switch header.Typeflag {
+        case tar.TypeReg, tar.TypeRegA:
+            entry.typeflag = tar.TypeReg
+            entry.body, err = ioutil.ReadAll(archiveIn)
+            if err != nil {
+                return nil, err
+            }
+            files[name] = len(output)
+        case tar.TypeLink:
+            target, ok := files[strings.TrimPrefix(header.Linkname, "./")]
+            if !ok {
+                return nil, ErrLinkTarget { name, header.Linkname }
+            }
+            entry.typeflag, entry.linkname = tar.TypeReg, ""
+            entry.body = output[target].body
+            files[name] = len(output)
+        case tar.TypeSymlink:
+            break
+        default:
+            continue
+        }
+        output = append(output, entry)
+    }
+    return output, nil
+}
+
+// A run of literal text or a marker in a template
+type piece struct {
+    marker bool
+    literal []byte
+    idx uint
+    encoding string
+}
+
+// A value found at a marker
+type observation struct {
+    value []byte
+    encoding string
+    // where the marker is in the source, line 0 for the file's name
+    file string
+    line int
+}
+
+// collector of the values found in a coin's source
+type recoverer struct {
+    markers Markers
+    hexValues map[uint]bool
+    found map[uint][]observation
+}
+
+// Parse template text into pieces.  Section markers can't be recovered from,
+// and are reported as errSections.
+func (tt *recoverer) parse(text []byte, name string) ([]piece, error) {
+    lead, tail := []byte(tt.markers.Lead), []byte(tt.markers.Tail)
+    output := make([]piece, 0)
+    literal := make([]byte, 0)
+    offset := 0
+    for {
+        start := bytes.Index(text[offset:], lead)
+        if start < 0 {
+            literal = append(literal, text[offset:]...)
+            break
+        }
+        literal = append(literal, text[offset:offset + start]...)
+        markOffset := offset + start
+        ii := markOffset + len(lead)
+        if ii < len(text) && text[ii] == EscapeDirective {
+            literal = append(literal, lead...)
+            offset = ii + 1
+            continue
+        }
+        if ii < len(text) {
+            switch text[ii] {
+            case IfDirective, UnlessDirective, ElseDirective,
+                    RepeatDirective, EndDirective:
+                return nil, errSections
+            }
+        }
+        digits := ii
+        for ii < len(text) && text[ii] >= '0' && text[ii] <= '9' &&
+                ii - digits < MaxMarkDigits {
+            ii++
+        }
+        idx, err := strconv.Atoi(string(text[digits:ii]))
+        if err != nil {
+            return nil, &TemplateError { "malformed marker",
+                    uint64(markOffset), name }
+        }
+        encoding := ""
+        if ii < len(text) && text[ii] == EncodingSeparator {
+            ii++
+            start := ii
+            for ii < len(text) && isEncodingChar(text[ii]) {
+                ii++
+            }
+            encoding = string(text[start:ii])
+        }
+        if !bytes.HasPrefix(text[ii:], tail) {
+            return nil, &TemplateError { "malformed marker",
+                    uint64(markOffset), name }
+        }
+        if len(literal) > 0 {
+            output = append(output, piece { literal: literal })
+            literal = make([]byte, 0)
+        }
+        output = append(output, piece { marker: true, idx: uint(idx),
+                encoding: encoding })
+        offset = ii + len(tail)
+    }
+    if len(literal) > 0 {
+        output = append(output, piece { literal: literal })
+    }
+    return output, nil
+}
+
+// Match text against a pattern of pieces, returning the text found at each
+// marker.  Values are tried shortest first.
+func matchPattern(pieces []piece, text []byte) ([][]byte, bool) {
+    if len(pieces) == 0 {
+        return nil, len(text) == 0
+    }
+    head := pieces[0]
+    if !head.marker {
+        if !bytes.HasPrefix(text, head.literal) {
+            return nil, false
+        }
+        return matchPattern(pieces[1:], text[len(head.literal):])
+    }
+    for end := 0; end <= len(text); end++ {
+        if len(pieces) > 1 && !pieces[1].marker &&
+                !bytes.HasPrefix(text[end:], pieces[1].literal) {
+            continue
+        }
+        rest, ok := matchPattern(pieces[1:], text[end:])
+        if ok {
+            return append([][]byte { text[:end] }, rest...), true
+        }
+    }
+    return nil, false
+}
+
+// Fill a pattern in with values, if they're all known and plain.
+func render(pieces []piece, values FilterMap) ([]byte, bool) {
+    output := make([]byte, 0)
+    for _, piece := range pieces {
+        if !piece.marker {
+            output = append(output, piece.literal...)
+            continue
+        }
+        value, ok := values[piece.idx]
+        if !ok || piece.encoding != "" {
+            return nil, false
+        }
+        output = append(output, value...)
+    }
+    return output, true
+}
+
+// Note the values at the markers of a pattern matching text.
+func (tt *recoverer) observeLine(pieces []piece, text []byte, file string,
+        line int) {
+    values, ok := matchPattern(pieces, text)
+    if !ok {
+        return
+    }
+    ii := 0
+    for _, piece := range pieces {
+        if piece.marker {
+            tt.found[piece.idx] = append(tt.found[piece.idx],
+                    observation { values[ii], piece.encoding, file, line })
+            ii++
+        }
+    }
+}
+
+// Align a source entry with its template entry, noting the values at the
+// markers and returning the drift.
+func (tt *recoverer) align(tmpl, src recoverEntry) ([]Drift, error) {
+    if tmpl.typeflag == tar.TypeSymlink {
+        pattern, err := tt.parse([]byte(tmpl.linkname), tmpl.name)
+        if err != nil {
+            return nil, err
+        }
+        if _, ok := matchPattern(pattern, []byte(src.linkname)); !ok {
+            return []Drift { Drift { DriftChanged, src.name, 0,
+                    "symlink to " + src.linkname } }, nil
+        }
+        tt.observeLine(pattern, []byte(src.linkname), src.name, 0)
+        return nil, nil
+    }
+
+    if IsBinary(sniffed(tmpl.body)) || IsBinary(sniffed(src.body)) {
+        if !bytes.Equal(tmpl.body, src.body) {
+            return []Drift { Drift { DriftChanged, src.name, 0,
+                    "binary file differs" } }, nil
+        }
+        return nil, nil
+    }
+
+    tmplLines, srcLines := splitLines(tmpl.body), splitLines(src.body)
+    patterns := make([][]piece, len(tmplLines))
+    offset := 0
+    for ii, line := range tmplLines {
+        var err error
+        patterns[ii], err = tt.parse(line, tmpl.name)
+        if err == errSections {
+            return []Drift { Drift { DriftSections, src.name, 0, "" } }, nil
+        }
+        if err, ok := err.(*TemplateError); ok {
+            err.index += uint64(offset)
+            return nil, err
+        }
+        offset += len(line)
+    }
+    marked := func(ii int) bool {
+        return len(patterns[ii]) != 1 || patterns[ii][0].marker
+    }
+    ops := editScript(len(tmplLines), len(srcLines), func(ii, jj int) bool {
+        if !marked(ii) {
+            return bytes.Equal(patterns[ii][0].literal, srcLines[jj])
+        }
+        _, ok := matchPattern(patterns[ii], srcLines[jj])
+        return ok
+    })
+
+    drift := make([]Drift, 0)
+    // the source line before the current run of changes
+    srcLine := 0
+    for ii := 0; ii < len(ops); ii++ {
+        if ops[ii].kind == ' ' {
+            srcLine = ops[ii].after + 1
+            if marked(ops[ii].before) {
+                tt.observeLine(patterns[ops[ii].before],
+                        srcLines[ops[ii].after], src.name, srcLine)
+            }
+            continue
+        }
+        removed, added := 0, 0
+        for ; ii < len(ops) && ops[ii].kind != ' '; ii++ {
+            if ops[ii].kind == '-' {
+                removed++
+            } else {
+                added++
+            }
+        }
+        ii--
+        drift = append(drift, Drift { DriftChanged, src.name, srcLine + 1,
+                changedLines(removed, added) })
+    }
+    return drift, nil
+}
+
+// Get the start of a file, as the runners look at it to decide whether it's
+// binary.
+func sniffed(body []byte) []byte {
+    if len(body) > SniffLen {
+        return body[:SniffLen]
+    }
+    return body
+}
+
+// Describe a run of changed lines.
+func changedLines(removed, added int) string {
+    plural := func(count int) string {
+        if count == 1 {
+            return "1 line"
+        }
+        return strconv.Itoa(count) + " lines"
+    }
+    switch {
+    case removed == 0:
+        return plural(added) + " added"
+    case added == 0:
+        return plural(removed) + " of the template removed"
+    }
+    return plural(removed) + " of the template replaced by " + plural(added)
+}
+
+// Decide the value of each substitution from what was found at its plain
+// markers, most often found first, and report the places that disagree.
+// Encoded markers only confirm values.
+func (tt *recoverer) resolve() (FilterMap, []Drift) {
+    values := make(FilterMap)
+    drift := make([]Drift, 0)
+    indices := make([]int, 0, len(tt.found))
+    for idx := range tt.found {
+        indices = append(indices, int(idx))
+    }
+    sort.Ints(indices)
+    for _, idx := range indices {
+        found := tt.found[uint(idx)]
+        counts := make(map[string]int)
+        best := ""
+        for _, seen := range found {
+            if seen.encoding != "" {
+                continue
+            }
+            counts[string(seen.value)]++
+            if counts[string(seen.value)] > counts[best] {
+                best = string(seen.value)
+            }
+        }
+        if len(counts) == 0 {
+            continue
+        }
+        values[uint(idx)] = []byte(best)
+
+        for _, seen := range found {
+            expected := []byte(best)
+            if seen.encoding != "" {
+                encode, ok := Encodings[seen.encoding]
+                if !ok {
+                    continue
+                }
+                var err error
+                expected, err = encode(expected, tt.hexValues[uint(idx)])
+                if err != nil {
+                    expected = nil
+                }
+            }
+            if !bytes.Equal(seen.value, expected) {
+                drift = append(drift, Drift { DriftConflict, seen.file,
+                        seen.line,
+                        "substitution " + strconv.Itoa(idx) + " is '" +
+                        string(seen.value) + "' here, not '" +
+                        string(expected) + "'" })
+            }
+        }
+    }
+    return values, drift
+}
+
+//
+// Errors
+//
+
+// Error when a template line has section markers
+var errSections error = errors.New("sections can't be recovered")
+
+type ErrLinkTarget struct { Name string; Target string }
+func (tt ErrLinkTarget) Error() string {
+    return "'" + tt.Name + "' links to '" + tt.Target + "', which isn't a " +
+            "file before it"
+}
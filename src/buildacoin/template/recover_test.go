@@ -0,0 +1,98 @@
+package template
+
+import (
+    "regexp"
+    "testing"
+)
+
+func TestRecover(t *testing.T) {
+    tmpl := testTar([][]string {
+        []string { "__._1-/main.h",
+                "#define NAME \"__._1-\"\n#define TICKER __._2-\n" +
+                "// __._\\ escaped\nint port = __._3.hex-;\n" },
+        []string { "__._1-/net.h", "int port = __._3-;\nint x;\n" },
+        []string { "__._1-/gone.h", "gone\n" },
+    })
+    src := testTar([][]string {
+        []string { "./bestcoin/main.h",
+                "#define NAME \"bestcoin\"\n#define TICKER BST\n" +
+                "// __._ escaped\nint port = 0x10;\n" },
+        []string { "./bestcoin/net.h",
+                "int port = 9333;\nint y;\nint z;\n" },
+        []string { "./bestcoin/extra.h", "extra\n" },
+    })
+    recovery, err := Recover(tmpl, Format { TarArchive, Uncompressed }, src,
+            Format { TarArchive, Uncompressed }, DefaultMarkers, FileRules {},
+            nil)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := map[uint]string { 1: "bestcoin", 2: "BST", 3: "9333" }
+    if len(recovery.Values) != len(expected) {
+        t.Fatalf("unexpected values %v\n", recovery.Values)
+    }
+    for idx, value := range expected {
+        if string(recovery.Values[idx]) != value {
+            t.Fatalf("substitution %d: expected '%s' / actual '%s'\n", idx,
+                    value, recovery.Values[idx])
+        }
+    }
+    drift := []string {
+        "changed bestcoin/net.h:2: 1 line of the template replaced by 2 " +
+                "lines",
+        "missing __._1-/gone.h",
+        "extra bestcoin/extra.h",
+        "conflict bestcoin/main.h:4: substitution 3 is '0x10' here, not " +
+                "'2475'",
+    }
+    if len(recovery.Drift) != len(drift) {
+        t.Fatalf("unexpected drift %v\n", recovery.Drift)
+    }
+    for ii, expected := range drift {
+        if recovery.Drift[ii].String() != expected {
+            t.Fatalf("drift %d: expected '%s' / actual '%s'\n", ii, expected,
+                    recovery.Drift[ii].String())
+        }
+    }
+}
+
+func TestRecoverRules(t *testing.T) {
+    tmpl := testTar([][]string {
+        []string { "coin/qt/main.cpp", "int main();\n" },
+        []string { "coin/bitcoin.pro", "TARGET = __._1-\n" },
+        []string { "coin/icon.png", "\x89PNG\x00old" },
+    })
+    src := testTar([][]string {
+        []string { "coin/best.pro", "TARGET = best\n" },
+        []string { "coin/icon.png", "\x89PNG\x00new" },
+        []string { "coin/PARAMETERS", "name: best\nticker: BST\n" },
+    })
+    rules := FileRules {
+        Exclude: []ExcludeRule {
+            ExcludeRule { regexp.MustCompile("^coin/qt/"), []uint { 2 } },
+        },
+        Rename: []RenameRule {
+            RenameRule { regexp.MustCompile("/bitcoin\\.pro$"),
+                    "/__._1-.pro" },
+        },
+        Generate: []GeneratedFile {
+            GeneratedFile { "coin/PARAMETERS",
+                    "name: __._1-\nticker: __._3-\n", nil },
+        },
+        Replace: []ReplacedFile {
+            ReplacedFile { "coin/icon.png", 4, nil },
+        },
+    }
+    recovery, err := Recover(tmpl, Format { TarArchive, Uncompressed }, src,
+            Format { TarArchive, Uncompressed }, DefaultMarkers, rules, nil)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(recovery.Drift) != 0 {
+        t.Fatalf("unexpected drift %v\n", recovery.Drift)
+    }
+    if string(recovery.Values[1]) != "best" ||
+            string(recovery.Values[3]) != "BST" {
+        t.Fatalf("unexpected values %v\n", recovery.Values)
+    }
+}
@@ -0,0 +1,150 @@
+package template
+
+import (
+    "archive/tar"
+    "io/ioutil"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Rules for the files of a template beyond filtering their names and
+// contents, applied by runners as they go.  Paths are matched against names
+// as they are in the template, before they're filtered.
+type FileRules struct {
+    Exclude []ExcludeRule
+    Rename []RenameRule
+    Generate []GeneratedFile
+    Replace []ReplacedFile
+}
+
+// Files left out of the output
+type ExcludeRule struct {
+    Path *regexp.Regexp
+    // Boolean substitutions which keep the files if all true; if none, the
+    // files are always left out
+    Unless []uint
+}
+
+// A change to the names of files, made before they're filtered.  Rules apply
+// in order, each to the name the last gave.
+type RenameRule struct {
+    Path *regexp.Regexp
+    // Replacement of each match, expanded as by regexp.ReplaceAllString
+    To string
+}
+
+// A file added to the output after the template's own
+type GeneratedFile struct {
+    // Name and contents, both filtered as template text
+    Name string
+    Contents string
+    // Boolean substitutions which must all be true for the file to be added
+    If []uint
+}
+
+// A file whose contents are drawn from a substitution's value rather than
+// filtered, such as an icon drawn from a logo.  Files are left as they are
+// when the value is empty.
+type ReplacedFile struct {
+    // Template name of the file
+    Name string
+    Idx uint
+    Draw func(value []byte) ([]byte, error)
+}
+
+// Check whether the file with the template name is left out of the output.
+func (tt FileRules) excluded(name string, values FilterMap) (bool, error) {
+    for _, rule := range tt.Exclude {
+        if !rule.Path.MatchString(name) {
+            continue
+        }
+        if len(rule.Unless) == 0 {
+            return true, nil
+        }
+        keep, err := allTrue(rule.Unless, values)
+        if err != nil || !keep {
+            return true, err
+        }
+    }
+    return false, nil
+}
+
+// Get the template name the file with the template name is output under.
+func (tt FileRules) rename(name string) string {
+    for _, rule := range tt.Rename {
+        name = rule.Path.ReplaceAllString(name, rule.To)
+    }
+    return name
+}
+
+// Get the contents replacing those of the file with the template name, if
+// any.
+func (tt FileRules) replacement(name string,
+        values FilterMap) ([]byte, bool, error) {
+    for _, file := range tt.Replace {
+        if file.Name != name || len(values[file.Idx]) == 0 {
+            continue
+        }
+        body, err := file.Draw(values[file.Idx])
+        if err != nil {
+            return nil, false, ErrFilterFailure { name, err }
+        }
+        return body, true, nil
+    }
+    return nil, false, nil
+}
+
+// Filter the files to be added to the output, as entries holding their
+// filtered names and contents.
+func (tt FileRules) generated(values FilterMap, markers Markers,
+        hexValues map[uint]bool) ([]recoverEntry, error) {
+    output := make([]recoverEntry, 0, len(tt.Generate))
+    filter := NewMarkedFilter(nil, values, markers).Hex(hexValues)
+    for _, file := range tt.Generate {
+        add, err := allTrue(file.If, values)
+        if err != nil {
+            return nil, err
+        }
+        if !add {
+            continue
+        }
+        name, err := ioutil.ReadAll(filter.Reset(
+                strings.NewReader(file.Name)).Named(file.Name))
+        if err != nil {
+            return nil, err
+        }
+        body, err := ioutil.ReadAll(filter.Reset(
+                strings.NewReader(file.Contents)).Named(file.Name))
+        if err != nil {
+            return nil, err
+        }
+        output = append(output, recoverEntry { name: string(name),
+                typeflag: tar.TypeReg, mode: 0644, body: body })
+    }
+    return output, nil
+}
+
+// Check whether every one of the boolean substitutions is true.
+func allTrue(indices []uint, values FilterMap) (bool, error) {
+    for _, idx := range indices {
+        value, err := strconv.ParseBool(string(values[idx]))
+        if err != nil {
+            return false, ErrRuleNotBool { idx }
+        }
+        if !value {
+            return false, nil
+        }
+    }
+    return true, nil
+}
+
+//
+// Errors
+//
+
+type ErrRuleNotBool struct { Idx uint }
+func (tt ErrRuleNotBool) Error() string {
+    return "substitution " + strconv.Itoa(int(tt.Idx)) + " of a file rule " +
+            "is not a boolean"
+}
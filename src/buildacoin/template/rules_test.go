@@ -0,0 +1,102 @@
+package template
+
+import (
+    "bytes"
+    "regexp"
+    "testing"
+)
+
+func TestFileRules(t *testing.T) {
+    archive := testTar([][]string {
+        []string { "__._1-/src/main.h", "#define NAME __._1-\n" },
+        []string { "__._1-/src/qt/gui.h", "gui\n" },
+        []string { "__._1-/contrib/gitian.yml", "gitian\n" },
+        []string { "__._1-/bitcoin-qt.pro", "pro\n" },
+        []string { "__._1-/logo.png", "placeholder" },
+    })
+    rules := FileRules {
+        Exclude: []ExcludeRule {
+            ExcludeRule { regexp.MustCompile("^[^/]*/src/qt/"),
+                    []uint { 2 } },
+            ExcludeRule { regexp.MustCompile("^[^/]*/contrib/"), nil },
+        },
+        Rename: []RenameRule {
+            RenameRule { regexp.MustCompile("/bitcoin(-qt\\.pro)$"),
+                    "/__._1-$1" },
+        },
+        Generate: []GeneratedFile {
+            GeneratedFile { "__._1-/PARAMETERS", "name: __._1-\n",
+                    []uint { 3 } },
+            GeneratedFile { "__._1-/UNUSED", "unused\n", []uint { 2 } },
+        },
+        Replace: []ReplacedFile {
+            ReplacedFile { "__._1-/logo.png", 4,
+                    func(value []byte) ([]byte, error) {
+                return append(value, '!'), nil
+            } },
+        },
+    }
+    runner, err := GetRunner(TarArchive, TarArchive, DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    runner.Rules = rules
+    values := FilterMap { 1: []byte("bestcoin"), 2: []byte("false"),
+            3: []byte("true"), 4: []byte("logo") }
+    out := new(bytes.Buffer)
+    err = runner.Run(out, archive, values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    files := readArchive(t, out.Bytes(), Format { TarArchive, Uncompressed })
+    expected := map[string]string {
+        "bestcoin/src/main.h": "#define NAME bestcoin\n",
+        "bestcoin/bestcoin-qt.pro": "pro\n",
+        "bestcoin/PARAMETERS": "name: bestcoin\n",
+        "bestcoin/logo.png": "logo!",
+    }
+    for name, contents := range expected {
+        if files[name] != contents {
+            t.Fatalf("%s: expected '%s' / actual '%s'\n", name, contents,
+                    files[name])
+        }
+    }
+    // along with the manifest and directory
+    if len(files) != len(expected) + 2 {
+        t.Fatalf("unexpected files %v\n", files)
+    }
+
+    // the git runner leaves the template's own commit as it is
+    gitRunner, err := GetGitRunner(TarArchive, "git.tar", DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    gitRunner.Rules = rules
+    out.Reset()
+    err = gitRunner.Run(out, testTar([][]string {
+        []string { "__._1-/src/main.h", "#define NAME __._1-\n" },
+        []string { "__._1-/contrib/gitian.yml", "gitian\n" },
+        []string { "__._1-/bitcoin-qt.pro", "pro\n" },
+        []string { "__._1-/logo.png", "placeholder" },
+    }), values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    files = readArchive(t, out.Bytes(), Format { TarArchive, Uncompressed })
+    for name, contents := range expected {
+        if files[name] != contents {
+            t.Fatalf("%s: expected '%s' / actual '%s'\n", name, contents,
+                    files[name])
+        }
+    }
+    if _, ok := files["bestcoin/contrib/gitian.yml"]; ok {
+        t.Fatal("excluded file in git work tree")
+    }
+
+    err = runner.Run(new(bytes.Buffer), testTar([][]string {
+        []string { "__._1-/src/qt/gui.h", "gui\n" },
+    }), FilterMap { 1: []byte("bestcoin"), 2: []byte("maybe") })
+    if _, ok := err.(ErrRuleNotBool); !ok {
+        t.Fatalf("expected ErrRuleNotBool, got %v\n", err)
+    }
+}
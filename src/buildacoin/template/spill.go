@@ -0,0 +1,128 @@
+package template
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "os"
+)
+
+const (
+    // Size above which a spill moves its contents from memory to a temporary
+    // file, unless a runner says otherwise
+    DefaultSpillThreshold = 4 * 1024 * 1024
+    // Prefix of the names of spill temporary files
+    spillPrefix = "buildacoin-spill-"
+)
+
+// Buffer for a file of unknown size, held in memory up to a threshold and in
+// a temporary file beyond it, so memory use doesn't scale with the file
+type spill struct {
+    threshold int64
+    buf bytes.Buffer
+    file *os.File
+    size int64
+}
+
+// Construct an empty spill.
+func newSpill(threshold int64) *spill {
+    return &spill { threshold: threshold }
+}
+
+func (tt *spill) Write(data []byte) (int, error) {
+    if tt.file == nil && tt.size + int64(len(data)) > tt.threshold {
+        file, err := ioutil.TempFile("", spillPrefix)
+        if err != nil {
+            return 0, err
+        }
+        tt.file = file
+        _, err = tt.buf.WriteTo(file)
+        if err != nil {
+            return 0, err
+        }
+    }
+    var count int
+    var err error
+    if tt.file != nil {
+        count, err = tt.file.Write(data)
+    } else {
+        count, err = tt.buf.Write(data)
+    }
+    tt.size += int64(count)
+    return count, err
+}
+
+// Get the number of bytes written to the spill.
+func (tt *spill) Size() int64 {
+    return tt.size
+}
+
+// Get a reader of the spill's contents from the beginning.  Writing to the
+// spill again invalidates the reader.
+func (tt *spill) Reader() (io.Reader, error) {
+    if tt.file == nil {
+        return bytes.NewReader(tt.buf.Bytes()), nil
+    }
+    _, err := tt.file.Seek(0, os.SEEK_SET)
+    if err != nil {
+        return nil, err
+    }
+    return io.LimitReader(tt.file, tt.size), nil
+}
+
+// Get random access to the spill's contents.  Writing to the spill again
+// invalidates the reader.
+func (tt *spill) ReaderAt() io.ReaderAt {
+    if tt.file == nil {
+        return bytes.NewReader(tt.buf.Bytes())
+    }
+    return tt.file
+}
+
+// Empty the spill for reuse, removing any temporary file.
+func (tt *spill) Reset() error {
+    tt.buf.Reset()
+    tt.size = 0
+    return tt.Close()
+}
+
+// Remove any temporary file.
+func (tt *spill) Close() error {
+    if tt.file == nil {
+        return nil
+    }
+    name := tt.file.Name()
+    tt.file.Close()
+    tt.file = nil
+    return os.Remove(name)
+}
+
+// Writer noting whether a byte sequence is written to it, including across
+// writes
+type seqDetector struct {
+    seq []byte
+    // last bytes written, short of a whole seq
+    tail []byte
+    seen bool
+}
+
+func (tt *seqDetector) Write(data []byte) (int, error) {
+    if tt.seen {
+        return len(data), nil
+    }
+    keep := len(tt.seq) - 1
+    head := data
+    if len(head) > keep {
+        head = head[:keep]
+    }
+    boundary := append(append([]byte {}, tt.tail...), head...)
+    tt.seen = bytes.Contains(boundary, tt.seq) || bytes.Contains(data, tt.seq)
+    if len(data) >= keep {
+        tt.tail = append(tt.tail[:0], data[len(data) - keep:]...)
+    } else if len(boundary) > keep {
+        tt.tail = append(tt.tail[:0], boundary[len(boundary) - keep:]...)
+    } else {
+        tt.tail = boundary
+    }
+    return len(data), nil
+}
@@ -2,127 +2,102 @@ package template
 
 import (
     "archive/tar"
+    "compress/bzip2"
     "compress/gzip"
+    "github.com/klauspost/compress/zstd"
+    "github.com/ulikunitz/xz"
     "io"
     "io/ioutil"
-    "strings"
 )
 
-const Uncompressed = ""
-
-type TarRunner struct {
-    Compression string
-}
-
-func (tt TarRunner) Run(dst io.Writer, src io.Reader, values FilterMap) error {
-    // wrap with appropriate compression
-    var (
-        compressIn io.ReadCloser
-        compressOut io.WriteCloser
-    )
-    switch tt.Compression {
-    case "gz":
-        var err error
-        compressIn, err = gzip.NewReader(src)
+// Open a tar archive, decompressing it as needed.
+func openTar(src io.Reader, compression string) (archiveReader, io.Closer,
+        error) {
+    var compressIn io.ReadCloser
+    switch compression {
+    case GzipCompression:
+        reader, err := gzip.NewReader(src)
+        if err != nil {
+            return nil, nil, err
+        }
+        compressIn = reader
+    case Bzip2Compression:
+        compressIn = ioutil.NopCloser(bzip2.NewReader(src))
+    case XzCompression:
+        reader, err := xz.NewReader(src)
+        if err != nil {
+            return nil, nil, err
+        }
+        compressIn = ioutil.NopCloser(reader)
+    case ZstdCompression:
+        reader, err := zstd.NewReader(src)
         if err != nil {
-            return err
+            return nil, nil, err
         }
-        compressOut = gzip.NewWriter(dst)
+        compressIn = reader.IOReadCloser()
     case Uncompressed:
         compressIn = ioutil.NopCloser(src)
-        compressOut = WriterNopCloser(dst)
     default:
-        return ErrUnknCompress { tt.Compression }
+        return nil, nil, ErrUnknCompress { compression }
     }
-    defer func() {
-        compressIn.Close()
-        compressOut.Close()
-    }()
-
-    // open tar streams
-    tarIn := tar.NewReader(compressIn)
-    tarOut := tar.NewWriter(compressOut)
-    defer func() {
-        tarOut.Close()
-    }()
+    return tar.NewReader(compressIn), compressIn, nil
+}
 
-    // initialize filter with a null reader; it will be reset for each stream
-    // in the tar
-    filter := NewFilter(nil, values)
+// gzip header OS for an unknown operating system
+const gzipUnknownOS = 255
 
-    // the comm channel is used to allow writing out to the tar concurrently
-    // while templating the next file.  Since the first file has no previous
-    // file to wait for, start a goroutine giving the first file the go-ahead
-    // immediately
-    comm := make(chan error)
-    go func() {
-        comm <- nil
-    }()
+// tar archive writer, closing its compression once the archive is closed
+type tarWriter struct {
+    *tar.Writer
+    compressOut io.WriteCloser
+}
 
-    filterloop:
-    for {
-        // get next file from tar
-        header, err := tarIn.Next()
-        if err == io.EOF {
-            // wait for the previous write-out to finish
-            err = <-comm
-            if err != nil {
-                return err
-            }
-            break filterloop
+// Create a tar archive, compressing it as needed.  Deterministic compression
+// leaves the time and name out of gzip headers, and compresses zstd on one
+// thread.
+func createTar(dst io.Writer, compression string,
+        deterministic bool) (archiveWriter, error) {
+    var compressOut io.WriteCloser
+    switch compression {
+    case GzipCompression:
+        writer := gzip.NewWriter(dst)
+        if deterministic {
+            writer.Header = gzip.Header { OS: gzipUnknownOS }
         }
+        compressOut = writer
+    case Bzip2Compression:
+        return nil, ErrUnwritableFormat { TarArchive + "." + compression }
+    case XzCompression:
+        writer, err := xz.NewWriter(dst)
         if err != nil {
-            return err
+            return nil, err
         }
-
-        // filter header
-        buf, _ := ioutil.ReadAll(filter.Reset(strings.NewReader(header.Name)))
-        header.Name = string(buf)
-        buf, _ =
-            ioutil.ReadAll(filter.Reset(strings.NewReader(header.Linkname)))
-        header.Linkname = string(buf)
-
-        // non-streaming filtration of file to get size for tar header.
-        fileData, err := ioutil.ReadAll(filter.Reset(tarIn))
-        if err != nil {
-            return ErrFilterFailure { header.Name, err }
+        compressOut = writer
+    case ZstdCompression:
+        options := []zstd.EOption {}
+        if deterministic {
+            options = append(options, zstd.WithEncoderConcurrency(1))
         }
-        header.Size = int64(len(fileData))
-
-        // wait for the previous write-out to finish
-        err = <-comm
+        writer, err := zstd.NewWriter(dst, options...)
         if err != nil {
-            return err
+            return nil, err
         }
-
-        // write out to tar
-        go func(header *tar.Header, body []byte) {
-            var err error
-            defer func() {
-                comm <- err
-            }()
-            err = tarOut.WriteHeader(header)
-            if err != nil {
-                return
-            }
-            _, err = tarOut.Write(body)
-        }(header, fileData)
+        compressOut = writer
+    case Uncompressed:
+        compressOut = WriterNopCloser(dst)
+    default:
+        return nil, ErrUnknCompress { compression }
     }
-
-    return nil
+    return tarWriter { tar.NewWriter(compressOut), compressOut }, nil
 }
 
-//
-// Errors
-//
-
-type ErrUnknCompress struct { Compression string }
-func (tt ErrUnknCompress) Error() string {
-    return "unknown compression format '" + tt.Compression + "'"
-}
-type ErrFilterFailure struct { templName string; cause error }
-func (tt ErrFilterFailure) Error() string {
-    return "failed to filter '" + tt.templName + "': " + tt.cause.Error()
+func (tt tarWriter) Close() error {
+    err := tt.Writer.Close()
+    compressErr := tt.compressOut.Close()
+    if err != nil {
+        return err
+    }
+    return compressErr
 }
 
 //
@@ -133,7 +108,7 @@ type nopWriteCloser struct {
     io.Writer
 }
 func (tt nopWriteCloser) Write(buf []byte) (int, error) {
-    return tt.Write(buf)
+    return tt.Writer.Write(buf)
 }
 func (tt nopWriteCloser) Close() error {
     return nil
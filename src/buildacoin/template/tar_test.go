@@ -1,11 +1,20 @@
 package template
 
 import (
+    "archive/tar"
+    "bytes"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "io/ioutil"
+    "strings"
     "testing"
+    "time"
 )
 
 func TestTarCompress(t *testing.T) {
-    unknown := TarRunner { "lolpression" }
+    unknown := ArchiveRunner { Input: Format { TarArchive, "lolpression" } }
 
     switch unknown.Run(nil, nil, nil).(type) {
     case ErrUnknCompress:
@@ -14,3 +23,454 @@ func TestTarCompress(t *testing.T) {
         t.Fatal("tar runner failed to catch weird compression")
     }
 }
+
+func TestFormats(t *testing.T) {
+    for _, format := range []string { "rar", "tar.lz", "zip.gz", "" } {
+        _, err := GetRunner(format, "", DefaultMarkers)
+        if err == nil {
+            t.Fatalf("format '%s' should have failed\n", format)
+        }
+    }
+    _, err := GetRunner("tar.gz", "tar.bz2", DefaultMarkers)
+    if _, ok := err.(ErrUnwritableFormat); !ok {
+        t.Fatalf("expected unwritable format error, got %v\n", err)
+    }
+    _, err = GetRunner("tar.bz2", "zip", DefaultMarkers)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+}
+
+func TestBadEntryName(t *testing.T) {
+    // names and link targets are filtered as templates are
+    for _, header := range []*tar.Header {
+        &tar.Header { Name: "__._1/", Typeflag: tar.TypeDir },
+        &tar.Header { Name: "link", Linkname: "__._1x",
+                Typeflag: tar.TypeSymlink },
+    } {
+        archive := new(bytes.Buffer)
+        tarOut := tar.NewWriter(archive)
+        tarOut.WriteHeader(header)
+        tarOut.Close()
+        runner, _ := GetRunner("tar", "tar", DefaultMarkers)
+        err := runner.Run(new(bytes.Buffer), archive,
+                FilterMap { 1: []byte("coin") })
+        if _, ok := err.(*TemplateError); !ok {
+            t.Fatalf("%s: expected template error, got %v\n", header.Name,
+                    err)
+        }
+    }
+}
+
+// Build an uncompressed tar of name, contents pairs.
+func testTar(files [][]string) *bytes.Buffer {
+    tarBuf := new(bytes.Buffer)
+    tarOut := tar.NewWriter(tarBuf)
+    for _, file := range files {
+        tarOut.WriteHeader(&tar.Header { Name: file[0], Mode: 0644,
+                Size: int64(len(file[1])), Typeflag: tar.TypeReg })
+        tarOut.Write([]byte(file[1]))
+    }
+    tarOut.WriteHeader(&tar.Header { Name: "dir/", Mode: 0755,
+            Typeflag: tar.TypeDir })
+    tarOut.Close()
+    return tarBuf
+}
+
+// Read the regular files of an archive into a map of name to contents.
+func readArchive(t *testing.T, archive []byte,
+        format Format) map[string]string {
+    archiveIn, closer, err := openArchive(bytes.NewReader(archive), format,
+            DefaultSpillThreshold)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    defer closer.Close()
+    output := make(map[string]string)
+    for {
+        header, err := archiveIn.Next()
+        if err != nil {
+            break
+        }
+        data, err := ioutil.ReadAll(archiveIn)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        if header.Typeflag == tar.TypeReg {
+            output[header.Name] = string(data)
+        } else {
+            output[header.Name] = header.FileInfo().Mode().String()
+        }
+    }
+    return output
+}
+
+func TestTarStreaming(t *testing.T) {
+    binary := append([]byte("__._1-\x00"), bytes.Repeat([]byte { 0xff },
+            3 * SniffLen)...)
+    big := strings.Repeat("line of text\n", 1000)
+    files := [][]string {
+        { "plain.txt", "no markers at all\n" },
+        { "__._1-.h", "#define NAME __._1-\n" },
+        { "logo.png", string(binary) },
+        { "big.txt", big + "__._1-" },
+        { "bigplain.txt", big },
+        { "empty", "" },
+    }
+    expected := map[string]string {
+        "plain.txt": "no markers at all\n",
+        "coin.h": "#define NAME coin\n",
+        "logo.png": string(binary),
+        "big.txt": big + "coin",
+        "bigplain.txt": big,
+        "empty": "",
+        "dir/": "drwxr-xr-x",
+    }
+
+    // a small threshold to spill the big files
+    tarFormat := Format { TarArchive, Uncompressed }
+    runner := ArchiveRunner { Input: tarFormat, Output: tarFormat,
+            SpillThreshold: 1024 }
+    output := new(bytes.Buffer)
+    err := runner.Run(output, testTar(files), FilterMap { 1 : []byte("coin") })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+
+    // and the manifest
+    contents := readArchive(t, output.Bytes(), tarFormat)
+    if len(contents) != len(expected) + 1 {
+        t.Fatalf("expected %d entries, got %d\n", len(expected) + 1,
+                len(contents))
+    }
+    for name, data := range expected {
+        if contents[name] != data {
+            t.Fatalf("unexpected contents for %s\n", name)
+        }
+    }
+}
+
+func TestArchiveFormats(t *testing.T) {
+    files := [][]string {
+        { "__._1-/readme", "this is __._1-\n" },
+        { "blob", "\x00\x01\x02" },
+    }
+    expected := map[string]string {
+        "coin/readme": "this is coin\n",
+        "blob": "\x00\x01\x02",
+        "dir/": "drwxr-xr-x",
+    }
+    values := FilterMap { 1 : []byte("coin") }
+
+    // every writable format out of tar, and back in again
+    for _, ext := range []string { "zip", "tar", "tar.gz", "tar.xz",
+            "tar.zst" } {
+        runner, err := GetRunner("tar", ext, DefaultMarkers)
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        output := new(bytes.Buffer)
+        err = runner.Run(output, testTar(files), values)
+        if err != nil {
+            t.Fatalf("%s: %s\n", ext, err.Error())
+        }
+        format, _ := ParseFormat(ext)
+        contents := readArchive(t, output.Bytes(), format)
+        for name, data := range expected {
+            if contents[name] != data {
+                t.Fatalf("%s: unexpected contents for %s: '%s'\n", ext, name,
+                        contents[name])
+            }
+        }
+
+        runner, _ = GetRunner(ext, "tar", DefaultMarkers)
+        roundTrip := new(bytes.Buffer)
+        err = runner.Run(roundTrip, bytes.NewReader(output.Bytes()), values)
+        if err != nil {
+            t.Fatalf("%s: %s\n", ext, err.Error())
+        }
+        contents = readArchive(t, roundTrip.Bytes(), Format { TarArchive,
+                Uncompressed })
+        if contents["coin/readme"] != expected["coin/readme"] {
+            t.Fatalf("%s: round trip failed\n", ext)
+        }
+    }
+
+    // tar.bz2 of a.txt holding "name: __._1-\n"
+    bz2, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWTddjKEAAHJ7gMqQA" +
+            "gBAA3eQAAjiAx5ACAggAFRCNRp6j1NMgeiaHoJKJo9Rk0AAB93IehA0EIRPq+s" +
+            "wlYyBDgueZ4675MKpwgV4g/HiSyVDMqlnTUqWrbdnfsyMaCIgFxdyRThQkDddj" +
+            "KE=")
+    runner, _ := GetRunner("tar.bz2", "zip", DefaultMarkers)
+    output := new(bytes.Buffer)
+    err := runner.Run(output, bytes.NewReader(bz2), values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    contents := readArchive(t, output.Bytes(), Format { ZipArchive,
+            Uncompressed })
+    if contents["a.txt"] != "name: coin\n" {
+        t.Fatalf("bad tar.bz2 contents '%s'\n", contents["a.txt"])
+    }
+}
+
+func TestDeterministic(t *testing.T) {
+    // the same files, in a different order, with different times, owners
+    // and permissions
+    base := func(names []string, modTime time.Time, uid int) []byte {
+        tarBuf := new(bytes.Buffer)
+        tarOut := tar.NewWriter(tarBuf)
+        for _, name := range names {
+            data := "file __._1-\n"
+            tarOut.WriteHeader(&tar.Header { Name: name, Mode: 0600,
+                    Size: int64(len(data)), Typeflag: tar.TypeReg,
+                    ModTime: modTime, Uid: uid, Uname: "someone" })
+            tarOut.Write([]byte(data))
+        }
+        tarOut.Close()
+        return tarBuf.Bytes()
+    }
+    first := base([]string { "b", "a", "c/d" }, time.Unix(1400000000, 0), 1000)
+    second := base([]string { "c/d", "b", "a" }, time.Unix(1500000000, 0), 0)
+    values := FilterMap { 1 : []byte("coin") }
+
+    for _, ext := range []string { "zip", "tar", "tar.gz", "tar.xz",
+            "tar.zst" } {
+        runner, _ := GetRunner("tar", ext, DefaultMarkers)
+        outputs := make([][]byte, 0)
+        for _, input := range [][]byte { first, second } {
+            output := new(bytes.Buffer)
+            err := runner.Run(output, bytes.NewReader(input), values)
+            if err != nil {
+                t.Fatalf("%s: %s\n", ext, err.Error())
+            }
+            outputs = append(outputs, output.Bytes())
+        }
+        if !bytes.Equal(outputs[0], outputs[1]) {
+            t.Fatalf("%s: archives differ\n", ext)
+        }
+    }
+
+    // entries are sorted and normalized
+    runner, _ := GetRunner("tar", "tar", DefaultMarkers)
+    output := new(bytes.Buffer)
+    err := runner.Run(output, bytes.NewReader(second), values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tarIn := tar.NewReader(output)
+    for _, name := range []string { "a", "b", "c/d" } {
+        header, err := tarIn.Next()
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+        if header.Name != name || header.Mode != 0644 || header.Uid != 0 ||
+                header.Uname != "" ||
+                !header.ModTime.Equal(DeterministicModTime) {
+            t.Fatalf("unexpected header: %+v\n", header)
+        }
+        data, _ := ioutil.ReadAll(tarIn)
+        if string(data) != "file coin\n" {
+            t.Fatalf("unexpected contents of %s: '%s'\n", name, data)
+        }
+    }
+
+    // hard links come after the entries they link to, whatever their names
+    linked := new(bytes.Buffer)
+    tarOut := tar.NewWriter(linked)
+    tarOut.WriteHeader(&tar.Header { Name: "coin/run.sh", Mode: 0755,
+            Size: 5, Typeflag: tar.TypeReg })
+    tarOut.Write([]byte("run\n\n"))
+    tarOut.WriteHeader(&tar.Header { Name: "coin/hard",
+            Linkname: "coin/run.sh", Typeflag: tar.TypeLink })
+    tarOut.WriteHeader(&tar.Header { Name: "coin/a-hard",
+            Linkname: "coin/zz-hard", Typeflag: tar.TypeLink })
+    tarOut.WriteHeader(&tar.Header { Name: "coin/zz-hard",
+            Linkname: "coin/hard", Typeflag: tar.TypeLink })
+    tarOut.Close()
+    output.Reset()
+    err = runner.Run(output, linked, values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    entries, err := readEntries(output, Format { TarArchive, Uncompressed })
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    names := make([]string, 0)
+    for _, entry := range entries {
+        if string(entry.body) != "run\n\n" {
+            t.Fatalf("unexpected contents of %s: '%s'\n", entry.name,
+                    entry.body)
+        }
+        names = append(names, entry.name)
+    }
+    if strings.Join(names, " ") != "coin/run.sh coin/hard coin/zz-hard " +
+            "coin/a-hard" {
+        t.Fatalf("unexpected order %v\n", names)
+    }
+}
+
+func TestManifest(t *testing.T) {
+    files := [][]string {
+        { "__._1-.h", "#define NAME __._1-\n" },
+        { "blob", "\x00\x01\x02" },
+    }
+    values := FilterMap { 1 : []byte("coin") }
+    seed := bytes.Repeat([]byte { 7 }, ed25519.SeedSize)
+    key := ed25519.NewKeyFromSeed(seed)
+    public := key.Public().(ed25519.PublicKey)
+    otherPublic :=
+        ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public()
+
+    for _, ext := range []string { "zip", "tar.gz" } {
+        format, _ := ParseFormat(ext)
+        runner, _ := GetRunner("tar", ext, DefaultMarkers)
+        unsigned := new(bytes.Buffer)
+        err := runner.Run(unsigned, testTar(files), values)
+        if err != nil {
+            t.Fatalf("%s: %s\n", ext, err.Error())
+        }
+        runner.SigningKey = key
+        signed := new(bytes.Buffer)
+        err = runner.Run(signed, testTar(files), values)
+        if err != nil {
+            t.Fatalf("%s: %s\n", ext, err.Error())
+        }
+
+        // the manifest lists the output files
+        contents := readArchive(t, signed.Bytes(), format)
+        sum := sha256.Sum256([]byte("#define NAME coin\n"))
+        if !strings.Contains(contents[ManifestName],
+                hex.EncodeToString(sum[:]) + "  coin.h\n") {
+            t.Fatalf("%s: unexpected manifest:\n%s\n", ext,
+                    contents[ManifestName])
+        }
+
+        checks := []struct {
+            archive []byte
+            key ed25519.PublicKey
+            expected error
+        } {
+            { unsigned.Bytes(), nil, nil },
+            { signed.Bytes(), nil, nil },
+            { signed.Bytes(), public, nil },
+            { unsigned.Bytes(), public, ErrNoSignature },
+            { signed.Bytes(), otherPublic.(ed25519.PublicKey),
+                    ErrBadSignature },
+        }
+        for ii, check := range checks {
+            err = VerifyArchive(bytes.NewReader(check.archive), format,
+                    check.key)
+            if err != check.expected {
+                t.Fatalf("%s: check %d: expected '%v' / actual '%v'\n", ext,
+                        ii, check.expected, err)
+            }
+        }
+    }
+
+    // entries changed or added after signing, links included
+    linked := testTar(files)
+    tarIn := tar.NewReader(bytes.NewReader(linked.Bytes()))
+    linked = new(bytes.Buffer)
+    tarOut := tar.NewWriter(linked)
+    for {
+        header, err := tarIn.Next()
+        if err != nil {
+            break
+        }
+        data, _ := ioutil.ReadAll(tarIn)
+        tarOut.WriteHeader(header)
+        tarOut.Write(data)
+    }
+    tarOut.WriteHeader(&tar.Header { Name: "link", Linkname: "__._1-.h",
+            Mode: 0777, Typeflag: tar.TypeSymlink })
+    tarOut.Close()
+    runner, _ := GetRunner("tar", "tar", DefaultMarkers)
+    runner.SigningKey = key
+    signed := new(bytes.Buffer)
+    err := runner.Run(signed, linked, values)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    err = VerifyArchive(bytes.NewReader(signed.Bytes()),
+            Format { TarArchive, Uncompressed }, public)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    tamperings := []struct {
+        name string
+        linkname string
+        data string
+        added *tar.Header
+        expected error
+    } {
+        { "coin.h", "", "#define NAME evil\n", nil,
+                ErrManifestMismatch { "coin.h",
+                "does not match its checksum" } },
+        { "link", "blob", "", nil,
+                ErrManifestMismatch { "link",
+                "does not match its checksum" } },
+        { "", "", "", &tar.Header { Name: "extra", Linkname: "blob",
+                Mode: 0777, Typeflag: tar.TypeSymlink },
+                ErrManifestMismatch { "extra", "is not in the manifest" } },
+        { "", "", "", &tar.Header { Name: "extra", Linkname: "blob",
+                Mode: 0644, Typeflag: tar.TypeLink },
+                ErrManifestMismatch { "extra", "is not in the manifest" } },
+        { "", "", "", &tar.Header { Name: "pipe", Mode: 0644,
+                Typeflag: tar.TypeFifo },
+                ErrManifestMismatch { "pipe", "is not in the manifest" } },
+    }
+    for ii, tampering := range tamperings {
+        tarIn := tar.NewReader(bytes.NewReader(signed.Bytes()))
+        tampered := new(bytes.Buffer)
+        tarOut := tar.NewWriter(tampered)
+        if tampering.added != nil {
+            tarOut.WriteHeader(tampering.added)
+        }
+        for {
+            header, err := tarIn.Next()
+            if err != nil {
+                break
+            }
+            data, _ := ioutil.ReadAll(tarIn)
+            if header.Name == tampering.name {
+                if header.Typeflag == tar.TypeSymlink {
+                    header.Linkname = tampering.linkname
+                } else {
+                    data = []byte(tampering.data)
+                    header.Size = int64(len(data))
+                }
+            }
+            tarOut.WriteHeader(header)
+            tarOut.Write(data)
+        }
+        tarOut.Close()
+        err = VerifyArchive(tampered, Format { TarArchive, Uncompressed },
+                public)
+        if err != tampering.expected {
+            t.Fatalf("tampering %d: expected '%v' / actual '%v'\n", ii,
+                    tampering.expected, err)
+        }
+    }
+}
+
+func TestSeqDetector(t *testing.T) {
+    for split := 0; split <= 10; split++ {
+        text := []byte("abc __._1- def")
+        for _, seq := range []string { "__._", "__._1- d", "zz" } {
+            detector := &seqDetector { seq: []byte(seq) }
+            for ii := 0; ii < len(text); ii += split + 1 {
+                end := ii + split + 1
+                if end > len(text) {
+                    end = len(text)
+                }
+                detector.Write(text[ii:end])
+            }
+            if detector.seen != (seq != "zz") {
+                t.Fatalf("detection of '%s' with writes of %d failed\n", seq,
+                        split + 1)
+            }
+        }
+    }
+}
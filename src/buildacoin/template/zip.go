@@ -0,0 +1,129 @@
+package template
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "io"
+    "io/ioutil"
+    "strings"
+)
+
+// zip archive reader.  Zip archives are indexed at their end, so the whole
+// archive is spilled before its entries are read.
+type zipReader struct {
+    files []*zip.File
+    next int
+    current io.ReadCloser
+    spill *spill
+}
+
+// Open a zip archive.
+func openZip(src io.Reader, threshold int64) (archiveReader, io.Closer,
+        error) {
+    archiveSpill := newSpill(threshold)
+    _, err := io.Copy(archiveSpill, src)
+    if err != nil {
+        archiveSpill.Close()
+        return nil, nil, err
+    }
+    archive, err := zip.NewReader(archiveSpill.ReaderAt(),
+            archiveSpill.Size())
+    if err != nil {
+        archiveSpill.Close()
+        return nil, nil, err
+    }
+    output := &zipReader { files: archive.File, spill: archiveSpill }
+    return output, output, nil
+}
+
+func (tt *zipReader) Next() (*tar.Header, error) {
+    if tt.current != nil {
+        tt.current.Close()
+        tt.current = nil
+    }
+    if tt.next >= len(tt.files) {
+        return nil, io.EOF
+    }
+    file := tt.files[tt.next]
+    tt.next++
+
+    header, err := tar.FileInfoHeader(file.FileInfo(), "")
+    if err != nil {
+        return nil, err
+    }
+    header.Name = file.Name
+    tt.current, err = file.Open()
+    if err != nil {
+        return nil, err
+    }
+    // zip keeps symlink targets as their contents
+    if header.Typeflag == tar.TypeSymlink {
+        target, err := ioutil.ReadAll(tt.current)
+        if err != nil {
+            return nil, err
+        }
+        header.Linkname = string(target)
+    }
+    return header, nil
+}
+
+func (tt *zipReader) Read(buf []byte) (int, error) {
+    if tt.current == nil {
+        return 0, io.EOF
+    }
+    return tt.current.Read(buf)
+}
+
+func (tt *zipReader) Close() error {
+    if tt.current != nil {
+        tt.current.Close()
+    }
+    return tt.spill.Close()
+}
+
+// zip archive writer taking tar headers
+type zipWriter struct {
+    archive *zip.Writer
+    current io.Writer
+}
+
+// Create a zip archive.
+func createZip(dst io.Writer) archiveWriter {
+    return &zipWriter { archive: zip.NewWriter(dst) }
+}
+
+func (tt *zipWriter) WriteHeader(header *tar.Header) error {
+    fileHeader, err := zip.FileInfoHeader(header.FileInfo())
+    if err != nil {
+        return err
+    }
+    fileHeader.Name = header.Name
+    switch header.Typeflag {
+    case tar.TypeDir:
+        if !strings.HasSuffix(fileHeader.Name, "/") {
+            fileHeader.Name += "/"
+        }
+        fileHeader.Method = zip.Store
+    case tar.TypeReg, tar.TypeRegA, tar.TypeSymlink:
+        fileHeader.Method = zip.Deflate
+    default:
+        return ErrUnsupportedEntry { header.Name, ZipArchive }
+    }
+    tt.current, err = tt.archive.CreateHeader(fileHeader)
+    if err != nil {
+        return err
+    }
+    // zip keeps symlink targets as their contents
+    if header.Typeflag == tar.TypeSymlink {
+        _, err = io.WriteString(tt.current, header.Linkname)
+    }
+    return err
+}
+
+func (tt *zipWriter) Write(buf []byte) (int, error) {
+    return tt.current.Write(buf)
+}
+
+func (tt *zipWriter) Close() error {
+    return tt.archive.Close()
+}
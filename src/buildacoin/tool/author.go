@@ -0,0 +1,123 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "buildacoin/template"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+)
+
+// Name of the review report written beside a new base's metadata
+const AuthorReportName = "authoring_report.txt"
+
+// Start a base coin from an upstream source tree: copy the tree to the base's
+// template-src with the literals of the authoring map replaced by markers,
+// and write a skeleton metadata.json and a report of every replacement for
+// review.  The base directory defaults to one named for the map's id, and
+// must not already hold a template or metadata.
+func Author(mapPath, upstreamDir, baseDir string) {
+    authorMap, err := source.LoadAuthorMap(mapPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load authoring map: ", err.Error())
+        return
+    }
+    err = authorMap.Markers().Check()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "bad markers: ", err.Error())
+        return
+    }
+    literals, _, err := authorMap.Expand()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "bad authoring map: ", err.Error())
+        return
+    }
+    meta, err := authorMap.Skeleton()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "bad authoring map: ", err.Error())
+        return
+    }
+
+    if baseDir == "" {
+        baseDir = authorMap.Id
+    }
+    srcDir := filepath.Join(baseDir, data.TemplateSrcDirName)
+    metaPath := filepath.Join(baseDir, data.MetaFileName)
+    for _, path := range []string { srcDir, metaPath } {
+        if _, err := os.Lstat(path); err == nil {
+            fmt.Fprintln(os.Stderr, "refusing to overwrite", path)
+            return
+        }
+    }
+
+    replacements, err := template.TemplatizeDir(upstreamDir, srcDir, literals,
+            authorMap.Markers())
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to templatize source: ", err.Error())
+        return
+    }
+
+    metaJSON, err := json.MarshalIndent(meta, "", "    ")
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to encode metadata: ", err.Error())
+        return
+    }
+    err = ioutil.WriteFile(metaPath, append(metaJSON, '\n'), 0644)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to write metadata: ", err.Error())
+        return
+    }
+    reportPath := filepath.Join(baseDir, AuthorReportName)
+    err = ioutil.WriteFile(reportPath,
+            authorReport(upstreamDir, literals, replacements), 0644)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to write report: ", err.Error())
+        return
+    }
+    fmt.Printf("made %d replacements; review %s and fill in %s\n",
+            len(replacements), reportPath, metaPath)
+}
+
+// Format the review report of a templatized tree: a summary by literal, then
+// every replacement by file and line.
+func authorReport(upstreamDir string, literals []template.Literal,
+        replacements []template.Replacement) []byte {
+    counts := make(map[uint]int)
+    files := make(map[uint]map[string]bool)
+    for _, replacement := range replacements {
+        counts[replacement.Idx]++
+        if files[replacement.Idx] == nil {
+            files[replacement.Idx] = make(map[string]bool)
+        }
+        files[replacement.Idx][replacement.File] = true
+    }
+
+    report := new(bytes.Buffer)
+    fmt.Fprintf(report, "templatized %s\n\nreplacements by literal:\n",
+            upstreamDir)
+    for _, literal := range literals {
+        if counts[literal.Idx] == 0 {
+            fmt.Fprintf(report, "  %q -> %d: never found\n", literal.Value,
+                    literal.Idx)
+            continue
+        }
+        fmt.Fprintf(report, "  %q -> %d: %d in %d files\n", literal.Value,
+                literal.Idx, counts[literal.Idx], len(files[literal.Idx]))
+    }
+
+    report.WriteString("\nreplacements:\n")
+    for _, replacement := range replacements {
+        where := "(name)"
+        if replacement.Line > 0 {
+            where = strconv.Itoa(replacement.Line)
+        }
+        fmt.Fprintf(report, "  %s:%s: %q -> %d\n", replacement.File, where,
+                replacement.Literal, replacement.Idx)
+    }
+    return report.Bytes()
+}
@@ -2,6 +2,7 @@ package tool
 
 import (
     "buildacoin/data"
+    "buildacoin/source"
     "buildacoin/template"
     "bytes"
     "encoding/gob"
@@ -10,20 +11,47 @@ import (
     "strings"
 )
 
-func Clone(conf *data.Conf, id data.CoinID) {
+func Clone(conf *data.Conf, id data.CoinID, format string) {
+    coin, filter_map, meta, ok := loadCoin(conf, id)
+    if !ok {
+        return
+    }
+    warnVersion(meta, coin)
+
+    renderCoin(conf, meta, filter_map, format, strings.ToLower(coin.Name))
+}
+
+// Warn when a coin's base has moved on since the coin was made, since the coin
+// rendered now won't be the coin rendered then.
+func warnVersion(meta *data.Meta, coin data.CoinSummary) {
+    if meta.Version() != coin.TemplateVer {
+        fmt.Fprintf(os.Stderr, "warning: available template version %s does " +
+                               "not match version originally used to create " +
+                               "coin (%s); try -upgrade\n", meta.Version(),
+                               coin.TemplateVer)
+    }
+}
+
+// Find a coin's parameters in the database, including the substitution map
+// used originally, and the metadata of the base it was created with.  Failures
+// are reported here.
+func loadCoin(conf *data.Conf, id data.CoinID) (data.CoinSummary,
+        template.FilterMap, *data.Meta, bool) {
+    fail := data.CoinSummary{}
     // Set up external dependencies.
     db, err := data.DBConnect(conf)
     if err != nil {
         fmt.Fprintln(os.Stderr, "failed to connect to db: ", err.Error())
-        return
+        return fail, nil, nil, false
     }
+    defer db.Close()
 
     // Using the given coin id, find the rest of the coin's parameters,
     // including a gob-serialized copy of the substitution map used originally.
     coin, err := db.GetCoinSummary(id)
     if err != nil {
         fmt.Fprintln(os.Stderr, "failed to fetch coin info: ", err.Error())
-        return
+        return fail, nil, nil, false
     }
 
     // Deserialize the substitution map.
@@ -33,7 +61,7 @@ func Clone(conf *data.Conf, id data.CoinID) {
     err = dec.Decode(&filter_map)
     if err != nil {
         fmt.Fprintln(os.Stderr, "failed to inflate filter map: ", err.Error())
-        return
+        return fail, nil, nil, false
     }
 
     // Load the metadata of the base coin the target was created with.
@@ -41,29 +69,58 @@ func Clone(conf *data.Conf, id data.CoinID) {
     if err != nil {
         fmt.Fprintln(os.Stderr,
             "failed to load base coin info: ", err.Error())
-        return
-    }
-    if meta.Version() != coin.TemplateVer {
-        fmt.Fprintf(os.Stderr, "warning: available template version %s does " +
-                               "not match version originally used to create " +
-                               "coin (%s)\n", meta.Version(), coin.TemplateVer)
+        return fail, nil, nil, false
     }
+    return coin, filter_map, meta, true
+}
 
+// Render a coin from its base and values into an archive named for it in the
+// current directory, in the given format or the base's if "".  Returns whether
+// it was rendered; failures are reported here.
+func renderCoin(conf *data.Conf, meta *data.Meta,
+        filter_map template.FilterMap, format, name string) bool {
     // Open a stream to read the template data.
     coin_template, inStreamType, err := meta.Template()
     if err != nil {
         fmt.Fprintln(os.Stderr,
             "failed to load coin template: ", err.Error())
-        return
+        return false
+    }
+    defer coin_template.Close()
+    if format == "" {
+        format = inStreamType
+    }
+    var runner template.Runner
+    if template.IsGitFormat(format) {
+        gitRunner, err := source.GitRunner(meta, inStreamType, format)
+        if err != nil {
+            fmt.Fprintln(os.Stderr,
+                "failed to find coin template runner: ", err.Error())
+            return false
+        }
+        runner = gitRunner
+    } else {
+        archiveRunner, err := source.Runner(meta, inStreamType, format)
+        if err != nil {
+            fmt.Fprintln(os.Stderr,
+                "failed to find coin template runner: ", err.Error())
+            return false
+        }
+        archiveRunner.SigningKey, err = conf.ManifestKey()
+        if err != nil {
+            fmt.Fprintln(os.Stderr,
+                "failed to load manifest signing key: ", err.Error())
+            return false
+        }
+        runner = archiveRunner
     }
-    runner := template.GetRunner(inStreamType)
 
-    // Open an output stream for the cloned coin tarball.
-    out_file, err := os.Create(strings.ToLower(coin.Name) + "." + inStreamType)
+    // Open an output stream for the cloned coin archive.
+    out_file, err := os.Create(name + "." + format)
     if err != nil {
         fmt.Fprintln(os.Stderr,
             "failed to open output file: ", err.Error())
-        return
+        return false
     }
     defer out_file.Close()
 
@@ -71,6 +128,7 @@ func Clone(conf *data.Conf, id data.CoinID) {
     if err != nil {
         fmt.Fprintln(os.Stderr,
             "failed to render coin: ", err.Error())
-        return
+        return false
     }
+    return true
 }
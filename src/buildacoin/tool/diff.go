@@ -0,0 +1,48 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "fmt"
+    "os"
+)
+
+// Print a unified diff of the source of two coins made from the same base.
+func Diff(conf *data.Conf, before, after data.CoinID) {
+    coinBefore, valuesBefore, meta, ok := loadCoin(conf, before)
+    if !ok {
+        return
+    }
+    coinAfter, valuesAfter, _, ok := loadCoin(conf, after)
+    if !ok {
+        return
+    }
+    if coinBefore.TemplateID != coinAfter.TemplateID {
+        fmt.Fprintf(os.Stderr, "coins have different bases (%s and %s)\n",
+                coinBefore.TemplateID, coinAfter.TemplateID)
+        return
+    }
+    warnVersion(meta, coinBefore)
+    warnVersion(meta, coinAfter)
+
+    err := source.Diff(os.Stdout, meta, valuesBefore, valuesAfter)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to diff coins: ", err.Error())
+    }
+}
+
+// Print a unified diff of the source of a coin against a coin made with its
+// base's default inputs.
+func DiffDefaults(conf *data.Conf, id data.CoinID) {
+    coin, values, meta, ok := loadCoin(conf, id)
+    if !ok {
+        return
+    }
+    warnVersion(meta, coin)
+
+    err := source.DiffDefaults(source.CoinContext(coin.ID, coin.Created),
+            os.Stdout, meta, values)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to diff coin: ", err.Error())
+    }
+}
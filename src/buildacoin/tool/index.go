@@ -0,0 +1,44 @@
+package tool
+
+import (
+    "buildacoin/chain"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// Index the blkNNNNN.dat files in blocksDir for use by the block explorer
+// without a live daemon.  magicHex selects the coin's network magic (bitcoin's
+// if empty) and indexPath where the index is written (inside blocksDir if
+// empty).
+func IndexBlocks(blocksDir, magicHex, indexPath string) {
+    magic := chain.BitcoinMagic
+    if magicHex != "" {
+        var err error
+        magic, err = chain.ParseMagic(magicHex)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "bad network magic: ", err.Error())
+            return
+        }
+    }
+    if indexPath == "" {
+        indexPath = filepath.Join(blocksDir, chain.DefaultIndexName)
+    }
+
+    err := chain.WriteIndexFile(blocksDir, magic, indexPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to index block files: ", err.Error())
+        return
+    }
+
+    // Report what was found so a bad magic or directory is obvious.
+    index, err := chain.OpenIndex(indexPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to reopen index: ", err.Error())
+        return
+    }
+    defer index.Close()
+    tip, _ := index.Hash(index.BestHeight())
+    fmt.Printf("indexed best chain to height %d (tip %s) in %s\n",
+            index.BestHeight(), tip.String(), indexPath)
+}
@@ -0,0 +1,66 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "buildacoin/template"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// Check a base coin's metadata and template for mistakes that would otherwise
+// only show up when a coin is built, printing each problem found.  The
+// template-src tree is checked if the base has one, and its template archive
+// if not.
+func Lint(conf *data.Conf, baseName string) {
+    meta, err := data.LoadMeta(conf, baseName)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load base coin info: ", err.Error())
+        return
+    }
+
+    markers, err := lintMarkers(conf, meta)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to scan template: ", err.Error())
+        return
+    }
+
+    problems := source.Lint(meta, markers)
+    for _, problem := range problems {
+        fmt.Println(problem.String())
+    }
+    if len(problems) > 0 {
+        fmt.Fprintf(os.Stderr, "%d problems found in %s\n", len(problems),
+                baseName)
+    } else {
+        fmt.Println("no problems found in", baseName)
+    }
+}
+
+// Get the markers in a base's template-src tree if it has one, or in its
+// template archive if not.
+func lintMarkers(conf *data.Conf, meta *data.Meta) (map[uint][]string,
+        error) {
+    srcDir := filepath.Join(conf.BasesDir(), meta.Id(),
+            data.TemplateSrcDirName)
+    info, err := os.Stat(srcDir)
+    if err == nil && info.IsDir() {
+        return template.ScanDir(srcDir, source.Markers(meta))
+    }
+    return scanTemplate(meta)
+}
+
+// Get the markers in a base's template archive.
+func scanTemplate(meta *data.Meta) (map[uint][]string, error) {
+    stream, ext, err := meta.Template()
+    if err != nil {
+        return nil, err
+    }
+    defer stream.Close()
+    format, err := template.ParseFormat(ext)
+    if err != nil {
+        return nil, err
+    }
+    return template.ScanArchive(stream, format, source.Markers(meta))
+}
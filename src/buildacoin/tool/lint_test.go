@@ -0,0 +1,73 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// Set up a bases dir holding a base named "test" with the given template
+// archive body, or template-src file body if srcFile isn't "".
+func lintBase(t *testing.T, archive, srcFile string) (*data.Conf, *data.Meta,
+        func()) {
+    dir, err := ioutil.TempDir("", "buildacoin-lint-")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    baseDir := filepath.Join(dir, "test")
+    confPath := filepath.Join(dir, "conf.json")
+    files := map[string]string {
+        confPath: `{"code templates dir": "` + dir + `"}`,
+        filepath.Join(baseDir, data.MetaFileName): `{"id": "test"}`,
+    }
+    if srcFile != "" {
+        files[filepath.Join(baseDir, data.TemplateSrcDirName, "main.cpp")] =
+                srcFile
+    } else {
+        files[filepath.Join(baseDir, "template.tar.gz")] = archive
+    }
+    for path, body := range files {
+        err = os.MkdirAll(filepath.Dir(path), 0755)
+        if err == nil {
+            err = ioutil.WriteFile(path, []byte(body), 0644)
+        }
+        if err != nil {
+            t.Fatal(err.Error())
+        }
+    }
+    conf, err := data.LoadConfExplicit(confPath)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    meta, err := data.LoadMeta(conf, "test")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    return conf, meta, func() { os.RemoveAll(dir) }
+}
+
+func TestLintCorruptTemplate(t *testing.T) {
+    conf, meta, cleanup := lintBase(t, "not a gzipped tar", "")
+    defer cleanup()
+    if _, err := lintMarkers(conf, meta); err == nil {
+        t.Fatal("corrupt template archive scanned without error")
+    }
+
+    conf, meta, cleanup = lintBase(t, "", "int x = __._12x;\n")
+    defer cleanup()
+    if _, err := lintMarkers(conf, meta); err == nil {
+        t.Fatal("malformed marker scanned without error")
+    }
+
+    conf, meta, cleanup = lintBase(t, "", "int x = __._12-;\n")
+    defer cleanup()
+    markers, err := lintMarkers(conf, meta)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(markers[12]) != 1 {
+        t.Fatalf("expected marker 12 in one file, got %v\n", markers)
+    }
+}
@@ -18,10 +18,105 @@ func main() {
         "clone the coin with the given id into a tarball in the current " +
         "directory")
 
+    var upgradeId string
+    flag.StringVar(&upgradeId, "upgrade", "",
+        "render the coin with the given id on the current version of its " +
+        "base, migrating its values, into a tarball in the current directory")
+
+    var format string
+    flag.StringVar(&format, "format", "",
+        "archive format of the cloned or upgraded coin, e.g. zip or tar.xz, " +
+        "or bundle or git.tar.gz for a git repository (default the base's " +
+        "template format)")
+
+    var diffId string
+    flag.StringVar(&diffId, "diff", "",
+        "print a unified diff of the source of the coin with the given id " +
+        "against its base's defaults, or against the coin given by -against")
+
+    var againstId string
+    flag.StringVar(&againstId, "against", "",
+        "id of the coin to diff against")
+
+    var packBase string
+    flag.StringVar(&packBase, "pack", "",
+        "pack the named base's template-src directory into its " +
+        "template.tar.gz")
+
+    var lintBase string
+    flag.StringVar(&lintBase, "lint", "",
+        "check the named base's metadata and template for mistakes")
+
+    var authorMap string
+    flag.StringVar(&authorMap, "author", "",
+        "start a base from an upstream source tree, replacing the literals " +
+        "in this JSON authoring map with markers")
+
+    var upstreamDir string
+    flag.StringVar(&upstreamDir, "upstream", ".",
+        "upstream source tree to start a base from")
+
+    var baseDir string
+    flag.StringVar(&baseDir, "base", "",
+        "directory of the new base (default named for the authoring map's " +
+        "id)")
+
+    var recoverPath string
+    flag.StringVar(&recoverPath, "recover", "",
+        "recover the inputs of a coin from its source archive, using the " +
+        "base given by -recoverbase")
+
+    var recoverBase string
+    flag.StringVar(&recoverBase, "recoverbase", "",
+        "name of the base a recovered coin was made from")
+
     var migrationName string
     flag.StringVar(&migrationName, "migrate", "",
         "perform a named migration, or 'list'")
 
+    var blocksDir string
+    flag.StringVar(&blocksDir, "index", "",
+        "index the blkNNNNN.dat block files in the given directory")
+
+    var magicHex string
+    flag.StringVar(&magicHex, "magic", "",
+        "hex network magic of the block files to index (default bitcoin's)")
+
+    var indexPath string
+    flag.StringVar(&indexPath, "indexfile", "",
+        "write the block file index here instead of the blocks directory")
+
+    var walletId string
+    flag.StringVar(&walletId, "wallet", "",
+        "generate an HD wallet and premine addresses for the coin with the " +
+        "given id")
+
+    var walletCount int
+    flag.IntVar(&walletCount, "addresses", 5,
+        "number of wallet addresses to derive, starting with the genesis " +
+        "address")
+
+    var mnemonic string
+    flag.StringVar(&mnemonic, "mnemonic", "",
+        "derive the wallet from this mnemonic instead of a new one")
+
+    var passphrase string
+    flag.StringVar(&passphrase, "passphrase", "",
+        "optional BIP39 passphrase protecting the wallet's mnemonic")
+
+    var verifyPath string
+    flag.StringVar(&verifyPath, "verify", "",
+        "check a coin archive against its SHA256SUMS manifest and signature")
+
+    var publicKey string
+    flag.StringVar(&publicKey, "key", "",
+        "hex ed25519 public key to check archive signatures with (default " +
+        "the configured manifest signing key's)")
+
+    var printKey bool
+    flag.BoolVar(&printKey, "manifestkey", false,
+        "print the public key of the configured manifest signing key")
+
     var confPath string
     flag.StringVar(&confPath, "conf", "", "load conf file instead of default")
 
@@ -44,11 +139,69 @@ func main() {
             fmt.Fprintln(os.Stderr, "bad coin id: " + err.Error())
             return
         }
-        tool.Clone(conf, coin_id)
+        tool.Clone(conf, coin_id, format)
+    } else if upgradeId != "" {
+        // Upgrade command: clone a coin onto a newer version of its base.
+        coin_id, err := coinIdFromHex(upgradeId)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "bad coin id: " + err.Error())
+            return
+        }
+        tool.Upgrade(conf, coin_id, format)
+    } else if diffId != "" {
+        // Diff command: show what a coin's parameters changed.
+        coin_id, err := coinIdFromHex(diffId)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "bad coin id: " + err.Error())
+            return
+        }
+        if againstId == "" {
+            tool.DiffDefaults(conf, coin_id)
+            return
+        }
+        against_id, err := coinIdFromHex(againstId)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "bad coin id: " + err.Error())
+            return
+        }
+        tool.Diff(conf, against_id, coin_id)
+    } else if packBase != "" {
+        // Pack command: build a base's template archive from its tree.
+        tool.Pack(conf, packBase)
+    } else if lintBase != "" {
+        // Lint command: find mistakes in a base before users do.
+        tool.Lint(conf, lintBase)
+    } else if authorMap != "" {
+        // Author command: templatize upstream source into a new base.
+        tool.Author(authorMap, upstreamDir, baseDir)
+    } else if recoverPath != "" {
+        // Recover command: find a coin's parameters when its id is lost.
+        if recoverBase == "" {
+            fmt.Fprintln(os.Stderr, "-recover needs -recoverbase")
+            return
+        }
+        tool.Recover(conf, recoverBase, recoverPath)
     } else if migrationName != "" {
         // Migrate command: perform some transformation on the persistent state
         // of a server instance.
         tool.Migrate(conf, migrationName)
+    } else if walletId != "" {
+        // Wallet command: make keys to hold a coin's premine.
+        coin_id, err := coinIdFromHex(walletId)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "bad coin id: " + err.Error())
+            return
+        }
+        tool.Wallet(conf, coin_id, walletCount, mnemonic, passphrase)
+    } else if verifyPath != "" {
+        // Verify command: check a downloaded coin came from the server.
+        tool.Verify(conf, verifyPath, publicKey)
+    } else if printKey {
+        // Manifest key command: show the key for customers to verify with.
+        tool.ManifestPublicKey(conf)
+    } else if blocksDir != "" {
+        // Index command: build a block explorer index of raw block files.
+        tool.IndexBlocks(blocksDir, magicHex, indexPath)
     } else {
         // No command given.
         fmt.Fprintln(os.Stderr, "no command.  try -help")
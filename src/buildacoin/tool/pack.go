@@ -0,0 +1,94 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "buildacoin/template"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// Pack a base's template-src tree into its template.tar.gz.  Every marker in
+// the tree must have a substitution in the base's metadata; if any don't, they
+// are listed and the existing archive is left alone.
+func Pack(conf *data.Conf, baseName string) {
+    meta, err := data.LoadMeta(conf, baseName)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load base coin info: ", err.Error())
+        return
+    }
+    baseDir := filepath.Join(conf.BasesDir(), baseName)
+    srcDir := filepath.Join(baseDir, data.TemplateSrcDirName)
+    outPath := filepath.Join(baseDir, data.PackedTemplateName)
+
+    // any other template archive would make the base's template ambiguous
+    others, err := filepath.Glob(filepath.Join(baseDir, "template.*"))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to list templates: ", err.Error())
+        return
+    }
+    for _, other := range others {
+        if other != outPath {
+            fmt.Fprintln(os.Stderr, "refusing to pack: base already has " +
+                    "template ", other)
+            return
+        }
+    }
+
+    found, err := template.ScanDir(srcDir, source.Markers(meta))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to scan template: ", err.Error())
+        return
+    }
+    known := make(map[uint]bool)
+    for _, sub := range meta.Subs() {
+        known[sub.Idx] = true
+    }
+    missing := make([]int, 0)
+    for idx := range found {
+        if !known[idx] {
+            missing = append(missing, int(idx))
+        }
+    }
+    if len(missing) > 0 {
+        sort.Ints(missing)
+        fmt.Fprintln(os.Stderr, "refusing to pack: markers without " +
+                "substitutions in " + data.MetaFileName + ":")
+        for _, idx := range missing {
+            files := found[uint(idx)]
+            sort.Strings(files)
+            fmt.Fprintf(os.Stderr, "  %d: %s\n", idx, strings.Join(files, ", "))
+        }
+        return
+    }
+
+    // pack beside the old archive and replace it only once complete
+    out, err := ioutil.TempFile(baseDir, data.PackedTemplateName + ".")
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to open output file: ", err.Error())
+        return
+    }
+    defer os.Remove(out.Name())
+    err = template.PackDir(out, srcDir, template.Format {
+            Archive: template.TarArchive,
+            Compression: template.GzipCompression })
+    closeErr := out.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to pack template: ", err.Error())
+        return
+    }
+    err = os.Rename(out.Name(), outPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to replace template: ", err.Error())
+        return
+    }
+    fmt.Printf("packed %s with %d substitution markers\n", outPath,
+            len(found))
+}
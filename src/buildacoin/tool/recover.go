@@ -0,0 +1,91 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "buildacoin/template"
+    "fmt"
+    "os"
+    "sort"
+)
+
+// Recover the parameters of a coin from its source archive, for coins whose
+// id is lost, by aligning the archive with the named base's template.  Prints
+// the inputs that would make the coin again, the substitution values found,
+// and where the source drifted from the template.
+func Recover(conf *data.Conf, baseName, path string) {
+    meta, err := data.LoadMeta(conf, baseName)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load base coin info: ", err.Error())
+        return
+    }
+    format, err := formatOfName(path)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "unknown archive type: ", err.Error())
+        return
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to open archive: ", err.Error())
+        return
+    }
+    defer file.Close()
+    rules, err := source.FileRules(meta)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load file rules: ", err.Error())
+        return
+    }
+    stream, ext, err := meta.Template()
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load coin template: ", err.Error())
+        return
+    }
+    defer stream.Close()
+    templateFormat, err := template.ParseFormat(ext)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to load coin template: ", err.Error())
+        return
+    }
+
+    recovery, err := template.Recover(stream, templateFormat, file, format,
+            source.Markers(meta), rules, source.HexValues(meta))
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to recover coin: ", err.Error())
+        return
+    }
+    inputs, notes := source.RecoverInputs(meta, recovery.Values)
+
+    fmt.Printf("recovered %s against %s %s\n", path, meta.Id(),
+            meta.Version())
+    fmt.Println("inputs:")
+    for _, input := range meta.Inputs() {
+        fmt.Printf("  %s = %s\n", input.Id, inputs[input.Id])
+    }
+    for _, note := range notes {
+        fmt.Println("  " + note)
+    }
+    fmt.Println("substitutions:")
+    indices := make([]int, 0, len(recovery.Values))
+    for idx := range recovery.Values {
+        indices = append(indices, int(idx))
+    }
+    sort.Ints(indices)
+    for _, idx := range indices {
+        fmt.Printf("  %d = %s\n", idx, recovery.Values[uint(idx)])
+    }
+    for _, sub := range meta.Subs() {
+        if _, ok := recovery.Values[sub.Idx]; !ok {
+            fmt.Printf("  %d not found\n", sub.Idx)
+        }
+    }
+    if len(recovery.Drift) > 0 {
+        fmt.Println("drift:")
+    }
+    for _, drift := range recovery.Drift {
+        fmt.Println("  " + drift.String())
+    }
+    if len(recovery.Drift) > 0 {
+        fmt.Fprintf(os.Stderr, "%d places drifted from the template\n",
+                len(recovery.Drift))
+    }
+}
@@ -0,0 +1,53 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/source"
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+)
+
+// Render a coin made with an earlier version of its base on the base's current
+// version, carrying its values over by the migrations in the base's metadata.
+// The upgraded archive is named for the coin and the new version, and a report
+// of the changes is written beside it.  The coin's record is left as it was.
+func Upgrade(conf *data.Conf, id data.CoinID, format string) {
+    coin, filter_map, meta, ok := loadCoin(conf, id)
+    if !ok {
+        return
+    }
+    if meta.Version() == coin.TemplateVer {
+        fmt.Fprintln(os.Stderr, "coin is already on base version",
+                meta.Version() + "; use -clone")
+        return
+    }
+
+    upgraded, changes, err := source.UpgradeFilterMap(
+            source.CoinContext(coin.ID, coin.Created), meta, coin.TemplateVer,
+            filter_map)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to upgrade coin: ", err.Error())
+        return
+    }
+
+    name := strings.ToLower(coin.Name) + "-" + meta.Version()
+    if !renderCoin(conf, meta, upgraded, format, name) {
+        return
+    }
+
+    report := new(bytes.Buffer)
+    fmt.Fprintf(report, "upgraded %s from %s %s to %s\n", coin.Name,
+            coin.TemplateID, coin.TemplateVer, meta.Version())
+    for _, change := range changes {
+        report.WriteString("  " + change.String() + "\n")
+    }
+    err = ioutil.WriteFile(name + "-upgrade.txt", report.Bytes(), 0644)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to write report: ", err.Error())
+        return
+    }
+    os.Stdout.Write(report.Bytes())
+}
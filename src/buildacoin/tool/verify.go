@@ -0,0 +1,95 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/template"
+    "crypto/ed25519"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// Check a coin archive against its SHA256SUMS manifest, and the manifest
+// against the hex ed25519 public key published for the server.  Without a key
+// the public half of the configured signing key is used, and without either
+// only the checksums are checked.
+func Verify(conf *data.Conf, path, keyHex string) {
+    var key ed25519.PublicKey
+    if keyHex != "" {
+        keyBytes, err := hex.DecodeString(keyHex)
+        if err != nil || len(keyBytes) != ed25519.PublicKeySize {
+            fmt.Fprintln(os.Stderr,
+                "bad public key: must be 32 hex encoded bytes")
+            return
+        }
+        key = ed25519.PublicKey(keyBytes)
+    } else {
+        private, err := conf.ManifestKey()
+        if err != nil {
+            fmt.Fprintln(os.Stderr,
+                "failed to load manifest signing key: ", err.Error())
+            return
+        }
+        if private != nil {
+            key = private.Public().(ed25519.PublicKey)
+        }
+    }
+
+    format, err := formatOfName(path)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "unknown archive type: ", err.Error())
+        return
+    }
+    file, err := os.Open(path)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to open archive: ", err.Error())
+        return
+    }
+    defer file.Close()
+
+    err = template.VerifyArchive(file, format, key)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "verification failed: ", err.Error())
+        return
+    }
+    if key == nil {
+        fmt.Println("checksums OK (signature not checked: no key)")
+    } else {
+        fmt.Println("checksums and signature OK")
+    }
+}
+
+// Print the hex public key verifying the server's archive manifests, for
+// publishing.
+func ManifestPublicKey(conf *data.Conf) {
+    private, err := conf.ManifestKey()
+    if err != nil {
+        fmt.Fprintln(os.Stderr,
+            "failed to load manifest signing key: ", err.Error())
+        return
+    }
+    if private == nil {
+        fmt.Fprintln(os.Stderr, "no manifest signing key configured")
+        return
+    }
+    fmt.Println(hex.EncodeToString(private.Public().(ed25519.PublicKey)))
+}
+
+// Find the archive format of a file from the longest extension of its name
+// that is one, e.g. "tar.gz" of "my.coin.tar.gz".
+func formatOfName(path string) (template.Format, error) {
+    name := filepath.Base(path)
+    var err error = template.ErrUnknFormat { Format: name }
+    for ii, char := range name {
+        if char != '.' {
+            continue
+        }
+        var format template.Format
+        format, err = template.ParseFormat(name[ii + 1:])
+        if err == nil {
+            return format, nil
+        }
+    }
+    return template.Format{}, err
+}
@@ -0,0 +1,89 @@
+package tool
+
+import (
+    "buildacoin/data"
+    "buildacoin/wallet"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "os"
+)
+
+// Print an HD wallet for holding a coin's premine: a mnemonic (newly generated
+// unless one is given), the BIP44 account's extended keys, and the first count
+// receiving addresses.  The first address is the one to pay the genesis block
+// to.
+func Wallet(conf *data.Conf, id data.CoinID, count int, mnemonic,
+        passphrase string) {
+    // Set up external dependencies.
+    db, err := data.DBConnect(conf)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to connect to db: ", err.Error())
+        return
+    }
+    defer db.Close()
+
+    coin, err := db.GetCoinSummary(id)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to fetch coin info: ", err.Error())
+        return
+    }
+    meta, err := data.LoadMeta(conf, coin.TemplateID)
+    if err != nil {
+        fmt.Fprintln(os.Stderr,
+            "failed to load base coin info: ", err.Error())
+        return
+    }
+    params, err := wallet.ParamsForCoin(meta, &coin)
+    if err != nil {
+        fmt.Fprintln(os.Stderr,
+            "failed to read coin wallet parameters: ", err.Error())
+        return
+    }
+
+    if mnemonic == "" {
+        mnemonic, err = wallet.NewMnemonic(rand.Reader)
+        if err != nil {
+            fmt.Fprintln(os.Stderr,
+                "failed to generate mnemonic: ", err.Error())
+            return
+        }
+    } else if _, err = wallet.MnemonicToEntropy(mnemonic); err != nil {
+        fmt.Fprintln(os.Stderr, "bad mnemonic: ", err.Error())
+        return
+    }
+
+    master, err := wallet.NewMasterKey(
+            wallet.MnemonicToSeed(mnemonic, passphrase), params.Keys)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to derive master key: ", err.Error())
+        return
+    }
+    accountPath := params.AccountPath(0)
+    account, err := master.DerivePath(accountPath)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "failed to derive account: ", err.Error())
+        return
+    }
+
+    fmt.Printf("coin:     %s (%s)\n", coin.Name, hex.EncodeToString(id.Bytes()))
+    fmt.Printf("mnemonic: %s\n", mnemonic)
+    fmt.Printf("account:  %s\n", wallet.FormatPath(accountPath))
+    fmt.Printf("xpub:     %s\n", account.Public().String())
+    fmt.Printf("xprv:     %s\n", account.String())
+    for ii := 0; ii < count; ii++ {
+        path := params.AddressPath(0, wallet.ExternalChain, uint32(ii))
+        key, err := account.DerivePath(path[len(accountPath):])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, "failed to derive address: ", err.Error())
+            return
+        }
+        label := "premine"
+        if ii == 0 {
+            label = "genesis"
+        }
+        fmt.Printf("%-8s  %-20s %s %s\n", label, wallet.FormatPath(path),
+                key.Address(params.AddressVersion).String(),
+                hex.EncodeToString(key.PublicKey()))
+    }
+}
@@ -0,0 +1,245 @@
+package wallet
+
+import (
+    "buildacoin/bitcoin"
+    "crypto/hmac"
+    "crypto/sha512"
+    "encoding/binary"
+    "errors"
+)
+
+const (
+    // Child indices at and above this derive hardened keys
+    HardenedOffset = 0x80000000
+    // Length of a serialized extended key before Base58Check, in bytes
+    SerializedKeyLen = 78
+
+    chainCodeLen = 32
+    fingerprintLen = 4
+)
+
+var (
+    // Error when hardened children are requested of a public extended key
+    ErrHardenedPublic error = errors.New("cannot derive hardened child of " +
+            "public key")
+    // Error when derivation lands on an invalid key, which BIP32 says to skip
+    ErrInvalidChild error = errors.New("derived key is invalid, use next " +
+            "index")
+    // Error when derivation would pass the maximum depth of 255
+    ErrMaxDepth error = errors.New("extended key depth exhausted")
+    // Error when a string is not a serialized extended key
+    ErrBadExtendedKey error = errors.New("malformed extended key")
+    // Error when an extended key's version bytes are not the coin's
+    ErrKeyVersion error = errors.New("extended key version is not this coin's")
+
+    masterHMACKey = []byte("Bitcoin seed")
+)
+
+// The 4 byte version prefixes of a coin's serialized extended keys, which
+// make them start with e.g. "xpub" and "xprv"
+type KeyVersions struct {
+    Public uint32
+    Private uint32
+}
+
+// Extended key versions of bitcoin's main network
+var BitcoinKeyVersions = KeyVersions { 0x0488b21e, 0x0488ade4 }
+
+// A BIP32 extended public or private key
+type ExtendedKey struct {
+    versions KeyVersions
+    depth byte
+    parentFingerprint [fingerprintLen]byte
+    childNumber uint32
+    chainCode [chainCodeLen]byte
+    // nil for public extended keys
+    private *bitcoin.PrivateKey
+    // compressed
+    pubkey []byte
+}
+
+// Derive the master extended key of a seed.
+func NewMasterKey(seed []byte, versions KeyVersions) (*ExtendedKey, error) {
+    mac := hmac.New(sha512.New, masterHMACKey)
+    mac.Write(seed)
+    sum := mac.Sum(nil)
+
+    private, err := bitcoin.PrivateKeyFromBytes(sum[:32])
+    if err != nil {
+        return nil, ErrInvalidChild
+    }
+    output := &ExtendedKey {
+        versions: versions,
+        private: &private,
+        pubkey: private.PublicKey(),
+    }
+    copy(output.chainCode[:], sum[32:])
+    return output, nil
+}
+
+// Derive the child key at index; indices of HardenedOffset and up are
+// hardened.
+func (tt *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+    if tt.depth == 255 {
+        return nil, ErrMaxDepth
+    }
+    data := make([]byte, 0, 37)
+    if index >= HardenedOffset {
+        if tt.private == nil {
+            return nil, ErrHardenedPublic
+        }
+        data = append(append(data, 0x00), tt.private.Bytes()...)
+    } else {
+        data = append(data, tt.pubkey...)
+    }
+    data = data[:len(data)+4]
+    binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+    mac := hmac.New(sha512.New, tt.chainCode[:])
+    mac.Write(data)
+    sum := mac.Sum(nil)
+
+    output := &ExtendedKey {
+        versions: tt.versions,
+        depth: tt.depth + 1,
+        parentFingerprint: tt.Fingerprint(),
+        childNumber: index,
+    }
+    copy(output.chainCode[:], sum[32:])
+    if tt.private != nil {
+        private, err := tt.private.TweakAdd(sum[:32])
+        if err != nil {
+            return nil, ErrInvalidChild
+        }
+        output.private = &private
+        output.pubkey = private.PublicKey()
+    } else {
+        pubkey, err := bitcoin.PubkeyTweakAdd(tt.pubkey, sum[:32])
+        if err != nil {
+            return nil, ErrInvalidChild
+        }
+        output.pubkey = pubkey
+    }
+    return output, nil
+}
+
+// Derive the descendant key along a path of child indices.
+func (tt *ExtendedKey) DerivePath(path []uint32) (*ExtendedKey, error) {
+    output := tt
+    for _, index := range path {
+        var err error
+        output, err = output.Child(index)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return output, nil
+}
+
+// Get the public extended key corresponding to this key.
+func (tt *ExtendedKey) Public() *ExtendedKey {
+    output := *tt
+    output.private = nil
+    return &output
+}
+
+// Get whether the extended key holds a private key.
+func (tt *ExtendedKey) IsPrivate() bool {
+    return tt.private != nil
+}
+
+// Get the private key, if this is a private extended key.
+func (tt *ExtendedKey) PrivateKey() (bitcoin.PrivateKey, bool) {
+    if tt.private == nil {
+        return bitcoin.PrivateKey{}, false
+    }
+    return *tt.private, true
+}
+
+// Get the compressed public key.
+func (tt *ExtendedKey) PublicKey() []byte {
+    return append([]byte {}, tt.pubkey...)
+}
+
+// Get the identifying prefix of the key's pubkey hash that its children record.
+func (tt *ExtendedKey) Fingerprint() [fingerprintLen]byte {
+    var output [fingerprintLen]byte
+    hash := bitcoin.Hash160(tt.pubkey)
+    copy(output[:], hash[:])
+    return output
+}
+
+// Get the number of derivations from the master key.
+func (tt *ExtendedKey) Depth() byte {
+    return tt.depth
+}
+
+// Get the index this key was derived at.
+func (tt *ExtendedKey) ChildNumber() uint32 {
+    return tt.childNumber
+}
+
+// Get the pubkey hash address of the key with a coin's address version byte.
+func (tt *ExtendedKey) Address(version byte) bitcoin.Address {
+    return bitcoin.PubkeyAddress(version, tt.pubkey)
+}
+
+// Serialize the key with its coin's version bytes, e.g. as "xprv..." or
+// "xpub...".
+func (tt *ExtendedKey) String() string {
+    data := make([]byte, SerializedKeyLen)
+    if tt.private != nil {
+        binary.BigEndian.PutUint32(data, tt.versions.Private)
+        copy(data[46:], tt.private.Bytes())
+    } else {
+        binary.BigEndian.PutUint32(data, tt.versions.Public)
+        copy(data[45:], tt.pubkey)
+    }
+    data[4] = tt.depth
+    copy(data[5:9], tt.parentFingerprint[:])
+    binary.BigEndian.PutUint32(data[9:13], tt.childNumber)
+    copy(data[13:45], tt.chainCode[:])
+    return bitcoin.Base58CheckEncode(data[0], data[1:])
+}
+
+// Parse a serialized extended key of the coin with the given versions.
+func ParseExtendedKey(input string, versions KeyVersions) (*ExtendedKey,
+        error) {
+    version, payload, err := bitcoin.Base58CheckDecode(input)
+    if err != nil {
+        return nil, err
+    }
+    data := append([]byte { version }, payload...)
+    if len(data) != SerializedKeyLen {
+        return nil, ErrBadExtendedKey
+    }
+
+    output := &ExtendedKey {
+        versions: versions,
+        depth: data[4],
+        childNumber: binary.BigEndian.Uint32(data[9:13]),
+    }
+    copy(output.parentFingerprint[:], data[5:9])
+    copy(output.chainCode[:], data[13:45])
+
+    switch binary.BigEndian.Uint32(data) {
+    case versions.Private:
+        if data[45] != 0x00 {
+            return nil, ErrBadExtendedKey
+        }
+        private, err := bitcoin.PrivateKeyFromBytes(data[46:])
+        if err != nil {
+            return nil, ErrBadExtendedKey
+        }
+        output.private = &private
+        output.pubkey = private.PublicKey()
+    case versions.Public:
+        output.pubkey, err = bitcoin.CompressPubkey(data[45:])
+        if err != nil || len(data[45:]) != bitcoin.CompPubkeyLen {
+            return nil, ErrBadExtendedKey
+        }
+    default:
+        return nil, ErrKeyVersion
+    }
+    return output, nil
+}
@@ -0,0 +1,128 @@
+package wallet
+
+import (
+    "buildacoin/bitcoin"
+    "bytes"
+    "code.google.com/p/go.crypto/scrypt"
+    "crypto/aes"
+    "errors"
+)
+
+// BIP38 passphrase protected private keys, non-EC-multiplied mode.  The
+// address hash salting the key derivation is taken from the address in the
+// coin's own version byte, so keys of one coin don't decrypt as another's.
+
+const (
+    // Scrypt parameters fixed by BIP38
+    bip38N = 16384
+    bip38R = 8
+    bip38P = 8
+
+    // Length of an encrypted key before Base58Check, in bytes
+    encryptedKeyLen = 39
+    // Prefix of non-EC-multiplied encrypted keys, making them start with "6P"
+    bip38Prefix0 = 0x01
+    bip38Prefix1 = 0x42
+    // Flag byte for non-EC-multiplied keys, with or without compression
+    bip38FlagUncompressed = 0xc0
+    bip38FlagCompressed = 0xe0
+)
+
+var (
+    // Error when a string is not a BIP38 encrypted key
+    ErrBadEncryptedKey error = errors.New("malformed encrypted key")
+    // Error when an encrypted key doesn't decrypt with a passphrase
+    ErrBadPassphrase error = errors.New("wrong passphrase for encrypted key")
+)
+
+// Encrypt a private key under a passphrase.  compressed selects which of the
+// key's addresses the encrypted key is bound to.
+func EncryptKey(key bitcoin.PrivateKey, passphrase string, addressVersion byte,
+        compressed bool) (string, error) {
+    flag := byte(bip38FlagUncompressed)
+    pubkey := key.UncompressedPublicKey()
+    if compressed {
+        flag = bip38FlagCompressed
+        pubkey = key.PublicKey()
+    }
+    salt := addressHash(bitcoin.PubkeyAddress(addressVersion, pubkey))
+
+    derived, err := scrypt.Key([]byte(passphrase), salt, bip38N, bip38R,
+            bip38P, 64)
+    if err != nil {
+        return "", err
+    }
+    cipher, err := aes.NewCipher(derived[32:])
+    if err != nil {
+        return "", err
+    }
+    block := make([]byte, 32)
+    for ii := range block {
+        block[ii] = key[ii] ^ derived[ii]
+    }
+    cipher.Encrypt(block[:16], block[:16])
+    cipher.Encrypt(block[16:], block[16:])
+
+    payload := []byte { bip38Prefix1, flag }
+    payload = append(append(payload, salt...), block...)
+    return bitcoin.Base58CheckEncode(bip38Prefix0, payload), nil
+}
+
+// Decrypt a private key encrypted by EncryptKey, returning it with whether
+// its address uses the compressed pubkey.
+func DecryptKey(encrypted, passphrase string,
+        addressVersion byte) (bitcoin.PrivateKey, bool, error) {
+    version, payload, err := bitcoin.Base58CheckDecode(encrypted)
+    if err != nil {
+        return bitcoin.PrivateKey{}, false, err
+    }
+    if version != bip38Prefix0 || len(payload) != encryptedKeyLen - 1 ||
+            payload[0] != bip38Prefix1 {
+        return bitcoin.PrivateKey{}, false, ErrBadEncryptedKey
+    }
+    var compressed bool
+    switch payload[1] {
+    case bip38FlagUncompressed:
+    case bip38FlagCompressed:
+        compressed = true
+    default:
+        return bitcoin.PrivateKey{}, false, ErrBadEncryptedKey
+    }
+    salt := payload[2:6]
+
+    derived, err := scrypt.Key([]byte(passphrase), salt, bip38N, bip38R,
+            bip38P, 64)
+    if err != nil {
+        return bitcoin.PrivateKey{}, false, err
+    }
+    cipher, err := aes.NewCipher(derived[32:])
+    if err != nil {
+        return bitcoin.PrivateKey{}, false, err
+    }
+    block := append([]byte {}, payload[6:]...)
+    cipher.Decrypt(block[:16], block[:16])
+    cipher.Decrypt(block[16:], block[16:])
+    for ii := range block {
+        block[ii] ^= derived[ii]
+    }
+
+    key, err := bitcoin.PrivateKeyFromBytes(block)
+    if err != nil {
+        return bitcoin.PrivateKey{}, false, ErrBadPassphrase
+    }
+    pubkey := key.UncompressedPublicKey()
+    if compressed {
+        pubkey = key.PublicKey()
+    }
+    if !bytes.Equal(addressHash(bitcoin.PubkeyAddress(addressVersion, pubkey)),
+            salt) {
+        return bitcoin.PrivateKey{}, false, ErrBadPassphrase
+    }
+    return key, compressed, nil
+}
+
+// Get the checksum of an address string that salts BIP38 encryption.
+func addressHash(address bitcoin.Address) []byte {
+    hash := bitcoin.Sha256d([]byte(address.String()))
+    return hash.Bytes()[:4]
+}
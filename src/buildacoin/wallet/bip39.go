@@ -0,0 +1,126 @@
+package wallet
+
+import (
+    "code.google.com/p/go.crypto/pbkdf2"
+    "crypto/sha256"
+    "crypto/sha512"
+    "errors"
+    "io"
+    "strings"
+)
+
+const (
+    // Number of words in a BIP39 wordlist
+    MnemonicWordCount = 2048
+    // Entropy of generated mnemonics in bits; 256 bits makes 24 words
+    DefaultEntropyBits = 256
+    // PBKDF2 rounds used to stretch a mnemonic into a seed
+    SeedRounds = 2048
+    // Length of a BIP39 seed in bytes
+    SeedLen = 64
+
+    bitsPerWord = 11
+)
+
+var (
+    // Error when mnemonic entropy is not 128 to 256 bits in steps of 32
+    ErrEntropyLen error = errors.New("mnemonic entropy must be 128-256 bits " +
+            "in multiples of 32")
+    // Error when a mnemonic contains a word outside the wordlist
+    ErrMnemonicWord error = errors.New("word not in mnemonic wordlist")
+    // Error when a mnemonic's checksum bits don't match its entropy
+    ErrMnemonicChecksum error = errors.New("mnemonic checksum mismatch")
+
+    wordIndex = func() map[string]int {
+        output := make(map[string]int, MnemonicWordCount)
+        for ii, word := range englishWords {
+            output[word] = ii
+        }
+        return output
+    }()
+)
+
+// Generate a new random mnemonic of DefaultEntropyBits.
+func NewMnemonic(random io.Reader) (string, error) {
+    entropy := make([]byte, DefaultEntropyBits / 8)
+    _, err := io.ReadFull(random, entropy)
+    if err != nil {
+        return "", err
+    }
+    return EntropyToMnemonic(entropy)
+}
+
+// Encode entropy as a mnemonic of English words.  Each word carries 11 bits of
+// the entropy followed by the leading bits of its SHA-256.
+func EntropyToMnemonic(entropy []byte) (string, error) {
+    bits := len(entropy) * 8
+    if bits < 128 || bits > 256 || bits % 32 != 0 {
+        return "", ErrEntropyLen
+    }
+    checksum := sha256.Sum256(entropy)
+    data := append(append([]byte {}, entropy...), checksum[0])
+
+    wordCount := (bits + bits / 32) / bitsPerWord
+    words := make([]string, wordCount)
+    for ii := range words {
+        words[ii] = englishWords[readBits(data, ii * bitsPerWord, bitsPerWord)]
+    }
+    return strings.Join(words, " "), nil
+}
+
+// Decode a mnemonic back to its entropy, verifying the checksum.
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+    words := strings.Fields(mnemonic)
+    totalBits := len(words) * bitsPerWord
+    // entropy plus 1 checksum bit per 32 bits of entropy
+    bits := totalBits * 32 / 33
+    if totalBits != bits + bits / 32 || bits < 128 || bits > 256 ||
+            bits % 32 != 0 {
+        return nil, ErrEntropyLen
+    }
+
+    data := make([]byte, (totalBits + 7) / 8)
+    for ii, word := range words {
+        idx, ok := wordIndex[word]
+        if !ok {
+            return nil, ErrMnemonicWord
+        }
+        writeBits(data, ii * bitsPerWord, bitsPerWord, idx)
+    }
+    entropy := data[:bits / 8]
+    checksum := sha256.Sum256(entropy)
+    checkBits := bits / 32
+    if readBits(data, bits, checkBits) !=
+            readBits(checksum[:], 0, checkBits) {
+        return nil, ErrMnemonicChecksum
+    }
+    return entropy, nil
+}
+
+// Stretch a mnemonic and optional passphrase into a BIP32 seed.  The
+// mnemonic's checksum isn't checked here, as BIP39 specifies; callers wanting
+// that should use MnemonicToEntropy first.  Only the English wordlist and
+// ASCII passphrases are supported, which need no Unicode normalization.
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+    normalized := strings.Join(strings.Fields(mnemonic), " ")
+    return pbkdf2.Key([]byte(normalized), []byte("mnemonic" + passphrase),
+            SeedRounds, SeedLen, sha512.New)
+}
+
+// Read count bits starting at bit offset, most significant first.
+func readBits(data []byte, offset, count int) int {
+    output := 0
+    for ii := offset; ii < offset + count; ii++ {
+        output = output << 1 | int(data[ii / 8] >> uint(7 - ii % 8) & 1)
+    }
+    return output
+}
+
+// Write the low count bits of value starting at bit offset.
+func writeBits(data []byte, offset, count, value int) {
+    for ii := 0; ii < count; ii++ {
+        bit := value >> uint(count - 1 - ii) & 1
+        pos := offset + ii
+        data[pos / 8] |= byte(bit << uint(7 - pos % 8))
+    }
+}
@@ -0,0 +1,156 @@
+package wallet
+
+import (
+    "buildacoin/bitcoin"
+    "buildacoin/data"
+    "crypto/sha256"
+    "encoding/binary"
+    "errors"
+    "strconv"
+    "strings"
+)
+
+const (
+    // BIP44 purpose, the first level of every path
+    Purpose = 44
+    // BIP44 chain of receiving addresses
+    ExternalChain = 0
+    // BIP44 chain of change addresses
+    ChangeChain = 1
+)
+
+// Comments of the substitutions a base coin can declare to fix a coin's HD
+// wallet parameters
+const (
+    CoinTypeComment = "bip44 coin type"
+    PublicVersionComment = "bip32 public key version"
+    PrivateVersionComment = "bip32 private key version"
+    AddressVersionComment = "address version byte"
+)
+
+// Error when a derivation path string is malformed
+var ErrBadPath error = errors.New("malformed derivation path")
+
+// The parameters of a coin that shape its HD wallets
+type CoinParams struct {
+    // BIP44 coin type, unhardened
+    CoinType uint32
+    // Version byte of pubkey hash addresses
+    AddressVersion byte
+    // Version byte of wallet import format private keys
+    WIFVersion byte
+    // Versions of serialized extended keys
+    Keys KeyVersions
+}
+
+// HD wallet parameters of bitcoin's main network
+var BitcoinParams = CoinParams { 0, 0, bitcoin.WIFVersion(0),
+        BitcoinKeyVersions }
+
+// Get the HD wallet parameters of a coin built from base.  Substitutions the
+// base declares for them are used if present; otherwise extended keys get
+// bitcoin's versions, and the coin type is derived from the coin ID so that a
+// mnemonic reused across coins built here never reuses keys.
+func ParamsForCoin(base *data.Meta, coin *data.CoinSummary) (CoinParams,
+        error) {
+    output := CoinParams {
+        CoinType: DefaultCoinType(coin.ID),
+        AddressVersion: coin.AddrId,
+        Keys: BitcoinKeyVersions,
+    }
+    subs, err := coin.Subs()
+    if err != nil {
+        return output, err
+    }
+    lookup := func(comment string, bits int) (uint64, bool, error) {
+        sub, ok := base.SubByComment(comment)
+        if !ok {
+            return 0, false, nil
+        }
+        value, ok := subs[sub.Idx]
+        if !ok {
+            return 0, false, nil
+        }
+        parsed, err := strconv.ParseUint(string(value), 0, bits)
+        return parsed, err == nil, err
+    }
+
+    if value, ok, err := lookup(AddressVersionComment, 8); err != nil {
+        return output, err
+    } else if ok {
+        output.AddressVersion = byte(value)
+    }
+    if value, ok, err := lookup(CoinTypeComment, 31); err != nil {
+        return output, err
+    } else if ok {
+        output.CoinType = uint32(value)
+    }
+    if value, ok, err := lookup(PublicVersionComment, 32); err != nil {
+        return output, err
+    } else if ok {
+        output.Keys.Public = uint32(value)
+    }
+    if value, ok, err := lookup(PrivateVersionComment, 32); err != nil {
+        return output, err
+    } else if ok {
+        output.Keys.Private = uint32(value)
+    }
+    output.WIFVersion = bitcoin.WIFVersion(output.AddressVersion)
+    return output, nil
+}
+
+// Derive an unregistered BIP44 coin type from a coin ID.
+func DefaultCoinType(id data.CoinID) uint32 {
+    hash := sha256.Sum256(id.Bytes())
+    return binary.BigEndian.Uint32(hash[:]) &^ HardenedOffset
+}
+
+// Get the BIP44 path of an account: m/44'/coin'/account'.
+func (tt CoinParams) AccountPath(account uint32) []uint32 {
+    return []uint32 { Purpose + HardenedOffset,
+            tt.CoinType + HardenedOffset, account + HardenedOffset }
+}
+
+// Get the BIP44 path of an address: m/44'/coin'/account'/chain/index.
+func (tt CoinParams) AddressPath(account, chain, index uint32) []uint32 {
+    return append(tt.AccountPath(account), chain, index)
+}
+
+// Format a derivation path in the usual m/44'/0'/0'/0/0 notation.
+func FormatPath(path []uint32) string {
+    output := "m"
+    for _, index := range path {
+        if index >= HardenedOffset {
+            output += "/" + strconv.FormatUint(
+                    uint64(index - HardenedOffset), 10) + "'"
+        } else {
+            output += "/" + strconv.FormatUint(uint64(index), 10)
+        }
+    }
+    return output
+}
+
+// Parse a derivation path in m/44'/0'/0'/0/0 notation; h also marks hardened
+// indices.
+func ParsePath(input string) ([]uint32, error) {
+    parts := strings.Split(input, "/")
+    if parts[0] != "m" {
+        return nil, ErrBadPath
+    }
+    output := make([]uint32, 0, len(parts)-1)
+    for _, part := range parts[1:] {
+        hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+        if hardened {
+            part = part[:len(part)-1]
+        }
+        index, err := strconv.ParseUint(part, 10, 31)
+        if err != nil {
+            return nil, ErrBadPath
+        }
+        if hardened {
+            index += HardenedOffset
+        }
+        output = append(output, uint32(index))
+    }
+    return output, nil
+}
@@ -0,0 +1,115 @@
+package wallet
+
+import (
+    "archive/zip"
+    "buildacoin/bitcoin"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "strings"
+    "text/template"
+)
+
+const (
+    // Name of the encrypted keys within a bundle archive
+    BundleKeysName = "keys.json"
+    // Name of the printable paper wallet within a bundle archive
+    BundlePaperName = "paper-wallet.html"
+    // Number of wordlist words in generated passphrases, about 66 bits
+    PassphraseWords = 6
+)
+
+// One passphrase encrypted key of a bundle
+type BundleKey struct {
+    // What the key is for, e.g. "prodnet alert key"
+    Label string `json:"label"`
+    Address string `json:"address"`
+    // Hex, as substituted into the coin's source
+    Pubkey string `json:"pubkey"`
+    // BIP38 encrypted private key
+    Encrypted string `json:"encrypted key"`
+}
+
+// The private keys generated for a coin, encrypted under one passphrase
+type KeyBundle struct {
+    Coin string `json:"coin"`
+    CoinID string `json:"coin id"`
+    AddressVersion byte `json:"address version"`
+    Keys []BundleKey `json:"keys"`
+}
+
+// Construct an empty bundle for a coin.
+func NewKeyBundle(coin, coinId string, addressVersion byte) *KeyBundle {
+    return &KeyBundle {
+        Coin: coin,
+        CoinID: coinId,
+        AddressVersion: addressVersion,
+        Keys: []BundleKey {},
+    }
+}
+
+// Encrypt a key into the bundle.  Keys are bound to their uncompressed
+// pubkey address, since that is the form substituted into coin source.
+func (tt *KeyBundle) Add(label string, key bitcoin.PrivateKey,
+        passphrase string) error {
+    encrypted, err := EncryptKey(key, passphrase, tt.AddressVersion, false)
+    if err != nil {
+        return err
+    }
+    pubkey := key.UncompressedPublicKey()
+    tt.Keys = append(tt.Keys, BundleKey {
+        Label: label,
+        Address: bitcoin.PubkeyAddress(tt.AddressVersion, pubkey).String(),
+        Pubkey: hex.EncodeToString(pubkey),
+        Encrypted: encrypted,
+    })
+    return nil
+}
+
+// Write the bundle as a zip archive holding the keys as JSON and a paper
+// wallet rendered from paperWallet with the bundle as its argument.
+func (tt *KeyBundle) WriteArchive(output io.Writer,
+        paperWallet *template.Template) error {
+    archive := zip.NewWriter(output)
+
+    file, err := archive.Create(BundleKeysName)
+    if err != nil {
+        return err
+    }
+    keysJSON, err := json.MarshalIndent(tt, "", "    ")
+    if err != nil {
+        return err
+    }
+    _, err = file.Write(append(keysJSON, '\n'))
+    if err != nil {
+        return err
+    }
+
+    file, err = archive.Create(BundlePaperName)
+    if err != nil {
+        return err
+    }
+    err = paperWallet.Execute(file, tt)
+    if err != nil {
+        return err
+    }
+
+    return archive.Close()
+}
+
+// Generate a random passphrase of PassphraseWords wordlist words.
+func NewPassphrase(random io.Reader) (string, error) {
+    words := make([]string, PassphraseWords)
+    buf := make([]byte, 2)
+    for ii := range words {
+        _, err := io.ReadFull(random, buf)
+        if err != nil {
+            return "", err
+        }
+        // 2048 divides 65536, so this is unbiased
+        words[ii] = englishWords[binary.BigEndian.Uint16(buf) %
+                MnemonicWordCount]
+    }
+    return strings.Join(words, " "), nil
+}
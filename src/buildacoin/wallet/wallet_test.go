@@ -0,0 +1,212 @@
+package wallet
+
+import (
+    "archive/zip"
+    "bytes"
+    "buildacoin/bitcoin"
+    "encoding/hex"
+    "strings"
+    "testing"
+    "text/template"
+)
+
+func TestMnemonic(t *testing.T) {
+    entropy := make([]byte, 16)
+    mnemonic, err := EntropyToMnemonic(entropy)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := strings.Repeat("abandon ", 11) + "about"
+    if mnemonic != expected {
+        t.Fatalf("expected '%s', got '%s'\n", expected, mnemonic)
+    }
+    seed := hex.EncodeToString(MnemonicToSeed(mnemonic, "TREZOR"))
+    expectedSeed := "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa370" +
+            "8e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f0016" +
+            "98e7463b04"
+    if seed != expectedSeed {
+        t.Fatalf("seed mismatch: %s\n", seed)
+    }
+
+    entropy = []byte("0123456789abcdef0123456789abcdef")
+    mnemonic, err = EntropyToMnemonic(entropy)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(strings.Fields(mnemonic)) != 24 {
+        t.Fatalf("expected 24 words: %s\n", mnemonic)
+    }
+    decoded, err := MnemonicToEntropy(mnemonic)
+    if err != nil || string(decoded) != string(entropy) {
+        t.Fatalf("round trip failed: %v\n", err)
+    }
+
+    _, err = MnemonicToEntropy(strings.Repeat("abandon ", 12))
+    if err != ErrMnemonicChecksum {
+        t.Fatalf("expected checksum error, got %v\n", err)
+    }
+    _, err = MnemonicToEntropy(strings.Repeat("abandon ", 11) + "aboot")
+    if err != ErrMnemonicWord {
+        t.Fatalf("expected word error, got %v\n", err)
+    }
+}
+
+// BIP32 test vector 1
+func TestExtendedKeys(t *testing.T) {
+    seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+    master, err := NewMasterKey(seed, BitcoinKeyVersions)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected := []string {
+        "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvN" +
+                "KmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi",
+        "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESF" +
+                "jqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8",
+    }
+    if master.String() != expected[0] ||
+            master.Public().String() != expected[1] {
+        t.Fatalf("master mismatch: %s %s\n", master, master.Public())
+    }
+
+    path, err := ParsePath("m/0'/1/2h")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if FormatPath(path) != "m/0'/1/2'" {
+        t.Fatalf("path mismatch: %s\n", FormatPath(path))
+    }
+    child, err := master.DerivePath(path)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    expected = []string {
+        "xprv9z4pot5VBttmtdRTWfWQmoH1taj2axGVzFqSb8C9xaxKymcFzXBDptWmT7FwuE" +
+                "zG3ryjH4ktypQSAewRiNMjANTtpgP4mLTj34bhnZX7UiM",
+        "xpub6D4BDPcP2GT577Vvch3R8wDkScZWzQzMMUm3PWbmWvVJrZwQY4VUNgqFJPMM3N" +
+                "o2dFDFGTsxxpG5uJh7n7epu4trkrX7x7DogT5Uv6fcLW5",
+    }
+    if child.String() != expected[0] ||
+            child.Public().String() != expected[1] {
+        t.Fatalf("child mismatch: %s %s\n", child, child.Public())
+    }
+
+    // public derivation agrees with private derivation
+    hardened, _ := master.Child(HardenedOffset)
+    fromPublic, err := hardened.Public().Child(1)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    fromPrivate, _ := hardened.Child(1)
+    if fromPublic.String() != fromPrivate.Public().String() {
+        t.Fatalf("public derivation mismatch\n")
+    }
+    _, err = hardened.Public().Child(HardenedOffset)
+    if err != ErrHardenedPublic {
+        t.Fatalf("expected hardened public error, got %v\n", err)
+    }
+
+    parsed, err := ParseExtendedKey(expected[1], BitcoinKeyVersions)
+    if err != nil || parsed.String() != expected[1] || parsed.IsPrivate() {
+        t.Fatalf("parse failed: %v\n", err)
+    }
+    _, err = ParseExtendedKey(expected[1], KeyVersions { 1, 2 })
+    if err != ErrKeyVersion {
+        t.Fatalf("expected version error, got %v\n", err)
+    }
+}
+
+func TestCoinPaths(t *testing.T) {
+    params := CoinParams { 2, 0x30, 0xb0, BitcoinKeyVersions }
+    path := FormatPath(params.AddressPath(0, ChangeChain, 7))
+    if path != "m/44'/2'/0'/1/7" {
+        t.Fatalf("path mismatch: %s\n", path)
+    }
+    _, err := ParsePath("44'/0'")
+    if err != ErrBadPath {
+        t.Fatalf("expected bad path error, got %v\n", err)
+    }
+}
+
+func TestEncryptedKeys(t *testing.T) {
+    // BIP38 test vectors, no compression and compression
+    vectors := [][]string {
+        { "6PRVWUbkzzsbcVac2qwfssoUJAN1Xhrg6bNk8J7Nzm5H7kxEbn2Nh2ZoGg",
+                "5KN7MzqK5wt2TP1fQCYyHBtDrXdJuXbUzm4A9rKAteGu3Qi5CVR" },
+        { "6PYNKZ1EAgYgmQfmNVamxyXVWHzK5s6DGhwP4J5o44cvXdoY7sRzhtpUeo",
+                "L44B5gGEpqEDRS9vVPz7QT35jcBG2r3CZwSwQ4fCewXAhAhqGVpP" },
+    }
+    for ii, vector := range vectors {
+        key, compressed, err := DecryptKey(vector[0], "TestingOneTwoThree", 0)
+        if err != nil {
+            t.Fatalf("vector %d: %s\n", ii, err.Error())
+        }
+        wif := key.UncompressedWIF(bitcoin.WIFVersion(0))
+        if compressed {
+            wif = key.WIF(bitcoin.WIFVersion(0))
+        }
+        if wif != vector[1] || compressed != (ii == 1) {
+            t.Fatalf("vector %d: got %s\n", ii, wif)
+        }
+    }
+
+    key, err := bitcoin.PrivateKeyFromBytes(bytes.Repeat([]byte { 7 }, 32))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    encrypted, err := EncryptKey(key, "passphrase", 0x30, false)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if !strings.HasPrefix(encrypted, "6P") {
+        t.Fatalf("unexpected encrypted key %s\n", encrypted)
+    }
+    decrypted, compressed, err := DecryptKey(encrypted, "passphrase", 0x30)
+    if err != nil || decrypted != key || compressed {
+        t.Fatalf("round trip failed: %v\n", err)
+    }
+    _, _, err = DecryptKey(encrypted, "wrong", 0x30)
+    if err != ErrBadPassphrase {
+        t.Fatalf("expected passphrase error, got %v\n", err)
+    }
+    _, _, err = DecryptKey(encrypted, "passphrase", 0)
+    if err != ErrBadPassphrase {
+        t.Fatalf("expected passphrase error for other coin, got %v\n", err)
+    }
+}
+
+func TestKeyBundle(t *testing.T) {
+    key, err := bitcoin.PrivateKeyFromBytes(bytes.Repeat([]byte { 9 }, 32))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    bundle := NewKeyBundle("testcoin", "00ff", 0x30)
+    err = bundle.Add("alert key", key, "passphrase")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    paper := template.Must(template.New("paper").Parse(
+            "{{range .Keys}}{{.Address}}{{end}}"))
+    buf := new(bytes.Buffer)
+    err = bundle.WriteArchive(buf, paper)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()),
+            int64(buf.Len()))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if len(archive.File) != 2 || archive.File[0].Name != BundleKeysName ||
+            archive.File[1].Name != BundlePaperName {
+        t.Fatalf("unexpected archive contents\n")
+    }
+
+    passphrase, err := NewPassphrase(bytes.NewReader(make([]byte, 12)))
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    if passphrase != strings.TrimSpace(strings.Repeat("abandon ", 6)) {
+        t.Fatalf("unexpected passphrase '%s'\n", passphrase)
+    }
+}
@@ -3,17 +3,32 @@ package render
 import (
     "buildacoin/data"
     "buildacoin/source"
+    "buildacoin/source/types"
+    "buildacoin/wallet"
     "bytes"
+    "crypto/rand"
     "encoding/hex"
     "encoding/gob"
+    "encoding/json"
     "errors"
+    "io/ioutil"
+    "mime/multipart"
     "net/http"
     "strconv"
     "strings"
+    "text/template"
+    "time"
     cointemplate "buildacoin/template"
     webutil "buildacoin/web/util"
 )
 
+// Form field holding the passphrase to encrypt a coin's generated keys with
+const KeyPassphraseField = "key passphrase"
+// Form field choosing the archive format of a coin, "" for the base's own
+const ArchiveFormatField = "archive format"
+// Largest coin form accepted, in bytes, leaving room for a logo
+const MaxFormLen = types.MaxPNGLen + 64 * 1024
+
 // web page where base coin template inputs are presented to the user on GET
 // and a coin is built from input values on POST
 type CoinPage struct {
@@ -22,6 +37,10 @@ type CoinPage struct {
     markupTemplate *basePage
     inputs [][]data.Input
     db data.DB
+    // shown instead of streaming the coin when keys were generated for it
+    readyPage *basePage
+    paperWallet *template.Template
+    vault *keyVault
 }
 
 func NewCoinPage(conf *data.Conf, base *data.Meta) (*CoinPage, error) {
@@ -51,13 +70,33 @@ func NewCoinPage(conf *data.Conf, base *data.Meta) (*CoinPage, error) {
         inputs = append(inputs, groupCache[base.InGroup(ii)])
     }
 
-    return &CoinPage { base, conf, markup, inputs, db }, nil
+    readyPage, err := SimplePage(conf, "markup/coin-ready.html",
+            "style/coin.css")
+    if err != nil {
+        return nil, err
+    }
+    paperStr, err := data.StringAsset(conf, "markup/paper-wallet.html")
+    if err != nil {
+        return nil, err
+    }
+    paperWallet, err := template.New("paper wallet").Parse(paperStr)
+    if err != nil {
+        return nil, err
+    }
+
+    return &CoinPage { base, conf, markup, inputs, db, readyPage, paperWallet,
+            newKeyVault() }, nil
 }
 
 func (tt *CoinPage) ServeHTTP(out http.ResponseWriter, req *http.Request) {
     switch req.Method {
     case "GET":
-        tt.serveForm(out, req, nil, nil)
+        query := req.URL.Query()
+        if query.Get("download") != "" || query.Get("keys") != "" {
+            tt.serveVault(out, req)
+        } else {
+            tt.serveForm(out, req, nil, nil)
+        }
     case "POST":
         tt.serveCoin(out, req)
     default:
@@ -65,27 +104,60 @@ func (tt *CoinPage) ServeHTTP(out http.ResponseWriter, req *http.Request) {
     }
 }
 
+// An input as the coin form offers it
+type formInput struct {
+    data.Input
+    // Bounds and step of a number input's control, "" for none
+    Min, Max, Step string
+    // Why the value last given for the input was refused, if it was
+    Errors []string
+}
+
 func (tt *CoinPage) serveForm(out http.ResponseWriter, req *http.Request,
         values map[string]string, errs []error) {
-    inputs := tt.inputs
-    // override default form values if supplied
-    if values != nil {
-        inputs = make([][]data.Input, len(tt.inputs))
-        for groupIdx, group := range tt.inputs {
-            inputs[groupIdx] = make([]data.Input, len(group))
-            for inputIdx, input := range group {
-                newInput := input
-                override, ok := values[input.Id]
-                if ok {
-                    newInput.Default = override
-                }
-                inputs[groupIdx][inputIdx] = newInput
+    // a coin asked for as JSON that can't be made gets its errors as JSON;
+    // the form itself is only ever a page
+    if len(errs) > 0 && req != nil && req.Method == "POST" && wantsJSON(req) {
+        serveErrorsJSON(out, errs)
+        return
+    }
+    // errors with single inputs are shown beside them
+    inputErrs := make(map[string][]string)
+    pageErrs := make([]error, 0, len(errs))
+    for _, err := range inputErrors(errs) {
+        inputErr, ok := err.(source.InputError)
+        if ok && inputErr.Input != "" {
+            inputErrs[inputErr.Input] = append(inputErrs[inputErr.Input],
+                    inputErr.Cause.Error())
+        } else {
+            pageErrs = append(pageErrs, err)
+        }
+    }
+    inputs := make([][]formInput, len(tt.inputs))
+    for groupIdx, group := range tt.inputs {
+        inputs[groupIdx] = make([]formInput, len(group))
+        for inputIdx, input := range group {
+            // override default form values if supplied
+            override, ok := values[input.Id]
+            if ok {
+                input.Default = override
+            }
+            newInput := formInput { Input: input,
+                    Min: source.FormatBound(input.Min),
+                    Max: source.FormatBound(input.Max),
+                    Errors: inputErrs[input.Id] }
+            switch input.Type {
+            case data.InputInt:
+                newInput.Step = "1"
+            case data.InputNumber:
+                newInput.Step = "any"
             }
+            inputs[groupIdx][inputIdx] = newInput
         }
     }
     err := tt.markupTemplate.Execute(out, map[string]interface{} {
         "groups": inputs,
-    }, errs)
+    }, pageErrs)
     if err != nil {
         NewErrorPage(tt.conf,
             "error creating coin form: " + err.Error()).ServeHTTP(out, req)
@@ -94,8 +166,53 @@ func (tt *CoinPage) serveForm(out http.ResponseWriter, req *http.Request,
     }
 }
 
+// Split any ErrBadValues among errors into the InputErrors they hold.
+func inputErrors(errs []error) []error {
+    output := make([]error, 0, len(errs))
+    for _, err := range errs {
+        if badValues, ok := err.(source.ErrBadValues); ok {
+            for _, inputErr := range badValues {
+                output = append(output, inputErr)
+            }
+        } else {
+            output = append(output, err)
+        }
+    }
+    return output
+}
+
+// Check whether a request asks for a JSON response rather than a page.
+func wantsJSON(req *http.Request) bool {
+    return strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// Serve errors with a coin as a JSON object whose "errors" each give an error
+// and, if it was with the value of an input, the input.
+func serveErrorsJSON(out http.ResponseWriter, errs []error) {
+    body := make([]interface{}, 0, len(errs))
+    for _, err := range inputErrors(errs) {
+        if inputErr, ok := err.(source.InputError); ok {
+            body = append(body, inputErr)
+        } else {
+            body = append(body, map[string]string { "error": err.Error() })
+        }
+    }
+    out.Header()["Content-Type"] = []string { "application/json" }
+    out.WriteHeader(http.StatusBadRequest)
+    err := json.NewEncoder(out).Encode(map[string]interface{} {
+        "errors": body,
+    })
+    if err != nil {
+        // TODO log
+    }
+}
+
 func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
-    err := req.ParseForm()
+    req.Body = http.MaxBytesReader(out, req.Body, MaxFormLen)
+    err := req.ParseMultipartForm(MaxFormLen)
+    if err == http.ErrNotMultipart {
+        err = req.ParseForm()
+    }
     if err != nil {
         err = errors.New("error reading coinfiguration data: " + err.Error())
         tt.serveForm(out, req, nil, []error { err })
@@ -103,15 +220,27 @@ func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
         return
     }
 
-    // unpack the www form data
+    // unpack the www form data, with uploads as their contents
     values := make(map[string]string)
     for key, value := range req.Form {
         values[key] = value[0]
     }
+    if req.MultipartForm != nil {
+        for key, files := range req.MultipartForm.File {
+            values[key], err = readUpload(files[0])
+            if err != nil {
+                err = errors.New("error reading upload: " + err.Error())
+                tt.serveForm(out, req, values, []error { err })
+                return
+            }
+        }
+    }
 
     // create a unique coin ID; this will be the only distinct handle on a
-    // previously generated coin
+    // previously generated coin.  Its values are produced in a context seeded
+    // by the ID, so that the ID and the time it was made reproduce them.
     coinID := data.NewCoinID()
+    created := time.Now().UTC().Truncate(time.Second)
 
     coinName := values["name"]
     if len(coinName) < 1 {
@@ -119,7 +248,26 @@ func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
     }
     coinName = strings.ToLower(coinName)
 
-    filterMap, err := source.BuildFilterMap(tt.base, values)
+    format := values[ArchiveFormatField]
+    if cointemplate.IsGitFormat(format) {
+        _, err := source.GitRunner(tt.base, cointemplate.TarArchive, format)
+        if err != nil {
+            tt.serveForm(out, req, values, []error { err })
+            return
+        }
+    } else if format != "" {
+        parsed, err := cointemplate.ParseFormat(format)
+        if err == nil && !parsed.Writable() {
+            err = cointemplate.ErrUnwritableFormat { Format: format }
+        }
+        if err != nil {
+            tt.serveForm(out, req, values, []error { err })
+            return
+        }
+    }
+
+    filterMap, keys, err := source.BuildFilterMapKeys(
+            source.CoinContext(coinID, created), tt.base, values)
     if err != nil {
         tt.serveForm(out, req, values, []error { err })
         // TODO log
@@ -134,9 +282,36 @@ func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
         }
     }
 
-    template, streamType, err := tt.base.Template()
+    // Coins with generated keys can't be streamed straight back: the keys
+    // have to be handed over too, so both wait in the vault for download.
+    if len(keys) > 0 {
+        tt.serveKeyedCoin(out, req, values, coinID, created, coinName,
+                format, filterMap, keys)
+        return
+    }
+
+    if !tt.streamCoin(out, req, values, coinName, format, filterMap) {
+        return
+    }
+    tt.recordCoin(req, values, coinID, created, filterMap)
+}
+
+func readUpload(header *multipart.FileHeader) (string, error) {
+    file, err := header.Open()
     if err != nil {
-        const terse = "error getting coin template"
+        return "", err
+    }
+    defer file.Close()
+    buf, err := ioutil.ReadAll(file)
+    return string(buf), err
+}
+
+// Render a coin's source as an attachment in the given archive format, or the
+// base's if "", returning whether it was sent.
+func (tt *CoinPage) streamCoin(out http.ResponseWriter, req *http.Request,
+        values map[string]string, coinName string, format string,
+        filterMap cointemplate.FilterMap) bool {
+    fail := func(terse string, err error) {
         if tt.conf.Debug() {
             err = errors.New(terse + ": " + err.Error())
         } else {
@@ -144,24 +319,158 @@ func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
         }
         tt.serveForm(out, req, values, []error { err })
         // TODO log
-        return
     }
 
-    runner := cointemplate.GetRunner(streamType)
+    template, streamType, err := tt.base.Template()
+    if err != nil {
+        fail("error getting coin template", err)
+        return false
+    }
+    defer template.Close()
+
+    if format == "" {
+        format = streamType
+    }
+    var runner cointemplate.Runner
+    if cointemplate.IsGitFormat(format) {
+        gitRunner, err := source.GitRunner(tt.base, streamType, format)
+        if err != nil {
+            fail("error reading coin template", err)
+            return false
+        }
+        gitRunner.CoinMessage = "Make " + coinName
+        runner = gitRunner
+    } else {
+        archiveRunner, err := source.Runner(tt.base, streamType, format)
+        if err != nil {
+            fail("error reading coin template", err)
+            return false
+        }
+        archiveRunner.SigningKey, err = tt.conf.ManifestKey()
+        if err != nil {
+            fail("error loading manifest signing key", err)
+            return false
+        }
+        runner = archiveRunner
+    }
 
     outHeader := out.Header()
     outHeader["Content-Disposition"] = []string { "attachment; filename=" +
-            coinName + "." + streamType }
+            coinName + "." + format }
 
     err = runner.Run(out, template, filterMap)
     if err != nil {
         // TODO log
+        return false
+    }
+    return true
+}
+
+// Encrypt the keys generated for a coin into a bundle, hold the coin and the
+// bundle for one download each, and show the page linking to them.
+func (tt *CoinPage) serveKeyedCoin(out http.ResponseWriter, req *http.Request,
+        values map[string]string, coinID data.CoinID, created time.Time,
+        coinName string, format string, filterMap cointemplate.FilterMap,
+        keys []source.GeneratedKey) {
+    fail := func(terse string, err error) {
+        if tt.conf.Debug() {
+            err = errors.New(terse + ": " + err.Error())
+        } else {
+            err = errors.New(terse)
+        }
+        tt.serveForm(out, req, values, []error { err })
+        // TODO log
+    }
+
+    // a passphrase we generate is shown once on the ready page and then
+    // forgotten
+    passphrase := values[KeyPassphraseField]
+    generatedPassphrase := ""
+    if passphrase == "" {
+        var err error
+        passphrase, err = wallet.NewPassphrase(rand.Reader)
+        if err != nil {
+            fail("error generating key passphrase", err)
+            return
+        }
+        generatedPassphrase = passphrase
+    }
+
+    addrVersion := byte(0)
+    if sub, ok := tt.base.SubByComment(wallet.AddressVersionComment); ok {
+        version, err := strconv.ParseUint(string(filterMap[sub.Idx]), 0, 8)
+        if err == nil {
+            addrVersion = byte(version)
+        }
+    }
+    bundle := wallet.NewKeyBundle(values["name"],
+            hex.EncodeToString(coinID.Bytes()), addrVersion)
+    for _, key := range keys {
+        err := bundle.Add(key.Sub.Comment, key.Key, passphrase)
+        if err != nil {
+            fail("error encrypting generated keys", err)
+            return
+        }
+    }
+    archive := new(bytes.Buffer)
+    err := bundle.WriteArchive(archive, tt.paperWallet)
+    if err != nil {
+        fail("error writing key bundle", err)
+        return
+    }
+
+    token, err := tt.vault.put(coinName, format, filterMap, archive.Bytes())
+    if err != nil {
+        fail("error holding coin for download", err)
+        return
+    }
+    tt.recordCoin(req, values, coinID, created, filterMap)
+
+    err = tt.readyPage.Execute(out, map[string]interface{} {
+        "name": values["name"],
+        "token": token,
+        "passphrase": generatedPassphrase,
+        "keys": bundle.Keys,
+        "ttl": VaultTTL.String(),
+    }, nil)
+    if err != nil {
+        // TODO log
+    }
+}
+
+// Serve the parts of a coin held in the vault, each only once.
+func (tt *CoinPage) serveVault(out http.ResponseWriter, req *http.Request) {
+    query := req.URL.Query()
+    if token := query.Get("download"); token != "" {
+        coinName, format, filterMap, ok := tt.vault.takeCoin(token)
+        if !ok {
+            NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+            return
+        }
+        tt.streamCoin(out, req, nil, coinName, format, filterMap)
         return
     }
 
-    template.Close()
+    coinName, archive, ok := tt.vault.takeKeys(query.Get("keys"))
+    if !ok {
+        NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+        return
+    }
+    outHeader := out.Header()
+    outHeader["Content-Type"] = []string { "application/zip" }
+    outHeader["Content-Disposition"] = []string { "attachment; filename=" +
+            coinName + "-keys.zip" }
+    outHeader["Cache-Control"] = []string { "no-store" }
+    _, err := out.Write(archive)
+    if err != nil {
+        // TODO log
+    }
+}
 
-    // add the newborn coin to the db
+// Add a newborn coin to the db.
+func (tt *CoinPage) recordCoin(req *http.Request, values map[string]string,
+        coinID data.CoinID, created time.Time,
+        filterMap cointemplate.FilterMap) {
     addrId, err := strconv.ParseUint(values["versionbyte"], 0, 8)
     if err != nil {
         // TODO log
@@ -212,6 +521,7 @@ func (tt *CoinPage) serveCoin(out http.ResponseWriter, req *http.Request) {
         initDiff,
         values["genesis message"],
         serialBuf.Bytes(),
+        created,
     }
 
     err = tt.db.PutCoinSummary(newCoin, webutil.RequestOrigin(tt.conf, req),
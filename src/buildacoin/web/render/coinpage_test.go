@@ -0,0 +1,43 @@
+package render
+
+import (
+    "buildacoin/data"
+    "errors"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "text/template"
+)
+
+func testCoinPage() *CoinPage {
+    markup := template.Must(template.New("coin").Parse(
+            "{{range .content.groups}}{{range .}}{{.Id}} {{end}}{{end}}" +
+            "{{range .errors}}error: {{.}}{{end}}"))
+    return &CoinPage { base: data.NewMeta("test", "", "", nil, nil, nil),
+            markupTemplate: &basePage { markupTemplate: markup },
+            inputs: [][]data.Input { { data.Input { Id: "name" } } } }
+}
+
+func TestCoinFormJSON(t *testing.T) {
+    page := testCoinPage()
+
+    // asking for the form as JSON still gets the form
+    req := httptest.NewRequest("GET", "/", nil)
+    req.Header.Set("Accept", "application/json")
+    out := httptest.NewRecorder()
+    page.ServeHTTP(out, req)
+    if out.Code != 200 || !strings.Contains(out.Body.String(), "name") {
+        t.Fatalf("expected the form, got %d '%s'\n", out.Code, out.Body)
+    }
+
+    // a coin that can't be made gets its errors as JSON
+    req = httptest.NewRequest("POST", "/", nil)
+    req.Header.Set("Accept", "application/json")
+    out = httptest.NewRecorder()
+    page.serveForm(out, req, nil, []error { errors.New("broken") })
+    expected := `{"errors":[{"error":"broken"}]}` + "\n"
+    if out.Code != 400 || out.Body.String() != expected {
+        t.Fatalf("expected 400 '%s', got %d '%s'\n", expected, out.Code,
+                out.Body)
+    }
+}
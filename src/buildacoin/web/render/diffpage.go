@@ -0,0 +1,134 @@
+package render
+
+import (
+    "buildacoin/data"
+    "bytes"
+    "encoding/hex"
+    "buildacoin/source"
+    "net/http"
+    "strings"
+    cointemplate "buildacoin/template"
+)
+
+// plain text unified diffs of coins' source, routed by path within root:
+//     <coin id hex>                   against the base's defaults
+//     <coin id hex>/<coin id hex>     from the first coin to the second
+type DiffPage struct {
+    conf *data.Conf
+    db data.DB
+    root string
+}
+
+func NewDiffPage(conf *data.Conf, root string) (*DiffPage, error) {
+    db, err := data.DBConnect(conf)
+    if err != nil {
+        return nil, err
+    }
+    return &DiffPage { conf, db, root }, nil
+}
+
+func (tt *DiffPage) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+    if req.Method != "GET" {
+        out.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+
+    path := strings.Split(strings.TrimPrefix(req.URL.Path, tt.root), "/")
+    if len(path) > 2 {
+        NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+        return
+    }
+    coins := make([]data.CoinSummary, len(path))
+    values := make([]cointemplate.FilterMap, len(path))
+    for ii, idHex := range path {
+        var err error
+        coins[ii], values[ii], err = tt.coin(idHex)
+        if err != nil {
+            NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+            return
+        }
+    }
+    if len(coins) == 2 && coins[0].TemplateID != coins[1].TemplateID {
+        NewErrorPage(tt.conf,
+            "coins have different bases").ServeHTTP(out, req)
+        return
+    }
+    base, err := data.LoadMeta(tt.conf, coins[0].TemplateID)
+    if err != nil {
+        NewErrorPage(tt.conf,
+            "error loading base coin: " + err.Error()).ServeHTTP(out, req)
+        return
+    }
+    tt.serveDiff(out, req, base, coins, values)
+}
+
+// Serve the diff of coins made from base.  Coins made from earlier versions of
+// the base are upgraded to the current one first, and noted above the diff;
+// those that can't be are reported instead.  The diff is written only once
+// it's whole, so a failure part way through is reported rather than ending it
+// short.
+func (tt *DiffPage) serveDiff(out http.ResponseWriter, req *http.Request,
+        base *data.Meta, coins []data.CoinSummary,
+        values []cointemplate.FilterMap) {
+    notes := new(bytes.Buffer)
+    for ii, coin := range coins {
+        if coin.TemplateVer == base.Version() {
+            continue
+        }
+        idHex := hex.EncodeToString(coin.ID.Bytes())
+        upgraded, _, err := source.UpgradeFilterMap(
+                source.CoinContext(coin.ID, coin.Created), base,
+                coin.TemplateVer, values[ii])
+        if err != nil {
+            NewErrorPage(tt.conf, "coin " + idHex +
+                " was made from " + base.Id() + " " + coin.TemplateVer +
+                ", which can't be compared with " + base.Version() + ": " +
+                err.Error()).ServeHTTP(out, req)
+            return
+        }
+        values[ii] = upgraded
+        notes.WriteString("coin " + idHex + " was made from " +
+                base.Id() + " " + coin.TemplateVer + "; compared as " +
+                "upgraded to " + base.Version() + "\n")
+    }
+
+    diff := new(bytes.Buffer)
+    var err error
+    if len(values) == 1 {
+        err = source.DiffDefaults(
+                source.CoinContext(coins[0].ID, coins[0].Created), diff,
+                base, values[0])
+    } else {
+        err = source.Diff(diff, base, values[0], values[1])
+    }
+    if err != nil {
+        NewErrorPage(tt.conf,
+            "error comparing coins: " + err.Error()).ServeHTTP(out, req)
+        return
+    }
+
+    out.Header()["Content-Type"] = []string { "text/plain; charset=utf-8" }
+    if notes.Len() > 0 {
+        notes.WriteString("\n")
+        out.Write(notes.Bytes())
+    }
+    out.Write(diff.Bytes())
+}
+
+// Get a coin's record and the values it was made with.
+func (tt *DiffPage) coin(idHex string) (data.CoinSummary,
+        cointemplate.FilterMap, error) {
+    id, err := data.ParseCoinID(strings.ToLower(idHex))
+    if err != nil {
+        return data.CoinSummary{}, nil, err
+    }
+    summary, err := tt.db.GetCoinSummary(id)
+    if err != nil {
+        return data.CoinSummary{}, nil, err
+    }
+    subs, err := summary.Subs()
+    if err != nil {
+        return data.CoinSummary{}, nil, err
+    }
+    return summary, cointemplate.FilterMap(subs), nil
+}
@@ -0,0 +1,163 @@
+package render
+
+import (
+    "archive/tar"
+    "bytes"
+    "buildacoin/data"
+    "buildacoin/source"
+    "io/ioutil"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    cointemplate "buildacoin/template"
+)
+
+// Set up a bases dir holding version 2 of a base named "test", whose template
+// has the files of the given name, contents pairs, and get a diff page on it.
+func testDiffPage(t *testing.T, files [][]string) (*DiffPage, *data.Meta,
+        func()) {
+    dir, err := ioutil.TempDir("", "buildacoin-diff-")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    archive := new(bytes.Buffer)
+    tarOut := tar.NewWriter(archive)
+    for _, file := range files {
+        tarOut.WriteHeader(&tar.Header { Name: file[0], Mode: 0644,
+                Size: int64(len(file[1])), Typeflag: tar.TypeReg })
+        tarOut.Write([]byte(file[1]))
+    }
+    tarOut.Close()
+    confPath := filepath.Join(dir, "conf.json")
+    baseDir := filepath.Join(dir, "test")
+    os.MkdirAll(baseDir, 0755)
+    ioutil.WriteFile(confPath, []byte(`{"code templates dir": "` + dir +
+            `", "debug mode": true}`), 0644)
+    ioutil.WriteFile(filepath.Join(baseDir, data.MetaFileName), []byte(`{
+        "id": "test", "version": "2",
+        "substitutions": [
+            {"substitution index": 1, "type": "literal"},
+            {"substitution index": 2, "type": "literal"},
+            {"substitution index": 3, "type": "random-hash"},
+            {"substitution index": 4, "type": "unixtime-current"}
+        ],
+        "migrations": [{"from version": "1", "to version": "2",
+            "added": [{"substitution index": 2, "default": "two"},
+                {"substitution index": 3, "default": "33"},
+                {"substitution index": 4, "default": "44"}]}]
+    }`), 0644)
+    ioutil.WriteFile(filepath.Join(baseDir, "template.tar"),
+            archive.Bytes(), 0644)
+    conf, err := data.LoadConfExplicit(confPath)
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    meta, err := data.LoadMeta(conf, "test")
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    return &DiffPage { conf: conf }, meta, func() { os.RemoveAll(dir) }
+}
+
+func TestDiffPage(t *testing.T) {
+    page, meta, cleanup := testDiffPage(t, [][]string {
+        []string { "coin/main.h", "#define NAME __._1-\n#define X __._2-\n" },
+    })
+    defer cleanup()
+    coins := []data.CoinSummary {
+        data.CoinSummary { ID: data.NewCoinID(), TemplateID: "test",
+                TemplateVer: "1" },
+        data.CoinSummary { ID: data.NewCoinID(), TemplateID: "test",
+                TemplateVer: "2" },
+    }
+    values := []cointemplate.FilterMap {
+        cointemplate.FilterMap { 1: []byte("best") },
+        cointemplate.FilterMap { 1: []byte("better"), 2: []byte("two") },
+    }
+
+    // coins made from earlier versions are compared as upgraded
+    req := httptest.NewRequest("GET", "/", nil)
+    out := httptest.NewRecorder()
+    page.serveDiff(out, req, meta, coins, values)
+    expected := "was made from test 1; compared as upgraded to 2\n\n" +
+            "--- a/coin/main.h\n+++ b/coin/main.h\n" +
+            "@@ -1,2 +1,2 @@\n-#define NAME best\n+#define NAME better\n" +
+            " #define X two\n"
+    if out.Code != 200 || !strings.HasSuffix(out.Body.String(), expected) {
+        t.Fatalf("expected 200 ending:\n%s\nactual %d:\n%s\n", expected,
+                out.Code, out.Body)
+    }
+
+    // and those that can't be upgraded are reported
+    coins[0].TemplateVer = "0"
+    values[0] = cointemplate.FilterMap { 1: []byte("best") }
+    out = httptest.NewRecorder()
+    page.serveDiff(out, req, meta, coins, values)
+    if out.Code != 500 ||
+            !strings.Contains(out.Body.String(), "made from test 0") {
+        t.Fatalf("expected version mismatch, got %d '%s'\n", out.Code,
+                out.Body)
+    }
+}
+
+func TestDiffPageFailure(t *testing.T) {
+    // the second file fails after the first has been diffed
+    page, meta, cleanup := testDiffPage(t, [][]string {
+        []string { "coin/main.h", "#define NAME __._1-\n" },
+        []string { "coin/bad.h", "__._1x\n" },
+    })
+    defer cleanup()
+    coins := []data.CoinSummary {
+        data.CoinSummary { TemplateID: "test", TemplateVer: "2" },
+        data.CoinSummary { TemplateID: "test", TemplateVer: "2" },
+    }
+    values := []cointemplate.FilterMap {
+        cointemplate.FilterMap { 1: []byte("best"), 2: nil },
+        cointemplate.FilterMap { 1: []byte("better"), 2: nil },
+    }
+    out := httptest.NewRecorder()
+    page.serveDiff(out, httptest.NewRequest("GET", "/", nil), meta, coins,
+            values)
+    if out.Code != 500 || strings.Contains(out.Body.String(), "---") {
+        t.Fatalf("expected only an error, got %d '%s'\n", out.Code,
+                out.Body)
+    }
+}
+
+func TestDiffPageDefaults(t *testing.T) {
+    page, meta, cleanup := testDiffPage(t, [][]string {
+        []string { "coin/main.h",
+                "#define NAME __._1-\n#define HASH __._3-\n" +
+                "#define TIME __._4-\n" },
+    })
+    defer cleanup()
+    coin := data.CoinSummary { ID: data.NewCoinID(), TemplateID: "test",
+            TemplateVer: "2", Created: time.Unix(1400000000, 0) }
+    values, _, err := source.BuildFilterMapKeys(
+            source.CoinContext(coin.ID, coin.Created), meta,
+            map[string]string {})
+    if err != nil {
+        t.Fatal(err.Error())
+    }
+    values[1] = []byte("best")
+
+    // the defaults' random and time-dependent values are the coin's, so
+    // only the changed input shows, the same every time
+    expected := "--- a/coin/main.h\n+++ b/coin/main.h\n" +
+            "@@ -1,3 +1,3 @@\n-#define NAME \n+#define NAME best\n" +
+            " #define HASH " + string(values[3]) + "\n" +
+            " #define TIME " + string(values[4]) + "\n"
+    for ii := 0; ii < 2; ii++ {
+        out := httptest.NewRecorder()
+        page.serveDiff(out, httptest.NewRequest("GET", "/", nil), meta,
+                []data.CoinSummary { coin },
+                []cointemplate.FilterMap { values })
+        if out.Code != 200 || out.Body.String() != expected {
+            t.Fatalf("expected:\n%s\nactual %d:\n%s\n", expected, out.Code,
+                    out.Body)
+        }
+    }
+}
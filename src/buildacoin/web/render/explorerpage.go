@@ -0,0 +1,206 @@
+package render
+
+import (
+    "buildacoin/bitcoin"
+    "buildacoin/chain"
+    "buildacoin/data"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const (
+    // Number of blocks listed on a coin's explorer index page
+    ExplorerRecentBlocks = 10
+    // p2sh version byte for coins whose template doesn't record one
+    DefaultScriptVersion = 5
+)
+
+// block explorer for coins built here whose chains the server can reach.
+// Pages are routed by path within root:
+//     <coin id hex>/                  recent blocks
+//     <coin id hex>/block/<hash or height>
+//     <coin id hex>/tx/<txid>
+//     <coin id hex>/address/<address>
+type ExplorerPage struct {
+    conf *data.Conf
+    db data.DB
+    root string
+    indexPage *basePage
+    blockPage *basePage
+    txPage *basePage
+    addressPage *basePage
+
+    lock sync.Mutex
+    coins map[string]*explorerCoin
+}
+
+// what the explorer needs to know about one hosted coin
+type explorerCoin struct {
+    name string
+    src chain.Source
+    versions chain.Versions
+}
+
+func NewExplorerPage(conf *data.Conf, root string) (*ExplorerPage, error) {
+    db, err := data.DBConnect(conf)
+    if err != nil {
+        return nil, err
+    }
+
+    output := &ExplorerPage {
+        conf: conf,
+        db: db,
+        root: root,
+        coins: make(map[string]*explorerCoin),
+    }
+    pages := map[string]**basePage {
+        "index": &output.indexPage,
+        "block": &output.blockPage,
+        "tx": &output.txPage,
+        "address": &output.addressPage,
+    }
+    for name, page := range pages {
+        *page, err = SimplePage(conf, "markup/explorer-" + name + ".html",
+                "style/explorer.css")
+        if err != nil {
+            return nil, err
+        }
+    }
+    return output, nil
+}
+
+func (tt *ExplorerPage) ServeHTTP(out http.ResponseWriter, req *http.Request) {
+    if req.Method != "GET" {
+        out.WriteHeader(http.StatusMethodNotAllowed)
+        return
+    }
+
+    path := strings.Split(strings.TrimPrefix(req.URL.Path, tt.root), "/")
+    coin, err := tt.coin(path[0])
+    if err != nil {
+        tt.serveError(out, req, err)
+        return
+    }
+
+    args := map[string]interface{} {
+        "name": coin.name,
+        "base": tt.root + path[0],
+    }
+    switch {
+    case len(path) == 1 || (len(path) == 2 && path[1] == ""):
+        args["chain"], err = chain.ViewChain(coin.src, ExplorerRecentBlocks)
+        tt.serve(out, req, tt.indexPage, args, err)
+    case len(path) == 3 && path[1] == "block":
+        args["block"], err = viewBlock(coin.src, path[2])
+        tt.serve(out, req, tt.blockPage, args, err)
+    case len(path) == 3 && path[1] == "tx":
+        var txid bitcoin.Hash
+        txid, err = bitcoin.HashFromHex(path[2])
+        if err == nil {
+            args["tx"], err = chain.ViewTx(coin.src, txid, coin.versions)
+        } else {
+            err = chain.ErrNotFound
+        }
+        tt.serve(out, req, tt.txPage, args, err)
+    case len(path) == 3 && path[1] == "address":
+        args["address"], err = chain.ViewAddress(coin.src, path[2],
+                coin.versions)
+        tt.serve(out, req, tt.addressPage, args, err)
+    default:
+        NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+    }
+}
+
+// Look up a block by height if the reference is numeric, by hash otherwise.
+func viewBlock(src chain.Source, ref string) (*chain.BlockView, error) {
+    height, err := strconv.ParseInt(ref, 10, 64)
+    if err == nil && len(ref) < bitcoin.HashSize {
+        return chain.ViewBlockAt(src, height)
+    }
+    hash, err := bitcoin.HashFromHex(ref)
+    if err != nil {
+        return nil, chain.ErrNotFound
+    }
+    return chain.ViewBlock(src, hash)
+}
+
+func (tt *ExplorerPage) serve(out http.ResponseWriter, req *http.Request,
+        page *basePage, args map[string]interface{}, err error) {
+    if err != nil {
+        tt.serveError(out, req, err)
+        return
+    }
+    err = page.Execute(out, args, nil)
+    if err != nil {
+        // TODO log
+    }
+}
+
+func (tt *ExplorerPage) serveError(out http.ResponseWriter, req *http.Request,
+        err error) {
+    switch err {
+    case chain.ErrNotFound, chain.ErrNoChain, data.ErrBadCoinID,
+            bitcoin.ErrBase58Char, bitcoin.ErrBadChecksum,
+            bitcoin.ErrAddressLen:
+        NewNotFoundPage(tt.conf).ServeHTTP(out, req)
+    default:
+        NewErrorPage(tt.conf,
+            "error exploring chain: " + err.Error()).ServeHTTP(out, req)
+    }
+}
+
+// Get the chain source and address versions of a hosted coin, caching them
+// since they don't change once the coin is built.
+func (tt *ExplorerPage) coin(idHex string) (*explorerCoin, error) {
+    idHex = strings.ToLower(idHex)
+
+    tt.lock.Lock()
+    cached, ok := tt.coins[idHex]
+    tt.lock.Unlock()
+    if ok {
+        return cached, nil
+    }
+
+    chainConf, ok := tt.conf.ExplorerChain(idHex)
+    if !ok {
+        return nil, chain.ErrNoChain
+    }
+    id, err := data.ParseCoinID(idHex)
+    if err != nil {
+        return nil, err
+    }
+    summary, err := tt.db.GetCoinSummary(id)
+    if err != nil {
+        return nil, err
+    }
+    src, err := chain.Open(chainConf)
+    if err != nil {
+        return nil, err
+    }
+
+    output := &explorerCoin {
+        name: summary.Name,
+        src: src,
+        versions: chain.Versions { Pubkey: summary.AddrId,
+                Script: DefaultScriptVersion },
+    }
+    // the p2sh version byte is only recorded among the coin's substitutions
+    base, err := data.LoadMeta(tt.conf, summary.TemplateID)
+    if err == nil {
+        sub, ok := base.SubByComment("p2sh address version byte")
+        subs, err := summary.Subs()
+        if ok && err == nil {
+            version, err := strconv.ParseUint(string(subs[sub.Idx]), 0, 8)
+            if err == nil {
+                output.versions.Script = byte(version)
+            }
+        }
+    }
+
+    tt.lock.Lock()
+    tt.coins[idHex] = output
+    tt.lock.Unlock()
+    return output, nil
+}
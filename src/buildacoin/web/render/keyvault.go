@@ -0,0 +1,112 @@
+package render
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "sync"
+    "time"
+    cointemplate "buildacoin/template"
+)
+
+const (
+    // How long a generated coin and its keys wait to be downloaded
+    VaultTTL = time.Hour
+    // Length of vault tokens in random bytes
+    vaultTokenLen = 16
+)
+
+// a coin whose keys were generated, waiting for its creator to download it
+type vaultEntry struct {
+    coinName string
+    // archive format to download the coin in, "" for the base's
+    format string
+    filterMap cointemplate.FilterMap
+    // zip archive of the encrypted key bundle; nil once downloaded
+    keys []byte
+    // whether the coin source was downloaded
+    taken bool
+    expires time.Time
+}
+
+// in-memory holding area for coins with generated keys.  Nothing put here is
+// written anywhere: each part of an entry can be taken once, and entries are
+// dropped once fully taken or expired.
+type keyVault struct {
+    lock sync.Mutex
+    entries map[string]*vaultEntry
+}
+
+func newKeyVault() *keyVault {
+    return &keyVault { entries: make(map[string]*vaultEntry) }
+}
+
+// Hold a coin and its key bundle archive, returning the token to take them
+// with.
+func (tt *keyVault) put(coinName, format string,
+        filterMap cointemplate.FilterMap, keys []byte) (string, error) {
+    tokenBytes := make([]byte, vaultTokenLen)
+    _, err := rand.Read(tokenBytes)
+    if err != nil {
+        return "", err
+    }
+    token := hex.EncodeToString(tokenBytes)
+
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    tt.sweep()
+    tt.entries[token] = &vaultEntry {
+        coinName: coinName,
+        format: format,
+        filterMap: filterMap,
+        keys: keys,
+        expires: time.Now().Add(VaultTTL),
+    }
+    return token, nil
+}
+
+// Take the coin held under token, with its archive format.
+func (tt *keyVault) takeCoin(token string) (string, string,
+        cointemplate.FilterMap, bool) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    tt.sweep()
+    entry, ok := tt.entries[token]
+    if !ok || entry.taken {
+        return "", "", nil, false
+    }
+    entry.taken = true
+    tt.drop(token, entry)
+    return entry.coinName, entry.format, entry.filterMap, true
+}
+
+// Take the key bundle archive held under token.
+func (tt *keyVault) takeKeys(token string) (string, []byte, bool) {
+    tt.lock.Lock()
+    defer tt.lock.Unlock()
+    tt.sweep()
+    entry, ok := tt.entries[token]
+    if !ok || entry.keys == nil {
+        return "", nil, false
+    }
+    keys := entry.keys
+    entry.keys = nil
+    tt.drop(token, entry)
+    return entry.coinName, keys, true
+}
+
+// Drop an entry once both of its parts are taken.
+func (tt *keyVault) drop(token string, entry *vaultEntry) {
+    if entry.taken && entry.keys == nil {
+        delete(tt.entries, token)
+    }
+}
+
+// Drop expired entries.  Must hold the lock.
+func (tt *keyVault) sweep() {
+    now := time.Now()
+    for token, entry := range tt.entries {
+        if now.After(entry.expires) {
+            delete(tt.entries, token)
+        }
+    }
+}
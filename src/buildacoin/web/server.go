@@ -10,6 +10,10 @@ import (
 const (
     // URL base inside which all the explicit coin pages are kept
     CoinPagesRoot = "/coin/"
+    // URL base inside which the block explorer pages for hosted coins are kept
+    ExplorerRoot = "/explorer/"
+    // URL base inside which diffs of coins' source are kept
+    DiffRoot = "/diff/"
 )
 
 // Serve the build-a-coin web interface
@@ -60,6 +64,20 @@ func Serve(conf *data.Conf) {
         }
     }
 
+    explorer, err := render.NewExplorerPage(conf, ExplorerRoot)
+    if err == nil {
+        mux.Handle(ExplorerRoot, explorer)
+    } else {
+        // TODO log
+    }
+
+    differ, err := render.NewDiffPage(conf, DiffRoot)
+    if err == nil {
+        mux.Handle(DiffRoot, differ)
+    } else {
+        // TODO log
+    }
+
     http.ListenAndServe(listenAt, mux)
 }
 